@@ -2,6 +2,7 @@ package tengo
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -9,170 +10,239 @@ var builtinFuncs = []*BuiltinFunction{
 	{
 		Name:  "len",
 		Value: builtinLen,
+		Usage: "len(x) => int",
 	},
 	{
 		Name:  "copy",
 		Value: builtinCopy,
+		Usage: "copy(x) => object",
 	},
 	{
 		Name:  "append",
 		Value: builtinAppend,
+		Usage: "append(arr, items...) => array",
 	},
 	{
 		Name:  "delete",
 		Value: builtinDelete,
+		Usage: "delete(map, keys...)",
 	},
 	{
 		Name:  "splice",
 		Value: builtinSplice,
+		Usage: "splice(arr[, start[, delete_count[, items...]]]) => array",
 	},
 	{
 		Name:  "string",
 		Value: builtinString,
+		Usage: "string(x[, default]) => string",
 	},
 	{
 		Name:  "int",
 		Value: builtinInt,
+		Usage: "int(x[, default]) => int",
 	},
 	{
 		Name:  "bool",
 		Value: builtinBool,
+		Usage: "bool(x) => bool",
 	},
 	{
 		Name:  "float",
 		Value: builtinFloat,
+		Usage: "float(x[, default]) => float",
 	},
 	{
 		Name:  "char",
 		Value: builtinChar,
+		Usage: "char(x[, default]) => char",
 	},
 	{
 		Name:  "bytes",
 		Value: builtinBytes,
+		Usage: "bytes(x[, default]) => bytes",
 	},
 	{
 		Name:  "time",
 		Value: builtinTime,
+		Usage: "time(x[, default]) => time",
 	},
 	{
 		Name:  "is_int",
 		Value: builtinIsInt,
+		Usage: "is_int(x) => bool",
 	},
 	{
 		Name:  "is_float",
 		Value: builtinIsFloat,
+		Usage: "is_float(x) => bool",
 	},
 	{
 		Name:  "is_string",
 		Value: builtinIsString,
+		Usage: "is_string(x) => bool",
 	},
 	{
 		Name:  "is_bool",
 		Value: builtinIsBool,
+		Usage: "is_bool(x) => bool",
 	},
 	{
 		Name:  "is_char",
 		Value: builtinIsChar,
+		Usage: "is_char(x) => bool",
 	},
 	{
 		Name:  "is_bytes",
 		Value: builtinIsBytes,
+		Usage: "is_bytes(x) => bool",
 	},
 	{
 		Name:  "is_array",
 		Value: builtinIsArray,
+		Usage: "is_array(x) => bool",
 	},
 	{
 		Name:  "is_immutable_array",
 		Value: builtinIsImmutableArray,
+		Usage: "is_immutable_array(x) => bool",
 	},
 	{
 		Name:  "is_map",
 		Value: builtinIsMap,
+		Usage: "is_map(x) => bool",
 	},
 	{
 		Name:  "is_immutable_map",
 		Value: builtinIsImmutableMap,
+		Usage: "is_immutable_map(x) => bool",
 	},
 	{
 		Name:  "is_iterable",
 		Value: builtinIsIterable,
+		Usage: "is_iterable(x) => bool",
 	},
 	{
 		Name:  "is_time",
 		Value: builtinIsTime,
+		Usage: "is_time(x) => bool",
 	},
 	{
 		Name:  "is_error",
 		Value: builtinIsError,
+		Usage: "is_error(x) => bool",
 	},
 	{
 		Name:  "is_undefined",
 		Value: builtinIsUndefined,
+		Usage: "is_undefined(x) => bool",
 	},
 	{
 		Name:  "is_function",
 		Value: builtinIsFunction,
+		Usage: "is_function(x) => bool",
 	},
 	{
 		Name:  "is_callable",
 		Value: builtinIsCallable,
+		Usage: "is_callable(x) => bool",
 	},
 	{
 		Name:  "type_name",
 		Value: builtinTypeName,
+		Usage: "type_name(x) => string",
 	},
 	{
 		Name:  "format",
 		Value: builtinFormat,
+		Usage: "format(format, args...) => string",
 	},
 	{
 		Name:  "range",
 		Value: builtinRange,
+		Usage: "range(start, stop[, step]) => array",
 	},
 	{
 		Name:  "map",
 		Value: builtinMap,
+		Usage: "map(maps...; kwargs...) => map",
 	},
 	{
 		Name:     "get_methods",
 		Value:    builtinGetMethods,
+		Usage:    "get_methods() => array",
 		IsMethod: true,
 	},
 	{
 		Name:  "type",
 		Value: builtinType,
+		Usage: "type(name, [new,] [fields,] [methods,] [properties]; fields=, methods=, properties=, new=) => type",
 	},
 	{
 		Name:  "typeof",
 		Value: builtinTypeOf,
+		Usage: "typeof(instance) => type",
 	},
 	{
 		Name:  "field",
 		Value: builtinField,
+		Usage: "field(value; tags...) => field",
 	},
 	{
 		Name:  "fields",
 		Value: builtinFields,
+		Usage: "fields(; name=value...) => fields",
 	},
 	{
 		Name:  "method",
 		Value: builtinMethod,
+		Usage: "method(fn; tags...) => method",
 	},
 	{
 		Name:  "methods",
 		Value: builtinMethods,
+		Usage: "methods(; name=fn...) => methods",
 	},
 	{
 		Name:  "property",
 		Value: builtinProperty,
+		Usage: "property(getter[, setter]; get=, set=, tags...) => property",
 	},
 	{
 		Name:  "properties",
 		Value: builtinProperties,
+		Usage: "properties(; name=property...) => properties",
 	},
 }
 
+func init() {
+	// resume and go run the VM, which refers to the builtin functions, so
+	// they are added here to avoid the initialization cycle. The builtin
+	// functions added later follow them to keep the indexes.
+	builtinFuncs = append(builtinFuncs,
+		&BuiltinFunction{
+			Name:  "resume",
+			Value: builtinResume,
+			Usage: "resume(generator[, value]) => object",
+		},
+		&BuiltinFunction{
+			Name:  "go",
+			Value: builtinGo,
+			Usage: "go(fn, args...; kwargs...) => routine",
+		},
+		&BuiltinFunction{
+			Name:  "chan",
+			Value: builtinChan,
+			Usage: "chan([size]) => channel",
+		},
+		&BuiltinFunction{
+			Name:  "select",
+			Value: builtinSelect,
+			Usage: "select(cases...; block=true) => [index, value]",
+		},
+	)
+}
+
 // GetAllBuiltinFunctions returns all builtin function objects.
 func GetAllBuiltinFunctions() []*BuiltinFunction {
 	return append([]*BuiltinFunction{}, builtinFuncs...)
@@ -417,6 +487,24 @@ func builtinRange(ctx *CallContext) (Object, error) {
 		step = &Int{Value: int64(1)}
 	}
 
+	// the distance between start and stop can exceed math.MaxInt64
+	var d uint64
+	if start.Value <= stop.Value {
+		d = uint64(stop.Value) - uint64(start.Value)
+	} else {
+		d = uint64(start.Value) - uint64(stop.Value)
+	}
+	n := d / uint64(step.Value)
+	if d%uint64(step.Value) != 0 {
+		n++
+	}
+	if n > (math.MaxInt64-objectSize)/(refSize+objectSize) {
+		return nil, ErrMemoryLimit
+	}
+	size := objectSize + int64(n)*(refSize+objectSize)
+	if err := alloc(ctx, size); err != nil {
+		return nil, err
+	}
 	return buildRange(start.Value, stop.Value, step.Value), nil
 }
 
@@ -572,6 +660,9 @@ func builtinBytes(ctx *CallContext) (Object, error) {
 		if n.Value > int64(MaxBytesLen) {
 			return nil, ErrBytesLimit
 		}
+		if err := alloc(ctx, objectSize+n.Value); err != nil {
+			return nil, err
+		}
 		return &Bytes{Value: make([]byte, int(n.Value))}, nil
 	}
 	v, ok := ToByteSlice(ctx.Args[0])
@@ -610,10 +701,17 @@ func builtinAppend(ctx *CallContext) (Object, error) {
 	if len(ctx.Args) < 2 {
 		return nil, ErrWrongNumArguments
 	}
+	size := objectSize + int64(len(ctx.Args)-1)*refSize
 	switch arg := ctx.Args[0].(type) {
 	case *Array:
+		if err := alloc(ctx, size); err != nil {
+			return nil, err
+		}
 		return &Array{Value: append(arg.Value, ctx.Args[1:]...)}, nil
 	case *ImmutableArray:
+		if err := alloc(ctx, size); err != nil {
+			return nil, err
+		}
 		return &Array{Value: append(arg.Value, ctx.Args[1:]...)}, nil
 	default:
 		return nil, ErrInvalidArgumentType{
@@ -693,6 +791,14 @@ func builtinSplice(ctx *CallContext) (Object, error) {
 	if startIdx+delCount > arrayLen {
 		delCount = arrayLen - startIdx
 	}
+	size := objectSize + int64(delCount)*refSize
+	if argsLen > 3 {
+		size += int64(argsLen-3) * refSize
+	}
+	if err := alloc(ctx, size); err != nil {
+		return nil, err
+	}
+
 	// delete items
 	endIdx := startIdx + delCount
 	deleted := append([]Object{}, array.Value[startIdx:endIdx]...)
@@ -712,6 +818,108 @@ func builtinSplice(ctx *CallContext) (Object, error) {
 	return &Array{Value: deleted}, nil
 }
 
+// builtinResume resumes the generator with the value and returns the value it
+// yields or returns.
+// usage: value := resume(generator[, value])
+func builtinResume(ctx *CallContext) (Object, error) {
+	argsLen := len(ctx.Args)
+	if argsLen != 1 && argsLen != 2 {
+		return nil, ErrWrongNumArguments
+	}
+	g, ok := ctx.Args[0].(*Generator)
+	if !ok {
+		return nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "generator",
+			Found:    ctx.Args[0].TypeName(),
+		}
+	}
+	var value Object = UndefinedValue
+	if argsLen == 2 {
+		value = ctx.Args[1]
+	}
+	return g.resumeBy(ctx.VM, value)
+}
+
+// builtinGo calls the function with the arguments concurrently in a VM of
+// its own and returns the routine.
+// usage: routine := go(fn, args...; kwargs...)
+func builtinGo(ctx *CallContext) (Object, error) {
+	if len(ctx.Args) == 0 {
+		return nil, ErrWrongNumArguments
+	}
+	if !ctx.Args[0].CanCall() {
+		return nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "callable",
+			Found:    ctx.Args[0].TypeName(),
+		}
+	}
+	if ctx.VM == nil {
+		return nil, fmt.Errorf("go requires a VM")
+	}
+	args := append([]Object{}, ctx.Args[1:]...)
+	r, err := ctx.VM.spawn(ctx.Args[0], args, ctx.Kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// builtinChan creates a channel with the buffer size.
+// usage: ch := chan([size])
+func builtinChan(ctx *CallContext) (Object, error) {
+	var size int
+	switch len(ctx.Args) {
+	case 0:
+	case 1:
+		n, ok := ctx.Args[0].(*Int)
+		if !ok {
+			return nil, ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "int",
+				Found:    ctx.Args[0].TypeName(),
+			}
+		}
+		if n.Value < 0 {
+			return nil, ErrInvalidIndex
+		}
+		size = int(n.Value)
+	default:
+		return nil, ErrWrongNumArguments
+	}
+	if err := alloc(ctx, int64(size)*refSize); err != nil {
+		return nil, err
+	}
+	return NewChannel(size), nil
+}
+
+// builtinSelect waits until one of the channels can receive a value or one
+// of the values can be sent to its channel. It returns the index of the case
+// and the received value. With block=false, it returns undefined
+// without waiting if none of the cases can proceed.
+// usage: [index, value] := select(ch1, [ch2, value]...; block=true)
+func builtinSelect(ctx *CallContext) (Object, error) {
+	block := true
+	for k, v := range ctx.Kwargs {
+		if k != "block" {
+			return nil, ErrUnexpectedKwargs
+		}
+		block = !v.IsFalsy()
+	}
+	if len(ctx.Args) == 0 && block {
+		return nil, ErrWrongNumArguments
+	}
+	idx, value, err := selectChannels(ctx.VM, ctx.Args, block)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 {
+		return UndefinedValue, nil
+	}
+	return &Array{Value: []Object{&Int{Value: int64(idx)}, value}}, nil
+}
+
 // builtinMap make new map merging args of map and kwargs
 // Usage: map([map...]...[,key=value,keyN=value])
 // Examples:
@@ -227,7 +227,8 @@ func fixDecodedObject(
 
 		for k, v := range o.Value {
 			// encoding of user function not supported
-			if _, isUserFunction := v.(*UserFunction); isUserFunction {
+			switch v.(type) {
+			case *UserFunction, *UserFunctionCtx:
 				return nil, fmt.Errorf("user function not decodable")
 			}
 
@@ -264,6 +265,14 @@ func updateConstIndexes(insts []byte, indexMap map[int]int) {
 				panic(fmt.Errorf("constant index not found: %d", curIdx))
 			}
 			copy(insts[i:], MakeInstruction(op, newIdx, numFree))
+		case parser.OpSwitch:
+			curIdx := int(insts[i+2]) | int(insts[i+1])<<8
+			numCases := int(insts[i+4]) | int(insts[i+3])<<8
+			newIdx, ok := indexMap[curIdx]
+			if !ok {
+				panic(fmt.Errorf("constant index not found: %d", curIdx))
+			}
+			copy(insts[i:], MakeInstruction(op, newIdx, numCases))
 		}
 
 		i += 1 + read
@@ -295,4 +304,5 @@ func init() {
 	gob.Register(&Time{})
 	gob.Register(&Undefined{})
 	gob.Register(&UserFunction{})
+	gob.Register(&UserFunctionCtx{})
 }
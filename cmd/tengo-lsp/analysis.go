@@ -0,0 +1,484 @@
+package main
+
+import (
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/token"
+)
+
+// definition is a variable defined in the source file.
+type definition struct {
+	Ident    *parser.Ident
+	Scope    tengo.SymbolScope
+	Param    bool
+	Import   string     // module name if the value is imported
+	ScopeEnd parser.Pos // end of the scope where the variable is visible
+}
+
+// reference is an identifier resolved to a definition or a builtin
+// function.
+type reference struct {
+	Ident   *parser.Ident
+	Def     *definition
+	Builtin *tengo.BuiltinFunction
+}
+
+// memberRef is a selector on an imported module such as `fmt.println`.
+type memberRef struct {
+	Module string
+	Name   string
+	Sel    *parser.StringLit
+}
+
+// analysis is the result of resolving the identifiers of a source file the
+// same way the compiler does.
+type analysis struct {
+	file    *parser.SourceFile
+	defs    []*definition
+	refs    []*reference
+	members []*memberRef
+	imports []*parser.ImportExpr
+}
+
+// analyze resolves the identifiers of the parsed file. It does not report
+// errors: unresolved identifiers are skipped and left to the compiler.
+func analyze(file *parser.File) *analysis {
+	a := &analysis{file: file.InputFile}
+	w := &walker{
+		a:        a,
+		table:    tengo.NewSymbolTable(),
+		defs:     make(map[*tengo.Symbol]*definition),
+		builtins: make(map[string]*tengo.BuiltinFunction),
+	}
+	for idx, fn := range tengo.GetAllBuiltinFunctions() {
+		w.table.DefineBuiltin(idx, fn.Name)
+		w.builtins[fn.Name] = fn
+	}
+	w.funcs = []*tengo.SymbolTable{w.table}
+	w.ends = []parser.Pos{parser.Pos(a.file.Base + a.file.Size + 1)}
+	for _, stmt := range file.Stmts {
+		w.stmt(stmt)
+	}
+	return a
+}
+
+// visible returns the definitions visible at the position. Inner
+// definitions shadow the outer ones.
+func (a *analysis) visible(pos parser.Pos) map[string]*definition {
+	defs := make(map[string]*definition)
+	for _, d := range a.defs {
+		if d.Ident.Pos() > pos || pos > d.ScopeEnd {
+			continue
+		}
+		if prev, ok := defs[d.Ident.Name]; ok && prev.Ident.Pos() > d.Ident.Pos() {
+			continue
+		}
+		defs[d.Ident.Name] = d
+	}
+	return defs
+}
+
+// referenceAt returns the identifier reference at the position.
+func (a *analysis) referenceAt(pos parser.Pos) *reference {
+	for _, r := range a.refs {
+		if r.Ident.Pos() <= pos && pos <= r.Ident.End() {
+			return r
+		}
+	}
+	return nil
+}
+
+// memberAt returns the module member reference at the position.
+func (a *analysis) memberAt(pos parser.Pos) *memberRef {
+	for _, m := range a.members {
+		if m.Sel.Pos() <= pos && pos <= m.Sel.End() {
+			return m
+		}
+	}
+	return nil
+}
+
+// importAt returns the import expression at the position.
+func (a *analysis) importAt(pos parser.Pos) *parser.ImportExpr {
+	for _, e := range a.imports {
+		if e.Pos() <= pos && pos <= e.End() {
+			return e
+		}
+	}
+	return nil
+}
+
+type walker struct {
+	a        *analysis
+	table    *tengo.SymbolTable
+	funcs    []*tengo.SymbolTable // symbol tables of the enclosing functions
+	ends     []parser.Pos         // end positions of the enclosing scopes
+	defs     map[*tengo.Symbol]*definition
+	builtins map[string]*tengo.BuiltinFunction
+}
+
+func (w *walker) enterBlock(end parser.Pos) {
+	w.table = w.table.Fork(true)
+	w.ends = append(w.ends, end)
+}
+
+func (w *walker) leaveBlock() {
+	w.table = w.table.Parent(false)
+	w.ends = w.ends[:len(w.ends)-1]
+}
+
+// define defines the variable. If assigned is false, the variable cannot be
+// referred until it's assigned.
+func (w *walker) define(ident *parser.Ident, assigned bool) *definition {
+	s := w.table.Define(ident.Name)
+	d := &definition{
+		Ident:    ident,
+		Scope:    s.Scope,
+		ScopeEnd: w.ends[len(w.ends)-1],
+	}
+	w.defs[s] = d
+	w.a.defs = append(w.a.defs, d)
+	w.a.refs = append(w.a.refs, &reference{Ident: ident, Def: d})
+	s.LocalAssigned = assigned
+	return d
+}
+
+func (w *walker) assigned(ident *parser.Ident) {
+	if s, _, ok := w.table.Resolve(ident.Name, true); ok {
+		s.LocalAssigned = true
+	}
+}
+
+func (w *walker) resolve(ident *parser.Ident) *definition {
+	s, _, ok := w.table.Resolve(ident.Name, false)
+	if !ok {
+		return nil
+	}
+	switch s.Scope {
+	case tengo.ScopeBuiltin:
+		w.a.refs = append(w.a.refs, &reference{
+			Ident:   ident,
+			Builtin: w.builtins[ident.Name],
+		})
+		return nil
+	case tengo.ScopeFree:
+		// free symbols point to the symbols of the enclosing functions.
+		for i := len(w.funcs) - 1; s.Scope == tengo.ScopeFree && i >= 0; i-- {
+			s = w.funcs[i].FreeSymbols()[s.Index]
+		}
+	}
+	d := w.defs[s]
+	if d != nil {
+		w.a.refs = append(w.a.refs, &reference{Ident: ident, Def: d})
+	}
+	return d
+}
+
+func (w *walker) stmts(stmts []parser.Stmt) {
+	for _, stmt := range stmts {
+		w.stmt(stmt)
+	}
+}
+
+func (w *walker) stmt(node parser.Stmt) {
+	switch node := node.(type) {
+	case *parser.AssignStmt:
+		w.assign(node)
+	case *parser.BlockStmt:
+		w.enterBlock(node.End())
+		w.stmts(node.Stmts)
+		w.leaveBlock()
+	case *parser.ExprStmt:
+		w.expr(node.Expr)
+	case *parser.IncDecStmt:
+		w.expr(node.Expr)
+	case *parser.ReturnStmt:
+		w.expr(node.Result)
+	case *parser.ExportStmt:
+		w.expr(node.Result)
+	case *parser.IfStmt:
+		w.enterBlock(node.End())
+		w.stmt(node.Init)
+		w.expr(node.Cond)
+		w.stmt(node.Body)
+		w.stmt(node.Else)
+		w.leaveBlock()
+	case *parser.ForStmt:
+		w.enterBlock(node.End())
+		w.stmt(node.Init)
+		w.expr(node.Cond)
+		w.stmt(node.Body)
+		w.stmt(node.Post)
+		w.leaveBlock()
+	case *parser.ForInStmt:
+		w.enterBlock(node.End())
+		w.expr(node.Iterable)
+		if node.Key != nil && node.Key.Name != "_" {
+			w.define(node.Key, true)
+		}
+		if node.Value != nil && node.Value.Name != "_" {
+			w.define(node.Value, true)
+		}
+		if node.Pattern != nil {
+			w.destructure(node.Pattern, true)
+		}
+		w.stmt(node.Body)
+		w.leaveBlock()
+	case *parser.TryStmt:
+		w.stmt(node.Body)
+		if node.Catch != nil {
+			w.enterBlock(node.Catch.End())
+			if node.Ident != nil && node.Ident.Name != "_" {
+				w.define(node.Ident, true)
+			}
+			w.stmt(node.Catch)
+			w.leaveBlock()
+		}
+		if node.Finally != nil {
+			w.stmt(node.Finally)
+		}
+	case *parser.SwitchStmt:
+		w.enterBlock(node.End())
+		w.stmt(node.Init)
+		w.expr(node.Tag)
+		for _, cc := range node.Cases {
+			w.enterBlock(cc.End())
+			bound := make(map[string]bool)
+			for _, p := range cc.Patterns {
+				if node.Tag == nil {
+					w.expr(p)
+				} else {
+					w.pattern(p, 0, bound)
+				}
+			}
+			w.expr(cc.Guard)
+			w.stmts(cc.Body)
+			w.leaveBlock()
+		}
+		w.leaveBlock()
+	}
+}
+
+// pattern walks the pattern of a switch case. The identifiers in the array
+// and the map patterns define the variables of the case.
+func (w *walker) pattern(
+	node parser.Expr,
+	depth int,
+	bound map[string]bool,
+) {
+	switch node := node.(type) {
+	case *parser.Ident:
+		if node.Name == "_" {
+			return
+		}
+		// the same variable can be bound by the other patterns of the case.
+		if depth == 0 || bound[node.Name] {
+			w.resolve(node)
+		} else {
+			bound[node.Name] = true
+			w.define(node, true)
+		}
+	case *parser.ArrayPattern:
+		for _, e := range node.Elements {
+			w.pattern(e, depth+1, bound)
+		}
+		if node.Rest != nil {
+			w.pattern(node.Rest, depth+1, bound)
+		}
+	case *parser.MapPattern:
+		for _, e := range node.Elements {
+			w.pattern(e.Value, depth+1, bound)
+		}
+		if node.Rest != nil {
+			w.pattern(node.Rest, depth+1, bound)
+		}
+	case *parser.DefaultPattern:
+		w.pattern(node.Pattern, depth, bound)
+		w.expr(node.Default)
+	case *parser.TypePattern:
+		w.expr(node.Type)
+	default:
+		w.expr(node)
+	}
+}
+
+func (w *walker) assign(node *parser.AssignStmt) {
+	if len(node.RHS) != 1 {
+		return
+	}
+	if len(node.LHS) > 1 {
+		w.expr(node.RHS[0])
+		for _, lhs := range node.LHS {
+			w.destructure(lhs, node.Token == token.Define)
+		}
+		return
+	}
+	switch node.LHS[0].(type) {
+	case *parser.ArrayPattern, *parser.MapPattern:
+		w.expr(node.RHS[0])
+		w.destructure(node.LHS[0], node.Token == token.Define)
+		return
+	}
+	ident, ok := node.LHS[0].(*parser.Ident)
+	if node.Token == token.Define {
+		if !ok {
+			return
+		}
+		// the variable is defined before the right-hand side is compiled,
+		// so that functions can refer to themselves.
+		d := w.define(ident, false)
+		w.expr(node.RHS[0])
+		w.assigned(ident)
+		if imp, ok := node.RHS[0].(*parser.ImportExpr); ok {
+			d.Import = imp.ModuleName
+		}
+		return
+	}
+
+	w.expr(node.LHS[0])
+	w.expr(node.RHS[0])
+}
+
+// destructure walks the targets of a destructuring assignment. If define is
+// true, the variables not defined in the block yet are defined.
+func (w *walker) destructure(node parser.Expr, define bool) {
+	switch node := node.(type) {
+	case *parser.Ident:
+		if node.Name == "_" {
+			return
+		}
+		if _, depth, ok := w.table.Resolve(node.Name, false); define &&
+			(!ok || depth > 0) {
+			w.define(node, true)
+		} else {
+			w.resolve(node)
+		}
+	case *parser.DefaultPattern:
+		w.expr(node.Default)
+		w.destructure(node.Pattern, define)
+	case *parser.ArrayPattern:
+		for _, e := range node.Elements {
+			w.destructure(e, define)
+		}
+		if node.Rest != nil {
+			w.destructure(node.Rest, define)
+		}
+	case *parser.MapPattern:
+		for _, e := range node.Elements {
+			w.destructure(e.Value, define)
+		}
+		if node.Rest != nil {
+			w.destructure(node.Rest, define)
+		}
+	default:
+		w.expr(node)
+	}
+}
+
+func (w *walker) expr(node parser.Expr) {
+	switch node := node.(type) {
+	case *parser.Ident:
+		w.resolve(node)
+	case *parser.ArrayLit:
+		for _, e := range node.Elements {
+			w.expr(e)
+		}
+	case *parser.MapLit:
+		for _, e := range node.Elements {
+			w.expr(e.Value)
+		}
+	case *parser.SpreadExpr:
+		w.expr(node.Expr)
+	case *parser.BinaryExpr:
+		w.expr(node.LHS)
+		w.expr(node.RHS)
+	case *parser.UnaryExpr:
+		w.expr(node.Expr)
+	case *parser.ParenExpr:
+		w.expr(node.Expr)
+	case *parser.CondExpr:
+		w.expr(node.Cond)
+		w.expr(node.True)
+		w.expr(node.False)
+	case *parser.ErrorExpr:
+		w.expr(node.Expr)
+	case *parser.ImmutableExpr:
+		w.expr(node.Expr)
+	case *parser.YieldExpr:
+		if node.Result != nil {
+			w.expr(node.Result)
+		}
+	case *parser.IndexExpr:
+		w.expr(node.Expr)
+		w.expr(node.Index)
+	case *parser.SliceExpr:
+		w.expr(node.Expr)
+		w.expr(node.Low)
+		w.expr(node.High)
+	case *parser.SelectorExpr:
+		w.selector(node)
+	case *parser.CallExpr:
+		w.expr(node.Func)
+		for _, e := range node.Args.Values {
+			w.expr(e)
+		}
+		for _, e := range node.Kwargs.Values {
+			w.expr(e)
+		}
+	case *parser.ImportExpr:
+		w.a.imports = append(w.a.imports, node)
+	case *parser.FuncLit:
+		w.funcLit(node)
+	}
+}
+
+func (w *walker) selector(node *parser.SelectorExpr) {
+	if ident, ok := node.Expr.(*parser.Ident); ok {
+		d := w.resolve(ident)
+		sel, ok := node.Sel.(*parser.StringLit)
+		if d != nil && d.Import != "" && ok {
+			w.a.members = append(w.a.members, &memberRef{
+				Module: d.Import,
+				Name:   sel.Value,
+				Sel:    sel,
+			})
+		}
+		return
+	}
+	w.expr(node.Expr)
+	w.expr(node.Sel)
+}
+
+func (w *walker) funcLit(node *parser.FuncLit) {
+	w.table = w.table.Fork(false)
+	w.funcs = append(w.funcs, w.table)
+	w.ends = append(w.ends, node.End())
+
+	params := node.Type.Params
+	if params.Args != nil {
+		for _, p := range params.Args.List {
+			w.define(p, true).Param = true
+		}
+	}
+	if params.Kwargs != nil {
+		for _, p := range params.Kwargs.Names {
+			w.define(p, true).Param = true
+		}
+		// default values are evaluated in the function scope.
+		for _, v := range params.Kwargs.Values {
+			w.expr(v)
+		}
+	}
+	if params.Args != nil {
+		for _, p := range params.Args.Patterns {
+			if p != nil {
+				w.destructure(p, true)
+			}
+		}
+	}
+	w.stmt(node.Body)
+
+	w.ends = w.ends[:len(w.ends)-1]
+	w.funcs = w.funcs[:len(w.funcs)-1]
+	w.table = w.table.Parent(false)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tengo-lsp is a language server of Tengo. It speaks Language Server
+// Protocol over stdin and stdout.
+func main() {
+	s := newServer(os.Stdin, os.Stdout)
+	if err := s.serve(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+var errExit = errors.New("exit")
+
+type rpcMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// conn reads and writes the messages with Content-Length headers.
+type conn struct {
+	r    *bufio.Reader
+	w    io.Writer
+	lock sync.Mutex
+}
+
+func (c *conn) read() (*rpcMessage, error) {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length < 0 {
+				continue
+			}
+			break
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 &&
+			strings.EqualFold(line[:i], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header: %s", line)
+			}
+		}
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	msg := &rpcMessage{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, &rpcError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+func (c *conn) write(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, _ = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
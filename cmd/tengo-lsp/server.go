@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+const sourceFileExt = ".tengo"
+
+// Completion item kinds of the protocol.
+const (
+	kindFunction = 3
+	kindVariable = 6
+	kindModule   = 9
+)
+
+var (
+	memberPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\.([A-Za-z0-9_]*)$`)
+	importPrefix = regexp.MustCompile(`import\(\s*"([^"]*)$`)
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// document is a source file opened in the editor.
+type document struct {
+	uri  string
+	path string
+	text string
+
+	// the result of the last successful parsing and the text it's based on.
+	analysis *analysis
+	parsed   string
+}
+
+type server struct {
+	conn     *conn
+	modules  *tengo.ModuleMap
+	docs     map[string]*document
+	shutdown bool
+}
+
+func newServer(r io.Reader, w io.Writer) *server {
+	return &server{
+		conn:    &conn{r: bufio.NewReader(r), w: w},
+		modules: stdlib.GetModuleMap(stdlib.AllModuleNames()...),
+		docs:    make(map[string]*document),
+	}
+}
+
+func (s *server) serve() error {
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if e, ok := err.(*rpcError); ok {
+				s.conn.write(&rpcResponse{JSONRPC: "2.0", Error: e})
+				continue
+			}
+			return err
+		}
+
+		result, err := s.handle(msg.Method, msg.Params)
+		if err == errExit {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		if msg.ID == nil {
+			// notification
+			continue
+		}
+		res := &rpcResponse{JSONRPC: "2.0", ID: msg.ID, Result: result}
+		if err != nil {
+			e, ok := err.(*rpcError)
+			if !ok {
+				e = &rpcError{Code: codeInternalError, Message: err.Error()}
+			}
+			res.Result = nil
+			res.Error = e
+		}
+		s.conn.write(res)
+	}
+}
+
+func (s *server) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   2, // incremental
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{".", "\""},
+				},
+			},
+			"serverInfo": map[string]interface{}{"name": "tengo-lsp"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "exit":
+		return nil, errExit
+	case "textDocument/didOpen":
+		var args struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := unmarshalParams(params, &args); err != nil {
+			return nil, err
+		}
+		s.update(args.TextDocument.URI, args.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var args struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Range *lspRange `json:"range"`
+				Text  string    `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := unmarshalParams(params, &args); err != nil {
+			return nil, err
+		}
+		if len(args.ContentChanges) == 0 {
+			return nil, nil
+		}
+		var text string
+		if doc := s.docs[args.TextDocument.URI]; doc != nil {
+			text = doc.text
+		}
+		// the changes without a range replace the whole text
+		for _, change := range args.ContentChanges {
+			if change.Range == nil {
+				text = change.Text
+				continue
+			}
+			start := offsetOf(text, change.Range.Start)
+			end := offsetOf(text, change.Range.End)
+			if end < start {
+				end = start
+			}
+			text = text[:start] + change.Text + text[end:]
+		}
+		s.update(args.TextDocument.URI, text)
+		return nil, nil
+	case "textDocument/didClose":
+		var args struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := unmarshalParams(params, &args); err != nil {
+			return nil, err
+		}
+		delete(s.docs, args.TextDocument.URI)
+		s.publishDiagnostics(args.TextDocument.URI, nil)
+		return nil, nil
+	case "textDocument/definition":
+		var args textDocumentPositionParams
+		if err := unmarshalParams(params, &args); err != nil {
+			return nil, err
+		}
+		return s.definition(args.TextDocument.URI, args.Position), nil
+	case "textDocument/hover":
+		var args textDocumentPositionParams
+		if err := unmarshalParams(params, &args); err != nil {
+			return nil, err
+		}
+		return s.hover(args.TextDocument.URI, args.Position), nil
+	case "textDocument/completion":
+		var args textDocumentPositionParams
+		if err := unmarshalParams(params, &args); err != nil {
+			return nil, err
+		}
+		return s.completion(args.TextDocument.URI, args.Position), nil
+	case "initialized", "textDocument/didSave", "$/cancelRequest",
+		"workspace/didChangeConfiguration":
+		return nil, nil
+	}
+	return nil, &rpcError{
+		Code:    codeMethodNotFound,
+		Message: fmt.Sprintf("method not found: %s", method),
+	}
+}
+
+func unmarshalParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// update parses and compiles the document and publishes the errors as
+// diagnostics.
+func (s *server) update(uri, text string) {
+	doc := s.docs[uri]
+	if doc == nil {
+		doc = &document{uri: uri, path: uriToPath(uri)}
+		s.docs[uri] = doc
+	}
+	doc.text = text
+
+	src := []byte(text)
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(doc.path, -1, len(src))
+	p := parser.NewParser(srcFile, src, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		var diags []lspDiagnostic
+		if list, ok := err.(parser.ErrorList); ok {
+			for _, e := range list {
+				pos := lspPositionOf(text, e.Pos)
+				diags = append(diags, lspDiagnostic{
+					Range:    lspRange{Start: pos, End: pos},
+					Severity: 1,
+					Source:   "tengo",
+					Message:  e.Msg,
+				})
+			}
+		}
+		s.publishDiagnostics(uri, diags)
+		return
+	}
+
+	// the compiler modifies the AST, so the file is analyzed first.
+	doc.analysis = analyze(file)
+	doc.parsed = text
+
+	c := tengo.NewCompiler(srcFile, nil, nil, s.modules, nil)
+	c.EnableFileImport(true)
+	c.SetImportDir(filepath.Dir(doc.path))
+	if err := c.Compile(file); err != nil {
+		s.publishDiagnostics(uri, []lspDiagnostic{s.compileError(doc, err)})
+		return
+	}
+	s.publishDiagnostics(uri, nil)
+}
+
+// compileError converts the compiler error into a diagnostic. The errors in
+// the imported modules are reported at the import expressions.
+func (s *server) compileError(doc *document, err error) lspDiagnostic {
+	diag := lspDiagnostic{Severity: 1, Source: "tengo", Message: err.Error()}
+
+	var filename string
+	switch err := err.(type) {
+	case *tengo.CompilerError:
+		pos := err.FileSet.Position(err.Node.Pos())
+		if pos.Filename == doc.path {
+			diag.Message = err.Err.Error()
+			diag.Range.Start = lspPositionOf(doc.parsed, pos)
+			diag.Range.End = diag.Range.Start
+			if end := err.FileSet.Position(err.Node.End()); end.IsValid() {
+				diag.Range.End = lspPositionOf(doc.parsed, end)
+			}
+			return diag
+		}
+		filename = pos.Filename
+	case parser.ErrorList:
+		if len(err) > 0 {
+			filename = err[0].Pos.Filename
+		}
+	}
+
+	for _, imp := range doc.analysis.imports {
+		if imp.ModuleName == filename ||
+			s.modulePath(doc, imp.ModuleName) == filename {
+			diag.Range = doc.rangeOf(doc.analysis, imp)
+			break
+		}
+	}
+	return diag
+}
+
+func (s *server) publishDiagnostics(uri string, diags []lspDiagnostic) {
+	if diags == nil {
+		diags = []lspDiagnostic{}
+	}
+	s.conn.write(&rpcNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]interface{}{
+			"uri":         uri,
+			"diagnostics": diags,
+		},
+	})
+}
+
+func (s *server) definition(uri string, position lspPosition) interface{} {
+	doc, pos, ok := s.lookup(uri, position)
+	if !ok {
+		return nil
+	}
+	a := doc.analysis
+
+	if r := a.referenceAt(pos); r != nil && r.Def != nil {
+		return &lspLocation{URI: uri, Range: doc.rangeOf(a, r.Def.Ident)}
+	}
+	if m := a.memberAt(pos); m != nil {
+		if path := s.modulePath(doc, m.Module); path != "" {
+			exports, text := moduleExports(path)
+			if p, ok := exports[m.Name]; ok {
+				return &lspLocation{
+					URI:   pathToURI(path),
+					Range: lspRange{Start: lspPositionOf(text, p), End: lspPositionOf(text, p)},
+				}
+			}
+			return &lspLocation{URI: pathToURI(path)}
+		}
+	}
+	if imp := a.importAt(pos); imp != nil {
+		if path := s.modulePath(doc, imp.ModuleName); path != "" {
+			return &lspLocation{URI: pathToURI(path)}
+		}
+	}
+	return nil
+}
+
+func (s *server) hover(uri string, position lspPosition) interface{} {
+	doc, pos, ok := s.lookup(uri, position)
+	if !ok {
+		return nil
+	}
+	a := doc.analysis
+
+	var code, desc string
+	var node parser.Node
+	if r := a.referenceAt(pos); r != nil {
+		node = r.Ident
+		switch {
+		case r.Builtin != nil:
+			code, desc = r.Builtin.Usage, "builtin function"
+			if code == "" {
+				code = r.Builtin.Name + "(...)"
+			}
+		case r.Def.Import != "":
+			code = fmt.Sprintf("%s := import(%q)", r.Def.Ident.Name,
+				r.Def.Import)
+		case r.Def.Param:
+			code, desc = r.Def.Ident.Name, "parameter"
+		case r.Def.Scope == tengo.ScopeGlobal:
+			code, desc = r.Def.Ident.Name, "global variable"
+		default:
+			code, desc = r.Def.Ident.Name, "local variable"
+		}
+	} else if m := a.memberAt(pos); m != nil {
+		node = m.Sel
+		code = m.Module + "." + m.Name
+		if mod := s.modules.GetBuiltinModule(m.Module); mod != nil {
+			if o, ok := mod.Attrs[m.Name]; ok {
+				desc = o.TypeName()
+			}
+		}
+	} else {
+		return nil
+	}
+
+	value := "```tengo\n" + code + "\n```"
+	if desc != "" {
+		value += "\n\n" + desc
+	}
+	return map[string]interface{}{
+		"contents": map[string]interface{}{
+			"kind":  "markdown",
+			"value": value,
+		},
+		"range": doc.rangeOf(a, node),
+	}
+}
+
+func (s *server) completion(uri string, position lspPosition) interface{} {
+	doc := s.docs[uri]
+	if doc == nil {
+		return []lspCompletionItem{}
+	}
+	offset := offsetOf(doc.text, position)
+	line := doc.text[strings.LastIndexByte(doc.text[:offset], '\n')+1 : offset]
+
+	items := []lspCompletionItem{}
+	if m := importPrefix.FindStringSubmatch(line); m != nil {
+		for _, name := range stdlib.AllModuleNames() {
+			items = append(items, lspCompletionItem{
+				Label: name,
+				Kind:  kindModule,
+			})
+		}
+		return items
+	}
+
+	var visible map[string]*definition
+	if doc.analysis != nil {
+		visible = doc.analysis.visible(doc.pos(offset))
+	}
+
+	if m := memberPrefix.FindStringSubmatch(line); m != nil {
+		d := visible[m[1]]
+		if d == nil || d.Import == "" {
+			return items
+		}
+		return s.moduleMembers(doc, d.Import)
+	}
+
+	for name, d := range visible {
+		items = append(items, lspCompletionItem{
+			Label: name,
+			Kind:  kindVariable,
+			Detail: func() string {
+				if d.Import != "" {
+					return fmt.Sprintf("import(%q)", d.Import)
+				}
+				return ""
+			}(),
+		})
+	}
+	for _, fn := range tengo.GetAllBuiltinFunctions() {
+		if _, ok := visible[fn.Name]; ok {
+			continue
+		}
+		items = append(items, lspCompletionItem{
+			Label:  fn.Name,
+			Kind:   kindFunction,
+			Detail: fn.Usage,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+// moduleMembers returns the exported members of the module.
+func (s *server) moduleMembers(doc *document, name string) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	if mod := s.modules.GetBuiltinModule(name); mod != nil {
+		for k, v := range mod.Attrs {
+			kind := kindVariable
+			if v.CanCall() {
+				kind = kindFunction
+			}
+			items = append(items, lspCompletionItem{
+				Label:  k,
+				Kind:   kind,
+				Detail: v.TypeName(),
+			})
+		}
+	} else {
+		var exports map[string]parser.SourceFilePos
+		if mod := s.modules.GetSourceModule(name); mod != nil {
+			exports, _ = parseExports(name, mod.Src)
+		} else if path := s.modulePath(doc, name); path != "" {
+			exports, _ = moduleExports(path)
+		}
+		for k := range exports {
+			items = append(items, lspCompletionItem{
+				Label: k,
+				Kind:  kindVariable,
+			})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+// lookup returns the document and the source position of the LSP position.
+// The document must be parsed successfully.
+func (s *server) lookup(
+	uri string,
+	position lspPosition,
+) (*document, parser.Pos, bool) {
+	doc := s.docs[uri]
+	if doc == nil || doc.analysis == nil || doc.parsed != doc.text {
+		return nil, parser.NoPos, false
+	}
+	return doc, doc.pos(offsetOf(doc.text, position)), true
+}
+
+// modulePath returns the path of the module file imported by the document.
+// It returns an empty string if it's not a module file.
+func (s *server) modulePath(doc *document, name string) string {
+	if name == "" || s.modules.Get(name) != nil {
+		return ""
+	}
+	if !strings.HasSuffix(name, sourceFileExt) {
+		name += sourceFileExt
+	}
+	path, err := filepath.Abs(filepath.Join(filepath.Dir(doc.path), name))
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// moduleExports returns the positions of the exported members of the module
+// file and the source text of the file.
+func moduleExports(path string) (map[string]parser.SourceFilePos, string) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, ""
+	}
+	return parseExports(path, src)
+}
+
+// parseExports returns the positions of the keys of the map exported by the
+// module source.
+func parseExports(
+	filename string,
+	src []byte,
+) (map[string]parser.SourceFilePos, string) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(filename, -1, len(src))
+	file, err := parser.NewParser(srcFile, src, nil).ParseFile()
+	if err != nil {
+		return nil, string(src)
+	}
+	exports := make(map[string]parser.SourceFilePos)
+	for _, stmt := range file.Stmts {
+		export, ok := stmt.(*parser.ExportStmt)
+		if !ok {
+			continue
+		}
+		if m, ok := export.Result.(*parser.MapLit); ok {
+			for _, e := range m.Elements {
+				exports[e.Key] = fileSet.Position(e.KeyPos)
+			}
+		}
+	}
+	return exports, string(src)
+}
+
+// lspPositionOf converts the file position into the LSP position of the
+// text. LSP counts the characters in UTF-16 code units.
+func lspPositionOf(text string, p parser.SourceFilePos) lspPosition {
+	if !p.IsValid() {
+		return lspPosition{}
+	}
+	offset := p.Offset
+	if offset > len(text) {
+		offset = len(text)
+	}
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	return lspPosition{
+		Line:      p.Line - 1,
+		Character: utf16Len(text[lineStart:offset]),
+	}
+}
+
+// rangeOf returns the LSP range of the node analyzed.
+func (d *document) rangeOf(a *analysis, node parser.Node) lspRange {
+	fileSet := a.file.Set()
+	return lspRange{
+		Start: lspPositionOf(d.parsed, fileSet.Position(node.Pos())),
+		End:   lspPositionOf(d.parsed, fileSet.Position(node.End())),
+	}
+}
+
+// pos converts the offset of the text into the source position of the
+// analysis.
+func (d *document) pos(offset int) parser.Pos {
+	f := d.analysis.file
+	if offset > f.Size {
+		offset = f.Size
+	}
+	return parser.Pos(f.Base + offset)
+}
+
+// offsetOf converts the LSP position into the byte offset of the text.
+func offsetOf(text string, p lspPosition) int {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	for n := 0; n < p.Character && offset < len(text); {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		if r == '\n' {
+			break
+		}
+		n += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/v2/require"
+)
+
+const testURI = "file:///tmp/main.tengo"
+
+// lspClient speaks the protocol to a server over in-memory pipes.
+type lspClient struct {
+	t  *testing.T
+	w  io.Writer
+	r  *bufio.Reader
+	id int
+}
+
+func startServer(t *testing.T) (*lspClient, chan error) {
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := newServer(reqR, resW).serve()
+		_ = resW.Close()
+		done <- err
+	}()
+	return &lspClient{t: t, w: reqW, r: bufio.NewReader(resR)}, done
+}
+
+func (c *lspClient) write(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	data, err := json.Marshal(msg)
+	require.NoError(c.t, err)
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	require.NoError(c.t, err)
+}
+
+func (c *lspClient) read() map[string]interface{} {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		require.NoError(c.t, err)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		length, err = strconv.Atoi(
+			strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		require.NoError(c.t, err)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(c.r, data)
+	require.NoError(c.t, err)
+	msg := make(map[string]interface{})
+	require.NoError(c.t, json.Unmarshal(data, &msg))
+	return msg
+}
+
+// call sends the request and returns the result of the response.
+func (c *lspClient) call(method string, params interface{}) interface{} {
+	c.id++
+	c.write(map[string]interface{}{
+		"id":     c.id,
+		"method": method,
+		"params": params,
+	})
+	msg := c.read()
+	require.Equal(c.t, float64(c.id), msg["id"], "%v", msg)
+	require.Nil(c.t, msg["error"], "%v", msg["error"])
+	return msg["result"]
+}
+
+// notify sends the notification.
+func (c *lspClient) notify(method string, params interface{}) {
+	c.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// diagnostics returns the messages of the diagnostics published next, with
+// their start positions.
+func (c *lspClient) diagnostics() []string {
+	msg := c.read()
+	require.Equal(c.t, "textDocument/publishDiagnostics", msg["method"])
+	params := msg["params"].(map[string]interface{})
+	require.Equal(c.t, testURI, params["uri"])
+	diags := []string{}
+	for _, d := range params["diagnostics"].([]interface{}) {
+		d := d.(map[string]interface{})
+		start := d["range"].(map[string]interface{})["start"]
+		diags = append(diags, fmt.Sprintf("%s: %s",
+			position(start), d["message"]))
+	}
+	return diags
+}
+
+// at returns the params of the position in the test document.
+func at(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+		"position": map[string]interface{}{
+			"line":      line,
+			"character": character,
+		},
+	}
+}
+
+// position formats the LSP position as "line:character".
+func position(p interface{}) string {
+	m := p.(map[string]interface{})
+	return fmt.Sprintf("%v:%v", m["line"], m["character"])
+}
+
+// change returns the params of an incremental change of the test document.
+func change(
+	startLine, startChar, endLine, endChar int,
+	text string,
+) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+		"contentChanges": []map[string]interface{}{{
+			"range": map[string]interface{}{
+				"start": map[string]interface{}{
+					"line":      startLine,
+					"character": startChar,
+				},
+				"end": map[string]interface{}{
+					"line":      endLine,
+					"character": endChar,
+				},
+			},
+			"text": text,
+		}},
+	}
+}
+
+func labels(result interface{}) map[string]bool {
+	labels := make(map[string]bool)
+	for _, item := range result.([]interface{}) {
+		labels[item.(map[string]interface{})["label"].(string)] = true
+	}
+	return labels
+}
+
+func TestServer(t *testing.T) {
+	c, done := startServer(t)
+
+	result := c.call("initialize", map[string]interface{}{})
+	capabilities := result.(map[string]interface{})["capabilities"]
+	require.Equal(t, float64(2),
+		capabilities.(map[string]interface{})["textDocumentSync"])
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        testURI,
+			"languageId": "tengo",
+			"version":    1,
+			"text": `fmt := import("fmt")
+x := 1
+f := func(a) { return a + x }
+y := f(x)
+`,
+		},
+	})
+	require.Equal(t, 0, len(c.diagnostics()))
+
+	// definition of x in the function
+	result = c.call("textDocument/definition", at(2, 26))
+	loc := result.(map[string]interface{})
+	require.Equal(t, testURI, loc["uri"])
+	r := loc["range"].(map[string]interface{})
+	require.Equal(t, "1:0", position(r["start"]))
+	require.Equal(t, "1:1", position(r["end"]))
+	require.Nil(t, c.call("textDocument/definition", at(1, 3)))
+
+	// hover
+	result = c.call("textDocument/hover", at(2, 26))
+	contents := result.(map[string]interface{})["contents"]
+	value := contents.(map[string]interface{})["value"].(string)
+	require.True(t, strings.Contains(value, "global variable"), "%s", value)
+	result = c.call("textDocument/hover", at(2, 22))
+	contents = result.(map[string]interface{})["contents"]
+	value = contents.(map[string]interface{})["value"].(string)
+	require.True(t, strings.Contains(value, "parameter"), "%s", value)
+	result = c.call("textDocument/hover", at(0, 0))
+	contents = result.(map[string]interface{})["contents"]
+	value = contents.(map[string]interface{})["value"].(string)
+	require.True(t, strings.Contains(value, `fmt := import("fmt")`), "%s", value)
+
+	// completion of the variables, the builtin functions and the members
+	// of the modules
+	items := labels(c.call("textDocument/completion", at(4, 0)))
+	require.True(t, items["x"] && items["f"] && items["fmt"] && items["len"])
+	require.False(t, items["a"])
+	items = labels(c.call("textDocument/completion", at(2, 15)))
+	require.True(t, items["a"])
+
+	// incremental changes
+	c.notify("textDocument/didChange", change(4, 0, 4, 0, "fmt.pr"))
+	require.Equal(t, 0, len(c.diagnostics()))
+	items = labels(c.call("textDocument/completion", at(4, 6)))
+	require.True(t, items["println"] && items["printf"])
+	require.False(t, items["x"])
+
+	c.notify("textDocument/didChange", change(4, 0, 4, 6, "z := x +"))
+	diags := c.diagnostics()
+	require.Equal(t, 1, len(diags))
+	require.True(t, strings.HasPrefix(diags[0], "4:8: expected operand"),
+		"%s", diags[0])
+
+	c.notify("textDocument/didChange", change(4, 8, 4, 8, " w"))
+	diags = c.diagnostics()
+	require.Equal(t, 1, len(diags))
+	require.Equal(t, "4:9: unresolved reference 'w'", diags[0])
+
+	c.notify("textDocument/didChange", change(4, 9, 4, 10, "y"))
+	require.Equal(t, 0, len(c.diagnostics()))
+	result = c.call("textDocument/definition", at(4, 9))
+	r = result.(map[string]interface{})["range"].(map[string]interface{})
+	require.Equal(t, "3:0", position(r["start"]))
+
+	c.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+	})
+	require.Equal(t, 0, len(c.diagnostics()))
+
+	c.call("shutdown", nil)
+	c.notify("exit", nil)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+)
+
+// RunDAP starts the Debug Adapter Protocol server. If addr is empty, the
+// server speaks the protocol over stdin and stdout. Otherwise, it listens on
+// the TCP address and serves each connection as a debug session.
+func RunDAP(modules *tengo.ModuleMap, addr string) error {
+	if addr == "" {
+		return newDAPSession(modules, os.Stdin, os.Stdout).serve()
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = l.Close() }()
+	_, _ = fmt.Fprintf(os.Stderr, "DAP server listening at %s\n", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s := newDAPSession(modules, conn, conn)
+		if err := s.serve(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "DAP session error: %s\n", err)
+		}
+		_ = conn.Close()
+	}
+}
+
+// dapRequest is a request message of the protocol.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapResponse is a response message of the protocol.
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// dapEvent is an event message of the protocol.
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapSession is a debug session of a single program. The VM runs in its own
+// goroutine and the requests inspecting the VM are executed in the VM
+// goroutine while the VM is stopped.
+type dapSession struct {
+	modules  *tengo.ModuleMap
+	r        *bufio.Reader
+	w        io.Writer
+	wlock    sync.Mutex
+	seq      int
+	bytecode *tengo.Bytecode
+	symbols  *tengo.SymbolTable
+	vm       *tengo.VM
+	debugger *tengo.Debugger
+	entry    bool
+	running  bool
+	lock     sync.Mutex
+	stopped  bool
+	cmds     chan func() bool
+	refs     map[int]tengo.Object
+	done     chan struct{}
+}
+
+func newDAPSession(
+	modules *tengo.ModuleMap,
+	r io.Reader,
+	w io.Writer,
+) *dapSession {
+	return &dapSession{
+		modules: modules,
+		r:       bufio.NewReader(r),
+		w:       w,
+		cmds:    make(chan func() bool),
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *dapSession) serve() error {
+	for {
+		req, err := s.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		body, err := s.handle(req)
+		res := &dapResponse{
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Success:    err == nil,
+			Command:    req.Command,
+			Body:       body,
+		}
+		if err != nil {
+			res.Message = err.Error()
+		}
+		s.send(func(seq int) interface{} {
+			res.Seq = seq
+			return res
+		})
+
+		switch req.Command {
+		case "initialize":
+			// configuration requests are accepted after the launch.
+		case "launch":
+			if err == nil {
+				s.event("initialized", nil)
+			}
+		case "disconnect", "terminate":
+			return nil
+		}
+	}
+}
+
+func (s *dapSession) handle(req *dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args.Program, args.StopOnEntry)
+	case "setBreakpoints":
+		var args struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if s.debugger == nil {
+			return nil, errors.New("program not launched")
+		}
+		s.debugger.ClearBreakpoints(args.Source.Path)
+		breakpoints := []map[string]interface{}{}
+		for _, bp := range args.Breakpoints {
+			line, ok := s.debugger.SetBreakpoint(args.Source.Path, bp.Line)
+			if !ok {
+				line = bp.Line
+			}
+			breakpoints = append(breakpoints, map[string]interface{}{
+				"verified": ok,
+				"line":     line,
+			})
+		}
+		return map[string]interface{}{"breakpoints": breakpoints}, nil
+	case "setExceptionBreakpoints":
+		return nil, nil
+	case "configurationDone":
+		if s.vm == nil {
+			return nil, errors.New("program not launched")
+		}
+		if !s.running {
+			s.running = true
+			go s.run()
+		}
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": 1, "name": "main"}},
+		}, nil
+	case "stackTrace":
+		var frames []map[string]interface{}
+		err := s.inVM(func() {
+			for i, f := range s.debugger.Frames() {
+				frames = append(frames, map[string]interface{}{
+					"id":   i,
+					"name": s.frameName(f.Fn),
+					"source": dapSource{
+						Name: filepath.Base(f.Pos.Filename),
+						Path: f.Pos.Filename,
+					},
+					"line":   f.Pos.Line,
+					"column": f.Pos.Column,
+				})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"stackFrames": frames,
+			"totalFrames": len(frames),
+		}, nil
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		var scopes []map[string]interface{}
+		err := s.inVM(func() {
+			scopes = []map[string]interface{}{
+				s.scope("Locals", s.debugger.Locals(args.FrameID)),
+				s.scope("Free Variables", s.debugger.FreeVars(args.FrameID)),
+				s.scope("Globals", s.debugger.Globals()),
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"scopes": scopes}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		variables := []dapVariable{}
+		err := s.inVM(func() {
+			variables = append(variables,
+				s.children(s.refs[args.VariablesReference])...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": variables}, nil
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		var (
+			res     dapVariable
+			evalErr error
+		)
+		err := s.inVM(func() {
+			var o tengo.Object
+			if o, evalErr = s.evaluate(args.FrameID, args.Expression); evalErr == nil {
+				res = s.variable("", o)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if evalErr != nil {
+			return nil, evalErr
+		}
+		return map[string]interface{}{
+			"result":             res.Value,
+			"type":               res.Type,
+			"variablesReference": res.VariablesReference,
+		}, nil
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true},
+			s.resume(s.debugger.Continue)
+	case "next":
+		return nil, s.resume(s.debugger.StepOver)
+	case "stepIn":
+		return nil, s.resume(s.debugger.StepInto)
+	case "stepOut":
+		return nil, s.resume(s.debugger.StepOut)
+	case "pause":
+		if s.debugger != nil {
+			s.debugger.Pause()
+		}
+		return nil, nil
+	case "disconnect", "terminate":
+		if s.running {
+			s.vm.Abort()
+			_ = s.resume(func() {})
+			<-s.done
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported command: %s", req.Command)
+}
+
+func (s *dapSession) launch(program string, stopOnEntry bool) error {
+	program, err := filepath.Abs(program)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(program)
+	if err != nil {
+		return err
+	}
+	if len(src) > 1 && string(src[:2]) == "#!" {
+		copy(src, "//")
+	}
+
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(program, -1, len(src))
+	p := parser.NewParser(srcFile, src, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return err
+	}
+
+	s.symbols = tengo.NewSymbolTable()
+	c := tengo.NewCompiler(srcFile, s.symbols, nil, s.modules, nil)
+	c.EnableFileImport(true)
+	if resolvePath {
+		c.SetImportDir(filepath.Dir(program))
+	}
+	if err := c.Compile(file); err != nil {
+		return err
+	}
+
+	s.bytecode = c.Bytecode()
+	s.vm = tengo.NewVM(s.bytecode, nil, -1)
+	s.debugger = tengo.NewDebugger(s.vm, s.symbols, s.onStop)
+	if stopOnEntry {
+		s.entry = true
+		s.debugger.StepInto()
+	}
+	return nil
+}
+
+func (s *dapSession) run() {
+	defer close(s.done)
+
+	// the script output is sent as output events so that it's not mixed
+	// with the protocol messages.
+	r, w, err := os.Pipe()
+	if err != nil {
+		s.output("stderr", err.Error()+"\n")
+		s.event("terminated", nil)
+		return
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	forwarded := make(chan struct{})
+	go func() {
+		s.forwardOutput(r)
+		close(forwarded)
+	}()
+
+	err = s.vm.Run()
+
+	os.Stdout = stdout
+	_ = w.Close()
+	<-forwarded
+	_ = r.Close()
+
+	exitCode := 0
+	if err != nil {
+		s.output("stderr", err.Error()+"\n")
+		exitCode = 1
+	}
+	s.event("exited", map[string]interface{}{"exitCode": exitCode})
+	s.event("terminated", nil)
+}
+
+// onStop is called in the VM goroutine when the debugger stops the VM. It
+// executes the commands until one of them resumes the VM.
+func (s *dapSession) onStop(_ *tengo.Debugger, reason tengo.StopReason) {
+	s.refs = make(map[int]tengo.Object)
+
+	r := reason.String()
+	if s.entry {
+		r = "entry"
+		s.entry = false
+	}
+
+	s.lock.Lock()
+	s.stopped = true
+	s.lock.Unlock()
+	s.event("stopped", map[string]interface{}{
+		"reason":            r,
+		"threadId":          1,
+		"allThreadsStopped": true,
+	})
+
+	for cmd := range s.cmds {
+		if cmd() {
+			return
+		}
+	}
+}
+
+// inVM executes fn in the VM goroutine while the VM is stopped.
+func (s *dapSession) inVM(fn func()) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.stopped {
+		return errors.New("program is not stopped")
+	}
+	done := make(chan struct{})
+	s.cmds <- func() bool {
+		fn()
+		close(done)
+		return false
+	}
+	<-done
+	return nil
+}
+
+// resume resumes the stopped VM after calling fn in the VM goroutine.
+func (s *dapSession) resume(fn func()) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.stopped {
+		return errors.New("program is not stopped")
+	}
+	s.stopped = false
+	s.cmds <- func() bool {
+		fn()
+		return true
+	}
+	return nil
+}
+
+func (s *dapSession) frameName(fn *tengo.CompiledFunction) string {
+	if fn == s.bytecode.MainFunction {
+		return "<main>"
+	}
+	return "<function>"
+}
+
+func (s *dapSession) scope(
+	name string,
+	vars map[string]tengo.Object,
+) map[string]interface{} {
+	ref := len(s.refs) + 1
+	s.refs[ref] = &tengo.Map{Value: vars}
+	return map[string]interface{}{
+		"name":               name,
+		"variablesReference": ref,
+		"expensive":          false,
+	}
+}
+
+func (s *dapSession) variable(name string, o tengo.Object) dapVariable {
+	v := dapVariable{
+		Name:  name,
+		Value: o.String(),
+		Type:  o.TypeName(),
+	}
+	switch o.(type) {
+	case *tengo.Array, *tengo.ImmutableArray, *tengo.Map, *tengo.ImmutableMap:
+		v.VariablesReference = len(s.refs) + 1
+		s.refs[v.VariablesReference] = o
+	}
+	return v
+}
+
+func (s *dapSession) children(o tengo.Object) (vars []dapVariable) {
+	var (
+		arr []tengo.Object
+		m   map[string]tengo.Object
+	)
+	switch o := o.(type) {
+	case *tengo.Array:
+		arr = o.Value
+	case *tengo.ImmutableArray:
+		arr = o.Value
+	case *tengo.Map:
+		m = o.Value
+	case *tengo.ImmutableMap:
+		m = o.Value
+	}
+	for i, e := range arr {
+		vars = append(vars, s.variable(strconv.Itoa(i), e))
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vars = append(vars, s.variable(k, m[k]))
+	}
+	return
+}
+
+// evaluate evaluates the expression with the variables visible in the
+// frame. Changes to the variables are not reflected to the program.
+func (s *dapSession) evaluate(frame int, expr string) (tengo.Object, error) {
+	vars := make(map[string]tengo.Object)
+	for _, m := range []map[string]tengo.Object{
+		s.debugger.Globals(),
+		s.debugger.FreeVars(frame),
+		s.debugger.Locals(frame),
+	} {
+		for name, o := range m {
+			vars[name] = o
+		}
+	}
+
+	src := []byte("__eval__ = (" + strings.TrimSpace(expr) + ")")
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("eval", -1, len(src))
+	p := parser.NewParser(srcFile, src, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	globals := make([]tengo.Object, tengo.GlobalsSize)
+	symbols := tengo.NewSymbolTable()
+	for idx, fn := range tengo.GetAllBuiltinFunctions() {
+		symbols.DefineBuiltin(idx, fn.Name)
+	}
+	for name, o := range vars {
+		globals[symbols.Define(name).Index] = o
+	}
+	result := symbols.Define("__eval__")
+
+	// compiled functions of the program refer to its constants.
+	constants := append([]tengo.Object(nil), s.bytecode.Constants...)
+	c := tengo.NewCompiler(srcFile, symbols, constants, s.modules, nil)
+	if err := c.Compile(file); err != nil {
+		return nil, err
+	}
+	if err := tengo.NewVM(c.Bytecode(), globals, -1).Run(); err != nil {
+		return nil, err
+	}
+	return globals[result.Index], nil
+}
+
+func (s *dapSession) read() (*dapRequest, error) {
+	length := -1
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length < 0 {
+				continue
+			}
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(
+				strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header: %s", line)
+			}
+		}
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return nil, err
+	}
+	msg := &dapRequest{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// send writes the message built with the next sequence number.
+func (s *dapSession) send(msg func(seq int) interface{}) {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+
+	s.seq++
+	data, err := json.Marshal(msg(s.seq))
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *dapSession) event(event string, body interface{}) {
+	s.send(func(seq int) interface{} {
+		return &dapEvent{Seq: seq, Type: "event", Event: event, Body: body}
+	})
+}
+
+func (s *dapSession) output(category, output string) {
+	s.event("output", map[string]interface{}{
+		"category": category,
+		"output":   output,
+	})
+}
+
+func (s *dapSession) forwardOutput(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			s.output("stdout", line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
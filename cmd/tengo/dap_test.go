@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/v2/require"
+)
+
+// dapClient speaks the protocol to a session over in-memory pipes.
+type dapClient struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	seq    int
+	events []map[string]interface{}
+}
+
+func (c *dapClient) read() map[string]interface{} {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		require.NoError(c.t, err)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		length, err = strconv.Atoi(
+			strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		require.NoError(c.t, err)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(c.r, data)
+	require.NoError(c.t, err)
+	msg := make(map[string]interface{})
+	require.NoError(c.t, json.Unmarshal(data, &msg))
+	return msg
+}
+
+// request sends the request and returns the response, keeping the events
+// received before the response.
+func (c *dapClient) request(
+	command string,
+	args interface{},
+) map[string]interface{} {
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	require.NoError(c.t, err)
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	require.NoError(c.t, err)
+
+	for {
+		msg := c.read()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		require.Equal(c.t, "response", msg["type"])
+		require.Equal(c.t, float64(c.seq), msg["request_seq"])
+		require.Equal(c.t, command, msg["command"])
+		return msg
+	}
+}
+
+// body sends the request and returns the body of the successful response.
+func (c *dapClient) body(
+	command string,
+	args interface{},
+) map[string]interface{} {
+	res := c.request(command, args)
+	require.Equal(c.t, true, res["success"], "%v", res["message"])
+	body, _ := res["body"].(map[string]interface{})
+	return body
+}
+
+// event returns the body of the next event, which must be of the kind.
+func (c *dapClient) event(event string) map[string]interface{} {
+	var msg map[string]interface{}
+	if len(c.events) > 0 {
+		msg, c.events = c.events[0], c.events[1:]
+	} else {
+		msg = c.read()
+	}
+	require.Equal(c.t, "event", msg["type"])
+	require.Equal(c.t, event, msg["event"], "%v", msg)
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+func startDAPSession(t *testing.T) (*dapClient, chan error) {
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := newDAPSession(nil, reqR, resW).serve()
+		_ = resW.Close()
+		done <- err
+	}()
+	return &dapClient{t: t, w: reqW, r: bufio.NewReader(resR)}, done
+}
+
+// writeProgram writes the source to a temporary file. It returns the path
+// and the function removing it.
+func writeProgram(t *testing.T, src string) (string, func()) {
+	dir, err := ioutil.TempDir("", "tengo-dap")
+	require.NoError(t, err)
+	program := filepath.Join(dir, "main.tengo")
+	require.NoError(t, ioutil.WriteFile(program, []byte(src), 0644))
+	return program, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestDAPSession(t *testing.T) {
+	program, cleanup := writeProgram(t, `a := 1
+f := func(x) {
+	y := x * 2
+	return y
+}
+b := f(a)
+m := {k: [1, 2]}
+`)
+	defer cleanup()
+	c, done := startDAPSession(t)
+
+	body := c.body("initialize", map[string]interface{}{"adapterID": "tengo"})
+	require.Equal(t, true, body["supportsConfigurationDoneRequest"])
+
+	// configuration requests are rejected before the launch
+	res := c.request("setBreakpoints", map[string]interface{}{
+		"source": map[string]interface{}{"path": program},
+	})
+	require.Equal(t, false, res["success"])
+	require.Equal(t, "program not launched", res["message"])
+
+	c.body("launch", map[string]interface{}{"program": program})
+	c.event("initialized")
+
+	body = c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": []map[string]interface{}{{"line": 4}},
+	})
+	breakpoints := body["breakpoints"].([]interface{})
+	require.Equal(t, 1, len(breakpoints))
+	bp := breakpoints[0].(map[string]interface{})
+	require.Equal(t, true, bp["verified"])
+	require.Equal(t, float64(4), bp["line"])
+
+	c.body("configurationDone", nil)
+	body = c.event("stopped")
+	require.Equal(t, "breakpoint", body["reason"])
+
+	// the function frame is on top of the main frame
+	body = c.body("stackTrace", map[string]interface{}{"threadId": 1})
+	frames := body["stackFrames"].([]interface{})
+	require.Equal(t, 2, len(frames))
+	top := frames[0].(map[string]interface{})
+	require.Equal(t, "<function>", top["name"])
+	require.Equal(t, float64(4), top["line"])
+	require.Equal(t, program,
+		top["source"].(map[string]interface{})["path"])
+	main := frames[1].(map[string]interface{})
+	require.Equal(t, "<main>", main["name"])
+	require.Equal(t, float64(6), main["line"])
+
+	body = c.body("scopes", map[string]interface{}{"frameId": 0})
+	scopes := body["scopes"].([]interface{})
+	require.Equal(t, 3, len(scopes))
+	refs := make(map[string]float64)
+	for _, scope := range scopes {
+		scope := scope.(map[string]interface{})
+		refs[scope["name"].(string)] = scope["variablesReference"].(float64)
+	}
+
+	locals := variables(c, refs["Locals"])
+	require.Equal(t, "1", locals["x"]["value"])
+	require.Equal(t, "2", locals["y"]["value"])
+	require.Equal(t, "int", locals["y"]["type"])
+	globals := variables(c, refs["Globals"])
+	require.Equal(t, "1", globals["a"]["value"])
+	require.Equal(t, "compiled-function", globals["f"]["type"])
+
+	body = c.body("evaluate", map[string]interface{}{
+		"expression": "x + y * 10",
+		"frameId":    0,
+	})
+	require.Equal(t, "21", body["result"])
+	require.Equal(t, "int", body["type"])
+	res = c.request("evaluate", map[string]interface{}{
+		"expression": "x +",
+		"frameId":    0,
+	})
+	require.Equal(t, false, res["success"])
+
+	// step out of the function and inspect the nested values
+	c.body("next", map[string]interface{}{"threadId": 1})
+	body = c.event("stopped")
+	require.Equal(t, "step", body["reason"])
+	body = c.body("stackTrace", map[string]interface{}{"threadId": 1})
+	frames = body["stackFrames"].([]interface{})
+	require.Equal(t, 1, len(frames))
+	require.Equal(t, float64(7), frames[0].(map[string]interface{})["line"])
+
+	body = c.body("evaluate", map[string]interface{}{
+		"expression": "{k: [b, 3]}",
+		"frameId":    0,
+	})
+	require.Equal(t, "map", body["type"])
+	values := variables(c, body["variablesReference"].(float64))
+	require.Equal(t, "array", values["k"]["type"])
+	elems := variables(c, values["k"]["variablesReference"].(float64))
+	require.Equal(t, "2", elems["0"]["value"])
+	require.Equal(t, "3", elems["1"]["value"])
+
+	// continue to the exit
+	c.body("continue", map[string]interface{}{"threadId": 1})
+	body = c.event("exited")
+	require.Equal(t, float64(0), body["exitCode"])
+	c.event("terminated")
+
+	res = c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	require.Equal(t, false, res["success"])
+	require.Equal(t, "program is not stopped", res["message"])
+
+	c.body("disconnect", nil)
+	require.NoError(t, <-done)
+}
+
+func TestDAPSession_StopOnEntry(t *testing.T) {
+	program, cleanup := writeProgram(t, "a := 1\nb := a + \"x\"\n")
+	defer cleanup()
+	c, done := startDAPSession(t)
+
+	c.body("initialize", nil)
+	c.body("launch", map[string]interface{}{
+		"program":     program,
+		"stopOnEntry": true,
+	})
+	c.event("initialized")
+	c.body("configurationDone", nil)
+	body := c.event("stopped")
+	require.Equal(t, "entry", body["reason"])
+
+	// the runtime error is reported as an output event
+	c.body("continue", map[string]interface{}{"threadId": 1})
+	body = c.event("output")
+	require.Equal(t, "stderr", body["category"])
+	require.True(t, strings.Contains(body["output"].(string),
+		"invalid operation"))
+	body = c.event("exited")
+	require.Equal(t, float64(1), body["exitCode"])
+	c.event("terminated")
+
+	res := c.request("launch", map[string]interface{}{
+		"program": filepath.Join(filepath.Dir(program), "missing.tengo"),
+	})
+	require.Equal(t, false, res["success"])
+	res = c.request("unknown", nil)
+	require.Equal(t, "unsupported command: unknown", res["message"])
+
+	c.body("disconnect", nil)
+	require.NoError(t, <-done)
+}
+
+// variables returns the variables of the reference by their names.
+func variables(
+	c *dapClient,
+	ref float64,
+) map[string]map[string]interface{} {
+	body := c.body("variables", map[string]interface{}{
+		"variablesReference": ref,
+	})
+	vars := make(map[string]map[string]interface{})
+	for _, v := range body["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		vars[v["name"].(string)] = v
+	}
+	return vars
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/d5/tengo/v2/format"
+)
+
+// RunFormat formats the source files in args. The directories in args are
+// walked for the source files. The formatted source code is written to the
+// standard output unless -w flag is given.
+func RunFormat(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "Write result to source file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	files, err := sourceFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := formatFile(file, *write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceFiles returns the files in args. The directories in args are walked
+// for the files with the source file extension.
+func sourceFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(
+			path string,
+			info os.FileInfo,
+			err error,
+		) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(path) == sourceFileExt {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func formatFile(path string, write bool) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	res, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if !write {
+		_, err = os.Stdout.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, res, info.Mode())
+}
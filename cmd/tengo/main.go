@@ -26,6 +26,9 @@ var (
 	showHelp      bool
 	showVersion   bool
 	resolvePath   bool // TODO Remove this flag at version 3
+	dapMode       bool
+	dapAddr       string
+	profileOutput string
 	version       = "dev"
 )
 
@@ -35,10 +38,15 @@ func init() {
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.BoolVar(&resolvePath, "resolve", false,
 		"Resolve relative import paths")
-	flag.Parse()
+	flag.BoolVar(&dapMode, "dap", false, "Start Debug Adapter Protocol server")
+	flag.StringVar(&dapAddr, "dap-addr", "",
+		"TCP address of Debug Adapter Protocol server (default: stdio)")
+	flag.StringVar(&profileOutput, "profile", "",
+		"Write pprof profile of the execution to file")
 }
 
 func main() {
+	flag.Parse()
 	if showHelp {
 		doHelp()
 		os.Exit(2)
@@ -47,7 +55,37 @@ func main() {
 		return
 	}
 
+	if flag.Arg(0) == "fmt" {
+		if err := RunFormat(flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	modules := stdlib.GetModuleMap(stdlib.AllModuleNames()...)
+	if flag.Arg(0) == "vet" {
+		if err := RunVet(modules, flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "test" {
+		if err := RunTest(modules, flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if dapMode {
+		if err := RunDAP(modules, dapAddr); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	inputFile := flag.Arg(0)
 	if inputFile == "" {
 		// REPL
@@ -141,7 +179,7 @@ func CompileAndRun(
 	}
 
 	machine := tengo.NewVM(bytecode, nil, -1)
-	err = machine.Run()
+	err = runProfiled(machine)
 	return
 }
 
@@ -154,10 +192,35 @@ func RunCompiled(modules *tengo.ModuleMap, data []byte) (err error) {
 	}
 
 	machine := tengo.NewVM(bytecode, nil, -1)
-	err = machine.Run()
+	err = runProfiled(machine)
 	return
 }
 
+// runProfiled runs the VM. If -profile flag is given, the execution is
+// profiled and the profile is written to the file.
+func runProfiled(machine *tengo.VM) error {
+	if profileOutput == "" {
+		return machine.Run()
+	}
+
+	profiler := tengo.NewProfiler(0)
+	machine.SetProfiler(profiler)
+	runErr := machine.Run()
+
+	out, err := os.Create(profileOutput)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WritePprof(out); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return runErr
+}
+
 // RunREPL starts REPL.
 func RunREPL(modules *tengo.ModuleMap, in io.Reader, out io.Writer) {
 	stdin := bufio.NewScanner(in)
@@ -255,11 +318,17 @@ func doHelp() {
 	fmt.Println("Usage:")
 	fmt.Println()
 	fmt.Println("	tengo [flags] {input-file}")
+	fmt.Println("	tengo fmt [-w] {input-files}")
+	fmt.Println("	tengo vet [-json] [-disable rules] {input-files}")
+	fmt.Println("	tengo test [-v] [-run regexp] [-json] [input-files]")
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println()
 	fmt.Println("	-o        compile output file")
 	fmt.Println("	-version  show version")
+	fmt.Println("	-dap      start Debug Adapter Protocol server")
+	fmt.Println("	-dap-addr TCP address of Debug Adapter Protocol server")
+	fmt.Println("	-profile  write pprof profile of the execution to file")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println()
@@ -280,6 +349,26 @@ func doHelp() {
 	fmt.Println()
 	fmt.Println("	          Run bytecode file (myapp)")
 	fmt.Println()
+	fmt.Println("	tengo -dap -dap-addr 127.0.0.1:4711")
+	fmt.Println()
+	fmt.Println("	          Start Debug Adapter Protocol server on TCP port 4711")
+	fmt.Println()
+	fmt.Println("	tengo -profile cpu.pprof myapp.tengo")
+	fmt.Println()
+	fmt.Println("	          Run source file (myapp.tengo) and write its profile (cpu.pprof)")
+	fmt.Println()
+	fmt.Println("	tengo fmt -w myapp.tengo")
+	fmt.Println()
+	fmt.Println("	          Format source file (myapp.tengo) in place")
+	fmt.Println()
+	fmt.Println("	tengo vet -json myapp.tengo")
+	fmt.Println()
+	fmt.Println("	          Report suspicious code in source file (myapp.tengo) in JSON")
+	fmt.Println()
+	fmt.Println("	tengo test -v ./tests")
+	fmt.Println()
+	fmt.Println("	          Run test functions of test files (*_test.tengo) in directory")
+	fmt.Println()
 	fmt.Println()
 }
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/tester"
+)
+
+// RunTest runs the test functions of the test files in args. The
+// directories in args are walked for the test files. The current directory
+// is used if args has no files. It returns an error if any test fails.
+func RunTest(modules *tengo.ModuleMap, args []string) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "Write the results of all tests")
+	run := flags.String("run", "", "Run only the tests matching the regexp")
+	jsonOutput := flags.Bool("json", false, "Write results in JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	r := tester.NewRunner(modules)
+	r.EnableFileImport(true)
+	if err := r.SetFilter(*run); err != nil {
+		return err
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := testFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no test files")
+	}
+
+	var failed bool
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if len(src) > 1 && string(src[:2]) == "#!" {
+			copy(src, "//")
+		}
+		dir, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		r.SetImportDir(dir)
+
+		res := r.Run(file, src)
+		if *jsonOutput {
+			err = res.WriteJSON(os.Stdout)
+		} else {
+			err = res.WriteReport(os.Stdout, *verbose)
+		}
+		if err != nil {
+			return err
+		}
+		failed = failed || res.Failed()
+	}
+	if failed {
+		return fmt.Errorf("FAIL")
+	}
+	return nil
+}
+
+// testFiles returns the files in paths. The directories in paths are walked
+// for the test files.
+func testFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(
+			path string,
+			info os.FileInfo,
+			err error,
+		) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && tester.IsTestFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
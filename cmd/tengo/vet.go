@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/lint"
+)
+
+// RunVet checks the source files in args with the linter and writes the
+// issues to the standard output. It returns an error if any issue is found.
+func RunVet(modules *tengo.ModuleMap, args []string) error {
+	flags := flag.NewFlagSet("vet", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Write issues in JSON")
+	disable := flags.String("disable", "",
+		"Comma-separated list of rules to disable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	l := lint.NewLinter(modules)
+	l.EnableFileImport(true)
+	if *disable != "" {
+		for _, rule := range strings.Split(*disable, ",") {
+			if !isLintRule(rule) {
+				return fmt.Errorf("unknown rule: %s", rule)
+			}
+			l.Disable(rule)
+		}
+	}
+
+	files, err := sourceFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	issues := []*lint.Issue{}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if len(src) > 1 && string(src[:2]) == "#!" {
+			copy(src, "//")
+		}
+		dir, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		l.SetImportDir(dir)
+		res, err := l.LintSource(file, src)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, res...)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue.String())
+		}
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%d issue(s) found", len(issues))
+	}
+	return nil
+}
+
+func isLintRule(name string) bool {
+	for _, rule := range lint.AllRules() {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
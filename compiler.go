@@ -4,14 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/d5/tengo/v2/parser"
 	"github.com/d5/tengo/v2/token"
+	"github.com/d5/tengo/v2/vfs"
 )
 
 // compilationScope represents a compiled instructions and the last two
@@ -20,6 +23,9 @@ type compilationScope struct {
 	Instructions []byte
 	SymbolInit   map[string]bool
 	SourceMap    map[int]parser.Pos
+	Locals       []LocalVar
+	Branches     []int
+	Generator    bool
 }
 
 // loop represents a loop construct that the compiler uses to track the current
@@ -27,6 +33,14 @@ type compilationScope struct {
 type loop struct {
 	Continues []int
 	Breaks    []int
+	NumTries  int
+}
+
+// tryBlock represents an active try handler that the compiler uses to leave
+// try blocks on return, break and continue.
+type tryBlock struct {
+	Finally    *parser.BlockStmt
+	ScopeIndex int
 }
 
 // CompilerError represents a compiler error.
@@ -55,8 +69,11 @@ type Compiler struct {
 	modules         ModuleGetter
 	compiledModules map[string]*CompiledFunction
 	allowFileImport bool
+	fs              vfs.FS
 	loops           []*loop
 	loopIndex       int
+	tries           []*tryBlock
+	blockStarts     []int
 	trace           io.Writer
 	indent          int
 }
@@ -138,7 +155,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return err
 		}
 	case *parser.BinaryExpr:
-		if node.Token == token.LAnd || node.Token == token.LOr {
+		if node.Token == token.LAnd || node.Token == token.LOr ||
+			node.Token == token.Coalesce {
 			return c.compileLogical(node)
 		}
 		if node.Token == token.Less {
@@ -253,10 +271,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 	case *parser.IfStmt:
 		// open new symbol table for the statement
-		c.symbolTable = c.symbolTable.Fork(true)
-		defer func() {
-			c.symbolTable = c.symbolTable.Parent(false)
-		}()
+		c.enterBlock()
+		defer c.leaveBlock()
 
 		if node.Init != nil {
 			if err := c.Compile(node.Init); err != nil {
@@ -269,6 +285,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 		// first jump placeholder
 		jumpPos1 := c.emit(node, parser.OpJumpFalsy, 0)
+		c.addBranch(jumpPos1)
 		if err := c.Compile(node.Body); err != nil {
 			return err
 		}
@@ -295,12 +312,19 @@ func (c *Compiler) Compile(node parser.Node) error {
 		return c.compileForStmt(node)
 	case *parser.ForInStmt:
 		return c.compileForInStmt(node)
+	case *parser.TryStmt:
+		return c.compileTryStmt(node)
+	case *parser.SwitchStmt:
+		return c.compileSwitchStmt(node)
 	case *parser.BranchStmt:
 		if node.Token == token.Break {
 			curLoop := c.currentLoop()
 			if curLoop == nil {
 				return c.errorf(node, "break not allowed outside loop")
 			}
+			if err := c.leaveTries(node, curLoop.NumTries); err != nil {
+				return err
+			}
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Breaks = append(curLoop.Breaks, pos)
 		} else if node.Token == token.Continue {
@@ -308,6 +332,9 @@ func (c *Compiler) Compile(node parser.Node) error {
 			if curLoop == nil {
 				return c.errorf(node, "continue not allowed outside loop")
 			}
+			if err := c.leaveTries(node, curLoop.NumTries); err != nil {
+				return err
+			}
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Continues = append(curLoop.Continues, pos)
 		} else {
@@ -319,10 +346,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return nil
 		}
 
-		c.symbolTable = c.symbolTable.Fork(true)
-		defer func() {
-			c.symbolTable = c.symbolTable.Parent(false)
-		}()
+		c.enterBlock()
+		defer c.leaveBlock()
 
 		for _, stmt := range node.Stmts {
 			if err := c.Compile(stmt); err != nil {
@@ -330,6 +355,9 @@ func (c *Compiler) Compile(node parser.Node) error {
 			}
 		}
 	case *parser.AssignStmt:
+		if isDestructuring(node.LHS) {
+			return c.compileDestructuringAssign(node)
+		}
 		err := c.compileAssign(node, node.LHS, node.RHS, node.Token)
 		if err != nil {
 			return err
@@ -351,62 +379,16 @@ func (c *Compiler) Compile(node parser.Node) error {
 			c.emit(node, parser.OpGetFree, symbol.Index)
 		}
 	case *parser.ArrayLit:
-		for _, elem := range node.Elements {
-			if err := c.Compile(elem); err != nil {
-				return err
-			}
-		}
-		c.emit(node, parser.OpArray, len(node.Elements))
+		return c.compileArrayLit(node)
 	case *parser.MapLit:
-		for _, elt := range node.Elements {
-			// key
-			if len(elt.Key) > MaxStringLen {
-				return c.error(node, ErrStringLimit)
-			}
-			c.emit(node, parser.OpConstant,
-				c.addConstant(&String{Value: elt.Key}))
-			// value
-			if err := c.Compile(elt.Value); err != nil {
-				return err
-			}
-		}
-		c.emit(node, parser.OpMap, len(node.Elements)*2)
+		return c.compileMapLit(node)
 
 	case *parser.SelectorExpr: // selector on RHS side
-		if err := c.Compile(node.Expr); err != nil {
-			return err
-		}
-		if err := c.Compile(node.Sel); err != nil {
-			return err
-		}
-		c.emit(node, parser.OpIndex)
+		return c.compileChain(node)
 	case *parser.IndexExpr:
-		if err := c.Compile(node.Expr); err != nil {
-			return err
-		}
-		if err := c.Compile(node.Index); err != nil {
-			return err
-		}
-		c.emit(node, parser.OpIndex)
+		return c.compileChain(node)
 	case *parser.SliceExpr:
-		if err := c.Compile(node.Expr); err != nil {
-			return err
-		}
-		if node.Low != nil {
-			if err := c.Compile(node.Low); err != nil {
-				return err
-			}
-		} else {
-			c.emit(node, parser.OpNull)
-		}
-		if node.High != nil {
-			if err := c.Compile(node.High); err != nil {
-				return err
-			}
-		} else {
-			c.emit(node, parser.OpNull)
-		}
-		c.emit(node, parser.OpSliceIndex)
+		return c.compileChain(node)
 	case *parser.FuncLit:
 		c.enterScope()
 
@@ -425,12 +407,16 @@ func (c *Compiler) Compile(node parser.Node) error {
 				args = args[0 : len(args)-1]
 			}
 
-			for _, p := range args {
-				s := c.symbolTable.Define(p.Name)
+			for i := range args {
+				s := c.symbolTable.Define(paramName(node.Type.Params.Args, i))
 				// function arguments is not assigned directly.
 				s.LocalAssigned = true
 			}
 
+			if compiledFunction.VarArgs.Valid {
+				compiledFunction.VarArgs.Name = paramName(
+					node.Type.Params.Args, len(args))
+			}
 			if compiledFunction.VarArgs.Name != "" {
 				s := c.symbolTable.Define(compiledFunction.VarArgs.Name)
 				// function arguments is not assigned directly.
@@ -511,16 +497,36 @@ func (c *Compiler) Compile(node parser.Node) error {
 			node.Body.Stmts = append(stmts, node.Body.Stmts...)
 		}
 
+		// destructure the arguments
+		for i, pattern := range node.Type.Params.Args.Patterns {
+			if pattern == nil {
+				continue
+			}
+			err := c.Compile(&parser.Ident{
+				Name:    paramName(node.Type.Params.Args, i),
+				NamePos: pattern.Pos(),
+			})
+			if err != nil {
+				return err
+			}
+			err = c.compileDestructuring(pattern, pattern, token.Define)
+			if err != nil {
+				return err
+			}
+		}
+
 		if err := c.Compile(node.Body); err != nil {
 			return err
 		}
 
 		// code optimization
 		c.optimizeFunc(node)
+		c.addLocals(0)
 
 		freeSymbols := c.symbolTable.FreeSymbols()
 		compiledFunction.NumLocals = c.symbolTable.MaxSymbols()
-		instructions, sourceMap := c.leaveScope()
+		compiledFunction.IsGenerator = c.scopes[c.scopeIndex].Generator
+		instructions, sourceMap, locals, branches := c.leaveScope()
 
 		for _, s := range freeSymbols {
 			switch s.Scope {
@@ -582,6 +588,12 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 		compiledFunction.Instructions = instructions
 		compiledFunction.SourceMap = sourceMap
+		compiledFunction.Locals = locals
+		compiledFunction.Branches = branches
+		for _, s := range freeSymbols {
+			compiledFunction.FreeNames = append(compiledFunction.FreeNames,
+				s.Name)
+		}
 
 		for i, name := range compiledFunction.KwargsNames {
 			compiledFunction.Kwargs[name] = i
@@ -600,68 +612,34 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 
 		if node.Result == nil {
+			if err := c.leaveTries(node, c.scopeTries()); err != nil {
+				return err
+			}
 			c.emit(node, parser.OpReturn, 0)
 		} else {
 			if err := c.Compile(node.Result); err != nil {
 				return err
 			}
-			c.emit(node, parser.OpReturn, 1)
-		}
-	case *parser.CallExpr:
-		// FUNC
-		// ARGS
-		// VAR ARGS
-		// KWARGS
-		// VAR KWARGS
-		if err := c.Compile(node.Func); err != nil {
-			return err
-		}
-
-		for _, arg := range node.Args.Values {
-			if err := c.Compile(arg); err != nil {
+			if err := c.leaveTries(node, c.scopeTries()); err != nil {
 				return err
 			}
+			c.emit(node, parser.OpReturn, 1)
 		}
-
-		var (
-			normalValues = node.Kwargs.Values
-			hasVarKwargs = node.Kwargs.Ellipsis.IsValid()
-			varArgs,
-			varKwargs,
-			kw int
-		)
-
-		if node.Args.Ellipsis.IsValid() {
-			varArgs = 1
-		}
-
-		if hasVarKwargs {
-			normalValues = normalValues[:len(normalValues)-1]
-		}
-
-		if len(normalValues) > 0 {
-			kw = len(normalValues)
-			kwargs := &parser.MapLit{Elements: make([]*parser.MapElementLit, kw)}
-
-			for i, arg := range normalValues {
-				kwargs.Elements[i] = &parser.MapElementLit{
-					Key:   node.Kwargs.Names[i].Name,
-					Value: arg,
-				}
-			}
-			if err := c.Compile(kwargs); err != nil {
-				return err
-			}
+	case *parser.YieldExpr:
+		if c.symbolTable.Parent(true) == nil {
+			// outside the function
+			return c.errorf(node, "yield not allowed outside function")
 		}
+		c.scopes[c.scopeIndex].Generator = true
 
-		if hasVarKwargs {
-			varKwargs = 1
-			if err := c.Compile(node.Kwargs.Values[len(node.Kwargs.Values)-1]); err != nil {
-				return err
-			}
+		if node.Result == nil {
+			c.emit(node, parser.OpNull)
+		} else if err := c.Compile(node.Result); err != nil {
+			return err
 		}
-
-		c.emit(node, parser.OpCall, len(node.Args.Values)-varArgs, varArgs, kw, varKwargs)
+		c.emit(node, parser.OpYield)
+	case *parser.CallExpr:
+		return c.compileChain(node)
 	case *parser.ImportExpr:
 		if node.ModuleName == "" {
 			return c.errorf(node, "empty module name")
@@ -696,7 +674,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 					err.Error())
 			}
 
-			moduleSrc, err := ioutil.ReadFile(modulePath)
+			moduleSrc, err := vfs.ReadFile(c.fileSystem(), modulePath)
 			if err != nil {
 				return c.errorf(node, "module file read error: %s",
 					err.Error())
@@ -725,6 +703,9 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return err
 		}
 		c.emit(node, parser.OpImmutable)
+		if err := c.leaveTries(node, c.scopeTries()); err != nil {
+			return err
+		}
 		c.emit(node, parser.OpReturn, 1)
 	case *parser.ErrorExpr:
 		if err := c.Compile(node.Expr); err != nil {
@@ -743,6 +724,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 		// first jump placeholder
 		jumpPos1 := c.emit(node, parser.OpJumpFalsy, 0)
+		c.addBranch(jumpPos1)
 		if err := c.Compile(node.True); err != nil {
 			return err
 		}
@@ -771,6 +753,8 @@ func (c *Compiler) Bytecode() *Bytecode {
 		MainFunction: &CompiledFunction{
 			Instructions: append(c.currentInstructions(), parser.OpSuspend),
 			SourceMap:    c.currentSourceMap(),
+			Locals:       c.scopes[c.scopeIndex].Locals,
+			Branches:     c.scopes[c.scopeIndex].Branches,
 		},
 		Constants: c.constants,
 	}
@@ -787,6 +771,19 @@ func (c *Compiler) SetImportDir(dir string) {
 	c.importDir = dir
 }
 
+// SetFS sets the file system to load the module files from. The host file
+// system is used if fsys is nil.
+func (c *Compiler) SetFS(fsys vfs.FS) {
+	c.fs = fsys
+}
+
+func (c *Compiler) fileSystem() vfs.FS {
+	if c.fs == nil {
+		return vfs.OS()
+	}
+	return c.fs
+}
+
 // SetImportFileExt sets the extension name of the source file for loading
 // local module files.
 //
@@ -829,6 +826,9 @@ func (c *Compiler) compileAssign(
 	if numLHS > 1 || numRHS > 1 {
 		return c.errorf(node, "tuple assignment not allowed")
 	}
+	if isOptionalChain(lhs[0]) {
+		return c.errorf(node, "optional chaining not allowed in assignment")
+	}
 
 	// resolve and compile left-hand side
 	ident, selectors := resolveAssignLHS(lhs[0])
@@ -930,105 +930,522 @@ func (c *Compiler) compileAssign(
 	return nil
 }
 
-func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
-	// left side term
-	if err := c.Compile(node.LHS); err != nil {
-		return err
-	}
+// compileArrayLit compiles the array literal. The elements before the first
+// spread element make the array and the elements of the spread values and
+// the following elements are appended to it.
+func (c *Compiler) compileArrayLit(node *parser.ArrayLit) error {
+	var numElements int
+	var spread bool
 
-	// jump position
-	var jumpPos int
-	if node.Token == token.LAnd {
-		jumpPos = c.emit(node, parser.OpAndJump, 0)
-	} else {
-		jumpPos = c.emit(node, parser.OpOrJump, 0)
+	// flush makes the array of the elements on the stack and appends it
+	// to the array made before.
+	flush := func() {
+		if !spread || numElements > 0 {
+			c.emit(node, parser.OpArray, numElements)
+		}
+		if spread && numElements > 0 {
+			c.emit(node, parser.OpSpreadArray)
+		}
 	}
-
-	// right side term
-	if err := c.Compile(node.RHS); err != nil {
-		return err
+	for _, elem := range node.Elements {
+		s, ok := elem.(*parser.SpreadExpr)
+		if !ok {
+			if err := c.Compile(elem); err != nil {
+				return err
+			}
+			numElements++
+			continue
+		}
+		flush()
+		if err := c.Compile(s.Expr); err != nil {
+			return err
+		}
+		c.emit(s, parser.OpSpreadArray)
+		numElements, spread = 0, true
 	}
-
-	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	flush()
 	return nil
 }
 
-func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
-	c.symbolTable = c.symbolTable.Fork(true)
-	defer func() {
-		c.symbolTable = c.symbolTable.Parent(false)
-	}()
+// compileMapLit compiles the map literal. The elements before the first
+// spread element make the map and the entries of the spread values and the
+// following elements are merged into it in order.
+func (c *Compiler) compileMapLit(node *parser.MapLit) error {
+	var numElements int
+	var spread bool
 
-	// init statement
-	if stmt.Init != nil {
-		if err := c.Compile(stmt.Init); err != nil {
-			return err
+	// flush makes the map of the elements on the stack and merges it
+	// into the map made before.
+	flush := func() {
+		if !spread || numElements > 0 {
+			c.emit(node, parser.OpMap, numElements)
+		}
+		if spread && numElements > 0 {
+			c.emit(node, parser.OpSpreadMap)
 		}
 	}
-
-	// pre-condition position
-	preCondPos := len(c.currentInstructions())
-
-	// condition expression
-	postCondPos := -1
-	if stmt.Cond != nil {
-		if err := c.Compile(stmt.Cond); err != nil {
+	for _, elt := range node.Elements {
+		s, ok := elt.Value.(*parser.SpreadExpr)
+		if !ok {
+			// key
+			if len(elt.Key) > MaxStringLen {
+				return c.error(node, ErrStringLimit)
+			}
+			c.emit(node, parser.OpConstant,
+				c.addConstant(&String{Value: elt.Key}))
+			// value
+			if err := c.Compile(elt.Value); err != nil {
+				return err
+			}
+			numElements += 2
+			continue
+		}
+		flush()
+		if err := c.Compile(s.Expr); err != nil {
 			return err
 		}
-		// condition jump position
-		postCondPos = c.emit(stmt, parser.OpJumpFalsy, 0)
+		c.emit(s, parser.OpSpreadMap)
+		numElements, spread = 0, true
 	}
+	flush()
+	return nil
+}
 
-	// enter loop
-	loop := c.enterLoop()
+// compileDestructuringAssign compiles the assignment of the value to the
+// multiple variables or the destructuring pattern on the left side.
+func (c *Compiler) compileDestructuringAssign(node *parser.AssignStmt) error {
+	if len(node.RHS) > 1 {
+		return c.errorf(node, "tuple assignment not allowed")
+	}
 
-	// body statement
-	if err := c.Compile(stmt.Body); err != nil {
-		c.leaveLoop()
+	// a, b := value is same as [a, b] := value
+	pattern := node.LHS[0]
+	if len(node.LHS) > 1 {
+		pattern = &parser.ArrayPattern{Elements: node.LHS}
+	}
+	if err := c.Compile(node.RHS[0]); err != nil {
 		return err
 	}
+	return c.compileDestructuring(node, pattern, node.Token)
+}
 
-	c.leaveLoop()
+// destructuring is the state to compile a destructuring assignment.
+type destructuring struct {
+	op    token.Token
+	temps []*Symbol       // values of the patterns at each depth
+	news  map[string]bool // variables to define by operator ":="
+}
 
-	// post-body position
-	postBodyPos := len(c.currentInstructions())
+// compileDestructuring compiles the assignment of the value on the stack to
+// the destructuring pattern. Operator ":=" defines the variables not defined
+// in the current block yet, and assigns the other ones, but at least one
+// variable must be new.
+func (c *Compiler) compileDestructuring(
+	node parser.Node,
+	pattern parser.Expr,
+	op token.Token,
+) error {
+	d := &destructuring{op: op}
+	if op == token.Define {
+		d.news = make(map[string]bool)
+	}
+	if err := c.checkDestructuring(d, pattern); err != nil {
+		return err
+	}
+	if op == token.Define {
+		var hasNew bool
+		for _, isNew := range d.news {
+			hasNew = hasNew || isNew
+		}
+		if !hasNew {
+			return c.errorf(node, "no new variables on left side of :=")
+		}
+	}
+	return c.compileDestructure(d, pattern, 0)
+}
 
-	// post statement
-	if stmt.Post != nil {
-		if err := c.Compile(stmt.Post); err != nil {
+// checkDestructuring checks the targets of the destructuring pattern and
+// finds the variables to define by operator ":=".
+func (c *Compiler) checkDestructuring(
+	d *destructuring,
+	target parser.Expr,
+) error {
+	switch target := target.(type) {
+	case *parser.Ident:
+		if target.Name == "_" || d.op != token.Define {
+			return nil
+		}
+		if _, ok := d.news[target.Name]; ok {
+			return c.errorf(target, "'%s' redeclared in this block",
+				target.Name)
+		}
+		_, depth, exists := c.symbolTable.Resolve(target.Name, false)
+		d.news[target.Name] = !exists || depth > 0
+		return nil
+	case *parser.SelectorExpr, *parser.IndexExpr:
+		if d.op == token.Define {
+			return c.errorf(target, "operator ':=' not allowed with selector")
+		}
+		return nil
+	case *parser.DefaultPattern:
+		return c.checkDestructuring(d, target.Pattern)
+	case *parser.ArrayPattern:
+		for _, elem := range target.Elements {
+			if err := c.checkDestructuring(d, elem); err != nil {
+				return err
+			}
+		}
+		if target.Rest != nil {
+			return c.checkDestructuring(d, target.Rest)
+		}
+		return nil
+	case *parser.MapPattern:
+		for _, elem := range target.Elements {
+			if err := c.checkDestructuring(d, elem.Value); err != nil {
+				return err
+			}
+		}
+		if target.Rest != nil {
+			return c.checkDestructuring(d, target.Rest)
+		}
+		return nil
+	}
+	return c.errorf(target, "invalid destructuring target: %s", target)
+}
+
+// compileDestructure compiles the assignment of the value on the stack to
+// the target of the destructuring pattern.
+func (c *Compiler) compileDestructure(
+	d *destructuring,
+	target parser.Expr,
+	depth int,
+) error {
+	switch target := target.(type) {
+	case *parser.Ident:
+		if target.Name == "_" {
+			c.emit(target, parser.OpPop)
+			return nil
+		}
+		if d.news[target.Name] {
+			c.emitDefineSymbol(target, c.symbolTable.Define(target.Name))
+			return nil
+		}
+	case *parser.DefaultPattern:
+		// the default value is used if the value is undefined
+		temp := c.destructureTemp(d, depth)
+		c.emitDefineSymbol(target, temp)
+		c.emitGetSymbol(target, temp)
+		c.emit(target, parser.OpNull)
+		c.emit(target, parser.OpEqual)
+		jumpPos := c.emit(target, parser.OpJumpFalsy, 0)
+		c.addBranch(jumpPos)
+		if err := c.Compile(target.Default); err != nil {
 			return err
 		}
+		c.emitDefineSymbol(target, temp)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		c.emitGetSymbol(target, temp)
+		return c.compileDestructure(d, target.Pattern, depth+1)
+	case *parser.ArrayPattern:
+		temp := c.destructureTemp(d, depth)
+		c.emitDefineSymbol(target, temp)
+		for i, elem := range target.Elements {
+			if ident, ok := elem.(*parser.Ident); ok && ident.Name == "_" {
+				continue
+			}
+			c.emitGetSymbol(elem, temp)
+			c.emit(elem, parser.OpConstant,
+				c.addConstant(&Int{Value: int64(i)}))
+			c.emit(elem, parser.OpIndex)
+			if err := c.compileDestructure(d, elem, depth+1); err != nil {
+				return err
+			}
+		}
+		if target.Rest != nil {
+			c.emitGetSymbol(target, temp)
+			c.emit(target, parser.OpArrayRest, len(target.Elements))
+			return c.compileDestructure(d, target.Rest, depth+1)
+		}
+		return nil
+	case *parser.MapPattern:
+		temp := c.destructureTemp(d, depth)
+		c.emitDefineSymbol(target, temp)
+		for _, elem := range target.Elements {
+			if ident, ok := elem.Value.(*parser.Ident); ok &&
+				ident.Name == "_" {
+				continue
+			}
+			c.emitGetSymbol(elem, temp)
+			c.emit(elem, parser.OpConstant,
+				c.addConstant(&String{Value: elem.Key}))
+			c.emit(elem, parser.OpIndex)
+			err := c.compileDestructure(d, elem.Value, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		if target.Rest != nil {
+			c.emitGetSymbol(target, temp)
+			for _, elem := range target.Elements {
+				c.emit(elem, parser.OpConstant,
+					c.addConstant(&String{Value: elem.Key}))
+			}
+			c.emit(target, parser.OpMapRest, len(target.Elements))
+			return c.compileDestructure(d, target.Rest, depth+1)
+		}
+		return nil
 	}
 
-	// back to condition
-	c.emit(stmt, parser.OpJump, preCondPos)
+	// the other targets are assigned by the value of the temporary variable
+	temp := c.destructureTemp(d, depth)
+	c.emitDefineSymbol(target, temp)
+	value := &parser.Ident{Name: temp.Name, NamePos: target.Pos()}
+	return c.compileAssign(target, []parser.Expr{target},
+		[]parser.Expr{value}, token.Assign)
+}
 
-	// post-statement position
-	postStmtPos := len(c.currentInstructions())
-	if postCondPos >= 0 {
-		c.changeOperand(postCondPos, postStmtPos)
+// destructureTemp returns the temporary variable of the destructuring
+// pattern at the depth. The variables are shared by the patterns of a
+// destructuring assignment at the same depth.
+func (c *Compiler) destructureTemp(d *destructuring, depth int) *Symbol {
+	for len(d.temps) <= depth {
+		d.temps = append(d.temps, c.symbolTable.Define(
+			fmt.Sprintf(":destructure%d", len(d.temps))))
 	}
+	return d.temps[depth]
+}
 
-	// update all break/continue jump positions
-	for _, pos := range loop.Breaks {
-		c.changeOperand(pos, postStmtPos)
+func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
+	// left side term
+	if err := c.Compile(node.LHS); err != nil {
+		return err
 	}
-	for _, pos := range loop.Continues {
-		c.changeOperand(pos, postBodyPos)
+
+	// jump position
+	var jumpPos int
+	switch node.Token {
+	case token.LAnd:
+		jumpPos = c.emit(node, parser.OpAndJump, 0)
+	case token.Coalesce:
+		jumpPos = c.emit(node, parser.OpCoalesceJump, 0)
+	default:
+		jumpPos = c.emit(node, parser.OpOrJump, 0)
+	}
+	c.addBranch(jumpPos)
+
+	// right side term
+	if err := c.Compile(node.RHS); err != nil {
+		return err
 	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
 	return nil
 }
 
-func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
-	c.symbolTable = c.symbolTable.Fork(true)
-	defer func() {
-		c.symbolTable = c.symbolTable.Parent(false)
-	}()
+// compileChain compiles a chain of selectors, indexes, slices and calls,
+// e.g. "a.b[c](d)". An optional link of the chain jumps to the end of the
+// chain if its operand is undefined or an error and the chain evaluates to
+// undefined without evaluating the rest of the links.
+func (c *Compiler) compileChain(node parser.Expr) error {
+	var jumps []int
+	if err := c.compileChainLink(node, &jumps); err != nil {
+		return err
+	}
+	curPos := len(c.currentInstructions())
+	for _, pos := range jumps {
+		c.changeOperand(pos, curPos)
+	}
+	return nil
+}
+
+func (c *Compiler) compileChainLink(node parser.Expr, jumps *[]int) error {
+	var (
+		operand  parser.Expr
+		optional bool
+	)
+	switch node := node.(type) {
+	case *parser.SelectorExpr:
+		operand, optional = node.Expr, node.Optional
+	case *parser.IndexExpr:
+		operand, optional = node.Expr, node.Optional
+	case *parser.SliceExpr:
+		operand, optional = node.Expr, node.Optional
+	case *parser.CallExpr:
+		operand, optional = node.Func, node.Optional
+	default:
+		return c.Compile(node)
+	}
+
+	if err := c.compileChainLink(operand, jumps); err != nil {
+		return err
+	}
+	if optional {
+		jumpPos := c.emit(node, parser.OpChainJump, 0)
+		c.addBranch(jumpPos)
+		*jumps = append(*jumps, jumpPos)
+	}
+
+	switch node := node.(type) {
+	case *parser.SelectorExpr:
+		if err := c.Compile(node.Sel); err != nil {
+			return err
+		}
+		c.emit(node, parser.OpIndex)
+	case *parser.IndexExpr:
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(node, parser.OpIndex)
+	case *parser.SliceExpr:
+		if node.Low != nil {
+			if err := c.Compile(node.Low); err != nil {
+				return err
+			}
+		} else {
+			c.emit(node, parser.OpNull)
+		}
+		if node.High != nil {
+			if err := c.Compile(node.High); err != nil {
+				return err
+			}
+		} else {
+			c.emit(node, parser.OpNull)
+		}
+		c.emit(node, parser.OpSliceIndex)
+	case *parser.CallExpr:
+		return c.compileCall(node)
+	}
+	return nil
+}
+
+// compileCall compiles the arguments of the call on top of the function
+// compiled by the chain.
+func (c *Compiler) compileCall(node *parser.CallExpr) error {
+	// FUNC
+	// ARGS
+	// VAR ARGS
+	// KWARGS
+	// VAR KWARGS
+	for _, arg := range node.Args.Values {
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+
+	var (
+		normalValues = node.Kwargs.Values
+		hasVarKwargs = node.Kwargs.Ellipsis.IsValid()
+		varArgs,
+		varKwargs,
+		kw int
+	)
+
+	if node.Args.Ellipsis.IsValid() {
+		varArgs = 1
+	}
+
+	if hasVarKwargs {
+		normalValues = normalValues[:len(normalValues)-1]
+	}
+
+	if len(normalValues) > 0 {
+		kw = len(normalValues)
+		kwargs := &parser.MapLit{Elements: make([]*parser.MapElementLit, kw)}
+
+		for i, arg := range normalValues {
+			kwargs.Elements[i] = &parser.MapElementLit{
+				Key:   node.Kwargs.Names[i].Name,
+				Value: arg,
+			}
+		}
+		if err := c.Compile(kwargs); err != nil {
+			return err
+		}
+	}
+
+	if hasVarKwargs {
+		varKwargs = 1
+		if err := c.Compile(node.Kwargs.Values[len(node.Kwargs.Values)-1]); err != nil {
+			return err
+		}
+	}
+
+	c.emit(node, parser.OpCall, len(node.Args.Values)-varArgs, varArgs, kw, varKwargs)
+	return nil
+}
+
+func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	// init statement
+	if stmt.Init != nil {
+		if err := c.Compile(stmt.Init); err != nil {
+			return err
+		}
+	}
+
+	// pre-condition position
+	preCondPos := len(c.currentInstructions())
+
+	// condition expression
+	postCondPos := -1
+	if stmt.Cond != nil {
+		if err := c.Compile(stmt.Cond); err != nil {
+			return err
+		}
+		// condition jump position
+		postCondPos = c.emit(stmt, parser.OpJumpFalsy, 0)
+	}
+
+	// enter loop
+	loop := c.enterLoop()
+
+	// body statement
+	if err := c.Compile(stmt.Body); err != nil {
+		c.leaveLoop()
+		return err
+	}
+
+	c.leaveLoop()
+
+	// post-body position
+	postBodyPos := len(c.currentInstructions())
+
+	// post statement
+	if stmt.Post != nil {
+		if err := c.Compile(stmt.Post); err != nil {
+			return err
+		}
+	}
+
+	// back to condition
+	c.emit(stmt, parser.OpJump, preCondPos)
+
+	// post-statement position
+	postStmtPos := len(c.currentInstructions())
+	if postCondPos >= 0 {
+		c.changeOperand(postCondPos, postStmtPos)
+	}
+
+	// update all break/continue jump positions
+	for _, pos := range loop.Breaks {
+		c.changeOperand(pos, postStmtPos)
+	}
+	for _, pos := range loop.Continues {
+		c.changeOperand(pos, postBodyPos)
+	}
+	return nil
+}
+
+func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
+	c.enterBlock()
+	defer c.leaveBlock()
 
 	// for-in statement is compiled like following:
 	//
 	//   for :it := iterator(iterable); :it.next();  {
-	//     k, v := :it.get()  // DEFINE operator
+	//     k, v := :it.get()  // DEFINE operator or destructuring pattern
 	//
 	//     ... body ...
 	//   }
@@ -1101,6 +1518,19 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 		}
 	}
 
+	// destructure value variable
+	//   pattern := :it.get()
+	if stmt.Pattern != nil {
+		c.emitGetSymbol(stmt, itSymbol)
+		c.emit(stmt, parser.OpIteratorValue)
+		err := c.compileDestructuring(stmt.Pattern, stmt.Pattern,
+			token.Define)
+		if err != nil {
+			c.leaveLoop()
+			return err
+		}
+	}
+
 	// body statement
 	if err := c.Compile(stmt.Body); err != nil {
 		c.leaveLoop()
@@ -1129,6 +1559,553 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 	return nil
 }
 
+func (c *Compiler) compileTryStmt(stmt *parser.TryStmt) error {
+	// try statement is compiled like following:
+	//
+	//     TRY      catch
+	//     ... body ...
+	//     TRYEND
+	//     ... finally ...
+	//     JMP      end
+	//   catch:                 // error object is pushed by VM
+	//     err := pop()
+	//     TRY      rethrow     // only if finally is present
+	//     ... catch ...
+	//     TRYEND
+	//     ... finally ...
+	//     JMP      end
+	//   rethrow:               // error object is pushed by VM
+	//     ... finally ...
+	//     THROW
+	//   end:
+	//
+	// a try statement without catch block has "rethrow" part only.
+	var endJumps []int
+
+	tryPos := c.emit(stmt, parser.OpTry, 0)
+	if err := c.compileTryBody(stmt, stmt.Body); err != nil {
+		return err
+	}
+	endJumps = append(endJumps, c.emit(stmt, parser.OpJump, 0))
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	if stmt.Catch != nil {
+		c.enterBlock()
+		if stmt.Ident != nil && stmt.Ident.Name != "_" {
+			symbol := c.symbolTable.Define(stmt.Ident.Name)
+			if symbol.Scope == ScopeGlobal {
+				c.emit(stmt, parser.OpSetGlobal, symbol.Index)
+			} else {
+				symbol.LocalAssigned = true
+				c.emit(stmt, parser.OpDefineLocal, symbol.Index)
+			}
+		} else {
+			c.emit(stmt, parser.OpPop)
+		}
+
+		if stmt.Finally == nil {
+			err := c.Compile(stmt.Catch)
+			c.leaveBlock()
+			if err != nil {
+				return err
+			}
+		} else {
+			tryPos = c.emit(stmt, parser.OpTry, 0)
+			err := c.compileTryBody(stmt, stmt.Catch)
+			c.leaveBlock()
+			if err != nil {
+				return err
+			}
+			endJumps = append(endJumps, c.emit(stmt, parser.OpJump, 0))
+			c.changeOperand(tryPos, len(c.currentInstructions()))
+		}
+	}
+
+	if stmt.Finally != nil {
+		if err := c.Compile(stmt.Finally); err != nil {
+			return err
+		}
+		c.emit(stmt, parser.OpThrow)
+	}
+
+	endPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+// compileTryBody compiles the block guarded by a try handler and the
+// instructions leaving it on normal completion.
+func (c *Compiler) compileTryBody(
+	stmt *parser.TryStmt,
+	body *parser.BlockStmt,
+) error {
+	c.tries = append(c.tries, &tryBlock{
+		Finally:    stmt.Finally,
+		ScopeIndex: c.scopeIndex,
+	})
+	if err := c.Compile(body); err != nil {
+		return err
+	}
+	c.tries = c.tries[:len(c.tries)-1]
+
+	c.emit(stmt, parser.OpTryEnd)
+	if stmt.Finally != nil {
+		return c.Compile(stmt.Finally)
+	}
+	return nil
+}
+
+// leaveTries emits instructions to leave the active try blocks above the
+// given depth, running their finally blocks from the innermost one.
+func (c *Compiler) leaveTries(node parser.Node, depth int) error {
+	tries := c.tries
+	defer func() {
+		c.tries = tries
+	}()
+
+	for i := len(tries) - 1; i >= depth; i-- {
+		// finally block must not be guarded by its own try handler.
+		c.tries = tries[:i]
+		c.emit(node, parser.OpTryEnd)
+		if tries[i].Finally != nil {
+			if err := c.Compile(tries[i].Finally); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileSwitchStmt(stmt *parser.SwitchStmt) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	if stmt.Init != nil {
+		if err := c.Compile(stmt.Init); err != nil {
+			return err
+		}
+	}
+
+	var defaultCase *parser.CaseClause
+	var cases []*parser.CaseClause
+	for _, cc := range stmt.Cases {
+		if cc.Patterns == nil {
+			if defaultCase != nil {
+				return c.errorf(cc, "multiple defaults in switch")
+			}
+			defaultCase = cc
+			continue
+		}
+		cases = append(cases, cc)
+	}
+
+	if table := switchTable(stmt.Tag, cases); table != nil {
+		return c.compileSwitchTable(stmt, table, cases, defaultCase)
+	}
+
+	// switch statement is compiled like following:
+	//
+	//     :switch := tag
+	//     ... patterns of case 1 ... // jump to case 2 if not matched
+	//     ... guard of case 1 ...    // jump to case 2 if falsy
+	//     ... body of case 1 ...
+	//     JMP      end
+	//   case 2:
+	//     ...
+	//   default:
+	//     ... body of default ...
+	//   end:
+	//
+	// ":switch" is a local variable but it will not conflict with other user
+	// variables because character ":" is not allowed in the variable names.
+	// A switch statement without tag matches the first case whose pattern is
+	// truthy.
+	m := &caseMatcher{node: stmt}
+	if stmt.Tag != nil {
+		if err := c.Compile(stmt.Tag); err != nil {
+			return err
+		}
+		m.tag = c.symbolTable.Define(":switch")
+		c.emitDefineSymbol(stmt, m.tag)
+	}
+
+	var endJumps []int
+	for _, cc := range cases {
+		c.enterBlock()
+		m.bindings = make(map[string]*Symbol)
+		m.temps = nil
+		var matchJumps []int
+		for i, pattern := range cc.Patterns {
+			m.fails = nil
+			var err error
+			if m.tag == nil {
+				switch pattern.(type) {
+				case *parser.ArrayPattern, *parser.MapPattern,
+					*parser.TypePattern:
+					c.leaveBlock()
+					return c.errorf(pattern,
+						"pattern not allowed in switch without value")
+				}
+				err = c.Compile(pattern)
+				m.fails = append(m.fails,
+					c.emit(pattern, parser.OpJumpFalsy, 0))
+			} else {
+				err = c.compilePattern(m, pattern, m.tag, 0)
+			}
+			if err != nil {
+				c.leaveBlock()
+				return err
+			}
+			if i < len(cc.Patterns)-1 {
+				// try the next pattern if not matched
+				matchJumps = append(matchJumps, c.emit(cc, parser.OpJump, 0))
+				curPos := len(c.currentInstructions())
+				for _, pos := range m.fails {
+					c.changeOperand(pos, curPos)
+				}
+			}
+		}
+		curPos := len(c.currentInstructions())
+		for _, pos := range matchJumps {
+			c.changeOperand(pos, curPos)
+		}
+
+		if cc.Guard != nil {
+			if err := c.Compile(cc.Guard); err != nil {
+				c.leaveBlock()
+				return err
+			}
+			jumpPos := c.emit(cc.Guard, parser.OpJumpFalsy, 0)
+			c.addBranch(jumpPos)
+			m.fails = append(m.fails, jumpPos)
+		}
+		if err := c.compileCaseBody(cc); err != nil {
+			c.leaveBlock()
+			return err
+		}
+		endJumps = append(endJumps, c.emit(cc, parser.OpJump, 0))
+		c.leaveBlock()
+
+		curPos = len(c.currentInstructions())
+		for _, pos := range m.fails {
+			c.changeOperand(pos, curPos)
+		}
+	}
+
+	if defaultCase != nil {
+		c.enterBlock()
+		err := c.compileCaseBody(defaultCase)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	curPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, curPos)
+	}
+	return nil
+}
+
+// compileSwitchTable compiles the switch statement whose cases are all
+// constants with a jump table.
+func (c *Compiler) compileSwitchTable(
+	stmt *parser.SwitchStmt,
+	table *ImmutableMap,
+	cases []*parser.CaseClause,
+	defaultCase *parser.CaseClause,
+) error {
+	// switch statement is compiled like following:
+	//
+	//     tag
+	//     SWITCH   table n      // jumps to the JMP of the matched case
+	//     JMP      case 1
+	//     ...
+	//     JMP      case n
+	//     JMP      default
+	//   case 1:
+	//     ... body of case 1 ...
+	//     JMP      end
+	//   ...
+	//   default:
+	//     ... body of default ...
+	//   end:
+	if err := c.Compile(stmt.Tag); err != nil {
+		return err
+	}
+	c.emit(stmt, parser.OpSwitch, c.addConstant(table), len(cases))
+	caseJumps := make([]int, len(cases)+1)
+	for i := range caseJumps {
+		caseJumps[i] = c.emit(stmt, parser.OpJump, 0)
+	}
+
+	var endJumps []int
+	for i, cc := range append(cases, defaultCase) {
+		c.changeOperand(caseJumps[i], len(c.currentInstructions()))
+		if cc == nil {
+			break
+		}
+		c.enterBlock()
+		err := c.compileCaseBody(cc)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+		if cc != defaultCase {
+			endJumps = append(endJumps, c.emit(cc, parser.OpJump, 0))
+		}
+	}
+
+	curPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, curPos)
+	}
+	return nil
+}
+
+func (c *Compiler) compileCaseBody(cc *parser.CaseClause) error {
+	for _, s := range cc.Body {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// caseMatcher is the state to compile the patterns of a switch statement.
+type caseMatcher struct {
+	node     parser.Node
+	tag      *Symbol            // value of the switch; or nil
+	temps    []*Symbol          // nested values of the patterns of a case
+	bindings map[string]*Symbol // variables bound by the patterns of a case
+	fails    []int              // jumps to take if the pattern does not match
+}
+
+// compilePattern compiles the pattern matching the value of the symbol. The
+// array and the map patterns bind the identifiers to the elements and the
+// other expressions are compared with the value.
+func (c *Compiler) compilePattern(
+	m *caseMatcher,
+	pattern parser.Expr,
+	value *Symbol,
+	depth int,
+) error {
+	switch pattern := pattern.(type) {
+	case *parser.Ident:
+		if pattern.Name == "_" {
+			return nil
+		}
+		// the identifiers in the destructuring patterns are bound by
+		// compilePatternValue and the other ones are the values.
+	case *parser.ArrayPattern:
+		hasRest := 0
+		if pattern.Ellipsis.IsValid() {
+			hasRest = 1
+		}
+		c.emitGetSymbol(pattern, value)
+		c.emit(pattern, parser.OpMatchArray, len(pattern.Elements), hasRest)
+		m.fails = append(m.fails, c.emit(pattern, parser.OpJumpFalsy, 0))
+		for i, elem := range pattern.Elements {
+			index := &parser.IntLit{
+				Value:    int64(i),
+				ValuePos: elem.Pos(),
+				Literal:  strconv.Itoa(i),
+			}
+			if err := c.compileSubpattern(m, elem, value, index,
+				depth); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			// rest := value[n:]
+			c.emitGetSymbol(pattern, value)
+			c.emit(pattern, parser.OpConstant, c.addConstant(
+				&Int{Value: int64(len(pattern.Elements))}))
+			c.emit(pattern, parser.OpNull)
+			c.emit(pattern, parser.OpSliceIndex)
+			return c.compilePatternValue(m, pattern.Rest, depth)
+		}
+		return nil
+	case *parser.MapPattern:
+		c.emitGetSymbol(pattern, value)
+		for _, elem := range pattern.Elements {
+			c.emit(elem, parser.OpConstant,
+				c.addConstant(&String{Value: elem.Key}))
+		}
+		c.emit(pattern, parser.OpMatchMap, len(pattern.Elements))
+		m.fails = append(m.fails, c.emit(pattern, parser.OpJumpFalsy, 0))
+		for _, elem := range pattern.Elements {
+			key := &parser.StringLit{
+				Value:    elem.Key,
+				ValuePos: elem.KeyPos,
+				Literal:  strconv.Quote(elem.Key),
+			}
+			if err := c.compileSubpattern(m, elem.Value, value, key,
+				depth); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			// rest := value without the keys
+			c.emitGetSymbol(pattern, value)
+			for _, elem := range pattern.Elements {
+				c.emit(elem, parser.OpConstant,
+					c.addConstant(&String{Value: elem.Key}))
+			}
+			c.emit(pattern, parser.OpMapRest, len(pattern.Elements))
+			return c.compilePatternValue(m, pattern.Rest, depth)
+		}
+		return nil
+	case *parser.DefaultPattern:
+		return c.errorf(pattern, "default value not allowed in case pattern")
+	case *parser.TypePattern:
+		c.emitGetSymbol(pattern, value)
+		if err := c.Compile(pattern.Type); err != nil {
+			return err
+		}
+		c.emit(pattern, parser.OpMatchType)
+		m.fails = append(m.fails, c.emit(pattern, parser.OpJumpFalsy, 0))
+		return nil
+	}
+
+	c.emitGetSymbol(pattern, value)
+	if err := c.Compile(pattern); err != nil {
+		return err
+	}
+	c.emit(pattern, parser.OpEqual)
+	m.fails = append(m.fails, c.emit(pattern, parser.OpJumpFalsy, 0))
+	return nil
+}
+
+// compileSubpattern compiles the pattern matching the element of the value
+// at the index.
+func (c *Compiler) compileSubpattern(
+	m *caseMatcher,
+	pattern parser.Expr,
+	value *Symbol,
+	index parser.Expr,
+	depth int,
+) error {
+	if ident, ok := pattern.(*parser.Ident); ok && ident.Name == "_" {
+		return nil
+	}
+	c.emitGetSymbol(pattern, value)
+	if err := c.Compile(index); err != nil {
+		return err
+	}
+	c.emit(pattern, parser.OpIndex)
+	return c.compilePatternValue(m, pattern, depth)
+}
+
+// compilePatternValue compiles the pattern matching the value on the stack.
+func (c *Compiler) compilePatternValue(
+	m *caseMatcher,
+	pattern parser.Expr,
+	depth int,
+) error {
+	if ident, ok := pattern.(*parser.Ident); ok {
+		symbol, ok := m.bindings[ident.Name]
+		if !ok {
+			symbol = c.symbolTable.Define(ident.Name)
+			m.bindings[ident.Name] = symbol
+		}
+		c.emitDefineSymbol(pattern, symbol)
+		return nil
+	}
+
+	// the temporary variables are shared by the patterns of a case at the
+	// same depth
+	if len(m.temps) == depth {
+		m.temps = append(m.temps,
+			c.symbolTable.Define(fmt.Sprintf(":switch%d", depth)))
+	}
+	temp := m.temps[depth]
+	c.emitDefineSymbol(pattern, temp)
+	return c.compilePattern(m, pattern, temp, depth+1)
+}
+
+// emitGetSymbol emits the instruction to push the value of the symbol.
+func (c *Compiler) emitGetSymbol(node parser.Node, symbol *Symbol) {
+	if symbol.Scope == ScopeGlobal {
+		c.emit(node, parser.OpGetGlobal, symbol.Index)
+	} else {
+		c.emit(node, parser.OpGetLocal, symbol.Index)
+	}
+}
+
+// emitDefineSymbol emits the instruction to pop the value and to assign it
+// to the newly defined symbol.
+func (c *Compiler) emitDefineSymbol(node parser.Node, symbol *Symbol) {
+	if symbol.Scope == ScopeGlobal {
+		c.emit(node, parser.OpSetGlobal, symbol.Index)
+	} else {
+		symbol.LocalAssigned = true
+		c.emit(node, parser.OpDefineLocal, symbol.Index)
+	}
+}
+
+// switchTable returns the jump table of the switch statement if all the
+// patterns of the cases are constants. The keys of the table are the keys of
+// the constants and the values are the indexes of the cases.
+func switchTable(tag parser.Expr, cases []*parser.CaseClause) *ImmutableMap {
+	if tag == nil || len(cases) == 0 {
+		return nil
+	}
+	table := make(map[string]Object)
+	for i, cc := range cases {
+		if cc.Guard != nil {
+			return nil
+		}
+		for _, pattern := range cc.Patterns {
+			var o Object
+			switch pattern := pattern.(type) {
+			case *parser.IntLit:
+				o = &Int{Value: pattern.Value}
+			case *parser.StringLit:
+				o = &String{Value: pattern.Value}
+			case *parser.CharLit:
+				o = &Char{Value: pattern.Value}
+			default:
+				return nil
+			}
+			key, _ := switchKey(o)
+			if _, ok := table[key]; !ok {
+				// the first case wins
+				table[key] = &Int{Value: int64(i)}
+			}
+		}
+	}
+	return &ImmutableMap{Value: table}
+}
+
+// switchKey returns the key of the value in the jump table of a switch
+// statement. The keys of the values of the different types are different as
+// those are not equal.
+func switchKey(o Object) (string, bool) {
+	switch o := o.(type) {
+	case *Int:
+		return "i" + strconv.FormatInt(o.Value, 10), true
+	case *String:
+		return "s" + o.Value, true
+	case *Char:
+		return "c" + string(o.Value), true
+	}
+	return "", false
+}
+
+// scopeTries returns the depth of the try blocks outside the current
+// function scope.
+func (c *Compiler) scopeTries() int {
+	depth := len(c.tries)
+	for depth > 0 && c.tries[depth-1].ScopeIndex == c.scopeIndex {
+		depth--
+	}
+	return depth
+}
+
 func (c *Compiler) checkCyclicImports(
 	node parser.Node,
 	modulePath string,
@@ -1179,6 +2156,7 @@ func (c *Compiler) compileModule(
 	}
 	// code optimization
 	moduleCompiler.optimizeFunc(node)
+	moduleCompiler.addLocals(0)
 	compiledFunc := moduleCompiler.Bytecode().MainFunction
 	compiledFunc.NumLocals = symbolTable.MaxSymbols()
 	c.storeCompiledModule(modulePath, compiledFunc)
@@ -1206,7 +2184,7 @@ func (c *Compiler) storeCompiledModule(
 }
 
 func (c *Compiler) enterLoop() *loop {
-	loop := &loop{}
+	loop := &loop{NumTries: len(c.tries)}
 	c.loops = append(c.loops, loop)
 	c.loopIndex++
 	if c.trace != nil {
@@ -1254,9 +2232,13 @@ func (c *Compiler) enterScope() {
 func (c *Compiler) leaveScope() (
 	instructions []byte,
 	sourceMap map[int]parser.Pos,
+	locals []LocalVar,
+	branches []int,
 ) {
 	instructions = c.currentInstructions()
 	sourceMap = c.currentSourceMap()
+	locals = c.scopes[c.scopeIndex].Locals
+	branches = c.scopes[c.scopeIndex].Branches
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
 	c.symbolTable = c.symbolTable.Parent(true)
@@ -1266,6 +2248,70 @@ func (c *Compiler) leaveScope() (
 	return
 }
 
+func (c *Compiler) enterBlock() {
+	c.symbolTable = c.symbolTable.Fork(true)
+	c.blockStarts = append(c.blockStarts, len(c.currentInstructions()))
+}
+
+func (c *Compiler) leaveBlock() {
+	start := c.blockStarts[len(c.blockStarts)-1]
+	c.blockStarts = c.blockStarts[:len(c.blockStarts)-1]
+	c.addLocals(start)
+	c.symbolTable = c.symbolTable.Parent(false)
+}
+
+// addBranch records the conditional jump instruction at the position as a
+// branch of an if statement, a conditional expression or a logical operator.
+// These are used to measure the branch coverage.
+func (c *Compiler) addBranch(pos int) {
+	c.scopes[c.scopeIndex].Branches = append(
+		c.scopes[c.scopeIndex].Branches, pos)
+}
+
+// addLocals records the local variables of the current symbol table defined
+// in the instructions since the start position. A variable is visible from
+// the instruction next to its definition until the end of the instructions.
+// These are used by debuggers to resolve the local variables by name.
+func (c *Compiler) addLocals(start int) {
+	insts := c.currentInstructions()
+	defs := make(map[int]int)
+	iterateInstructions(insts[start:],
+		func(pos int, opcode parser.Opcode, operands []int) bool {
+			if opcode == parser.OpDefineLocal {
+				if _, ok := defs[operands[0]]; !ok {
+					defs[operands[0]] = start + pos + 2
+				}
+			}
+			return true
+		})
+
+	var locals []LocalVar
+	for name, s := range c.symbolTable.store {
+		if s.Scope != ScopeLocal || strings.HasPrefix(name, ":") {
+			continue
+		}
+		def, ok := defs[s.Index]
+		if !ok {
+			if c.symbolTable.block {
+				continue
+			}
+			// function parameters are defined on the call.
+			def = 0
+		}
+		locals = append(locals, LocalVar{
+			Name:  name,
+			Index: s.Index,
+			Start: def,
+			End:   len(insts),
+		})
+	}
+	sort.Slice(locals, func(i, j int) bool {
+		return locals[i].Index < locals[j].Index
+	})
+	c.scopes[c.scopeIndex].Locals = append(
+		c.scopes[c.scopeIndex].Locals, locals...)
+}
+
 func (c *Compiler) fork(
 	file *parser.SourceFile,
 	modulePath string,
@@ -1278,6 +2324,7 @@ func (c *Compiler) fork(
 	child.allowFileImport = c.allowFileImport
 	child.importDir = c.importDir
 	child.importFileExt = c.importFileExt
+	child.fs = c.fs
 	if isFile && c.importDir != "" {
 		child.importDir = filepath.Dir(modulePath)
 	}
@@ -1352,7 +2399,8 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy,
-				parser.OpAndJump, parser.OpOrJump:
+				parser.OpAndJump, parser.OpOrJump, parser.OpTry,
+				parser.OpChainJump, parser.OpCoalesceJump:
 				dsts[operands[0]] = true
 			}
 			return true
@@ -1392,7 +2440,8 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
-				parser.OpOrJump:
+				parser.OpOrJump, parser.OpTry, parser.OpChainJump,
+				parser.OpCoalesceJump:
 				newDst, ok := posMap[operands[0]]
 				if ok {
 					copy(newInsts[pos:],
@@ -1425,6 +2474,29 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 	c.scopes[c.scopeIndex].Instructions = newInsts
 	c.scopes[c.scopeIndex].SourceMap = newSourceMap
 
+	// pass 5. update local variable ranges
+	newPos := func(pos int) int {
+		for ; pos < endPos; pos++ {
+			if p, ok := posMap[pos]; ok {
+				return p
+			}
+		}
+		return newEndPost
+	}
+	for i, l := range c.scopes[c.scopeIndex].Locals {
+		c.scopes[c.scopeIndex].Locals[i].Start = newPos(l.Start)
+		c.scopes[c.scopeIndex].Locals[i].End = newPos(l.End)
+	}
+
+	// pass 6. update branch positions
+	var branches []int
+	for _, pos := range c.scopes[c.scopeIndex].Branches {
+		if p, ok := posMap[pos]; ok {
+			branches = append(branches, p)
+		}
+	}
+	c.scopes[c.scopeIndex].Branches = branches
+
 	// append "return"
 	if appendReturn {
 		c.emit(node, parser.OpReturn, 0)
@@ -1475,13 +2547,20 @@ func (c *Compiler) getPathModule(moduleName string) (pathFile string, err error)
 			nameFile += ext
 		}
 
-		pathFile, err = filepath.Abs(filepath.Join(c.importDir, nameFile))
-		if err != nil {
-			continue
+		if c.fs == nil {
+			pathFile, err = filepath.Abs(filepath.Join(c.importDir, nameFile))
+			if err != nil {
+				continue
+			}
+		} else {
+			// the virtual file systems use the slash separated paths
+			pathFile = path.Join("/", filepath.ToSlash(c.importDir),
+				filepath.ToSlash(nameFile))
 		}
 
 		// Check if file exists
-		if _, err := os.Stat(pathFile); !errors.Is(err, os.ErrNotExist) {
+		_, err := c.fileSystem().Stat(pathFile)
+		if !errors.Is(err, os.ErrNotExist) {
 			return pathFile, nil
 		}
 	}
@@ -1506,6 +2585,40 @@ func resolveAssignLHS(
 	return
 }
 
+// isOptionalChain returns true if a selector or an index of the expression
+// is optional.
+// paramName returns the name of the parameter at the index. The destructured
+// parameters are the hidden variables named ":arg0", ":arg1" and so on.
+func paramName(params *parser.IdentList, i int) string {
+	if i < len(params.Patterns) && params.Patterns[i] != nil {
+		return fmt.Sprintf(":arg%d", i)
+	}
+	return params.List[i].Name
+}
+
+// isDestructuring reports whether the left side of an assignment is
+// multiple variables or a destructuring pattern.
+func isDestructuring(lhs []parser.Expr) bool {
+	if len(lhs) > 1 {
+		return true
+	}
+	switch lhs[0].(type) {
+	case *parser.ArrayPattern, *parser.MapPattern:
+		return true
+	}
+	return false
+}
+
+func isOptionalChain(expr parser.Expr) bool {
+	switch term := expr.(type) {
+	case *parser.SelectorExpr:
+		return term.Optional || isOptionalChain(term.Expr)
+	case *parser.IndexExpr:
+		return term.Optional || isOptionalChain(term.Expr)
+	}
+	return false
+}
+
 func iterateInstructions(
 	b []byte,
 	fn func(pos int, opcode parser.Opcode, operands []int) bool,
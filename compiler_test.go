@@ -257,6 +257,30 @@ func TestCompiler_Compile(t *testing.T) {
 				intObject(20),
 				intObject(3333))))
 
+	expectCompile(t, `switch 1 { case 1: 10; case "a": 20 }`,
+		bytecode(
+			concatInsts(
+				tengo.MakeInstruction(parser.OpConstant, 0),  // 0000
+				tengo.MakeInstruction(parser.OpSwitch, 1, 2), // 0003
+				tengo.MakeInstruction(parser.OpJump, 17),     // 0008
+				tengo.MakeInstruction(parser.OpJump, 24),     // 0011
+				tengo.MakeInstruction(parser.OpJump, 31),     // 0014
+				tengo.MakeInstruction(parser.OpConstant, 2),  // 0017
+				tengo.MakeInstruction(parser.OpPop),          // 0020
+				tengo.MakeInstruction(parser.OpJump, 31),     // 0021
+				tengo.MakeInstruction(parser.OpConstant, 3),  // 0024
+				tengo.MakeInstruction(parser.OpPop),          // 0027
+				tengo.MakeInstruction(parser.OpJump, 31),     // 0028
+				tengo.MakeInstruction(parser.OpSuspend)),     // 0031
+			objectsArray(
+				intObject(1),
+				&tengo.ImmutableMap{Value: map[string]tengo.Object{
+					"i1": intObject(0),
+					"sa": intObject(1),
+				}},
+				intObject(10),
+				intObject(20))))
+
 	expectCompile(t, `"kami"`,
 		bytecode(
 			concatInsts(
@@ -1011,6 +1035,71 @@ func() {
 				intObject(0),
 				intObject(1))))
 
+	expectCompile(t, `a := {}; a?.b ?? 1`,
+		bytecode(
+			concatInsts(
+				tengo.MakeInstruction(parser.OpMap, 0),
+				tengo.MakeInstruction(parser.OpSetGlobal, 0),
+				tengo.MakeInstruction(parser.OpGetGlobal, 0),
+				tengo.MakeInstruction(parser.OpChainJump, 16),
+				tengo.MakeInstruction(parser.OpConstant, 0),
+				tengo.MakeInstruction(parser.OpIndex),
+				tengo.MakeInstruction(parser.OpCoalesceJump, 22),
+				tengo.MakeInstruction(parser.OpConstant, 1),
+				tengo.MakeInstruction(parser.OpPop),
+				tengo.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				stringObject("b"),
+				intObject(1))))
+
+	expectCompile(t, `a := []; [1, ...a, 2]`,
+		bytecode(
+			concatInsts(
+				tengo.MakeInstruction(parser.OpArray, 0),
+				tengo.MakeInstruction(parser.OpSetGlobal, 0),
+				tengo.MakeInstruction(parser.OpConstant, 0),
+				tengo.MakeInstruction(parser.OpArray, 1),
+				tengo.MakeInstruction(parser.OpGetGlobal, 0),
+				tengo.MakeInstruction(parser.OpSpreadArray),
+				tengo.MakeInstruction(parser.OpConstant, 1),
+				tengo.MakeInstruction(parser.OpArray, 1),
+				tengo.MakeInstruction(parser.OpSpreadArray),
+				tengo.MakeInstruction(parser.OpPop),
+				tengo.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(2))))
+
+	expectCompile(t, `a := {}; {...a, ...a}`,
+		bytecode(
+			concatInsts(
+				tengo.MakeInstruction(parser.OpMap, 0),
+				tengo.MakeInstruction(parser.OpSetGlobal, 0),
+				tengo.MakeInstruction(parser.OpMap, 0),
+				tengo.MakeInstruction(parser.OpGetGlobal, 0),
+				tengo.MakeInstruction(parser.OpSpreadMap),
+				tengo.MakeInstruction(parser.OpGetGlobal, 0),
+				tengo.MakeInstruction(parser.OpSpreadMap),
+				tengo.MakeInstruction(parser.OpPop),
+				tengo.MakeInstruction(parser.OpSuspend)),
+			objectsArray()))
+
+	expectCompile(t, `a, ...r := []`,
+		bytecode(
+			concatInsts(
+				tengo.MakeInstruction(parser.OpArray, 0),
+				tengo.MakeInstruction(parser.OpSetGlobal, 0),
+				tengo.MakeInstruction(parser.OpGetGlobal, 0),
+				tengo.MakeInstruction(parser.OpConstant, 0),
+				tengo.MakeInstruction(parser.OpIndex),
+				tengo.MakeInstruction(parser.OpSetGlobal, 1),
+				tengo.MakeInstruction(parser.OpGetGlobal, 0),
+				tengo.MakeInstruction(parser.OpArrayRest, 1),
+				tengo.MakeInstruction(parser.OpSetGlobal, 2),
+				tengo.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(0))))
+
 	// unknown module name
 	expectCompileError(t, `import("user1")`, "module 'user1' not found")
 
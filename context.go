@@ -74,3 +74,18 @@ func (this *CallContext) GetKwargsVar(argVar map[string]Object, dest map[string]
 		}
 	}
 }
+
+// Suspend suspends the VM after the function returns. The run returns a
+// Suspension with the value. The result of the call is the value the run is
+// resumed with. The function should return the results of Suspend. It returns
+// ErrNotSuspendable if the function is called from a generator or a compiled
+// function called from Go.
+func (this *CallContext) Suspend(value Object) (Object, error) {
+	if this.VM == nil {
+		return nil, ErrNotSuspendable
+	}
+	if err := this.VM.suspend(value); err != nil {
+		return nil, err
+	}
+	return UndefinedValue, nil
+}
@@ -0,0 +1,547 @@
+package tengo
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/d5/tengo/v2/parser"
+)
+
+// Coverage records the source lines and the branches executed by the VMs.
+// The branches are the conditions of the if statements, the conditional
+// expressions, the logical operators (&& and ||) and the optional chaining
+// and nil-coalescing operators (?. and ??). A Coverage can be
+// shared by multiple VMs running concurrently and the results of all runs
+// are merged.
+type Coverage struct {
+	lock      sync.Mutex
+	files     map[string]*coverageFile
+	funcs     map[*byte]*coverageFunc
+	bytecodes map[*byte]bool
+}
+
+// CoverageFile is the coverage of a source file.
+type CoverageFile struct {
+	Name     string
+	Lines    []CoverageLine   // sorted by line
+	Branches []CoverageBranch // sorted by position
+}
+
+// CoverageLine is the execution count of a source line.
+type CoverageLine struct {
+	Line  int
+	Count int64
+}
+
+// CoverageBranch is the execution counts of a branch. True and False are
+// the number of times the condition or the left operand of the logical
+// operator was evaluated to truthy and falsy values. For the optional
+// chaining and nil-coalescing operators, False is the number of times the
+// operand was undefined or an error.
+type CoverageBranch struct {
+	Line   int
+	Column int
+	True   int64
+	False  int64
+}
+
+// Covered returns true if both ways of the branch are executed.
+func (b CoverageBranch) Covered() bool {
+	return b.True > 0 && b.False > 0
+}
+
+// LinesCovered returns the number of the executed lines and the number of
+// all lines.
+func (f *CoverageFile) LinesCovered() (covered, total int) {
+	for _, l := range f.Lines {
+		if l.Count > 0 {
+			covered++
+		}
+	}
+	return covered, len(f.Lines)
+}
+
+// BranchesCovered returns the number of the executed ways of the branches
+// and the number of all ways. Each branch has two ways.
+func (f *CoverageFile) BranchesCovered() (covered, total int) {
+	for _, b := range f.Branches {
+		if b.True > 0 {
+			covered++
+		}
+		if b.False > 0 {
+			covered++
+		}
+	}
+	return covered, 2 * len(f.Branches)
+}
+
+type coverageFile struct {
+	lines    map[int]int64
+	branches map[coverageBranch]*[2]int64
+}
+
+// coverageBranch identifies a branch by its source position. The branches
+// at the same position, e.g. "a && b || c", are distinguished by index.
+type coverageBranch struct {
+	line   int
+	column int
+	index  int
+}
+
+// coverageFunc is the source positions of a compiled function.
+type coverageFunc struct {
+	file     *coverageFile
+	lines    []int                  // source line for each ip
+	branches map[int]coverageBranch // ip to branch
+}
+
+// vmCoverage records a single run of a VM. It's merged into the Coverage
+// when the run ends.
+type vmCoverage struct {
+	vm    *VM
+	fn    *CompiledFunction
+	cur   *vmCoverageFunc
+	funcs map[*byte]*vmCoverageFunc
+}
+
+type vmCoverageFunc struct {
+	fn       *CompiledFunction
+	counts   []int64           // number of times each ip is executed
+	branches map[int]*[2]int64 // ip to truthy and falsy counts
+}
+
+// NewCoverage creates a Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files:     make(map[string]*coverageFile),
+		funcs:     make(map[*byte]*coverageFunc),
+		bytecodes: make(map[*byte]bool),
+	}
+}
+
+// begin starts recording a run of the VM.
+func (c *Coverage) begin(v *VM) *vmCoverage {
+	return &vmCoverage{
+		vm:    v,
+		funcs: make(map[*byte]*vmCoverageFunc),
+	}
+}
+
+// end merges the recorded run into the coverage.
+func (c *Coverage) end(r *vmCoverage) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// all functions of the bytecode are registered so that the lines and
+	// the branches not executed are reported.
+	v := r.vm
+	if key := functionKey(v.bc.MainFunction); !c.bytecodes[key] {
+		c.bytecodes[key] = true
+		c.function(v, v.bc.MainFunction)
+		for _, o := range v.bc.Constants {
+			if fn, ok := o.(*CompiledFunction); ok {
+				c.function(v, fn)
+			}
+		}
+	}
+
+	for _, f := range r.funcs {
+		cf := c.function(v, f.fn)
+		if cf.file == nil {
+			continue
+		}
+		// the count of a line is the maximum count of its instructions.
+		lines := make(map[int]int64)
+		for ip, n := range f.counts {
+			if line := cf.lines[ip]; line > 0 && n > lines[line] {
+				lines[line] = n
+			}
+		}
+		for line, n := range lines {
+			cf.file.lines[line] += n
+		}
+		for ip, taken := range f.branches {
+			if b, ok := cf.branches[ip]; ok {
+				t := cf.file.branches[b]
+				t[0] += taken[0]
+				t[1] += taken[1]
+			}
+		}
+	}
+}
+
+func (c *Coverage) function(v *VM, fn *CompiledFunction) *coverageFunc {
+	key := functionKey(fn)
+	if f, ok := c.funcs[key]; ok {
+		return f
+	}
+
+	f := &coverageFunc{
+		lines:    make([]int, len(fn.Instructions)),
+		branches: make(map[int]coverageBranch),
+	}
+	c.funcs[key] = f
+
+	// the instructions without source positions are not counted.
+	for ip, p := range fn.SourceMap {
+		if ip >= len(f.lines) || !p.IsValid() {
+			continue
+		}
+		filePos := v.fileSet.Position(p)
+		if f.file == nil {
+			f.file = c.file(filePos.Filename)
+		}
+		f.lines[ip] = filePos.Line
+		if _, ok := f.file.lines[filePos.Line]; !ok {
+			f.file.lines[filePos.Line] = 0
+		}
+	}
+	if f.file == nil {
+		return f
+	}
+
+	for _, ip := range fn.Branches {
+		filePos := v.fileSet.Position(fn.SourceMap[ip])
+		if !filePos.IsValid() {
+			continue
+		}
+		b := coverageBranch{line: filePos.Line, column: filePos.Column}
+		for _, other := range f.branches {
+			if other.line == b.line && other.column == b.column {
+				b.index++
+			}
+		}
+		f.branches[ip] = b
+		if _, ok := f.file.branches[b]; !ok {
+			f.file.branches[b] = &[2]int64{}
+		}
+	}
+	return f
+}
+
+func (c *Coverage) file(name string) *coverageFile {
+	f, ok := c.files[name]
+	if !ok {
+		f = &coverageFile{
+			lines:    make(map[int]int64),
+			branches: make(map[coverageBranch]*[2]int64),
+		}
+		c.files[name] = f
+	}
+	return f
+}
+
+// Files returns the coverage of the source files sorted by name.
+func (c *Coverage) Files() []*CoverageFile {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var files []*CoverageFile
+	for name, f := range c.files {
+		cf := &CoverageFile{Name: name}
+		for line, n := range f.lines {
+			cf.Lines = append(cf.Lines, CoverageLine{Line: line, Count: n})
+		}
+		sort.Slice(cf.Lines, func(i, j int) bool {
+			return cf.Lines[i].Line < cf.Lines[j].Line
+		})
+
+		var keys []coverageBranch
+		for b := range f.branches {
+			keys = append(keys, b)
+		}
+		sortCoverageBranches(keys)
+		for _, b := range keys {
+			t := f.branches[b]
+			cf.Branches = append(cf.Branches, CoverageBranch{
+				Line:   b.line,
+				Column: b.column,
+				True:   t[0],
+				False:  t[1],
+			})
+		}
+		files = append(files, cf)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files
+}
+
+func sortCoverageBranches(branches []coverageBranch) {
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].line != branches[j].line {
+			return branches[i].line < branches[j].line
+		}
+		if branches[i].column != branches[j].column {
+			return branches[i].column < branches[j].column
+		}
+		return branches[i].index < branches[j].index
+	})
+}
+
+// Merge adds the counts recorded by the other coverage.
+func (c *Coverage) Merge(other *Coverage) {
+	if other == c {
+		return
+	}
+
+	other.lock.Lock()
+	files := make(map[string]*coverageFile, len(other.files))
+	for name, f := range other.files {
+		cf := &coverageFile{
+			lines:    make(map[int]int64, len(f.lines)),
+			branches: make(map[coverageBranch]*[2]int64, len(f.branches)),
+		}
+		for line, n := range f.lines {
+			cf.lines[line] = n
+		}
+		for b, t := range f.branches {
+			taken := *t
+			cf.branches[b] = &taken
+		}
+		files[name] = cf
+	}
+	other.lock.Unlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, f := range files {
+		cf := c.file(name)
+		for line, n := range f.lines {
+			cf.lines[line] += n
+		}
+		for b, t := range f.branches {
+			taken, ok := cf.branches[b]
+			if !ok {
+				taken = &[2]int64{}
+				cf.branches[b] = taken
+			}
+			taken[0] += t[0]
+			taken[1] += t[1]
+		}
+	}
+}
+
+// Reset discards the recorded counts.
+func (c *Coverage) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.files = make(map[string]*coverageFile)
+	c.funcs = make(map[*byte]*coverageFunc)
+	c.bytecodes = make(map[*byte]bool)
+}
+
+// WriteLCOV writes the coverage in LCOV tracefile format. The two ways of
+// each branch, truthy and falsy, are written as the branch 0 and 1 of a
+// block.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.Files() {
+		_, _ = fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+
+		// blocks are numbered for each line.
+		var line, block int
+		for _, b := range f.Branches {
+			if b.Line != line {
+				line = b.Line
+				block = 0
+			}
+			for i, n := range []int64{b.True, b.False} {
+				taken := "-"
+				if b.True > 0 || b.False > 0 {
+					taken = fmt.Sprint(n)
+				}
+				_, _ = fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n",
+					b.Line, block, i, taken)
+			}
+			block++
+		}
+		hit, found := f.BranchesCovered()
+		_, _ = fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", found, hit)
+
+		for _, l := range f.Lines {
+			_, _ = fmt.Fprintf(bw, "DA:%d,%d\n", l.Line, l.Count)
+		}
+		hit, found = f.LinesCovered()
+		_, _ = fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+	}
+	return bw.Flush()
+}
+
+// WriteHTML writes the coverage report in HTML. The source files are read
+// using readFile or from the file system if readFile is nil. The files
+// that cannot be read are reported without the source code.
+func (c *Coverage) WriteHTML(
+	w io.Writer,
+	readFile func(filename string) ([]byte, error),
+) error {
+	if readFile == nil {
+		readFile = ioutil.ReadFile
+	}
+
+	var data htmlCoverage
+	for i, f := range c.Files() {
+		hf := htmlCoverageFile{
+			ID:       fmt.Sprintf("file%d", i),
+			Name:     f.Name,
+			Lines:    coveragePercent(f.LinesCovered()),
+			Branches: coveragePercent(f.BranchesCovered()),
+		}
+
+		lines := make(map[int]CoverageLine)
+		for _, l := range f.Lines {
+			lines[l.Line] = l
+		}
+		branches := make(map[int][]CoverageBranch)
+		for _, b := range f.Branches {
+			branches[b.Line] = append(branches[b.Line], b)
+		}
+
+		var src []string
+		if s, err := readFile(f.Name); err == nil {
+			src = strings.Split(strings.TrimSuffix(string(s), "\n"), "\n")
+		}
+		n := len(src)
+		if len(f.Lines) > 0 && f.Lines[len(f.Lines)-1].Line > n {
+			n = f.Lines[len(f.Lines)-1].Line
+		}
+		for line := 1; line <= n; line++ {
+			hl := htmlCoverageLine{Line: line, Class: "none"}
+			if line <= len(src) {
+				hl.Source = src[line-1]
+			}
+			if l, ok := lines[line]; ok {
+				hl.Count = fmt.Sprint(l.Count)
+				hl.Class = "hit"
+				if l.Count == 0 {
+					hl.Class = "miss"
+				}
+			}
+			var info []string
+			for _, b := range branches[line] {
+				info = append(info, fmt.Sprintf("column %d: true %d, false %d",
+					b.Column, b.True, b.False))
+				if hl.Class == "hit" && !b.Covered() {
+					hl.Class = "partial"
+				}
+			}
+			hl.Branches = strings.Join(info, "; ")
+			hf.Source = append(hf.Source, hl)
+		}
+		data.Files = append(data.Files, hf)
+	}
+	return htmlCoverageTemplate.Execute(w, data)
+}
+
+func coveragePercent(covered, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%d/%d)",
+		float64(covered)*100/float64(total), covered, total)
+}
+
+type htmlCoverage struct {
+	Files []htmlCoverageFile
+}
+
+type htmlCoverageFile struct {
+	ID       string
+	Name     string
+	Lines    string
+	Branches string
+	Source   []htmlCoverageLine
+}
+
+type htmlCoverageLine struct {
+	Line     int
+	Count    string
+	Class    string
+	Source   string
+	Branches string
+}
+
+var htmlCoverageTemplate = template.Must(template.New("coverage").Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 0 8px; text-align: left; }
+pre { margin: 0; }
+.source td { font-family: monospace; white-space: pre; }
+.num { color: #999; text-align: right; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.partial { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Coverage</h1>
+<table>
+<tr><th>File</th><th>Lines</th><th>Branches</th></tr>
+{{range .Files}}<tr><td><a href="#{{.ID}}">{{.Name}}</a></td><td>{{.Lines}}</td><td>{{.Branches}}</td></tr>
+{{end}}</table>
+{{range .Files}}
+<h2 id="{{.ID}}">{{.Name}}</h2>
+<table class="source">
+{{range .Source}}<tr class="{{.Class}}"{{if .Branches}} title="{{.Branches}}"{{end}}><td class="num">{{.Line}}</td><td class="num">{{.Count}}</td><td>{{.Source}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// trace is called by the VM before executing each instruction.
+func (r *vmCoverage) trace() {
+	v := r.vm
+	if fn := v.curFrame.fn; fn != r.fn {
+		r.fn = fn
+		key := functionKey(fn)
+		f, ok := r.funcs[key]
+		if !ok {
+			f = &vmCoverageFunc{
+				fn:       fn,
+				counts:   make([]int64, len(fn.Instructions)),
+				branches: make(map[int]*[2]int64),
+			}
+			r.funcs[key] = f
+		}
+		r.cur = f
+	}
+	if v.ip >= len(r.cur.counts) {
+		return
+	}
+	r.cur.counts[v.ip]++
+
+	// the condition is on top of the stack.
+	var falsy bool
+	switch v.curInsts[v.ip] {
+	case parser.OpJumpFalsy, parser.OpAndJump, parser.OpOrJump:
+		falsy = v.stack[v.sp-1].IsFalsy()
+	case parser.OpChainJump, parser.OpCoalesceJump:
+		falsy = isNullish(v.stack[v.sp-1])
+	default:
+		return
+	}
+	taken, ok := r.cur.branches[v.ip]
+	if !ok {
+		taken = &[2]int64{}
+		r.cur.branches[v.ip] = taken
+	}
+	if falsy {
+		taken[1]++
+	} else {
+		taken[0]++
+	}
+}
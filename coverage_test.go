@@ -0,0 +1,169 @@
+package tengo_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/require"
+)
+
+func TestCoverage(t *testing.T) {
+	src := `
+f := func(x) {
+	if x > 5 {
+		return "big"
+	} else if x < 0 {
+		return "negative"
+	}
+	return x ? "some" : "zero"
+}
+g := func() {
+	return 1
+}
+out := []
+for i := 0; i < 10; i++ {
+	out = append(out, f(i))
+}
+a := true && out[0] == "zero" || false
+`
+	cov := tengo.NewCoverage()
+	v, _ := compileDebug(t, src)
+	v.SetCoverage(cov)
+	require.NoError(t, v.Run())
+
+	files := cov.Files()
+	require.Equal(t, 1, len(files))
+	f := files[0]
+	require.Equal(t, "test", f.Name)
+	require.Equal(t, []string{"2:1", "3:10", "4:4", "5:6", "6:0", "8:6",
+		"10:1", "11:0", "13:1", "14:11", "15:10", "17:1"},
+		coverageLines(f))
+	require.Equal(t, []string{
+		"3:2 4/6",   // x > 5
+		"5:9 0/6",   // x < 0
+		"8:9 5/1",   // x ?
+		"17:6 1/0",  // true &&
+		"17:6 1/0"}, // ... ||
+		coverageBranches(f))
+	covered, total := f.LinesCovered()
+	require.Equal(t, 10, covered)
+	require.Equal(t, 12, total)
+	covered, total = f.BranchesCovered()
+	require.Equal(t, 7, covered)
+	require.Equal(t, 10, total)
+
+	// runs are merged
+	require.NoError(t, v.Run())
+	f = cov.Files()[0]
+	require.Equal(t, "15:20", coverageLines(f)[10])
+	require.Equal(t, "3:2 8/12", coverageBranches(f)[0])
+
+	other := tengo.NewCoverage()
+	v.SetCoverage(other)
+	require.NoError(t, v.Run())
+	cov.Merge(other)
+	f = cov.Files()[0]
+	require.Equal(t, "15:30", coverageLines(f)[10])
+	require.Equal(t, "3:2 12/18", coverageBranches(f)[0])
+
+	cov.Reset()
+	require.Equal(t, 0, len(cov.Files()))
+}
+
+func TestCoverage_Optional(t *testing.T) {
+	src := `
+a := {b: 0}
+for k in ["b", "c"] {
+	x := a?.[k] ?? 1
+}
+`
+	cov := tengo.NewCoverage()
+	v, _ := compileDebug(t, src)
+	v.SetCoverage(cov)
+	require.NoError(t, v.Run())
+	require.Equal(t, []string{
+		"4:7 2/0",  // a?.
+		"4:7 1/1"}, // ... ??
+		coverageBranches(cov.Files()[0]))
+}
+
+func TestCoverage_Script(t *testing.T) {
+	s := tengo.NewScript([]byte(`
+b := 0
+if a > 0 {
+	b = string(a)
+}`))
+	require.NoError(t, s.Add("a", 0))
+	c, err := s.Compile()
+	require.NoError(t, err)
+
+	cov := tengo.NewCoverage()
+	c.SetCoverage(cov)
+	require.NoError(t, c.Run())
+	c2 := c.Clone()
+	require.NoError(t, c2.Set("a", 1))
+	require.NoError(t, c2.Run())
+
+	files := cov.Files()
+	require.Equal(t, 1, len(files))
+	require.Equal(t, "(main)", files[0].Name)
+	require.Equal(t, []string{"2:2", "3:2", "4:1"}, coverageLines(files[0]))
+	require.Equal(t, []string{"3:1 1/1"}, coverageBranches(files[0]))
+
+	var lcov bytes.Buffer
+	require.NoError(t, cov.WriteLCOV(&lcov))
+	require.Equal(t, `TN:
+SF:(main)
+BRDA:3,0,0,1
+BRDA:3,0,1,1
+BRF:2
+BRH:2
+DA:2,2
+DA:3,2
+DA:4,1
+LF:3
+LH:3
+end_of_record
+`, lcov.String())
+
+	var html bytes.Buffer
+	require.NoError(t, cov.WriteHTML(&html,
+		func(filename string) ([]byte, error) {
+			require.Equal(t, "(main)", filename)
+			return []byte("// <source>\nif a > 0 {\n\tb := 1\n}\n"), nil
+		}))
+	for _, s := range []string{
+		`<a href="#file0">(main)</a>`,
+		"<td>100.0% (3/3)</td>",
+		"&lt;source&gt;",
+		`<tr class="hit" title="column 1: true 1, false 1">`,
+	} {
+		require.True(t, strings.Contains(html.String(), s), s)
+	}
+
+	// source files not found
+	html.Reset()
+	require.NoError(t, cov.WriteHTML(&html, nil))
+	require.True(t, strings.Contains(html.String(),
+		`<td class="num">4</td><td class="num">1</td><td></td>`))
+}
+
+func coverageLines(f *tengo.CoverageFile) []string {
+	var res []string
+	for _, l := range f.Lines {
+		res = append(res, fmt.Sprintf("%d:%d", l.Line, l.Count))
+	}
+	return res
+}
+
+func coverageBranches(f *tengo.CoverageFile) []string {
+	var res []string
+	for _, b := range f.Branches {
+		res = append(res, fmt.Sprintf("%d:%d %d/%d",
+			b.Line, b.Column, b.True, b.False))
+	}
+	return res
+}
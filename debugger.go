@@ -0,0 +1,368 @@
+package tengo
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/d5/tengo/v2/parser"
+)
+
+// StopReason represents the reason why the debugger stopped the VM.
+type StopReason int
+
+// List of stop reasons.
+const (
+	StopBreakpoint StopReason = iota + 1
+	StopStep
+	StopPause
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	case StopPause:
+		return "pause"
+	}
+	return ""
+}
+
+// DebugHandler is called in the goroutine running the VM when the debugger
+// stops the VM. The VM resumes when the handler returns. The handler can
+// inspect the VM state and choose how to resume using Continue, StepInto,
+// StepOver or StepOut of the debugger. The VM continues by default.
+type DebugHandler func(d *Debugger, reason StopReason)
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepInto
+	stepOver
+	stepOut
+)
+
+type sourceLine struct {
+	filename string
+	line     int
+}
+
+// DebugFrame represents a call frame of the VM being debugged.
+type DebugFrame struct {
+	Fn  *CompiledFunction
+	Pos parser.SourceFilePos
+}
+
+// Debugger is a step debugger of a VM. It stops the VM at the breakpoints or
+// after the step operations and it provides the call frames and the
+// variables of the VM while it's stopped.
+type Debugger struct {
+	vm          *VM
+	globals     map[string]int
+	handler     DebugHandler
+	lock        sync.Mutex
+	breakpoints map[sourceLine]bool
+	codeLines   map[sourceLine]bool
+	pausing     int32
+	mode        stepMode
+	stepDepth   int
+	depth       int
+	lines       [MaxFrames]sourceLine
+	pos         parser.SourceFilePos
+}
+
+// NewDebugger creates a Debugger and attaches it to the VM. Symbol table is
+// used to resolve the global variables by name and it can be nil.
+func NewDebugger(
+	vm *VM,
+	symbolTable *SymbolTable,
+	handler DebugHandler,
+) *Debugger {
+	d := &Debugger{
+		vm:          vm,
+		globals:     make(map[string]int),
+		handler:     handler,
+		breakpoints: make(map[sourceLine]bool),
+	}
+	for symbolTable != nil && symbolTable.parent != nil {
+		symbolTable = symbolTable.parent
+	}
+	if symbolTable != nil {
+		for name, s := range symbolTable.store {
+			if s.Scope == ScopeGlobal {
+				d.globals[name] = s.Index
+			}
+		}
+	}
+	vm.debugger = d
+	return d
+}
+
+// Detach detaches the debugger from the VM.
+func (d *Debugger) Detach() {
+	d.vm.debugger = nil
+}
+
+// SetBreakpoint sets a breakpoint at the given line of the file. If the
+// line has no instructions, the breakpoint is set at the next line that has
+// instructions. It returns the line of the breakpoint or false if there are
+// no instructions at or after the line.
+func (d *Debugger) SetBreakpoint(filename string, line int) (int, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.codeLines == nil {
+		d.codeLines = make(map[sourceLine]bool)
+		d.addCodeLines(d.vm.bc.MainFunction)
+		for _, c := range d.vm.bc.Constants {
+			if fn, ok := c.(*CompiledFunction); ok {
+				d.addCodeLines(fn)
+			}
+		}
+	}
+
+	var last int
+	for l := range d.codeLines {
+		if l.filename == filename && l.line > last {
+			last = l.line
+		}
+	}
+	for ; line <= last; line++ {
+		l := sourceLine{filename: filename, line: line}
+		if d.codeLines[l] {
+			d.breakpoints[l] = true
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+// SetBreakpointAt sets a breakpoint at the line of the source position.
+func (d *Debugger) SetBreakpointAt(pos parser.Pos) (int, bool) {
+	p := d.vm.fileSet.Position(pos)
+	if !p.IsValid() {
+		return 0, false
+	}
+	return d.SetBreakpoint(p.Filename, p.Line)
+}
+
+// ClearBreakpoint removes the breakpoint at the given line of the file.
+func (d *Debugger) ClearBreakpoint(filename string, line int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.breakpoints, sourceLine{filename: filename, line: line})
+}
+
+// ClearBreakpoints removes all the breakpoints of the file.
+func (d *Debugger) ClearBreakpoints(filename string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for l := range d.breakpoints {
+		if l.filename == filename {
+			delete(d.breakpoints, l)
+		}
+	}
+}
+
+// Continue resumes the VM until it reaches a breakpoint.
+func (d *Debugger) Continue() {
+	d.mode = stepNone
+}
+
+// StepInto resumes the VM until it reaches the next line.
+func (d *Debugger) StepInto() {
+	d.mode = stepInto
+}
+
+// StepOver resumes the VM until it reaches the next line of the current
+// function or its callers.
+func (d *Debugger) StepOver() {
+	d.mode = stepOver
+}
+
+// StepOut resumes the VM until it returns from the current function.
+func (d *Debugger) StepOut() {
+	d.mode = stepOut
+}
+
+// Pause stops the VM at the next line. Unlike other methods, it's safe to
+// call Pause from any goroutine.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pausing, 1)
+}
+
+// Pos returns the source position where the VM is stopped.
+func (d *Debugger) Pos() parser.SourceFilePos {
+	return d.pos
+}
+
+// Frames returns the call frames of the VM starting from the innermost one.
+func (d *Debugger) Frames() []DebugFrame {
+	v := d.vm
+	frames := make([]DebugFrame, 0, v.framesIndex)
+	for i := 0; i < v.framesIndex; i++ {
+		f := &v.frames[v.framesIndex-1-i]
+		frames = append(frames, DebugFrame{
+			Fn:  f.fn,
+			Pos: v.fileSet.Position(f.fn.SourcePos(d.frameIP(i))),
+		})
+	}
+	return frames
+}
+
+// Locals returns the local variables visible in the call frame. Frame 0 is
+// the innermost frame.
+func (d *Debugger) Locals(frame int) map[string]Object {
+	f := d.frame(frame)
+	if f == nil {
+		return nil
+	}
+	ip := d.frameIP(frame)
+	locals := make(map[string]Object)
+	starts := make(map[string]int)
+	for _, l := range f.fn.Locals {
+		if ip < l.Start || ip >= l.End {
+			continue
+		}
+		// variable of the inner block shadows the outer one.
+		if start, ok := starts[l.Name]; ok && start > l.Start {
+			continue
+		}
+		starts[l.Name] = l.Start
+		locals[l.Name] = derefObject(d.vm.stack[f.basePointer+l.Index])
+	}
+	return locals
+}
+
+// FreeVars returns the free variables of the call frame. Frame 0 is the
+// innermost frame.
+func (d *Debugger) FreeVars(frame int) map[string]Object {
+	f := d.frame(frame)
+	if f == nil {
+		return nil
+	}
+	free := make(map[string]Object)
+	for i, name := range f.fn.FreeNames {
+		if i < len(f.freeVars) {
+			free[name] = *f.freeVars[i].Value
+		}
+	}
+	return free
+}
+
+// Globals returns the global variables.
+func (d *Debugger) Globals() map[string]Object {
+	globals := make(map[string]Object)
+	for name, idx := range d.globals {
+		if idx < len(d.vm.globals) {
+			globals[name] = derefObject(d.vm.globals[idx])
+		}
+	}
+	return globals
+}
+
+// Variable returns the value of the variable visible in the call frame. It
+// looks up the local variables, the free variables and the global variables
+// in order.
+func (d *Debugger) Variable(frame int, name string) (Object, bool) {
+	if o, ok := d.Locals(frame)[name]; ok {
+		return o, true
+	}
+	if o, ok := d.FreeVars(frame)[name]; ok {
+		return o, true
+	}
+	if idx, ok := d.globals[name]; ok && idx < len(d.vm.globals) {
+		return derefObject(d.vm.globals[idx]), true
+	}
+	return nil, false
+}
+
+func (d *Debugger) frame(i int) *frame {
+	if i < 0 || i >= d.vm.framesIndex {
+		return nil
+	}
+	return &d.vm.frames[d.vm.framesIndex-1-i]
+}
+
+func (d *Debugger) frameIP(frame int) int {
+	if frame == 0 {
+		return d.vm.ip
+	}
+	// ip of the caller frames is the last operand of the call instruction.
+	return d.vm.frames[d.vm.framesIndex-1-frame].ip
+}
+
+func (d *Debugger) addCodeLines(fn *CompiledFunction) {
+	for _, p := range fn.SourceMap {
+		pos := d.vm.fileSet.Position(p)
+		if pos.IsValid() {
+			d.codeLines[sourceLine{filename: pos.Filename, line: pos.Line}] = true
+		}
+	}
+}
+
+// trace is called by the VM before executing each instruction.
+func (d *Debugger) trace() {
+	v := d.vm
+
+	// source line of new call frames are reset.
+	depth := v.framesIndex
+	for ; d.depth < depth; d.depth++ {
+		d.lines[d.depth] = sourceLine{}
+	}
+	d.depth = depth
+
+	p, ok := v.curFrame.fn.SourceMap[v.ip]
+	if !ok {
+		return
+	}
+	pos := v.fileSet.Position(p)
+	if !pos.IsValid() {
+		return
+	}
+
+	// the VM stops only when the source line of the frame changes.
+	line := sourceLine{filename: pos.Filename, line: pos.Line}
+	if d.lines[depth-1] == line {
+		return
+	}
+	d.lines[depth-1] = line
+
+	var reason StopReason
+	switch {
+	case atomic.CompareAndSwapInt32(&d.pausing, 1, 0):
+		reason = StopPause
+	case d.mode == stepInto,
+		d.mode == stepOver && depth <= d.stepDepth,
+		d.mode == stepOut && depth < d.stepDepth:
+		reason = StopStep
+	default:
+		d.lock.Lock()
+		if d.breakpoints[line] {
+			reason = StopBreakpoint
+		}
+		d.lock.Unlock()
+	}
+	if reason == 0 {
+		return
+	}
+
+	d.mode = stepNone
+	d.stepDepth = depth
+	d.pos = pos
+	if d.handler != nil {
+		d.handler(d, reason)
+	}
+}
+
+func derefObject(o Object) Object {
+	if ptr, ok := o.(*ObjectPtr); ok {
+		return *ptr.Value
+	}
+	if o == nil {
+		return UndefinedValue
+	}
+	return o
+}
@@ -0,0 +1,172 @@
+package tengo_test
+
+import (
+	"testing"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/require"
+)
+
+func TestDebugger(t *testing.T) {
+	src := `
+a := 1
+f := func(x) {
+	y := x * 2
+	return y
+}
+b := f(a)
+c := f(b)
+`
+	var lines []int
+	run := func(
+		handler tengo.DebugHandler,
+		setup func(d *tengo.Debugger),
+	) {
+		lines = nil
+		v, symbols := compileDebug(t, src)
+		d := tengo.NewDebugger(v, symbols,
+			func(d *tengo.Debugger, reason tengo.StopReason) {
+				lines = append(lines, d.Pos().Line)
+				handler(d, reason)
+			})
+		setup(d)
+		require.NoError(t, v.Run())
+	}
+
+	// breakpoints
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {
+		require.Equal(t, "breakpoint", reason.String())
+	}, func(d *tengo.Debugger) {
+		line, ok := d.SetBreakpoint("test", 4)
+		require.True(t, ok)
+		require.Equal(t, 4, line)
+	})
+	require.Equal(t, []int{4, 4}, lines)
+
+	// breakpoint at the line without instructions
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {},
+		func(d *tengo.Debugger) {
+			line, ok := d.SetBreakpoint("test", 6)
+			require.True(t, ok)
+			require.Equal(t, 7, line)
+			_, ok = d.SetBreakpoint("test", 9)
+			require.False(t, ok)
+		})
+	require.Equal(t, []int{7}, lines)
+
+	// step into
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {
+		require.Equal(t, "step", reason.String())
+		d.StepInto()
+	}, func(d *tengo.Debugger) {
+		d.StepInto()
+	})
+	require.Equal(t, []int{2, 3, 7, 4, 5, 8, 4, 5}, lines)
+
+	// step over
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {
+		d.StepOver()
+	}, func(d *tengo.Debugger) {
+		d.StepInto()
+	})
+	require.Equal(t, []int{2, 3, 7, 8}, lines)
+
+	// step out
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {
+		d.StepOut()
+	}, func(d *tengo.Debugger) {
+		d.SetBreakpoint("test", 5)
+	})
+	require.Equal(t, []int{5, 8, 5}, lines)
+
+	// pause
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {
+		require.Equal(t, "pause", reason.String())
+	}, func(d *tengo.Debugger) {
+		d.Pause()
+	})
+	require.Equal(t, []int{2}, lines)
+
+	// clear breakpoints
+	run(func(d *tengo.Debugger, reason tengo.StopReason) {
+		d.ClearBreakpoint("test", 4)
+	}, func(d *tengo.Debugger) {
+		d.SetBreakpoint("test", 4)
+		d.SetBreakpoint("test", 8)
+	})
+	require.Equal(t, []int{4, 8}, lines)
+}
+
+func TestDebuggerVariables(t *testing.T) {
+	v, symbols := compileDebug(t, `
+a := 1
+f := func(x) {
+	y := x * 2
+	if y > 0 {
+		y := "shadow"
+		g := func() {
+			return x + a
+		}
+		g()
+	}
+	return y
+}
+b := f(a)
+`)
+	var stops int
+	d := tengo.NewDebugger(v, symbols,
+		func(d *tengo.Debugger, reason tengo.StopReason) {
+			stops++
+			frames := d.Frames()
+			switch d.Pos().Line {
+			case 4:
+				require.Equal(t, 2, len(frames))
+				require.Equal(t, 14, frames[1].Pos.Line)
+				require.Equal(t, &tengo.Map{Value: map[string]tengo.Object{
+					"x": &tengo.Int{Value: 1},
+				}}, &tengo.Map{Value: d.Locals(0)})
+				require.Equal(t, 0, len(d.Locals(1)))
+				require.Equal(t, &tengo.Int{Value: 1}, d.Globals()["a"])
+			case 10:
+				require.Equal(t, &tengo.Map{Value: map[string]tengo.Object{
+					"x": &tengo.Int{Value: 1},
+					"y": &tengo.String{Value: "shadow"},
+					"g": d.Locals(0)["g"],
+				}}, &tengo.Map{Value: d.Locals(0)})
+			case 12:
+				y, ok := d.Variable(0, "y")
+				require.True(t, ok)
+				require.Equal(t, &tengo.Int{Value: 2}, y)
+			case 8:
+				require.Equal(t, 3, len(frames))
+				require.Equal(t, &tengo.Map{Value: map[string]tengo.Object{
+					"x": &tengo.Int{Value: 1},
+				}}, &tengo.Map{Value: d.FreeVars(0)})
+				a, ok := d.Variable(0, "a")
+				require.True(t, ok)
+				require.Equal(t, &tengo.Int{Value: 1}, a)
+				_, ok = d.Variable(0, "y")
+				require.False(t, ok)
+			}
+		})
+	for _, line := range []int{4, 8, 10, 12} {
+		_, ok := d.SetBreakpoint("test", line)
+		require.True(t, ok)
+	}
+	require.NoError(t, v.Run())
+	require.Equal(t, 4, stops)
+}
+
+func compileDebug(t *testing.T, src string) (*tengo.VM, *tengo.SymbolTable) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("test", -1, len(src))
+	p := parser.NewParser(srcFile, []byte(src), nil)
+	file, err := p.ParseFile()
+	require.NoError(t, err)
+
+	symbols := tengo.NewSymbolTable()
+	c := tengo.NewCompiler(srcFile, symbols, nil, nil, nil)
+	require.NoError(t, c.Compile(file))
+	return tengo.NewVM(c.Bytecode(), nil, -1), symbols
+}
@@ -3,6 +3,8 @@ package tengo
 import (
 	"errors"
 	"fmt"
+
+	"github.com/d5/tengo/v2/parser"
 )
 
 var (
@@ -60,6 +62,22 @@ var (
 	// required method.
 	ErrNotImplemented = errors.New("not implemented")
 
+	// ErrMemoryLimit is an error where the VM allocated more memory than the
+	// limit.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+
+	// ErrInstructionLimit is an error where the VM executed more instructions
+	// than the limit.
+	ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+	// ErrTimeLimit is an error where the VM was still running after the
+	// deadline.
+	ErrTimeLimit = errors.New("time limit exceeded")
+
+	// ErrAborted is an error where a compiled function called by a Go
+	// function did not return because the VM was aborted.
+	ErrAborted = errors.New("vm aborted")
+
 	// ErrInvalidRangeStep is an error where the step parameter is less than or equal to 0 when using builtin range function.
 	ErrInvalidRangeStep = errors.New("range step must be greater than 0")
 )
@@ -75,3 +93,39 @@ func (e ErrInvalidArgumentType) Error() string {
 	return fmt.Sprintf("invalid type for argument '%s': expected %s, found %s",
 		e.Name, e.Expected, e.Found)
 }
+
+// DecodeError represents an error where an object cannot be decoded to the
+// Go value. Path is the path of the object from the decoded object, e.g.
+// "rules[3].threshold" or empty if the decoded object itself cannot be
+// decoded.
+type DecodeError struct {
+	Path     string
+	Expected string
+	Found    string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("expected %s, got %s", e.Expected, e.Found)
+	}
+	return fmt.Sprintf("%s: expected %s, got %s",
+		e.Path, e.Expected, e.Found)
+}
+
+// LimitError represents a runtime error where the execution exceeded the
+// instruction or the time budget. Err is either ErrInstructionLimit or
+// ErrTimeLimit and Pos is the position of the instruction the VM stopped
+// at. The errors cannot be caught by try statements.
+type LimitError struct {
+	Err error
+	Pos parser.SourceFilePos
+}
+
+func (e *LimitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the ErrInstructionLimit or ErrTimeLimit error.
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
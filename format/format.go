@@ -0,0 +1,64 @@
+// Package format implements the canonical formatting of Tengo source code.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/d5/tengo/v2/parser"
+)
+
+// Source formats the source code in the canonical style. Comments are
+// preserved. It returns an error if the source code cannot be parsed.
+func Source(src []byte) ([]byte, error) {
+	// shebang line is formatted as a comment.
+	shebang := len(src) > 1 && string(src[:2]) == "#!"
+	if shebang {
+		src = append([]byte("//"), src[2:]...)
+	}
+
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("", -1, len(src))
+	p := parser.NewParserWithMode(srcFile, src, nil, parser.ParseComments)
+	file, err := p.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := Node(&buf, file); err != nil {
+		return nil, err
+	}
+	res := buf.Bytes()
+	if shebang {
+		copy(res, "#!")
+	}
+	return res, nil
+}
+
+// Node formats the node in the canonical style and writes the result to w.
+// The node must be a *parser.File, a parser.Stmt or a parser.Expr. The
+// comments are preserved only if the node is a *parser.File parsed with
+// parser.ParseComments mode.
+func Node(w io.Writer, node parser.Node) error {
+	p := &printer{}
+	switch node := node.(type) {
+	case *parser.File:
+		p.file = node.InputFile
+		p.comments = node.Comments
+		p.stmtList(node.Stmts)
+		p.flushComments(parser.Pos(p.file.Base + p.file.Size + 1))
+		if !p.lineStart {
+			p.newline()
+		}
+	case parser.Stmt:
+		p.stmt(node)
+	case parser.Expr:
+		p.expr(node)
+	default:
+		return fmt.Errorf("unsupported node type: %T", node)
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
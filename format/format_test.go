@@ -0,0 +1,246 @@
+package format_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/d5/tengo/v2/format"
+	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/require"
+)
+
+func TestSource(t *testing.T) {
+	expectFormat(t, `a:=1`, "a := 1\n")
+	expectFormat(t, "a := 1\n\n\n\nb := 2", "a := 1\n\nb := 2\n")
+	expectFormat(t, `a  =  b+c*d`, "a = b + c * d\n")
+	expectFormat(t, `x := (a+b)*-c`, "x := (a + b) * -c\n")
+	expectFormat(t, `x := a ? b:c`, "x := a ? b : c\n")
+	expectFormat(t, `a += 1; b++`, "a += 1\nb++\n")
+	expectFormat(t, `x := a[1:] + a[:2] + a[1:2] + a[:]`,
+		"x := a[1:] + a[:2] + a[1:2] + a[:]\n")
+	expectFormat(t, `x := a.b.c["d"]`, "x := a.b.c[\"d\"]\n")
+	expectFormat(t, `x := a?.b?.["c"]?.(1)?.[1:]??d`,
+		"x := a?.b?.[\"c\"]?.(1)?.[1:] ?? d\n")
+	expectFormat(t, `x := [1,2,  3]`, "x := [1, 2, 3]\n")
+	expectFormat(t, `x := {a:1, "b c": 2, "if": 3, d}`,
+		"x := {a: 1, \"b c\": 2, \"if\": 3, d}\n")
+	expectFormat(t, `x := 0x1F + 1e3 + 'a' + "\u00e9" + `+"`raw`",
+		"x := 0x1F + 1e3 + 'a' + \"\\u00e9\" + `raw`\n")
+	expectFormat(t, `x := import("fmt")`, "x := import(\"fmt\")\n")
+	expectFormat(t, `x := immutable([error("e")])`,
+		"x := immutable([error(\"e\")])\n")
+	expectFormat(t, `export {a: 1}`, "export {a: 1}\n")
+}
+
+func TestSource_Statements(t *testing.T) {
+	expectFormat(t, `if a { b() } else if c { d() } else { e() }`,
+		"if a { b() } else if c { d() } else { e() }\n")
+	expectFormat(t, `if x := f(); x > 0 {
+return x
+}`, `if x := f(); x > 0 {
+	return x
+}
+`)
+	expectFormat(t, `for {
+break
+}`, `for {
+	break
+}
+`)
+	expectFormat(t, `for i:=0;i<10;i++ {
+if i == 5 { continue }
+}`, `for i := 0; i < 10; i++ {
+	if i == 5 { continue }
+}
+`)
+	expectFormat(t, `for ;; {}`, "for {}\n")
+	expectFormat(t, `for a < 10 {}`, "for a < 10 {}\n")
+	expectFormat(t, `for x in arr {}`, "for x in arr {}\n")
+	expectFormat(t, `for k,v in m {}`, "for k, v in m {}\n")
+	expectFormat(t, `for k,[a,b=1] in m {}`, "for k, [a, b = 1] in m {}\n")
+	expectFormat(t, `for {k,v} in m {}`, "for {k, v} in m {}\n")
+	expectFormat(t, `a,b,...rest:=arr`, "a, b, ...rest := arr\n")
+	expectFormat(t, `{name,age:years=1,x=2,...others}:=m`,
+		"{name, age: years = 1, x = 2, ...others} := m\n")
+	expectFormat(t, `[x,{y}]=v`, "[x, {y}] = v\n")
+	expectFormat(t, `a:=[1,... xs]`, "a := [1, ...xs]\n")
+	expectFormat(t, `a:={...m,k:1}`, "a := {...m, k: 1}\n")
+	expectFormat(t, `try { a() } catch err { b(err) } finally { c() }`,
+		"try { a() } catch err { b(err) } finally { c() }\n")
+	expectFormat(t, `try {
+a()
+} catch {
+}`, `try {
+	a()
+} catch {}
+`)
+	expectFormat(t, `switch x { case 1,2: a(); b()
+case [a,[b,_],...r] if a>b:
+
+	c()
+// default
+default:
+}`, `switch x {
+case 1, 2:
+	a()
+	b()
+case [a, [b, _], ...r] if a > b:
+	c()
+// default
+default:
+}
+`)
+	expectFormat(t, `switch s:=f();{
+case {k:[v],"a b":1,n}, is "int": return // ok
+}`, `switch s := f(); {
+case {k: [v], "a b": 1, n}, is "int":
+	return // ok
+}
+`)
+	expectFormat(t, `switch {}`, "switch {\n}\n")
+	expectFormat(t, `f := func() {
+	a := 1
+
+
+	return a
+}`, `f := func() {
+	a := 1
+
+	return a
+}
+`)
+	expectFormat(t, `f := func() {
+
+	return
+}`, `f := func() {
+	return
+}
+`)
+}
+
+func TestSource_Comments(t *testing.T) {
+	expectFormat(t, `// header
+
+a := 1 // trailing
+/* block */ b := 2
+// last`, `// header
+
+a := 1 // trailing
+/* block */ b := 2
+// last
+`)
+	expectFormat(t, `f := func() {
+	// inside
+	return 1 // one
+	// end
+}`, `f := func() {
+	// inside
+	return 1 // one
+	// end
+}
+`)
+	expectFormat(t, `if a { // comment
+}`, `if a { // comment
+}
+`)
+	expectFormat(t, `x := [
+	1, // one
+	// two
+	2
+]`, `x := [
+	1, // one
+	// two
+	2
+]
+`)
+
+	// block comments inside the lines stay before the following node
+	expectFormat(t, `a := 1 /* inline */ + 2`, "a := 1 /* inline */ + 2\n")
+	expectFormat(t, `a := 1 +   /* two */2 // end`,
+		"a := 1 + /* two */ 2 // end\n")
+	expectFormat(t, `a /* a */ := f(/* x */x, [1, /* y */ 2])`,
+		"a /* a */ := f(/* x */ x, [1, /* y */ 2])\n")
+	expectFormat(t, `a := b /* b */ ? /* c */ c : d /* d */`,
+		"a := b /* b */ ? /* c */ c : d /* d */\n")
+	expectFormat(t, "a := 1 + /* two\nlines */ 2",
+		"a := 1 + /* two\nlines */ 2\n")
+	expectFormat(t, "#!/usr/bin/env tengo\nfmt := import(\"fmt\")",
+		"#!/usr/bin/env tengo\nfmt := import(\"fmt\")\n")
+}
+
+func TestSource_Calls(t *testing.T) {
+	expectFormat(t, `f(1,2)`, "f(1, 2)\n")
+	expectFormat(t, `f(args...)`, "f(args...)\n")
+	expectFormat(t, `f(1, c=2)`, "f(1; c=2)\n")
+	expectFormat(t, `f(1; c=2, d=3)`, "f(1; c=2, d=3)\n")
+	expectFormat(t, `f(c=2)`, "f(c=2)\n")
+	expectFormat(t, `f(a, b...; c=2, kw...)`, "f(a, b...; c=2, kw...)\n")
+	expectFormat(t, `f(; kw...)`, "f(; kw...)\n")
+	expectFormat(t, `f(c=2, kw...)`, "f(c=2, kw...)\n")
+	expectFormat(t, `f(1,
+2)`, `f(1,
+	2)
+`)
+	expectFormat(t, `Point := type("Point",
+fields(x=0,y=0),
+   methods(
+   str=func(this) {
+     return format("%d,%d", this.x, this.y)
+   }
+ )
+)`, `Point := type("Point",
+	fields(x=0, y=0),
+	methods(
+		str=func(this) {
+			return format("%d,%d", this.x, this.y)
+		}
+	)
+)
+`)
+}
+
+func TestSource_FuncParams(t *testing.T) {
+	expectFormat(t, `f := func(a,b) {}`, "f := func(a, b) {}\n")
+	expectFormat(t, `f := func(a, ...b) {}`, "f := func(a, ...b) {}\n")
+	expectFormat(t, `f := func(...) {}`, "f := func(...) {}\n")
+	expectFormat(t, `f := func(a; b=1, c=(1+2), d=(x ? 1 : 2), ...e) {}`,
+		"f := func(a; b=1, c=(1 + 2), d=(x ? 1 : 2), ...e) {}\n")
+	expectFormat(t, `f := func(; b=-1, ...) {}`,
+		"f := func(; b=-1, ...) {}\n")
+	expectFormat(t, `f := func(; ...kw) {}`, "f := func(; ...kw) {}\n")
+	expectFormat(t, `f := func([a,b],{c},...[d]) {}`,
+		"f := func([a, b], {c}, ...[d]) {}\n")
+}
+
+func TestSource_Error(t *testing.T) {
+	_, err := format.Source([]byte(`a := `))
+	require.Error(t, err)
+}
+
+func TestNode(t *testing.T) {
+	fileSet := parser.NewFileSet()
+	src := []byte(`a := [1,2]; b := func(x; y=1) { return x+y }`)
+	srcFile := fileSet.AddFile("test", -1, len(src))
+	file, err := parser.NewParser(srcFile, src, nil).ParseFile()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, format.Node(&buf, file.Stmts[1]))
+	require.Equal(t, "b := func(x; y=1) {\n\treturn x + y\n}", buf.String())
+
+	buf.Reset()
+	expr := file.Stmts[0].(*parser.AssignStmt).RHS[0]
+	require.NoError(t, format.Node(&buf, expr))
+	require.Equal(t, "[1, 2]", buf.String())
+}
+
+func expectFormat(t *testing.T, input, expected string) {
+	actual, err := format.Source([]byte(input))
+	require.NoError(t, err, input)
+	require.Equal(t, expected, string(actual), input)
+
+	// formatting the formatted source must not change it.
+	again, err := format.Source(actual)
+	require.NoError(t, err, expected)
+	require.Equal(t, expected, string(again), expected)
+}
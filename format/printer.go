@@ -0,0 +1,749 @@
+package format
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/token"
+)
+
+// printer prints the AST. The line breaks of the source are used to decide
+// the layout of blocks and lists: a block written in a single line stays in
+// a single line and the elements of a list are broken into lines only
+// where the source has line breaks.
+type printer struct {
+	buf       bytes.Buffer
+	file      *parser.SourceFile
+	comments  []*parser.Comment
+	next      int  // index of the next comment to print
+	indent    int  // current indentation level
+	lastLine  int  // source line of the last printed node
+	lineStart bool // nothing is written on the current output line
+	afterOpen bool // nothing is written after the opening brace
+	inline    bool // a block comment is the last thing written
+}
+
+func (p *printer) line(pos parser.Pos) int {
+	if p.file == nil || int(pos) < p.file.Base ||
+		int(pos) > p.file.Base+p.file.Size {
+		return 0
+	}
+	return p.file.Position(pos).Line
+}
+
+func (p *printer) write(s string) {
+	if p.lineStart {
+		for i := 0; i < p.indent; i++ {
+			p.buf.WriteByte('\t')
+		}
+		p.lineStart = false
+	} else if p.inline && s != "" && !strings.ContainsAny(s[:1], " ,;:)]}") {
+		// a space after the block comment
+		p.buf.WriteByte(' ')
+	}
+	p.buf.WriteString(s)
+	p.afterOpen = false
+	p.inline = false
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.lineStart = true
+}
+
+// linebreak starts a new line for the node at the source line. An empty
+// line of the source between the nodes is preserved.
+func (p *printer) linebreak(line int) {
+	if p.buf.Len() == 0 {
+		return
+	}
+	if !p.lineStart {
+		p.newline()
+	}
+	if !p.afterOpen && p.lastLine > 0 && line-p.lastLine > 1 {
+		p.newline()
+	}
+}
+
+// flushComments prints the comments before the position. A comment in the
+// same line of the last printed node is printed at the end of the line.
+func (p *printer) flushComments(pos parser.Pos) {
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if c.Pos() >= pos {
+			return
+		}
+		line := p.line(c.Pos())
+		if !p.lineStart && p.buf.Len() > 0 && line == p.lastLine {
+			if b := p.buf.Bytes(); strings.HasPrefix(c.Text, "//") ||
+				!strings.ContainsRune("([", rune(b[len(b)-1])) {
+				p.write(" ")
+			}
+		} else {
+			p.linebreak(line)
+		}
+		p.write(c.Text)
+		p.lastLine = p.line(c.End())
+		if strings.HasPrefix(c.Text, "//") {
+			p.newline()
+		} else {
+			p.inline = true
+		}
+	}
+}
+
+// inlineComments prints the block comments before the position inside the
+// current line, such as `1 /* one */ + 2`. The other comments are printed by
+// flushComments at the end of the line.
+func (p *printer) inlineComments(pos parser.Pos) {
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if c.Pos() >= pos || p.lineStart || !strings.HasPrefix(c.Text, "/*") {
+			return
+		}
+		if b := p.buf.Bytes(); !p.inline && len(b) > 0 &&
+			!strings.ContainsRune(" ([{", rune(b[len(b)-1])) {
+			p.write(" ")
+		}
+		p.write(c.Text)
+		p.lastLine = p.line(c.End())
+		p.inline = true
+	}
+}
+
+// hasComments returns true if there are comments to print before the
+// position.
+func (p *printer) hasComments(pos parser.Pos) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Pos() < pos
+}
+
+func (p *printer) stmtList(stmts []parser.Stmt) {
+	for _, s := range stmts {
+		if _, ok := s.(*parser.EmptyStmt); ok {
+			continue
+		}
+		p.flushComments(s.Pos())
+		if line := p.line(s.Pos()); p.inline && line == p.lastLine {
+			p.write(" ")
+		} else {
+			p.linebreak(line)
+		}
+		p.stmt(s)
+		p.lastLine = p.line(s.End())
+	}
+}
+
+func (p *printer) block(b *parser.BlockStmt) {
+	var stmts []parser.Stmt
+	for _, s := range b.Stmts {
+		if _, ok := s.(*parser.EmptyStmt); !ok {
+			stmts = append(stmts, s)
+		}
+	}
+
+	p.write("{")
+	p.lastLine = p.line(b.LBrace)
+	if !p.hasComments(b.RBrace) {
+		if len(stmts) == 0 {
+			p.write("}")
+			return
+		}
+		if len(stmts) == 1 && p.lastLine != 0 &&
+			p.lastLine == p.line(b.RBrace) {
+			p.write(" ")
+			p.stmt(stmts[0])
+			p.write(" }")
+			return
+		}
+	}
+
+	p.indent++
+	p.afterOpen = true
+	p.stmtList(stmts)
+	p.flushComments(b.RBrace)
+	p.indent--
+	p.afterOpen = true
+	p.linebreak(p.line(b.RBrace))
+	p.write("}")
+	p.lastLine = p.line(b.RBrace)
+}
+
+func (p *printer) stmt(node parser.Stmt) {
+	switch node := node.(type) {
+	case *parser.ExprStmt:
+		p.expr(node.Expr)
+	case *parser.AssignStmt:
+		p.exprList(node.LHS)
+		p.inlineComments(node.TokenPos)
+		p.write(" " + node.Token.String() + " ")
+		p.exprList(node.RHS)
+	case *parser.IncDecStmt:
+		p.expr(node.Expr)
+		p.write(node.Token.String())
+	case *parser.ReturnStmt:
+		p.write("return")
+		if node.Result != nil {
+			p.write(" ")
+			p.expr(node.Result)
+		}
+	case *parser.ExportStmt:
+		p.write("export ")
+		p.expr(node.Result)
+	case *parser.BranchStmt:
+		p.write(node.Token.String())
+		if node.Label != nil {
+			p.write(" " + node.Label.Name)
+		}
+	case *parser.BlockStmt:
+		p.block(node)
+	case *parser.IfStmt:
+		p.write("if ")
+		if node.Init != nil {
+			p.stmt(node.Init)
+			p.write("; ")
+		}
+		p.expr(node.Cond)
+		p.write(" ")
+		p.block(node.Body)
+		if node.Else != nil {
+			p.write(" else ")
+			p.stmt(node.Else)
+		}
+	case *parser.ForStmt:
+		p.write("for ")
+		if node.Init != nil || node.Post != nil {
+			if node.Init != nil {
+				p.stmt(node.Init)
+			}
+			p.write("; ")
+			if node.Cond != nil {
+				p.expr(node.Cond)
+			}
+			p.write("; ")
+			if node.Post != nil {
+				p.stmt(node.Post)
+				p.write(" ")
+			}
+		} else if node.Cond != nil {
+			p.expr(node.Cond)
+			p.write(" ")
+		}
+		p.block(node.Body)
+	case *parser.ForInStmt:
+		p.write("for ")
+		if node.Key.Name != "_" {
+			p.write(node.Key.Name + ", ")
+		}
+		if node.Pattern != nil {
+			p.expr(node.Pattern)
+			p.write(" in ")
+		} else {
+			p.write(node.Value.Name + " in ")
+		}
+		p.expr(node.Iterable)
+		p.write(" ")
+		p.block(node.Body)
+	case *parser.TryStmt:
+		p.write("try ")
+		p.block(node.Body)
+		if node.Catch != nil {
+			p.write(" catch ")
+			if node.Ident != nil {
+				p.write(node.Ident.Name + " ")
+			}
+			p.block(node.Catch)
+		}
+		if node.Finally != nil {
+			p.write(" finally ")
+			p.block(node.Finally)
+		}
+	case *parser.SwitchStmt:
+		p.write("switch ")
+		if node.Init != nil {
+			p.stmt(node.Init)
+			p.write("; ")
+		}
+		if node.Tag != nil {
+			p.expr(node.Tag)
+			p.write(" ")
+		}
+		p.switchBody(node)
+	case *parser.EmptyStmt:
+	default:
+		p.write(node.String())
+	}
+}
+
+// switchBody prints the cases of the switch statement. Like gofmt, the cases
+// are indented at the level of the switch and their statements are always
+// in the separate lines.
+func (p *printer) switchBody(s *parser.SwitchStmt) {
+	p.write("{")
+	p.lastLine = p.line(s.LBrace)
+	p.afterOpen = true
+	for _, c := range s.Cases {
+		p.flushComments(c.Pos())
+		p.linebreak(p.line(c.Pos()))
+		if c.Patterns == nil {
+			p.write("default")
+		} else {
+			p.write("case ")
+			p.exprList(c.Patterns)
+			if c.Guard != nil {
+				p.write(" if ")
+				p.expr(c.Guard)
+			}
+		}
+		p.write(":")
+		p.lastLine = p.line(c.Colon)
+
+		p.indent++
+		p.afterOpen = true
+		p.stmtList(c.Body)
+		p.indent--
+	}
+	p.indent++
+	p.flushComments(s.RBrace)
+	p.indent--
+	p.afterOpen = true
+	p.linebreak(p.line(s.RBrace))
+	p.write("}")
+	p.lastLine = p.line(s.RBrace)
+}
+
+func (p *printer) exprList(list []parser.Expr) {
+	for i, e := range list {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e)
+	}
+}
+
+func (p *printer) expr(node parser.Expr) {
+	p.inlineComments(node.Pos())
+	switch node := node.(type) {
+	case *parser.Ident:
+		p.write(node.Name)
+	case *parser.IntLit:
+		p.literal(node.Literal, strconv.FormatInt(node.Value, 10))
+	case *parser.FloatLit:
+		p.literal(node.Literal,
+			strconv.FormatFloat(node.Value, 'f', -1, 64))
+	case *parser.CharLit:
+		p.literal(node.Literal, strconv.QuoteRune(node.Value))
+	case *parser.StringLit:
+		p.literal(node.Literal, strconv.Quote(node.Value))
+	case *parser.BoolLit:
+		p.write(strconv.FormatBool(node.Value))
+	case *parser.UndefinedLit:
+		p.write("undefined")
+	case *parser.DefaultLit:
+		p.write("default")
+	case *parser.CalleeLit:
+		p.write(token.Callee.String())
+	case *parser.CalledArgsLit:
+		p.write(token.CalledArgs.String())
+	case *parser.CalledKwargsLit:
+		p.write(token.CalledKwargs.String())
+	case *parser.BinaryExpr:
+		p.expr(node.LHS)
+		p.inlineComments(node.TokenPos)
+		p.write(" " + node.Token.String() + " ")
+		p.expr(node.RHS)
+	case *parser.UnaryExpr:
+		p.write(node.Token.String())
+		p.expr(node.Expr)
+	case *parser.ParenExpr:
+		p.write("(")
+		p.expr(node.Expr)
+		p.write(")")
+	case *parser.CondExpr:
+		p.expr(node.Cond)
+		p.inlineComments(node.QuestionPos)
+		p.write(" ? ")
+		p.expr(node.True)
+		p.inlineComments(node.ColonPos)
+		p.write(" : ")
+		p.expr(node.False)
+	case *parser.IndexExpr:
+		p.expr(node.Expr)
+		p.optional(node.Optional)
+		p.write("[")
+		p.expr(node.Index)
+		p.write("]")
+	case *parser.SliceExpr:
+		p.expr(node.Expr)
+		p.optional(node.Optional)
+		p.write("[")
+		if node.Low != nil {
+			p.expr(node.Low)
+		}
+		p.write(":")
+		if node.High != nil {
+			p.expr(node.High)
+		}
+		p.write("]")
+	case *parser.SelectorExpr:
+		p.expr(node.Expr)
+		if sel, ok := node.Sel.(*parser.StringLit); ok {
+			if node.Optional {
+				p.write("?." + sel.Value)
+			} else {
+				p.write("." + sel.Value)
+			}
+		} else {
+			p.optional(node.Optional)
+			p.write("[")
+			p.expr(node.Sel)
+			p.write("]")
+		}
+	case *parser.CallExpr:
+		p.call(node)
+	case *parser.ArrayLit:
+		items := make([]listItem, len(node.Elements))
+		for i, e := range node.Elements {
+			items[i] = listItem{node: e, print: p.exprFunc(e)}
+			if i > 0 {
+				items[i].sep = ", "
+			}
+		}
+		p.list("[", node.LBrack, items, "]", node.RBrack)
+	case *parser.MapLit:
+		items := make([]listItem, len(node.Elements))
+		for i, e := range node.Elements {
+			e := e
+			items[i] = listItem{node: e, print: func() { p.mapElement(e) }}
+			if i > 0 {
+				items[i].sep = ", "
+			}
+		}
+		p.list("{", node.LBrace, items, "}", node.RBrace)
+	case *parser.ArrayPattern:
+		items := make([]listItem, len(node.Elements))
+		for i, e := range node.Elements {
+			items[i] = listItem{node: e, print: p.exprFunc(e)}
+			if i > 0 {
+				items[i].sep = ", "
+			}
+		}
+		if node.Ellipsis.IsValid() {
+			items = append(items, p.restItem(node.Ellipsis, node.Rest,
+				len(items) > 0))
+		}
+		if !node.LBrack.IsValid() {
+			// a, b, ...rest := value
+			for _, item := range items {
+				p.write(item.sep)
+				item.print()
+			}
+			return
+		}
+		p.list("[", node.LBrack, items, "]", node.RBrack)
+	case *parser.MapPattern:
+		items := make([]listItem, len(node.Elements))
+		for i, e := range node.Elements {
+			e := e
+			items[i] = listItem{node: e, print: func() { p.mapPattern(e) }}
+			if i > 0 {
+				items[i].sep = ", "
+			}
+		}
+		if node.Ellipsis.IsValid() {
+			items = append(items, p.restItem(node.Ellipsis, node.Rest,
+				len(items) > 0))
+		}
+		p.list("{", node.LBrace, items, "}", node.RBrace)
+	case *parser.SpreadExpr:
+		p.write("...")
+		p.expr(node.Expr)
+	case *parser.DefaultPattern:
+		p.expr(node.Pattern)
+		p.write(" = ")
+		p.expr(node.Default)
+	case *parser.TypePattern:
+		p.write("is ")
+		p.expr(node.Type)
+	case *parser.FuncLit:
+		p.write("func")
+		p.funcParams(node.Type.Params)
+		p.write(" ")
+		p.block(node.Body)
+	case *parser.YieldExpr:
+		p.write("yield")
+		if node.Result != nil {
+			p.write(" ")
+			p.expr(node.Result)
+		}
+	case *parser.ErrorExpr:
+		p.write("error(")
+		p.expr(node.Expr)
+		p.write(")")
+	case *parser.ImmutableExpr:
+		p.write("immutable(")
+		p.expr(node.Expr)
+		p.write(")")
+	case *parser.ImportExpr:
+		p.write("import(" + strconv.Quote(node.ModuleName) + ")")
+	default:
+		p.write(node.String())
+	}
+}
+
+func (p *printer) exprFunc(e parser.Expr) func() {
+	return func() { p.expr(e) }
+}
+
+// literal writes the literal as written in the source or the value if the
+// node is not from the source.
+func (p *printer) literal(lit, value string) {
+	if lit == "" {
+		lit = value
+	}
+	p.write(lit)
+}
+
+// optional writes the optional chaining operator of an optional selector,
+// index or call.
+func (p *printer) optional(optional bool) {
+	if optional {
+		p.write("?.")
+	}
+}
+
+func (p *printer) mapElement(e *parser.MapElementLit) {
+	if s, ok := e.Value.(*parser.SpreadExpr); ok {
+		p.expr(s)
+		return
+	}
+	if isIdent(e.Key) {
+		p.write(e.Key)
+	} else {
+		p.write(strconv.Quote(e.Key))
+	}
+	// a key without value is a shorthand of `key: default`.
+	if d, ok := e.Value.(*parser.DefaultLit); ok && !d.TokenPos.IsValid() {
+		return
+	}
+	p.write(": ")
+	p.expr(e.Value)
+}
+
+func (p *printer) mapPattern(e *parser.MapElementLit) {
+	if isIdent(e.Key) {
+		p.write(e.Key)
+	} else {
+		p.write(strconv.Quote(e.Key))
+	}
+	// a key without pattern binds the variable of the same name.
+	if !e.ColonPos.IsValid() {
+		if d, ok := e.Value.(*parser.DefaultPattern); ok {
+			p.write(" = ")
+			p.expr(d.Default)
+		}
+		return
+	}
+	p.write(": ")
+	p.expr(e.Value)
+}
+
+// restItem returns the list item of "...rest" of a destructuring pattern.
+func (p *printer) restItem(
+	ellipsis parser.Pos,
+	ident *parser.Ident,
+	sep bool,
+) listItem {
+	rest := "..."
+	if ident != nil {
+		rest += ident.Name
+	}
+	item := listItem{
+		node:  &parser.Ident{Name: rest, NamePos: ellipsis},
+		print: func() { p.write(rest) },
+	}
+	if sep {
+		item.sep = ", "
+	}
+	return item
+}
+
+func (p *printer) call(node *parser.CallExpr) {
+	p.expr(node.Func)
+	p.optional(node.Optional)
+
+	var items []listItem
+	for i, e := range node.Args.Values {
+		item := listItem{node: e, print: p.exprFunc(e)}
+		if i > 0 {
+			item.sep = ", "
+		}
+		if i == len(node.Args.Values)-1 && node.Args.Ellipsis.IsValid() {
+			item.print = func() {
+				p.expr(e)
+				p.write("...")
+			}
+		}
+		items = append(items, item)
+	}
+
+	kwargs := node.Kwargs
+	numNames := len(kwargs.Values)
+	if kwargs.Ellipsis.IsValid() {
+		numNames--
+	}
+	for i := 0; i < len(kwargs.Values); i++ {
+		i := i
+		var item listItem
+		if i < numNames {
+			item = listItem{
+				node: kwargs.Names[i],
+				print: func() {
+					p.write(kwargs.Names[i].Name + "=")
+					p.expr(kwargs.Values[i])
+				},
+			}
+		} else {
+			item = listItem{
+				node: kwargs.Values[i],
+				print: func() {
+					p.expr(kwargs.Values[i])
+					p.write("...")
+				},
+			}
+		}
+		switch {
+		case i == 0 && len(items) > 0:
+			item.sep = "; "
+		case i == 0 && i == numNames:
+			// `f(; kwargs...)` is not same as `f(args...)`.
+			item.sep = "; "
+		case i > 0:
+			item.sep = ", "
+		}
+		items = append(items, item)
+	}
+	p.list("(", node.LParen, items, ")", node.RParen)
+}
+
+func (p *printer) funcParams(params *parser.FuncParams) {
+	p.write("(")
+	if args := params.Args; args != nil {
+		for i, a := range args.List {
+			if i > 0 {
+				p.write(", ")
+			}
+			if args.VarArgs && i == len(args.List)-1 {
+				p.write("...")
+			}
+			if i < len(args.Patterns) && args.Patterns[i] != nil {
+				p.expr(args.Patterns[i])
+				continue
+			}
+			p.write(a.Name)
+		}
+	}
+	if kwargs := params.Kwargs; kwargs != nil && len(kwargs.Names) > 0 {
+		p.write("; ")
+		for i, name := range kwargs.Names {
+			if i > 0 {
+				p.write(", ")
+			}
+			if kwargs.VarArgs && i == len(kwargs.Names)-1 {
+				p.write("..." + name.Name)
+				continue
+			}
+			p.write(name.Name + "=")
+			// default values are unary expressions.
+			switch v := kwargs.Values[i].(type) {
+			case *parser.BinaryExpr, *parser.CondExpr:
+				p.write("(")
+				p.expr(v)
+				p.write(")")
+			default:
+				p.expr(v)
+			}
+		}
+	}
+	p.write(")")
+}
+
+type listItem struct {
+	node  parser.Node
+	sep   string // separator before the item
+	print func()
+}
+
+// list prints the items between the brackets. The items are broken into
+// lines where the source has line breaks.
+func (p *printer) list(
+	open string,
+	openPos parser.Pos,
+	items []listItem,
+	close string,
+	closePos parser.Pos,
+) {
+	// the items are indented if there's any line break.
+	prev := p.line(openPos)
+	broken := false
+	for _, item := range items {
+		line := p.line(item.node.Pos())
+		if line > prev {
+			broken = true
+		}
+		prev = p.line(item.node.End())
+	}
+	if p.line(closePos) > prev {
+		broken = true
+	}
+
+	p.write(open)
+	p.lastLine = p.line(openPos)
+	if broken {
+		p.indent++
+		p.afterOpen = true
+	}
+	for _, item := range items {
+		pos := item.node.Pos()
+		p.write(strings.TrimRight(item.sep, " "))
+		p.flushComments(pos)
+		if line := p.line(pos); p.lineStart || line > p.lastLine {
+			p.linebreak(line)
+		} else if strings.HasSuffix(item.sep, " ") {
+			p.write(" ")
+		}
+		item.print()
+		p.lastLine = p.line(item.node.End())
+	}
+	p.flushComments(closePos)
+	if broken {
+		p.indent--
+		if line := p.line(closePos); p.lineStart || line > p.lastLine {
+			p.afterOpen = true
+			p.linebreak(line)
+		}
+	}
+	p.write(close)
+	p.lastLine = p.line(closePos)
+}
+
+func isIdent(s string) bool {
+	if s == "" || token.Lookup(s) != token.Ident {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !isLetter(c) && (i == 0 || !isDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c rune) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c rune) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,329 @@
+package tengo
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/d5/tengo/v2/parser"
+)
+
+// ErrGeneratorRunning is an error where a generator is resumed while it is
+// already running.
+var ErrGeneratorRunning = errors.New("generator is already running")
+
+// ErrGeneratorOwner is an error where a generator is resumed by a routine
+// other than the one that created it.
+var ErrGeneratorOwner = errors.New("generator belongs to another routine")
+
+// ErrGeneratorFinished is an error where a generator is resumed after it
+// returned.
+var ErrGeneratorFinished = errors.New("generator is finished")
+
+// Generator represents a suspended call of a generator function, a function
+// using yield expressions. Calling a generator function creates a generator
+// without running the function. Resuming the generator runs the function
+// until it yields a value or returns. Generators are iterable over the
+// yielded values and can be resumed with a value using the resume builtin
+// function.
+type Generator struct {
+	ObjectImpl
+	vm          *VM
+	frame       frame // basePointer is relative to the saved stack
+	stack       []Object
+	handlers    []tryHandler // relative to the frame and the saved stack
+	frameIndex  int
+	numHandlers int
+	value       Object
+	started     bool
+	running     bool
+	done        bool
+}
+
+// TypeName returns the name of the type.
+func (o *Generator) TypeName() string {
+	return "generator"
+}
+
+func (o *Generator) String() string {
+	return "<generator>"
+}
+
+// Copy returns the generator itself as the state of a function call cannot be
+// copied.
+func (o *Generator) Copy() Object {
+	return o
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Generator) Equals(x Object) bool {
+	return o == x
+}
+
+// IndexGet returns true for "done" if the generator returned.
+func (o *Generator) IndexGet(_ *VM, index Object) (Object, error) {
+	key, ok := index.(*String)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	if key.Value == "done" {
+		if o.done {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	}
+	return UndefinedValue, nil
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Generator) CanIterate() bool {
+	return true
+}
+
+// Iterate returns an iterator of the values the generator yields.
+func (o *Generator) Iterate() Iterator {
+	return &GeneratorIterator{g: o}
+}
+
+// Done returns true if the generator function returned.
+func (o *Generator) Done() bool {
+	return o.done
+}
+
+// Resume runs the generator function until it yields a value or returns. The
+// value is the result of the yield expression the generator is suspended at.
+// It is ignored when the generator starts. Resume returns the yielded
+// value or the returned value if the generator is done. The generator runs
+// on the stack of the VM that created it and Resume must not be called
+// while the VM is running in another goroutine.
+func (o *Generator) Resume(value Object) (Object, error) {
+	return o.vm.resume(o, value)
+}
+
+// resumeBy resumes the generator for the VM or returns ErrGeneratorOwner
+// if the generator was created by another VM, such as the VM of another
+// routine.
+func (o *Generator) resumeBy(vm *VM, value Object) (Object, error) {
+	if vm != nil && vm != o.vm {
+		return nil, ErrGeneratorOwner
+	}
+	return o.vm.resume(o, value)
+}
+
+// GeneratorIterator is an iterator of the values a generator yields.
+type GeneratorIterator struct {
+	ObjectImpl
+	g   *Generator
+	i   int
+	vm  *VM
+	err error
+}
+
+// TypeName returns the name of the type.
+func (i *GeneratorIterator) TypeName() string {
+	return "generator-iterator"
+}
+
+func (i *GeneratorIterator) String() string {
+	return "<generator-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *GeneratorIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *GeneratorIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *GeneratorIterator) Copy() Object {
+	return &GeneratorIterator{g: i.g, i: i.i, vm: i.vm}
+}
+
+// Next resumes the generator and returns true if it yielded a value.
+func (i *GeneratorIterator) Next() bool {
+	if i.g.done || i.err != nil {
+		return false
+	}
+	if _, i.err = i.g.resumeBy(i.vm, UndefinedValue); i.err != nil {
+		return false
+	}
+	if i.g.done {
+		return false
+	}
+	i.i++
+	return true
+}
+
+// Key returns the index of the current value.
+func (i *GeneratorIterator) Key() Object {
+	return &Int{Value: int64(i.i - 1)}
+}
+
+// Value returns the current value the generator yielded.
+func (i *GeneratorIterator) Value() Object {
+	return i.g.value
+}
+
+// Err returns the runtime error of the generator.
+func (i *GeneratorIterator) Err() error {
+	return i.err
+}
+
+// traceError is a runtime error of a generator function or a function
+// called by a Go function, with the source positions of its call frames.
+type traceError struct {
+	err   error
+	trace []parser.SourceFilePos
+}
+
+func (e *traceError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.err.Error())
+	for _, pos := range e.trace {
+		sb.WriteString("\n\tat ")
+		sb.WriteString(pos.String())
+	}
+	return sb.String()
+}
+
+func (e *traceError) Unwrap() error {
+	return e.err
+}
+
+// resume runs the generator on top of the current call frame until it yields
+// or returns and restores the state of the VM.
+func (v *VM) resume(g *Generator, value Object) (Object, error) {
+	switch {
+	case g.running:
+		return nil, ErrGeneratorRunning
+	case g.done:
+		return nil, ErrGeneratorFinished
+	}
+	base := v.sp + 1
+	if v.framesIndex >= MaxFrames || base+len(g.stack)+1 >= StackSize {
+		return nil, ErrStackOverflow
+	}
+
+	// save the state of the VM
+	var (
+		curFrame    = v.curFrame
+		curInsts    = v.curInsts
+		ip          = v.ip
+		sp          = v.sp
+		framesIndex = v.framesIndex
+		gen         = v.gen
+	)
+	defer func() {
+		v.curFrame = curFrame
+		v.curInsts = curInsts
+		v.ip = ip
+		v.sp = sp
+		v.framesIndex = framesIndex
+		v.gen = gen
+	}()
+
+	// restore the state of the generator
+	v.curFrame.ip = v.ip + 1 // the positions of the errors are ip - 1
+	v.stack[v.sp] = g
+	copy(v.stack[base:], g.stack)
+	v.sp = base + len(g.stack)
+	g.frameIndex = v.framesIndex
+	g.numHandlers = len(v.handlers)
+	for _, h := range g.handlers {
+		v.handlers = append(v.handlers, tryHandler{
+			framesIndex: h.framesIndex + g.frameIndex,
+			sp:          h.sp + base,
+			catchPos:    h.catchPos,
+		})
+	}
+	v.curFrame = &v.frames[v.framesIndex]
+	*v.curFrame = g.frame
+	v.curFrame.basePointer = base
+	v.curInsts = g.frame.fn.Instructions
+	v.ip = g.frame.ip
+	v.framesIndex++
+	if g.started {
+		// result of the yield expression
+		v.stack[v.sp] = value
+		v.sp++
+	}
+	g.started = true
+	g.running = true
+	v.gen = g
+
+	v.run()
+	for v.err != nil && len(v.handlers) > g.numHandlers && v.catch() {
+		v.run()
+	}
+	if g.running {
+		// runtime error or abort
+		g.running = false
+		g.done = true
+	}
+	if g.done {
+		g.stack = nil
+		g.handlers = nil
+		v.handlers = v.handlers[:g.numHandlers]
+	}
+	if err := v.err; err != nil {
+		v.err = nil
+		return nil, v.traceError(err, g.frameIndex)
+	}
+	return g.value, nil
+}
+
+// yield suspends the generator of the current call frame, saving the frame,
+// the stack and the try blocks of the frame.
+func (v *VM) yield(value Object) {
+	g := v.gen
+	base := v.curFrame.basePointer
+	g.frame = *v.curFrame
+	g.frame.ip = v.ip
+	g.frame.basePointer = 0
+	g.stack = append(g.stack[:0], v.stack[base:v.sp]...)
+	g.handlers = g.handlers[:0]
+	for _, h := range v.handlers[g.numHandlers:] {
+		g.handlers = append(g.handlers, tryHandler{
+			framesIndex: h.framesIndex - g.frameIndex,
+			sp:          h.sp - base,
+			catchPos:    h.catchPos,
+		})
+	}
+	v.handlers = v.handlers[:g.numHandlers]
+	g.value = value
+	g.running = false
+}
+
+// traceError returns the runtime error with the source positions of the
+// call frames from the current frame to the frame at frameIndex.
+func (v *VM) traceError(err error, frameIndex int) error {
+	trace := []parser.SourceFilePos{
+		v.fileSet.Position(v.curFrame.fn.SourcePos(v.ip - 1)),
+	}
+	for i := v.framesIndex - 2; i >= frameIndex; i-- {
+		f := &v.frames[i]
+		trace = append(trace, v.fileSet.Position(f.fn.SourcePos(f.ip-1)))
+	}
+	return &traceError{err: err, trace: trace}
+}
+
+// errorTrace returns the error and the source position of a runtime error
+// raised by a generator or by a function called by a Go function. Other
+// errors are returned as they are.
+func errorTrace(err error) (error, []parser.SourceFilePos) {
+	var trace []parser.SourceFilePos
+	for {
+		traceErr, ok := err.(*traceError)
+		if !ok {
+			return err, trace
+		}
+		trace = append(append([]parser.SourceFilePos{}, traceErr.trace...),
+			trace...)
+		err = traceErr.err
+	}
+}
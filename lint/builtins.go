@@ -0,0 +1,76 @@
+package lint
+
+import "fmt"
+
+// arity is the number of positional arguments of a builtin function. max is
+// -1 if the function takes variadic arguments.
+type arity struct {
+	min, max int
+}
+
+func (a arity) accepts(n int) bool {
+	return n >= a.min && (a.max < 0 || n <= a.max)
+}
+
+func (a arity) String() string {
+	switch {
+	case a.max < 0:
+		return fmt.Sprintf("at least %d", a.min)
+	case a.min == a.max:
+		return fmt.Sprintf("%d", a.min)
+	case a.min+1 == a.max:
+		return fmt.Sprintf("%d or %d", a.min, a.max)
+	default:
+		return fmt.Sprintf("%d to %d", a.min, a.max)
+	}
+}
+
+// builtinArities are the number of positional arguments accepted by the
+// builtin functions.
+var builtinArities = map[string]arity{
+	"len":                {1, 1},
+	"copy":               {1, 1},
+	"append":             {2, -1},
+	"delete":             {2, -1},
+	"splice":             {1, -1},
+	"string":             {1, 2},
+	"int":                {1, 2},
+	"bool":               {1, 1},
+	"float":              {1, 2},
+	"char":               {1, 2},
+	"bytes":              {1, 2},
+	"time":               {1, 2},
+	"is_int":             {1, 1},
+	"is_float":           {1, 1},
+	"is_string":          {1, 1},
+	"is_bool":            {1, 1},
+	"is_char":            {1, 1},
+	"is_bytes":           {1, 1},
+	"is_array":           {1, 1},
+	"is_immutable_array": {1, 1},
+	"is_map":             {1, 1},
+	"is_immutable_map":   {1, 1},
+	"is_iterable":        {1, 1},
+	"is_time":            {1, 1},
+	"is_error":           {1, 1},
+	"is_undefined":       {1, 1},
+	"is_function":        {1, 1},
+	"is_callable":        {1, 1},
+	"type_name":          {1, 1},
+	"format":             {1, -1},
+	"range":              {2, 3},
+	"map":                {0, -1},
+	"get_methods":        {0, 0},
+	"type":               {1, 5},
+	"typeof":             {1, 1},
+	"field":              {1, 1},
+	"fields":             {0, 0},
+	"method":             {1, 1},
+	"methods":            {0, 0},
+	"property":           {0, 2},
+	"properties":         {0, 0},
+	"resume":             {1, 2},
+	"go":                 {1, -1},
+	"chan":               {0, 1},
+	"select":             {0, -1},
+}
@@ -0,0 +1,617 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/token"
+)
+
+// definition is a variable defined in the source file.
+type definition struct {
+	ident  *parser.Ident
+	scope  tengo.SymbolScope
+	table  *tengo.SymbolTable // symbol table where the variable is defined
+	param  bool
+	rest   bool   // the rest of a destructuring pattern
+	module string // module name if the value is imported
+	used   bool
+}
+
+// checker resolves the identifiers with the symbol tables the same way the
+// compiler does and reports the issues.
+type checker struct {
+	linter *Linter
+	file   *parser.SourceFile
+	table  *tengo.SymbolTable
+	funcs  []*tengo.SymbolTable // symbol tables of the enclosing functions
+	scopes [][]*definition      // definitions of the enclosing scopes
+	defs   map[*tengo.Symbol]*definition
+	issues []*Issue
+}
+
+func newChecker(l *Linter, file *parser.SourceFile) *checker {
+	c := &checker{
+		linter: l,
+		file:   file,
+		table:  tengo.NewSymbolTable(),
+		defs:   make(map[*tengo.Symbol]*definition),
+	}
+	for idx, fn := range tengo.GetAllBuiltinFunctions() {
+		c.table.DefineBuiltin(idx, fn.Name)
+	}
+	for _, name := range l.globals {
+		c.table.Define(name)
+	}
+	c.funcs = []*tengo.SymbolTable{c.table}
+	c.scopes = [][]*definition{nil}
+	return c
+}
+
+func (c *checker) report(
+	pos parser.Pos,
+	rule string,
+	format string,
+	args ...interface{},
+) {
+	if !c.linter.Enabled(rule) {
+		return
+	}
+	p := c.file.Position(pos)
+	c.issues = append(c.issues, &Issue{
+		Rule:    rule,
+		File:    p.Filename,
+		Line:    p.Line,
+		Column:  p.Column,
+		Message: fmt.Sprintf(format, args...),
+		pos:     pos,
+	})
+}
+
+func (c *checker) enterBlock() {
+	c.table = c.table.Fork(true)
+	c.scopes = append(c.scopes, nil)
+}
+
+func (c *checker) leaveBlock() {
+	c.leaveScope()
+	c.table = c.table.Parent(false)
+}
+
+func (c *checker) enterFunc() {
+	c.table = c.table.Fork(false)
+	c.funcs = append(c.funcs, c.table)
+	c.scopes = append(c.scopes, nil)
+}
+
+func (c *checker) leaveFunc() {
+	c.leaveScope()
+	c.funcs = c.funcs[:len(c.funcs)-1]
+	c.table = c.table.Parent(false)
+}
+
+// leaveScope reports the unused variables of the innermost scope. Global
+// variables of the top-level scope can be used by the host application, so
+// only the unused imports and the rests of the destructuring patterns,
+// which can be left out of the patterns, are reported for them.
+func (c *checker) leaveScope() {
+	defs := c.scopes[len(c.scopes)-1]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	for _, d := range defs {
+		if d.used || d.param || ignored(d.ident.Name) {
+			continue
+		}
+		if d.module != "" {
+			c.report(d.ident.Pos(), RuleUnused,
+				"%q imported and not used", d.module)
+		} else if d.scope == tengo.ScopeLocal || d.table != c.funcs[0] ||
+			d.rest {
+			c.report(d.ident.Pos(), RuleUnused,
+				"%s declared and not used", d.ident.Name)
+		}
+	}
+}
+
+// define defines the variable. If assigned is false, the variable cannot be
+// referred until it's assigned.
+func (c *checker) define(
+	ident *parser.Ident,
+	assigned, param bool,
+) (*definition, *tengo.Symbol) {
+	if !param && !ignored(ident.Name) {
+		if prev, s, ok := c.lookup(ident.Name); ok && prev != nil &&
+			prev.table != c.table && s.Scope != tengo.ScopeBuiltin {
+			p := c.file.Position(prev.ident.Pos())
+			c.report(ident.Pos(), RuleShadow,
+				"declaration of %s shadows declaration at line %d",
+				ident.Name, p.Line)
+		}
+	}
+
+	s := c.table.Define(ident.Name)
+	s.LocalAssigned = assigned
+	d := &definition{
+		ident: ident,
+		scope: s.Scope,
+		table: c.table,
+		param: param,
+	}
+	c.defs[s] = d
+	c.scopes[len(c.scopes)-1] = append(c.scopes[len(c.scopes)-1], d)
+	return d, s
+}
+
+// lookup resolves the name. The definition is nil if the name is a builtin
+// function or a global variable of the host application.
+func (c *checker) lookup(name string) (*definition, *tengo.Symbol, bool) {
+	s, _, ok := c.table.Resolve(name, false)
+	if !ok {
+		return nil, nil, false
+	}
+	// free symbols point to the symbols of the enclosing functions.
+	for i := len(c.funcs) - 1; s.Scope == tengo.ScopeFree && i >= 0; i-- {
+		s = c.funcs[i].FreeSymbols()[s.Index]
+	}
+	return c.defs[s], s, true
+}
+
+// use resolves the identifier that's read.
+func (c *checker) use(ident *parser.Ident) *tengo.Symbol {
+	d, s, ok := c.lookup(ident.Name)
+	if !ok {
+		c.report(ident.Pos(), RuleUndefined, "undefined: %s", ident.Name)
+		return nil
+	}
+	if d != nil {
+		d.used = true
+	}
+	return s
+}
+
+// assign resolves the identifier that's assigned.
+func (c *checker) assign(ident *parser.Ident) {
+	if _, _, ok := c.lookup(ident.Name); !ok {
+		c.report(ident.Pos(), RuleUndefined,
+			"assignment to undefined variable %s", ident.Name)
+	}
+}
+
+func (c *checker) stmts(stmts []parser.Stmt) {
+	terminated := false
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*parser.EmptyStmt); ok {
+			continue
+		}
+		if terminated {
+			c.report(stmt.Pos(), RuleUnreachable, "unreachable code")
+			terminated = false
+		} else {
+			terminated = terminates(stmt)
+		}
+		c.stmt(stmt)
+	}
+}
+
+func (c *checker) stmt(node parser.Stmt) {
+	switch node := node.(type) {
+	case *parser.AssignStmt:
+		c.assignStmt(node)
+	case *parser.BlockStmt:
+		c.enterBlock()
+		c.stmts(node.Stmts)
+		c.leaveBlock()
+	case *parser.ExprStmt:
+		c.expr(node.Expr)
+	case *parser.IncDecStmt:
+		if ident, ok := node.Expr.(*parser.Ident); ok {
+			c.assign(ident)
+		} else {
+			c.expr(node.Expr)
+		}
+	case *parser.ReturnStmt:
+		c.expr(node.Result)
+	case *parser.ExportStmt:
+		c.expr(node.Result)
+	case *parser.IfStmt:
+		c.enterBlock()
+		c.stmt(node.Init)
+		c.expr(node.Cond)
+		c.stmt(node.Body)
+		c.stmt(node.Else)
+		c.leaveBlock()
+	case *parser.ForStmt:
+		c.enterBlock()
+		c.stmt(node.Init)
+		c.expr(node.Cond)
+		c.stmt(node.Body)
+		c.stmt(node.Post)
+		c.leaveBlock()
+	case *parser.ForInStmt:
+		c.enterBlock()
+		c.expr(node.Iterable)
+		if node.Key.Name != "_" {
+			c.define(node.Key, true, false)
+		}
+		if node.Value.Name != "_" {
+			c.define(node.Value, true, false)
+		}
+		if node.Pattern != nil {
+			c.destructure(node.Pattern, true)
+		}
+		c.stmt(node.Body)
+		c.leaveBlock()
+	case *parser.TryStmt:
+		c.stmt(node.Body)
+		if node.Catch != nil {
+			c.enterBlock()
+			if node.Ident != nil && node.Ident.Name != "_" {
+				c.define(node.Ident, true, false)
+			}
+			c.stmt(node.Catch)
+			c.leaveBlock()
+		}
+		if node.Finally != nil {
+			c.stmt(node.Finally)
+		}
+	case *parser.SwitchStmt:
+		c.enterBlock()
+		c.stmt(node.Init)
+		c.expr(node.Tag)
+		for _, cc := range node.Cases {
+			c.enterBlock()
+			bound := make(map[string]bool)
+			for _, p := range cc.Patterns {
+				if node.Tag == nil {
+					c.expr(p)
+				} else {
+					c.pattern(p, 0, bound)
+				}
+			}
+			c.expr(cc.Guard)
+			c.stmts(cc.Body)
+			c.leaveBlock()
+		}
+		c.leaveBlock()
+	}
+}
+
+// pattern resolves the identifiers of the pattern of a switch case. The
+// identifiers in the array and the map patterns are the variables bound to
+// the elements.
+func (c *checker) pattern(node parser.Expr, depth int, bound map[string]bool) {
+	switch node := node.(type) {
+	case *parser.Ident:
+		if ignored(node.Name) {
+			return
+		}
+		if depth == 0 {
+			c.use(node)
+		} else if !bound[node.Name] {
+			bound[node.Name] = true
+			c.define(node, true, false)
+		}
+	case *parser.ArrayPattern:
+		for _, e := range node.Elements {
+			c.pattern(e, depth+1, bound)
+		}
+		if node.Rest != nil {
+			c.pattern(node.Rest, depth+1, bound)
+		}
+	case *parser.MapPattern:
+		for _, e := range node.Elements {
+			c.pattern(e.Value, depth+1, bound)
+		}
+		if node.Rest != nil {
+			c.pattern(node.Rest, depth+1, bound)
+		}
+	case *parser.DefaultPattern:
+		c.pattern(node.Pattern, depth, bound)
+		c.expr(node.Default)
+	case *parser.TypePattern:
+		c.expr(node.Type)
+	default:
+		c.expr(node)
+	}
+}
+
+func (c *checker) assignStmt(node *parser.AssignStmt) {
+	if len(node.LHS) > 1 || isPattern(node.LHS[0]) {
+		for _, rhs := range node.RHS {
+			c.expr(rhs)
+		}
+		for _, lhs := range node.LHS {
+			c.destructure(lhs, node.Token == token.Define)
+		}
+		return
+	}
+	if node.Token == token.Define {
+		ident, ok := node.LHS[0].(*parser.Ident)
+		if len(node.LHS) != 1 || len(node.RHS) != 1 || !ok {
+			// not allowed by the compiler
+			return
+		}
+		// the variable is defined before the right-hand side is compiled,
+		// so that functions can refer to themselves.
+		d, s := c.define(ident, false, false)
+		c.expr(node.RHS[0])
+		s.LocalAssigned = true
+		if imp, ok := node.RHS[0].(*parser.ImportExpr); ok {
+			d.module = imp.ModuleName
+		}
+		return
+	}
+
+	for _, rhs := range node.RHS {
+		c.expr(rhs)
+	}
+	for _, lhs := range node.LHS {
+		if ident, ok := lhs.(*parser.Ident); ok {
+			c.assign(ident)
+		} else {
+			c.expr(lhs)
+		}
+	}
+}
+
+// destructure resolves the targets of a destructuring assignment. If define
+// is true, the variables not defined in the block yet are defined.
+func (c *checker) destructure(node parser.Expr, define bool) {
+	switch node := node.(type) {
+	case *parser.Ident:
+		c.destructureIdent(node, define)
+	case *parser.DefaultPattern:
+		c.expr(node.Default)
+		c.destructure(node.Pattern, define)
+	case *parser.ArrayPattern:
+		for _, e := range node.Elements {
+			c.destructure(e, define)
+		}
+		if node.Rest != nil {
+			c.destructureRest(node.Rest, define)
+		}
+	case *parser.MapPattern:
+		for _, e := range node.Elements {
+			c.destructure(e.Value, define)
+		}
+		if node.Rest != nil {
+			c.destructureRest(node.Rest, define)
+		}
+	default:
+		c.expr(node)
+	}
+}
+
+// destructureIdent defines or assigns the variable of the pattern and
+// returns the definition if it's defined.
+func (c *checker) destructureIdent(
+	node *parser.Ident,
+	define bool,
+) *definition {
+	if ignored(node.Name) {
+		return nil
+	}
+	if _, depth, ok := c.table.Resolve(node.Name, false); define &&
+		(!ok || depth > 0) {
+		d, _ := c.define(node, true, false)
+		return d
+	}
+	c.assign(node)
+	return nil
+}
+
+func (c *checker) destructureRest(node *parser.Ident, define bool) {
+	if d := c.destructureIdent(node, define); d != nil {
+		d.rest = true
+	}
+}
+
+func (c *checker) expr(node parser.Expr) {
+	switch node := node.(type) {
+	case *parser.Ident:
+		c.use(node)
+	case *parser.ArrayLit:
+		for _, e := range node.Elements {
+			c.expr(e)
+		}
+	case *parser.MapLit:
+		for _, e := range node.Elements {
+			c.expr(e.Value)
+		}
+	case *parser.SpreadExpr:
+		c.expr(node.Expr)
+	case *parser.BinaryExpr:
+		c.expr(node.LHS)
+		c.expr(node.RHS)
+	case *parser.UnaryExpr:
+		c.expr(node.Expr)
+	case *parser.ParenExpr:
+		c.expr(node.Expr)
+	case *parser.CondExpr:
+		c.expr(node.Cond)
+		c.expr(node.True)
+		c.expr(node.False)
+	case *parser.ErrorExpr:
+		c.expr(node.Expr)
+	case *parser.ImmutableExpr:
+		c.expr(node.Expr)
+	case *parser.YieldExpr:
+		if node.Result != nil {
+			c.expr(node.Result)
+		}
+	case *parser.IndexExpr:
+		c.expr(node.Expr)
+		c.expr(node.Index)
+	case *parser.SliceExpr:
+		c.expr(node.Expr)
+		c.expr(node.Low)
+		c.expr(node.High)
+	case *parser.SelectorExpr:
+		c.expr(node.Expr)
+		c.expr(node.Sel)
+	case *parser.CallExpr:
+		c.call(node)
+	case *parser.ImportExpr:
+		c.importExpr(node)
+	case *parser.FuncLit:
+		c.funcLit(node)
+	}
+}
+
+func (c *checker) call(node *parser.CallExpr) {
+	fn := node.Func
+	for {
+		paren, ok := fn.(*parser.ParenExpr)
+		if !ok {
+			break
+		}
+		fn = paren.Expr
+	}
+	switch fn.(type) {
+	case *parser.IntLit, *parser.FloatLit, *parser.CharLit,
+		*parser.StringLit, *parser.BoolLit, *parser.UndefinedLit,
+		*parser.ArrayLit, *parser.MapLit, *parser.ErrorExpr,
+		*parser.ImmutableExpr:
+		c.report(node.Func.Pos(), RuleNotCallable,
+			"cannot call non-function %s", fn.String())
+	}
+
+	if ident, ok := fn.(*parser.Ident); ok {
+		s := c.use(ident)
+		if s != nil && s.Scope == tengo.ScopeBuiltin {
+			c.checkArity(ident, node)
+		}
+	} else {
+		c.expr(node.Func)
+	}
+	for _, e := range node.Args.Values {
+		c.expr(e)
+	}
+	for _, e := range node.Kwargs.Values {
+		c.expr(e)
+	}
+}
+
+func (c *checker) checkArity(fn *parser.Ident, node *parser.CallExpr) {
+	a, ok := builtinArities[fn.Name]
+	if !ok || node.Args.Ellipsis.IsValid() {
+		// the number of arguments is unknown.
+		return
+	}
+	if n := len(node.Args.Values); !a.accepts(n) {
+		c.report(node.Pos(), RuleArity,
+			"wrong number of arguments in call to %s: want %s, got %d",
+			fn.Name, a, n)
+	}
+}
+
+func (c *checker) importExpr(node *parser.ImportExpr) {
+	l := c.linter
+	if l.modules == nil || node.ModuleName == "" {
+		return
+	}
+	if l.modules.Get(node.ModuleName) != nil {
+		return
+	}
+	if l.allowFileImport {
+		name := node.ModuleName
+		if !strings.HasSuffix(name, tengo.SourceFileExtDefault) {
+			name += tengo.SourceFileExtDefault
+		}
+		if _, err := os.Stat(filepath.Join(l.importDir, name)); err == nil {
+			return
+		}
+	}
+	c.report(node.Pos(), RuleImport, "module '%s' not found",
+		node.ModuleName)
+}
+
+func (c *checker) funcLit(node *parser.FuncLit) {
+	c.enterFunc()
+	params := node.Type.Params
+	if params.Args != nil {
+		for _, p := range params.Args.List {
+			c.define(p, true, true)
+		}
+	}
+	if params.Kwargs != nil {
+		for _, p := range params.Kwargs.Names {
+			c.define(p, true, true)
+		}
+	}
+	if params.Args != nil {
+		for _, p := range params.Args.Patterns {
+			if p != nil {
+				c.destructure(p, true)
+			}
+		}
+	}
+
+	// default values of keyword arguments are evaluated in the function
+	// body.
+	c.enterBlock()
+	if params.Kwargs != nil {
+		for _, v := range params.Kwargs.Values {
+			c.expr(v)
+		}
+	}
+	c.stmts(node.Body.Stmts)
+	c.leaveBlock()
+	c.leaveFunc()
+}
+
+// terminates returns true if the statements after the statement are never
+// executed.
+func terminates(node parser.Stmt) bool {
+	switch node := node.(type) {
+	case *parser.ReturnStmt:
+		return true
+	case *parser.BranchStmt:
+		return node.Token == token.Break || node.Token == token.Continue
+	case *parser.BlockStmt:
+		for i := len(node.Stmts) - 1; i >= 0; i-- {
+			if _, ok := node.Stmts[i].(*parser.EmptyStmt); !ok {
+				return terminates(node.Stmts[i])
+			}
+		}
+	case *parser.IfStmt:
+		return node.Else != nil && terminates(node.Body) &&
+			terminates(node.Else)
+	case *parser.SwitchStmt:
+		// the switch statement terminates if all the cases terminate and
+		// there's the default case.
+		hasDefault := false
+		for _, cc := range node.Cases {
+			hasDefault = hasDefault || cc.Patterns == nil
+			if !terminates(&parser.BlockStmt{Stmts: cc.Body}) {
+				return false
+			}
+		}
+		return hasDefault
+	case *parser.TryStmt:
+		if node.Finally != nil && terminates(node.Finally) {
+			return true
+		}
+		return terminates(node.Body) &&
+			(node.Catch == nil || terminates(node.Catch))
+	}
+	return false
+}
+
+// ignored returns true if the variable is not checked for unused or
+// shadowing.
+// isPattern returns true if the expression is a destructuring pattern.
+func isPattern(node parser.Expr) bool {
+	switch node.(type) {
+	case *parser.ArrayPattern, *parser.MapPattern:
+		return true
+	}
+	return false
+}
+
+func ignored(name string) bool {
+	return name == "" || name == "_"
+}
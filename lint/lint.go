@@ -0,0 +1,146 @@
+// Package lint implements a static checker of Tengo source code. It reports
+// suspicious constructs that are not compile errors, such as unused
+// variables or unreachable code and the errors that can be found before
+// running the code, such as wrong number of arguments for the builtin
+// functions.
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+)
+
+// Names of the rules.
+const (
+	// RuleUnused reports the local variables and the imported modules that
+	// are never used.
+	RuleUnused = "unused"
+	// RuleShadow reports the variables that shadow the variables of the
+	// outer scopes.
+	RuleShadow = "shadow"
+	// RuleUnreachable reports the statements after return, break or
+	// continue.
+	RuleUnreachable = "unreachable"
+	// RuleUndefined reports the references and the assignments to the
+	// variables that are not defined.
+	RuleUndefined = "undefined"
+	// RuleNotCallable reports the calls of the literals that are not
+	// callable.
+	RuleNotCallable = "not-callable"
+	// RuleArity reports the calls of the builtin functions with wrong number
+	// of arguments.
+	RuleArity = "arity"
+	// RuleImport reports the imports of the modules that cannot be found.
+	RuleImport = "import"
+)
+
+// AllRules returns the names of all rules.
+func AllRules() []string {
+	return []string{
+		RuleUnused,
+		RuleShadow,
+		RuleUnreachable,
+		RuleUndefined,
+		RuleNotCallable,
+		RuleArity,
+		RuleImport,
+	}
+}
+
+// Issue is a problem found in the source code.
+type Issue struct {
+	Rule    string `json:"rule"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	pos     parser.Pos
+}
+
+func (i *Issue) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)",
+		i.File, i.Line, i.Column, i.Message, i.Rule)
+}
+
+// Linter checks the source code with the enabled rules. All rules are
+// enabled by default.
+type Linter struct {
+	modules         tengo.ModuleGetter
+	disabled        map[string]bool
+	globals         []string
+	allowFileImport bool
+	importDir       string
+}
+
+// NewLinter creates a Linter. The modules are used to check the imports. If
+// modules is nil, the imports are not checked.
+func NewLinter(modules tengo.ModuleGetter) *Linter {
+	return &Linter{
+		modules:  modules,
+		disabled: make(map[string]bool),
+	}
+}
+
+// Enable enables the rules.
+func (l *Linter) Enable(rules ...string) {
+	for _, rule := range rules {
+		delete(l.disabled, rule)
+	}
+}
+
+// Disable disables the rules.
+func (l *Linter) Disable(rules ...string) {
+	for _, rule := range rules {
+		l.disabled[rule] = true
+	}
+}
+
+// Enabled returns true if the rule is enabled.
+func (l *Linter) Enabled(rule string) bool {
+	return !l.disabled[rule]
+}
+
+// AddGlobals adds the names of the global variables defined by the host
+// application, such as the variables added to the Script.
+func (l *Linter) AddGlobals(names ...string) {
+	l.globals = append(l.globals, names...)
+}
+
+// EnableFileImport enables or disables the imports of the module files.
+func (l *Linter) EnableFileImport(enable bool) {
+	l.allowFileImport = enable
+}
+
+// SetImportDir sets the directory to search the module files.
+func (l *Linter) SetImportDir(dir string) {
+	l.importDir = dir
+}
+
+// Lint checks the parsed file and returns the issues sorted by their
+// positions.
+func (l *Linter) Lint(file *parser.File) []*Issue {
+	c := newChecker(l, file.InputFile)
+	c.stmts(file.Stmts)
+	c.leaveScope()
+
+	issues := c.issues
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].pos < issues[j].pos
+	})
+	return issues
+}
+
+// LintSource parses and checks the source code. It returns an error if the
+// source code cannot be parsed.
+func (l *Linter) LintSource(filename string, src []byte) ([]*Issue, error) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(filename, -1, len(src))
+	file, err := parser.NewParser(srcFile, src, nil).ParseFile()
+	if err != nil {
+		return nil, err
+	}
+	return l.Lint(file), nil
+}
@@ -0,0 +1,306 @@
+package lint_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/v2/lint"
+	"github.com/d5/tengo/v2/require"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+func TestUnused(t *testing.T) {
+	expectIssues(t, lint.RuleUnused, `
+f := func(a, b) {
+	x := 1
+	y := 2
+	z := 3
+	z = 4
+	return y
+}`, "3:2: x declared and not used", "5:2: z declared and not used")
+
+	expectIssues(t, lint.RuleUnused, `
+fmt := import("fmt")
+text := import("text")
+a := 1
+for k, v in [1, 2] { fmt.println(v) }
+f := func() {
+	g := func() { return g }
+	return g
+}`, `3:1: "text" imported and not used`, "5:5: k declared and not used")
+
+	expectIssues(t, lint.RuleUnused, `
+f := func() {
+	a := 1
+	return func() { return a }
+}
+try { f() } catch err {}
+try { f() } catch _ {}`, "6:19: err declared and not used")
+
+	expectIssues(t, lint.RuleUnused, `
+f := func(v) {
+	switch v {
+	case [a, b, _], {a, b}: return a
+	case [x, ...rest]: return x
+	}
+}`, "4:11: b declared and not used", "5:14: rest declared and not used")
+
+	expectIssues(t, lint.RuleUnused, `
+f := func([a, b], {c}) {
+	x, {y = c, ...z} := [a, {}]
+	for [k, v] in [[1, 2]] { x = v }
+	return y + z
+}`, "2:15: b declared and not used", "3:2: x declared and not used",
+		"4:7: k declared and not used")
+
+	// the rests are reported in the global scope too
+	expectIssues(t, lint.RuleUnused, `
+{k, ...r} := {k: 1}
+a, ...b := [1, 2]
+[c, ...d] := [1, 2]
+e := 1
+[e, ...d] = [1]
+switch a { case [x, ...y]: }
+f := func([p, ...q]) { return p }`, "2:8: r declared and not used",
+		"3:7: b declared and not used", "4:8: d declared and not used",
+		"7:18: x declared and not used",
+		"7:24: y declared and not used", "8:18: q declared and not used")
+}
+
+func TestShadow(t *testing.T) {
+	expectIssues(t, lint.RuleShadow, `
+a := 1
+f := func(a) {
+	b := 1
+	if true {
+		b := 2
+		a := 3
+		return a + b
+	}
+	return b
+}
+if a := 2; a > 1 {}
+for a in [1] {}
+g := func() {
+	return func() { f := 1; return f }
+}
+len := 1`,
+		"6:3: declaration of b shadows declaration at line 4",
+		"7:3: declaration of a shadows declaration at line 3",
+		"12:4: declaration of a shadows declaration at line 2",
+		"13:5: declaration of a shadows declaration at line 2",
+		"15:18: declaration of f shadows declaration at line 3")
+}
+
+func TestUnreachable(t *testing.T) {
+	expectIssues(t, lint.RuleUnreachable, `
+f := func(x) {
+	for {
+		if x { break; x = 1 }
+		continue
+		x = 2
+	}
+	if x { return 1 } else { return 2 }
+	x = 3
+	x = 4
+}
+g := func() {
+	try { return 1 } finally {}
+	return 2
+}
+h := func() {
+	try { return 1 } catch {}
+	return 2
+}
+i := func(x) {
+	switch x {
+	case 1: return 1
+	default: return 2
+	}
+	return 3
+}
+j := func(x) {
+	switch x { case 1: return 1 }
+	return 2
+}`, "4:17: unreachable code", "6:3: unreachable code",
+		"9:2: unreachable code", "14:2: unreachable code",
+		"25:2: unreachable code")
+}
+
+func TestUndefined(t *testing.T) {
+	expectIssues(t, lint.RuleUndefined, `
+a := 1
+b = 2
+c += a
+d++
+e.f = 1
+f := func() { return g }
+h := h + 1
+x := [y, len(a)]
+switch a { case z, [w], is T: w }
+a, [i = j] := [1]
+{k: l, ...m} = a`,
+		"3:1: assignment to undefined variable b",
+		"4:1: assignment to undefined variable c",
+		"5:1: assignment to undefined variable d",
+		"6:1: undefined: e",
+		"7:22: undefined: g",
+		"9:7: undefined: y",
+		"10:17: undefined: z",
+		"10:28: undefined: T",
+		"11:9: undefined: j",
+		"12:5: assignment to undefined variable l",
+		"12:11: assignment to undefined variable m")
+
+	l := lint.NewLinter(nil)
+	l.AddGlobals("g", "y")
+	issues, err := l.LintSource("test", []byte(`a := g + y`))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(issues))
+}
+
+func TestNotCallable(t *testing.T) {
+	expectIssues(t, lint.RuleNotCallable, `
+a := 1()
+b := "s"(1)
+c := [1]()
+d := {}()
+e := (1.5)()
+f := undefined()
+g := a()
+h := func(){}()`,
+		"2:6: cannot call non-function 1",
+		`3:6: cannot call non-function "s"`,
+		"4:6: cannot call non-function [1]",
+		"5:6: cannot call non-function {}",
+		"6:6: cannot call non-function 1.5",
+		"7:6: cannot call non-function undefined")
+}
+
+func TestArity(t *testing.T) {
+	expectIssues(t, lint.RuleArity, `
+a := len()
+b := len(1, 2)
+c := append([])
+d := range(1)
+e := string(1, 2, 3)
+f := append([], 1, 2)
+g := len([1]...)
+h := format("%d", 1)
+len2 := len
+i := len2(1, 2)`,
+		"2:6: wrong number of arguments in call to len: want 1, got 0",
+		"3:6: wrong number of arguments in call to len: want 1, got 2",
+		"4:6: wrong number of arguments in call to append: want at least 2, got 1",
+		"5:6: wrong number of arguments in call to range: want 2 or 3, got 1",
+		"6:6: wrong number of arguments in call to string: want 1 or 2, got 3")
+}
+
+func TestImport(t *testing.T) {
+	src := `
+a := import("fmt")
+b := import("foo")
+c := import("mod")
+d := import("mod.tengo")`
+
+	expectIssues(t, lint.RuleImport, src,
+		"3:6: module 'foo' not found",
+		"4:6: module 'mod' not found",
+		"5:6: module 'mod.tengo' not found")
+
+	dir, err := ioutil.TempDir("", "lint")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	err = ioutil.WriteFile(filepath.Join(dir, "mod.tengo"), nil, 0644)
+	require.NoError(t, err)
+
+	l := newLinter(lint.RuleImport)
+	l.EnableFileImport(true)
+	l.SetImportDir(dir)
+	issues, err := l.LintSource("test", []byte(src))
+	require.NoError(t, err)
+	require.Equal(t, []string{"3:6: module 'foo' not found"},
+		issueStrings(issues))
+
+	// imports are not checked without modules
+	l = lint.NewLinter(nil)
+	issues, err = l.LintSource("test", []byte(`export import("foo")`))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(issues))
+}
+
+func TestLinter(t *testing.T) {
+	src := []byte(`
+f := func() {
+	x := len()
+	return 1
+	y := 2
+}`)
+	l := lint.NewLinter(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	issues, err := l.LintSource("test.tengo", src)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(issues))
+	require.Equal(t,
+		"test.tengo:3:2: x declared and not used (unused)",
+		issues[0].String())
+	require.Equal(t, lint.RuleArity, issues[1].Rule)
+	require.Equal(t, lint.RuleUnreachable, issues[2].Rule)
+	require.Equal(t, lint.RuleUnused, issues[3].Rule)
+
+	data, err := json.Marshal(issues[0])
+	require.NoError(t, err)
+	require.Equal(t, `{"rule":"unused","file":"test.tengo","line":3,`+
+		`"column":2,"message":"x declared and not used"}`, string(data))
+
+	l.Disable(lint.RuleUnused, lint.RuleArity)
+	require.False(t, l.Enabled(lint.RuleUnused))
+	issues, err = l.LintSource("test.tengo", src)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(issues))
+	require.Equal(t, lint.RuleUnreachable, issues[0].Rule)
+
+	l.Enable(lint.RuleArity)
+	issues, err = l.LintSource("test.tengo", src)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(issues))
+
+	_, err = l.LintSource("test.tengo", []byte(`a := `))
+	require.Error(t, err)
+}
+
+func TestStdlibSourceModules(t *testing.T) {
+	l := lint.NewLinter(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	for name, src := range stdlib.SourceModules {
+		issues, err := l.LintSource(name, []byte(src))
+		require.NoError(t, err)
+		require.Equal(t, 0, len(issues), issueStrings(issues))
+	}
+}
+
+func newLinter(rule string) *lint.Linter {
+	l := lint.NewLinter(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	l.Disable(lint.AllRules()...)
+	l.Enable(rule)
+	return l
+}
+
+func issueStrings(issues []*lint.Issue) []string {
+	var res []string
+	for _, issue := range issues {
+		s := issue.String()
+		s = strings.TrimPrefix(s, issue.File+":")
+		s = strings.TrimSuffix(s, " ("+issue.Rule+")")
+		res = append(res, s)
+	}
+	return res
+}
+
+func expectIssues(t *testing.T, rule, src string, expected ...string) {
+	issues, err := newLinter(rule).LintSource("test", []byte(src))
+	require.NoError(t, err)
+	require.Equal(t, expected, issueStrings(issues))
+}
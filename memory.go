@@ -0,0 +1,58 @@
+package tengo
+
+// The approximate sizes in bytes used to account the memory allocated by the
+// VM.
+const (
+	objectSize   = 32 // an object
+	refSize      = 16 // an element of an array
+	mapEntrySize = 48 // an entry of a map, excluding the key
+)
+
+// sizeOf returns the approximate number of bytes allocated for the object,
+// excluding the objects it refers to.
+func sizeOf(o Object) int64 {
+	switch o := o.(type) {
+	case *String:
+		return objectSize + int64(len(o.Value))
+	case *Bytes:
+		return objectSize + int64(len(o.Value))
+	case *Array:
+		return objectSize + int64(len(o.Value))*refSize
+	case *ImmutableArray:
+		return objectSize + int64(len(o.Value))*refSize
+	case *Map:
+		return objectSize + mapSize(o.Value)
+	case *ImmutableMap:
+		return objectSize + mapSize(o.Value)
+	case *Instance:
+		return objectSize + mapSize(o.Values)
+	}
+	return objectSize
+}
+
+func mapSize(m map[string]Object) int64 {
+	var size int64
+	for key := range m {
+		size += mapEntrySize + int64(len(key))
+	}
+	return size
+}
+
+// allocMapEntry reports the memory of the new map entry to the VM.
+func allocMapEntry(vm *VM, m map[string]Object, key string) error {
+	if vm == nil {
+		return nil
+	}
+	if _, ok := m[key]; ok {
+		return nil
+	}
+	return vm.Alloc(mapEntrySize + int64(len(key)))
+}
+
+// alloc reports the memory allocated by a builtin function to the VM.
+func alloc(ctx *CallContext, size int64) error {
+	if ctx.VM == nil {
+		return nil
+	}
+	return ctx.VM.Alloc(size)
+}
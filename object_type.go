@@ -2,6 +2,7 @@ package tengo
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 )
 
@@ -317,6 +318,8 @@ type Type struct {
 	Methods    *TypeMethods
 	Fields     *TypeFields
 	Properties *TypeProperties
+
+	goType reflect.Type // Go struct type of the instances
 }
 
 func (c *Type) TypeName() string {
@@ -483,6 +486,7 @@ type Instance struct {
 	Callable bool
 	Values   map[string]Object
 	Methods  map[string]ToMethodConverter
+	goValue  reflect.Value // pointer to the Go struct of the instance
 }
 
 // TypeName returns the name of the type.
@@ -504,7 +508,7 @@ func (o *Instance) Copy() Object {
 	for k, v := range o.Values {
 		c[k] = v.Copy()
 	}
-	return &Instance{Values: c}
+	return &Instance{Type: o.Type, Values: c, goValue: copyGoValue(o.goValue)}
 }
 
 // IsFalsy returns true if the value of the type is falsy.
@@ -563,7 +567,7 @@ func (o *Instance) IndexGet(Vm *VM, index Object) (res Object, err error) {
 		}
 		if prop := o.Type.Properties.Value[name]; prop != nil {
 			if prop.Setter != nil {
-				_, err = prop.Setter.Call(&CallContext{VM: Vm, This: o})
+				_, err = Vm.callGo(prop.Setter, &CallContext{VM: Vm, This: o})
 			}
 		}
 		return UndefinedValue, nil
@@ -571,12 +575,15 @@ func (o *Instance) IndexGet(Vm *VM, index Object) (res Object, err error) {
 }
 
 // IndexSet sets the value for the given key.
-func (o *Instance) IndexSet(_ *VM, index, value Object) (err error) {
+func (o *Instance) IndexSet(vm *VM, index, value Object) (err error) {
 	strIdx, ok := ToString(index)
 	if !ok {
 		err = ErrInvalidIndexType
 		return
 	}
+	if err = allocMapEntry(vm, o.Values, strIdx); err != nil {
+		return
+	}
 	o.Values[strIdx] = value
 	return nil
 }
@@ -585,9 +592,13 @@ func (o *Instance) IndexSet(_ *VM, index, value Object) (err error) {
 func (o *Instance) Set(Vm *VM, name string, value Object) (err error) {
 	if prop := o.Type.Properties.Value[name]; prop != nil {
 		if prop.Setter != nil {
-			_, err = prop.Setter.Call(&CallContext{VM: Vm, This: o, Args: []Object{value}})
+			_, err = Vm.callGo(prop.Setter,
+				&CallContext{VM: Vm, This: o, Args: []Object{value}})
 		}
 	} else {
+		if err = allocMapEntry(Vm, o.Values, name); err != nil {
+			return
+		}
 		o.Values[name] = value
 	}
 	return
@@ -400,6 +400,7 @@ type BuiltinFunction struct {
 	ObjectImpl
 	Name         string
 	Value        CallableFuncCtx
+	Usage        string // e.g. "len(x) => int"
 	IsMethod     bool
 	methodTarget Object
 }
@@ -684,24 +685,34 @@ type Variadic struct {
 	Name  string
 }
 
+// LocalVar represents a local variable of a compiled function and the range
+// of the instructions where the variable is visible.
+type LocalVar struct {
+	Name  string
+	Index int
+	Start int // position of the first instruction (inclusive)
+	End   int // position of the last instruction (exclusive)
+}
+
 // CompiledFunction represents a compiled function.
 type CompiledFunction struct {
 	ObjectImpl
-	Instructions     []byte
-	NumLocals        int // number of local variables (including function parameters)
-	NumArgs          int
-	VarArgs          Variadic
-	Kwargs           map[string]int
-	KwargsNames      []string
-	KwargsDefaults   []Object
-	VarKwargs        Variadic
-	SourceMap        map[int]parser.Pos
-	IsMethod         bool // receive `this` as first arg
-	methodTarget     Object
-	Free             []*ObjectPtr
-	vm               *VM
-	vmConstantsCount int
-	running          uint32
+	Instructions   []byte
+	NumLocals      int // number of local variables (including function parameters)
+	NumArgs        int
+	VarArgs        Variadic
+	Kwargs         map[string]int
+	KwargsNames    []string
+	KwargsDefaults []Object
+	VarKwargs      Variadic
+	SourceMap      map[int]parser.Pos
+	Locals         []LocalVar // local variables for debuggers
+	Branches       []int      // positions of conditional jumps for coverage
+	FreeNames      []string   // names of free variables for debuggers
+	IsMethod       bool       // receive `this` as first arg
+	IsGenerator    bool       // calls return generators
+	methodTarget   Object
+	Free           []*ObjectPtr
 }
 
 // TypeName returns the name of the type.
@@ -732,12 +743,38 @@ func (o *CompiledFunction) Copy() Object {
 		KwargsNames:    o.KwargsNames,
 		KwargsDefaults: o.KwargsDefaults,
 		VarKwargs:      o.VarKwargs,
+		SourceMap:      o.SourceMap,
+		Locals:         o.Locals,
+		Branches:       o.Branches,
+		FreeNames:      o.FreeNames,
 		IsMethod:       o.IsMethod,
+		IsGenerator:    o.IsGenerator,
 		methodTarget:   o.methodTarget,
 		Free:           append([]*ObjectPtr{}, o.Free...), // DO NOT Copy() of elements; these are variable pointers
 	}
 }
 
+// closure returns a closure of the compiled function with the free
+// variables.
+func (o *CompiledFunction) closure(free []*ObjectPtr) *CompiledFunction {
+	return &CompiledFunction{
+		Instructions: o.Instructions,
+		IsMethod:     o.IsMethod,
+		NumLocals:    o.NumLocals,
+		NumArgs:      o.NumArgs,
+		VarArgs:      o.VarArgs,
+		KwargsNames:  o.KwargsNames,
+		Kwargs:       o.Kwargs,
+		VarKwargs:    o.VarKwargs,
+		SourceMap:    o.SourceMap,
+		Locals:       o.Locals,
+		Branches:     o.Branches,
+		FreeNames:    o.FreeNames,
+		IsGenerator:  o.IsGenerator,
+		Free:         free,
+	}
+}
+
 // ToMethod converts this caller to method of instance
 func (o CompiledFunction) ToMethodOf(of Object) ToMethodConverter {
 	o.methodTarget = of
@@ -766,63 +803,51 @@ func (o *CompiledFunction) CanCall() bool {
 	return true
 }
 
-func (o *CompiledFunction) SetupVM(vm *VM) {
-	constsOffset := len(vm.bc.Constants)
-
-	// Load fn
-	inst := MakeInstruction(parser.OpConstant, constsOffset)
-
-	// Load args
-	inst = append(inst,
-		MakeInstruction(parser.OpConstant, constsOffset+1)...)
-	// Load kwargs
-	inst = append(inst,
-		MakeInstruction(parser.OpConstant, constsOffset+2)...)
-
-	// Call, set value to a global, stop
-	inst = append(inst, MakeInstruction(parser.OpCall, 0, 1, 0, 1)...)
-	inst = append(inst, MakeInstruction(parser.OpSuspend)...)
-
-	bc := *vm.bc
-	bc.Constants = append(bc.Constants, o, &Array{}, &Map{})
-
-	bc.MainFunction = &CompiledFunction{
-		Instructions: inst,
-	}
-
-	o.vm = NewVM(&bc, vm.globals, vm.maxAllocs)
-	o.vm.context = vm.context
-	o.vmConstantsCount = constsOffset + 3
-}
-
-// Call should take an arbitrary number of arguments and returns a return
-// value and/or an error, which the VM will consider as a run-time error.
+// SetupVM does nothing. The function is called on the stack of the VM of the
+// call context.
+//
+// Deprecated: Call no longer needs a VM set up for the function.
+func (o *CompiledFunction) SetupVM(vm *VM) {}
+
+// Call calls the function on the stack of the VM of the call context, e.g.
+// when a Go function calls a compiled function passed to it. The function
+// shares the limits and the context of the run and can be called
+// recursively. The runtime error of the function is returned with the source
+// positions of its call frames. The receiver of the call is the first argument
+// of a method without a target.
+//
+// The VM must be running the Go function making the call, on the same
+// goroutine: Call must not be used concurrently with the run of the VM, e.g.
+// from another goroutine started by the Go function. Call returns an error if
+// the VM is running without calling a Go function or another compiled
+// function is being called with it at the same depth.
 func (o *CompiledFunction) Call(ctx *CallContext) (ret Object, err error) {
-	if atomic.LoadUint32(&o.running) == 1 {
-		err = fmt.Errorf("compile function is running")
-		return
+	vm := ctx.VM
+	if vm == nil {
+		return nil, fmt.Errorf("compiled function is called without VM")
 	}
-
-	atomic.StoreUint32(&o.running, 1)
-	defer atomic.StoreUint32(&o.running, 0)
-
-	if o.vm == nil {
-		o.SetupVM(ctx.VM)
+	calls := atomic.LoadInt64(&vm.calls)
+	if calls >= atomic.LoadInt64(&vm.goCalls) ||
+		!atomic.CompareAndSwapInt64(&vm.calls, calls, calls+1) {
+		return nil, fmt.Errorf("compiled function is called concurrently with VM")
 	}
-
-	o.vm.bc.Constants[o.vmConstantsCount-2].(*Array).Value = ctx.Args
-	o.vm.bc.Constants[o.vmConstantsCount-1].(*Map).Value = ctx.Kwargs
-
-	if err = o.vm.Run(); err == nil {
-		ret = o.vm.stack[0]
+	defer atomic.AddInt64(&vm.calls, -1)
+	args := ctx.Args
+	if o.IsMethod && o.methodTarget == nil && ctx.This != nil {
+		args = append([]Object{ctx.This}, args...)
 	}
-	return
+	return vm.call(o, args, ctx.Kwargs)
 }
 
 // Error represents an error value.
 type Error struct {
 	ObjectImpl
 	Value Object
+
+	// runtime error and its position if the error was caught by try
+	// statement.
+	err error
+	pos parser.SourceFilePos
 }
 
 // TypeName returns the name of the type.
@@ -844,7 +869,7 @@ func (o *Error) IsFalsy() bool {
 
 // Copy returns a copy of the type.
 func (o *Error) Copy() Object {
-	return &Error{Value: o.Value.Copy()}
+	return &Error{Value: o.Value.Copy(), err: o.err, pos: o.pos}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -855,11 +880,18 @@ func (o *Error) Equals(x Object) bool {
 
 // IndexGet returns an element at a given index.
 func (o *Error) IndexGet(_ *VM, index Object) (res Object, err error) {
-	if strIdx, _ := ToString(index); strIdx != "value" {
+	switch strIdx, _ := ToString(index); strIdx {
+	case "value":
+		res = o.Value
+	case "pos":
+		if o.pos.IsValid() {
+			res = &String{Value: o.pos.String()}
+		} else {
+			res = UndefinedValue
+		}
+	default:
 		err = ErrInvalidIndexOnError
-		return
 	}
-	res = o.Value
 	return
 }
 
@@ -1464,12 +1496,15 @@ func (o *Map) IndexGet(_ *VM, index Object) (res Object, err error) {
 }
 
 // IndexSet sets the value for the given key.
-func (o *Map) IndexSet(_ *VM, index, value Object) (err error) {
+func (o *Map) IndexSet(vm *VM, index, value Object) (err error) {
 	strIdx, ok := ToString(index)
 	if !ok {
 		err = ErrInvalidIndexType
 		return
 	}
+	if err = allocMapEntry(vm, o.Value, strIdx); err != nil {
+		return
+	}
 	o.Value[strIdx] = value
 	return nil
 }
@@ -693,11 +693,11 @@ func TestMap_Index(t *testing.T) {
 	m := &tengo.Map{Value: make(map[string]tengo.Object)}
 	k := &tengo.Int{Value: 1}
 	v := &tengo.String{Value: "abcdef"}
-	err := m.IndexSet(k, v)
+	err := m.IndexSet(nil, k, v)
 
 	require.NoError(t, err)
 
-	res, err := m.IndexGet(k)
+	res, err := m.IndexGet(nil, k)
 	require.NoError(t, err)
 	require.Equal(t, v, res)
 }
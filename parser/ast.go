@@ -19,12 +19,36 @@ type Node interface {
 	String() string
 }
 
+// Comment represents a single //-style or /*-style comment.
+type Comment struct {
+	Slash Pos    // position of "/" starting the comment
+	Text  string // comment text excluding '\n' of //-style comments
+}
+
+// Pos returns the position of first character belonging to the node.
+func (c *Comment) Pos() Pos {
+	return c.Slash
+}
+
+// End returns the position of first character immediately after the node.
+func (c *Comment) End() Pos {
+	return Pos(int(c.Slash) + len(c.Text))
+}
+
+func (c *Comment) String() string {
+	return c.Text
+}
+
 // IdentList represents a list of identifiers.
 type IdentList struct {
 	LParen  Pos
 	VarArgs bool
 	List    []*Ident
-	RParen  Pos
+	// Patterns are the destructuring patterns of the parameters; or nil if
+	// no parameter is destructured. The identifier of a destructured
+	// parameter is "_".
+	Patterns []Expr
+	RParen   Pos
 }
 
 // Pos returns the position of first character belonging to the node.
@@ -60,11 +84,16 @@ func (n *IdentList) NumFields() int {
 func (n *IdentList) String() string {
 	var list []string
 	for i, e := range n.List {
-		if n.VarArgs && i == len(n.List)-1 {
-			list = append(list, "..."+e.String())
+		var name string
+		if i < len(n.Patterns) && n.Patterns[i] != nil {
+			name = n.Patterns[i].String()
 		} else {
-			list = append(list, e.String())
+			name = e.String()
+		}
+		if n.VarArgs && i == len(n.List)-1 {
+			name = "..." + name
 		}
+		list = append(list, name)
 	}
 	return "(" + strings.Join(list, ", ") + ")"
 }
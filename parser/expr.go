@@ -40,6 +40,83 @@ func (e *ArrayLit) String() string {
 	return "[" + strings.Join(elements, ", ") + "]"
 }
 
+// ArrayPattern represents an array destructuring pattern of a switch case or
+// an assignment. The pattern on the left side of an assignment may omit the
+// brackets, e.g. "a, b, ...rest := arr".
+type ArrayPattern struct {
+	Elements []Expr
+	LBrack   Pos
+	Ellipsis Pos    // position of "..."; or NoPos
+	Rest     *Ident // variable of the rest elements; or nil
+	RBrack   Pos
+}
+
+func (e *ArrayPattern) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *ArrayPattern) Pos() Pos {
+	if !e.LBrack.IsValid() && len(e.Elements) > 0 {
+		return e.Elements[0].Pos()
+	} else if !e.LBrack.IsValid() {
+		return e.Ellipsis
+	}
+	return e.LBrack
+}
+
+// End returns the position of first character immediately after the node.
+func (e *ArrayPattern) End() Pos {
+	if e.RBrack.IsValid() {
+		return e.RBrack + 1
+	} else if e.Rest != nil {
+		return e.Rest.End()
+	} else if e.Ellipsis.IsValid() {
+		return e.Ellipsis + 3
+	}
+	return e.Elements[len(e.Elements)-1].End()
+}
+
+func (e *ArrayPattern) String() string {
+	var elements []string
+	for _, m := range e.Elements {
+		elements = append(elements, m.String())
+	}
+	if e.Ellipsis.IsValid() {
+		rest := "..."
+		if e.Rest != nil {
+			rest += e.Rest.Name
+		}
+		elements = append(elements, rest)
+	}
+	if !e.LBrack.IsValid() {
+		return strings.Join(elements, ", ")
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// DefaultPattern represents an element of a destructuring assignment with
+// the default value used if the element is undefined, e.g. "a = 1".
+type DefaultPattern struct {
+	Pattern   Expr
+	AssignPos Pos
+	Default   Expr
+}
+
+func (e *DefaultPattern) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *DefaultPattern) Pos() Pos {
+	return e.Pattern.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (e *DefaultPattern) End() Pos {
+	return e.Default.End()
+}
+
+func (e *DefaultPattern) String() string {
+	return e.Pattern.String() + " = " + e.Default.String()
+}
+
 // BadExpr represents a bad expression.
 type BadExpr struct {
 	From Pos
@@ -123,13 +200,15 @@ type CallExprKwargs struct {
 	Ellipsis Pos
 }
 
-// CallExpr represents a function call expression.
+// CallExpr represents a function call expression. An optional call "f?.()"
+// is not made if the function is undefined or an error.
 type CallExpr struct {
-	Func   Expr
-	LParen Pos
-	Args   CallExprArgs
-	Kwargs CallExprKwargs
-	RParen Pos
+	Func     Expr
+	LParen   Pos
+	Args     CallExprArgs
+	Kwargs   CallExprKwargs
+	RParen   Pos
+	Optional bool
 }
 
 func (e *CallExpr) exprNode() {}
@@ -146,6 +225,9 @@ func (e *CallExpr) End() Pos {
 
 func (e *CallExpr) String() string {
 	var buf = bytes.NewBufferString(e.Func.String())
+	if e.Optional {
+		buf.WriteString("?.")
+	}
 	buf.WriteString("(")
 	if l := len(e.Args.Values); l > 0 {
 		for i, e := range e.Args.Values {
@@ -402,12 +484,14 @@ func (e *ImportExpr) String() string {
 	return `import("` + e.ModuleName + `")"`
 }
 
-// IndexExpr represents an index expression.
+// IndexExpr represents an index expression. An optional index "x?.[k]"
+// is not evaluated if the expression is undefined or an error.
 type IndexExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Index  Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Index    Expr
+	RBrack   Pos
+	Optional bool
 }
 
 func (e *IndexExpr) exprNode() {}
@@ -427,6 +511,9 @@ func (e *IndexExpr) String() string {
 	if e.Index != nil {
 		index = e.Index.String()
 	}
+	if e.Optional {
+		return e.Expr.String() + "?.[" + index + "]"
+	}
 	return e.Expr.String() + "[" + index + "]"
 }
 
@@ -477,6 +564,9 @@ func (e *MapElementLit) String() string {
 	if e.Value == nil {
 		return e.Key
 	}
+	if _, ok := e.Value.(*SpreadExpr); ok {
+		return e.Value.String()
+	}
 	return e.Key + ": " + e.Value.String()
 }
 
@@ -507,6 +597,43 @@ func (e *MapLit) String() string {
 	return "{" + strings.Join(elements, ", ") + "}"
 }
 
+// MapPattern represents a map destructuring pattern of a switch case or an
+// assignment.
+type MapPattern struct {
+	LBrace   Pos
+	Elements []*MapElementLit
+	Ellipsis Pos    // position of "..."; or NoPos
+	Rest     *Ident // variable of the other entries; or nil
+	RBrace   Pos
+}
+
+func (e *MapPattern) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *MapPattern) Pos() Pos {
+	return e.LBrace
+}
+
+// End returns the position of first character immediately after the node.
+func (e *MapPattern) End() Pos {
+	return e.RBrace + 1
+}
+
+func (e *MapPattern) String() string {
+	var elements []string
+	for _, m := range e.Elements {
+		elements = append(elements, m.String())
+	}
+	if e.Ellipsis.IsValid() {
+		rest := "..."
+		if e.Rest != nil {
+			rest += e.Rest.Name
+		}
+		elements = append(elements, rest)
+	}
+	return "{" + strings.Join(elements, ", ") + "}"
+}
+
 // ParenExpr represents a parenthesis wrapped expression.
 type ParenExpr struct {
 	Expr   Expr
@@ -530,10 +657,12 @@ func (e *ParenExpr) String() string {
 	return "(" + e.Expr.String() + ")"
 }
 
-// SelectorExpr represents a selector expression.
+// SelectorExpr represents a selector expression. An optional selector
+// "x?.name" is not evaluated if the expression is undefined or an error.
 type SelectorExpr struct {
-	Expr Expr
-	Sel  Expr
+	Expr     Expr
+	Sel      Expr
+	Optional bool
 }
 
 func (e *SelectorExpr) exprNode() {}
@@ -549,16 +678,21 @@ func (e *SelectorExpr) End() Pos {
 }
 
 func (e *SelectorExpr) String() string {
+	if e.Optional {
+		return e.Expr.String() + "?." + e.Sel.String()
+	}
 	return e.Expr.String() + "." + e.Sel.String()
 }
 
-// SliceExpr represents a slice expression.
+// SliceExpr represents a slice expression. An optional slice "x?.[i:j]"
+// is not evaluated if the expression is undefined or an error.
 type SliceExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Low    Expr
-	High   Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Low      Expr
+	High     Expr
+	RBrack   Pos
+	Optional bool
 }
 
 func (e *SliceExpr) exprNode() {}
@@ -581,9 +715,36 @@ func (e *SliceExpr) String() string {
 	if e.High != nil {
 		high = e.High.String()
 	}
+	if e.Optional {
+		return e.Expr.String() + "?.[" + low + ":" + high + "]"
+	}
 	return e.Expr.String() + "[" + low + ":" + high + "]"
 }
 
+// SpreadExpr represents an element of an array or a map literal spreading
+// the elements of the value, e.g. "...xs". The map element spreading the
+// value has the empty key.
+type SpreadExpr struct {
+	Ellipsis Pos
+	Expr     Expr
+}
+
+func (e *SpreadExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *SpreadExpr) Pos() Pos {
+	return e.Ellipsis
+}
+
+// End returns the position of first character immediately after the node.
+func (e *SpreadExpr) End() Pos {
+	return e.Expr.End()
+}
+
+func (e *SpreadExpr) String() string {
+	return "..." + e.Expr.String()
+}
+
 // StringLit represents a string literal.
 type StringLit struct {
 	Value    string
@@ -607,6 +768,29 @@ func (e *StringLit) String() string {
 	return e.Literal
 }
 
+// TypePattern represents a type pattern of a switch case. It matches the
+// values of the type name or the instances of the user type.
+type TypePattern struct {
+	IsPos Pos
+	Type  Expr
+}
+
+func (e *TypePattern) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *TypePattern) Pos() Pos {
+	return e.IsPos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *TypePattern) End() Pos {
+	return e.Type.End()
+}
+
+func (e *TypePattern) String() string {
+	return "is " + e.Type.String()
+}
+
 // UnaryExpr represents an unary operator expression.
 type UnaryExpr struct {
 	Expr     Expr
@@ -734,3 +918,31 @@ func (e *CalledKwargsLit) End() Pos {
 func (e *CalledKwargsLit) String() string {
 	return "kwargv"
 }
+
+// YieldExpr represents a yield expression suspending a generator function.
+type YieldExpr struct {
+	Result   Expr
+	YieldPos Pos
+}
+
+func (e *YieldExpr) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *YieldExpr) Pos() Pos {
+	return e.YieldPos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *YieldExpr) End() Pos {
+	if e.Result != nil {
+		return e.Result.End()
+	}
+	return e.YieldPos + 5 // len("yield") == 5
+}
+
+func (e *YieldExpr) String() string {
+	if e.Result != nil {
+		return "yield " + e.Result.String()
+	}
+	return "yield"
+}
@@ -51,6 +51,20 @@ const (
 	OpIteratorValue               // Iterator value
 	OpBinaryOp                    // Binary operation
 	OpSuspend                     // Suspend VM
+	OpTry                         // Setup try block
+	OpTryEnd                      // End try block
+	OpThrow                       // Re-raise caught error
+	OpSwitch                      // Jump table of switch
+	OpMatchArray                  // Match array pattern
+	OpMatchMap                    // Match map pattern
+	OpMatchType                   // Match type pattern
+	OpYield                       // Suspend generator
+	OpChainJump                   // Optional chaining jump
+	OpCoalesceJump                // Nil-coalescing jump
+	OpArrayRest                   // Rest elements of array
+	OpMapRest                     // Rest entries of map
+	OpSpreadArray                 // Append elements to array
+	OpSpreadMap                   // Merge entries into map
 )
 
 // OpcodeNames are string representation of opcodes.
@@ -98,6 +112,20 @@ var OpcodeNames = [...]string{
 	OpIteratorValue: "ITVAL",
 	OpBinaryOp:      "BINARYOP",
 	OpSuspend:       "SUSPEND",
+	OpTry:           "TRY",
+	OpTryEnd:        "TRYEND",
+	OpThrow:         "THROW",
+	OpSwitch:        "SWITCH",
+	OpMatchArray:    "MATCHARR",
+	OpMatchMap:      "MATCHMAP",
+	OpMatchType:     "MATCHTYPE",
+	OpYield:         "YIELD",
+	OpChainJump:     "CHAINJMP",
+	OpCoalesceJump:  "COALJMP",
+	OpArrayRest:     "ARRREST",
+	OpMapRest:       "MAPREST",
+	OpSpreadArray:   "SPREADARR",
+	OpSpreadMap:     "SPREADMAP",
 }
 
 // OpcodeOperands is the number of operands.
@@ -145,6 +173,20 @@ var OpcodeOperands = [...][]int{
 	OpIteratorValue: {},
 	OpBinaryOp:      {1},
 	OpSuspend:       {},
+	OpTry:           {2},
+	OpTryEnd:        {},
+	OpThrow:         {},
+	OpSwitch:        {2, 2},
+	OpMatchArray:    {2, 1},
+	OpMatchMap:      {2},
+	OpMatchType:     {},
+	OpYield:         {},
+	OpChainJump:     {2},
+	OpCoalesceJump:  {2},
+	OpArrayRest:     {2},
+	OpMapRest:       {2},
+	OpSpreadArray:   {},
+	OpSpreadMap:     {},
 }
 
 // ReadOperands reads operands from the bytecode.
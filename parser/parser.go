@@ -12,6 +12,15 @@ import (
 
 type bailout struct{}
 
+// Mode represents a parser mode.
+type Mode int
+
+// Names of parser modes.
+const (
+	// ParseComments makes the parser keep the comments in File.Comments.
+	ParseComments Mode = 1 << iota
+)
+
 var stmtStart = map[token.Token]bool{
 	token.Break:    true,
 	token.Continue: true,
@@ -19,6 +28,8 @@ var stmtStart = map[token.Token]bool{
 	token.If:       true,
 	token.Return:   true,
 	token.Export:   true,
+	token.Try:      true,
+	token.Switch:   true,
 }
 
 // Error represents a parser error.
@@ -105,19 +116,36 @@ type Parser struct {
 	trace     bool
 	indent    int
 	traceOut  io.Writer
+	mode      Mode
+	comments  []*Comment
 }
 
 // NewParser creates a Parser.
 func NewParser(file *SourceFile, src []byte, trace io.Writer) *Parser {
+	return NewParserWithMode(file, src, trace, 0)
+}
+
+// NewParserWithMode creates a Parser with the parser mode flags.
+func NewParserWithMode(
+	file *SourceFile,
+	src []byte,
+	trace io.Writer,
+	mode Mode,
+) *Parser {
 	p := &Parser{
 		file:     file,
 		trace:    trace != nil,
 		traceOut: trace,
+		mode:     mode,
+	}
+	var scanMode ScanMode
+	if mode&ParseComments != 0 {
+		scanMode = ScanComments
 	}
 	p.scanner = NewScanner(p.file, src,
 		func(pos SourceFilePos, msg string) {
 			p.errors.Add(pos, msg)
-		}, 0)
+		}, scanMode)
 	p.next()
 	return p
 }
@@ -151,6 +179,7 @@ func (p *Parser) ParseFile() (file *File, err error) {
 	file = &File{
 		InputFile: p.file,
 		Stmts:     stmts,
+		Comments:  p.comments,
 	}
 	return
 }
@@ -225,10 +254,33 @@ func (p *Parser) parseUnaryExpr() Expr {
 			TokenPos: pos,
 			Expr:     x,
 		}
+	case token.Yield:
+		return p.parseYieldExpr()
 	}
 	return p.parsePrimaryExpr()
 }
 
+func (p *Parser) parseYieldExpr() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "YieldExpr"))
+	}
+
+	pos := p.pos
+	p.expect(token.Yield)
+
+	var x Expr
+	switch p.token {
+	case token.Semicolon, token.RBrace, token.RParen, token.RBrack,
+		token.Comma, token.Colon, token.EOF:
+	default:
+		x = p.parseExpr()
+	}
+	return &YieldExpr{
+		YieldPos: pos,
+		Result:   x,
+	}
+}
+
 func (p *Parser) parsePrimaryExpr() Expr {
 	if p.trace {
 		defer untracep(tracep(p, "PrimaryExpression"))
@@ -255,6 +307,11 @@ L:
 			x = p.parseIndexOrSlice(x)
 		case token.LParen:
 			x = p.parseCall(x)
+		case token.QuestionDot:
+			x = p.parseOptional(x)
+			if _, ok := x.(*BadExpr); ok {
+				return x
+			}
 		default:
 			break L
 		}
@@ -262,6 +319,40 @@ L:
 	return x
 }
 
+func (p *Parser) parseOptional(x Expr) Expr {
+	if p.trace {
+		defer untracep(tracep(p, "Optional"))
+	}
+
+	p.expect(token.QuestionDot)
+
+	switch p.token {
+	case token.Ident:
+		sel := p.parseSelector(x).(*SelectorExpr)
+		sel.Optional = true
+		return sel
+	case token.LBrack:
+		x = p.parseIndexOrSlice(x)
+		switch x := x.(type) {
+		case *IndexExpr:
+			x.Optional = true
+		case *SliceExpr:
+			x.Optional = true
+		}
+		return x
+	case token.LParen:
+		call := p.parseCall(x)
+		if call != nil {
+			call.Optional = true
+		}
+		return call
+	}
+	pos := p.pos
+	p.errorExpected(pos, "selector, index or call")
+	p.advance(stmtStart)
+	return &BadExpr{From: pos, To: p.pos}
+}
+
 func (p *Parser) parseCall(x Expr) *CallExpr {
 	if p.trace {
 		defer untracep(tracep(p, "Call"))
@@ -610,7 +701,11 @@ func (p *Parser) parseArrayLit() Expr {
 
 	var elements []Expr
 	for p.token != token.RBrack && p.token != token.EOF {
-		elements = append(elements, p.parseExpr())
+		if p.token == token.Ellipsis {
+			elements = append(elements, p.parseSpreadExpr())
+		} else {
+			elements = append(elements, p.parseExpr())
+		}
 
 		if !p.expectComma(token.RBrack, "array element") {
 			break
@@ -626,6 +721,16 @@ func (p *Parser) parseArrayLit() Expr {
 	}
 }
 
+// parseSpreadExpr parses "...value" of an array or a map literal.
+func (p *Parser) parseSpreadExpr() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "SpreadExpr"))
+	}
+
+	pos := p.expect(token.Ellipsis)
+	return &SpreadExpr{Ellipsis: pos, Expr: p.parseExpr()}
+}
+
 func (p *Parser) parseErrorExpr() Expr {
 	pos := p.pos
 
@@ -735,7 +840,7 @@ func (p *Parser) parseFuncParams() *FuncParams {
 			}
 		}
 
-		args.List = append(args.List, p.parseIdent())
+		p.parseParam(args)
 		for !args.VarArgs && p.token == token.Comma {
 			p.next()
 			if p.token == token.Semicolon {
@@ -749,7 +854,7 @@ func (p *Parser) parseFuncParams() *FuncParams {
 					goto kws
 				}
 			}
-			args.List = append(args.List, p.parseIdent())
+			p.parseParam(args)
 		}
 
 	kws:
@@ -800,6 +905,9 @@ func (p *Parser) parseFuncParams() *FuncParams {
 	}
 
 done:
+	for args.Patterns != nil && len(args.Patterns) < len(args.List) {
+		args.Patterns = append(args.Patterns, nil)
+	}
 	rparen := p.expect(token.RParen)
 	return &FuncParams{
 		LParen: lparen,
@@ -809,6 +917,25 @@ done:
 	}
 }
 
+// parseParam parses a parameter, which is an identifier or a destructuring
+// pattern, and adds it to the list.
+func (p *Parser) parseParam(args *IdentList) {
+	switch p.token {
+	case token.LBrack, token.LBrace:
+		pos := p.pos
+		if args.Patterns == nil {
+			args.Patterns = make([]Expr, len(args.List))
+		}
+		args.List = append(args.List, &Ident{Name: "_", NamePos: pos})
+		args.Patterns = append(args.Patterns, p.parsePattern())
+	default:
+		args.List = append(args.List, p.parseIdent())
+		if args.Patterns != nil {
+			args.Patterns = append(args.Patterns, nil)
+		}
+	}
+}
+
 func (p *Parser) parseStmt() (stmt Stmt) {
 	if p.trace {
 		defer untracep(tracep(p, "Statement"))
@@ -820,7 +947,8 @@ func (p *Parser) parseStmt() (stmt Stmt) {
 		token.Float, token.Char, token.String, token.True, token.False,
 		token.Undefined, token.Default, token.Import, token.LParen,
 		token.LBrace, token.LBrack, token.Add, token.Sub, token.Mul, token.And,
-		token.Xor, token.Not, token.Callee, token.CalledArgs, token.CalledKwargs:
+		token.Xor, token.Not, token.Callee, token.CalledArgs, token.CalledKwargs,
+		token.Yield:
 		s := p.parseSimpleStmt(false)
 		p.expectSemi()
 		return s
@@ -832,6 +960,10 @@ func (p *Parser) parseStmt() (stmt Stmt) {
 		return p.parseIfStmt()
 	case token.For:
 		return p.parseForStmt()
+	case token.Try:
+		return p.parseTryStmt()
+	case token.Switch:
+		return p.parseSwitchStmt()
 	case token.Break, token.Continue:
 		return p.parseBranchStmt(p.token)
 	case token.Semicolon:
@@ -857,7 +989,7 @@ func (p *Parser) parseForStmt() Stmt {
 	pos := p.expect(token.For)
 
 	// for {}
-	if p.token == token.LBrace {
+	if p.token == token.LBrace && !p.isPattern(true) {
 		body := p.parseBlockStmt()
 		p.expectSemi()
 
@@ -971,6 +1103,282 @@ func (p *Parser) parseIfStmt() Stmt {
 	}
 }
 
+func (p *Parser) parseTryStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "TryStmt"))
+	}
+
+	pos := p.expect(token.Try)
+	stmt := &TryStmt{
+		TryPos: pos,
+		Body:   p.parseBlockStmt(),
+	}
+
+	// try {} catch {}          or
+	// try {} catch err {}
+	if p.token == token.Catch {
+		stmt.CatchPos = p.pos
+		p.next()
+		if p.token == token.Ident {
+			stmt.Ident = p.parseIdent()
+		}
+		stmt.Catch = p.parseBlockStmt()
+	}
+
+	// try {} finally {}        or
+	// try {} catch err {} finally {}
+	if p.token == token.Finally {
+		stmt.FinallyPos = p.pos
+		p.next()
+		stmt.Finally = p.parseBlockStmt()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errorExpected(p.pos, "catch or finally")
+	}
+	p.expectSemi()
+	return stmt
+}
+
+func (p *Parser) parseSwitchStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "SwitchStmt"))
+	}
+
+	pos := p.expect(token.Switch)
+
+	// switch {}                or
+	// switch tag {}            or
+	// switch init; tag {}
+	var init Stmt
+	var tag Expr
+	if p.token != token.LBrace {
+		outer := p.exprLevel
+		p.exprLevel = -1
+
+		var tagStmt Stmt
+		if p.token != token.Semicolon {
+			tagStmt = p.parseSimpleStmt(false)
+		}
+		if p.token == token.Semicolon {
+			p.next()
+			init = tagStmt
+			tagStmt = nil
+			if p.token != token.LBrace {
+				tagStmt = p.parseSimpleStmt(false)
+			}
+		}
+		tag = p.makeExpr(tagStmt, "switch expression")
+		p.exprLevel = outer
+	}
+
+	lbrace := p.expect(token.LBrace)
+	var cases []*CaseClause
+	for p.token == token.Case || p.token == token.Default {
+		cases = append(cases, p.parseCaseClause())
+	}
+	rbrace := p.expect(token.RBrace)
+	p.expectSemi()
+	return &SwitchStmt{
+		SwitchPos: pos,
+		Init:      init,
+		Tag:       tag,
+		LBrace:    lbrace,
+		Cases:     cases,
+		RBrace:    rbrace,
+	}
+}
+
+func (p *Parser) parseCaseClause() *CaseClause {
+	if p.trace {
+		defer untracep(tracep(p, "CaseClause"))
+	}
+
+	clause := &CaseClause{CasePos: p.pos}
+	if p.token == token.Case {
+		p.next()
+		for {
+			clause.Patterns = append(clause.Patterns, p.parsePattern())
+			if p.token != token.Comma {
+				break
+			}
+			p.next()
+		}
+		if p.token == token.If {
+			p.next()
+			clause.Guard = p.parseExpr()
+		}
+	} else {
+		p.expect(token.Default)
+	}
+	clause.Colon = p.expect(token.Colon)
+
+	for p.token != token.Case && p.token != token.Default &&
+		p.token != token.RBrace && p.token != token.EOF {
+		clause.Body = append(clause.Body, p.parseStmt())
+	}
+	return clause
+}
+
+// parsePattern parses a pattern of a switch case. The array and the map
+// patterns destructure the value and the other expressions are compared
+// with the value.
+func (p *Parser) parsePattern() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "Pattern"))
+	}
+
+	switch p.token {
+	case token.LBrack:
+		return p.parseArrayPattern()
+	case token.LBrace:
+		return p.parseMapPattern()
+	case token.Is:
+		pos := p.pos
+		p.next()
+		return &TypePattern{
+			IsPos: pos,
+			Type:  p.parseUnaryExpr(),
+		}
+	}
+	return p.parseExpr()
+}
+
+func (p *Parser) parseArrayPattern() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "ArrayPattern"))
+	}
+
+	pattern := &ArrayPattern{LBrack: p.expect(token.LBrack)}
+	p.exprLevel++
+	for p.token != token.RBrack && p.token != token.EOF {
+		// [a, b, ...rest]
+		if p.token == token.Ellipsis {
+			pattern.Ellipsis = p.pos
+			p.next()
+			if p.token == token.Ident {
+				pattern.Rest = p.parseIdent()
+			}
+			if p.token == token.Comma {
+				p.errorExpected(p.pos, "']'")
+			}
+			break
+		}
+		pattern.Elements = append(pattern.Elements,
+			p.parseDefault(p.parsePattern()))
+
+		if !p.expectComma(token.RBrack, "array element") {
+			break
+		}
+	}
+	p.exprLevel--
+	pattern.RBrack = p.expect(token.RBrack)
+	return pattern
+}
+
+func (p *Parser) parseMapPattern() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "MapPattern"))
+	}
+
+	pattern := &MapPattern{LBrace: p.expect(token.LBrace)}
+	p.exprLevel++
+	for p.token != token.RBrace && p.token != token.EOF {
+		// {key, ...rest}
+		if p.token == token.Ellipsis {
+			pattern.Ellipsis = p.pos
+			p.next()
+			if p.token == token.Ident {
+				pattern.Rest = p.parseIdent()
+			}
+			if p.token == token.Comma {
+				p.errorExpected(p.pos, "'}'")
+			}
+			break
+		}
+
+		pos := p.pos
+		name := "_"
+		if p.token == token.Ident {
+			name = p.tokenLit
+		} else if p.token == token.String {
+			v, _ := strconv.Unquote(p.tokenLit)
+			name = v
+		} else {
+			p.errorExpected(pos, "map key")
+		}
+		p.next()
+
+		// {key: pattern} or {key}
+		element := &MapElementLit{Key: name, KeyPos: pos}
+		if p.token == token.Colon {
+			element.ColonPos = p.pos
+			p.next()
+			element.Value = p.parsePattern()
+		} else {
+			element.Value = &Ident{Name: name, NamePos: pos}
+		}
+		element.Value = p.parseDefault(element.Value)
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.expectComma(token.RBrace, "map element") {
+			break
+		}
+	}
+	p.exprLevel--
+	pattern.RBrace = p.expect(token.RBrace)
+	return pattern
+}
+
+// parseDefault parses the default value of an element of a destructuring
+// pattern if any.
+func (p *Parser) parseDefault(pattern Expr) Expr {
+	if p.token != token.Assign {
+		return pattern
+	}
+	pos := p.pos
+	p.next()
+	return &DefaultPattern{
+		Pattern:   pattern,
+		AssignPos: pos,
+		Default:   p.parseExpr(),
+	}
+}
+
+// isPattern reports whether the array or the map literal starting at the
+// current token is a destructuring pattern, i.e., it's followed by an
+// assignment, a comma or "in" of a for-in statement. It scans ahead using a
+// copy of the scanner without changing the state of the parser.
+func (p *Parser) isPattern(forIn bool) bool {
+	s := *p.scanner
+	s.errorHandler = nil
+	scan := func() token.Token {
+		tok, _, _ := s.Scan()
+		for tok == token.Comment {
+			tok, _, _ = s.Scan()
+		}
+		return tok
+	}
+
+	for depth := 1; depth > 0; {
+		switch scan() {
+		case token.LBrack, token.LBrace, token.LParen:
+			depth++
+		case token.RBrack, token.RBrace, token.RParen:
+			depth--
+		case token.EOF:
+			return false
+		}
+	}
+	switch scan() {
+	case token.Assign, token.Define, token.Comma:
+		return true
+	case token.In:
+		return forIn
+	}
+	return false
+}
+
 func (p *Parser) parseBlockStmt() *BlockStmt {
 	if p.trace {
 		defer untracep(tracep(p, "BlockStmt"))
@@ -1079,7 +1487,7 @@ func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 		defer untracep(tracep(p, "SimpleStmt"))
 	}
 
-	x := p.parseExprList()
+	x := p.parseTargetList(forIn)
 
 	switch p.token {
 	case token.Assign, token.Define: // assignment statement
@@ -1098,31 +1506,24 @@ func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 			y := p.parseExpr()
 
 			var key, value *Ident
+			var pattern Expr
 			var ok bool
 			switch len(x) {
 			case 1:
 				key = &Ident{Name: "_", NamePos: x[0].Pos()}
-
-				value, ok = x[0].(*Ident)
-				if !ok {
-					p.errorExpected(x[0].Pos(), "identifier")
-					value = &Ident{Name: "_", NamePos: x[0].Pos()}
-				}
+				value, pattern = p.forInValue(x[0])
 			case 2:
 				key, ok = x[0].(*Ident)
 				if !ok {
 					p.errorExpected(x[0].Pos(), "identifier")
 					key = &Ident{Name: "_", NamePos: x[0].Pos()}
 				}
-				value, ok = x[1].(*Ident)
-				if !ok {
-					p.errorExpected(x[1].Pos(), "identifier")
-					value = &Ident{Name: "_", NamePos: x[1].Pos()}
-				}
+				value, pattern = p.forInValue(x[1])
 			}
 			return &ForInStmt{
 				Key:      key,
 				Value:    value,
+				Pattern:  pattern,
 				Iterable: y,
 			}
 		}
@@ -1131,6 +1532,8 @@ func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 	if len(x) > 1 {
 		p.errorExpected(x[0].Pos(), "1 expression")
 		// continue with first expression
+	} else if isTargetPattern(x[0]) {
+		p.errorExpected(p.pos, "'=' or ':='")
 	}
 
 	switch p.token {
@@ -1156,6 +1559,65 @@ func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 	return &ExprStmt{Expr: x[0]}
 }
 
+// parseTargetList parses the expressions of a simple statement, which may be
+// the targets of an assignment. An array or a map literal is a destructuring
+// pattern if an assignment follows it, or if it is not the first element of
+// the list. The list ending with "...rest" is an array pattern without
+// brackets, e.g. "a, b, ...rest := arr".
+func (p *Parser) parseTargetList(forIn bool) (list []Expr) {
+	if p.trace {
+		defer untracep(tracep(p, "TargetList"))
+	}
+
+	if (p.token == token.LBrack || p.token == token.LBrace) &&
+		p.isPattern(forIn) {
+		list = append(list, p.parsePattern())
+	} else {
+		list = append(list, p.parseExpr())
+	}
+	for p.token == token.Comma {
+		p.next()
+		switch p.token {
+		case token.LBrack, token.LBrace:
+			list = append(list, p.parsePattern())
+		case token.Ellipsis:
+			pattern := &ArrayPattern{Elements: list, Ellipsis: p.pos}
+			p.next()
+			pattern.Rest = p.parseIdent()
+			return []Expr{pattern}
+		default:
+			list = append(list, p.parseExpr())
+		}
+	}
+	return
+}
+
+// forInValue returns the value variable or the destructuring pattern of the
+// value of a for-in statement.
+func (p *Parser) forInValue(x Expr) (*Ident, Expr) {
+	switch x := x.(type) {
+	case *Ident:
+		return x, nil
+	case *ArrayPattern:
+		if x.LBrack.IsValid() {
+			return &Ident{Name: "_", NamePos: x.Pos()}, x
+		}
+	case *MapPattern:
+		return &Ident{Name: "_", NamePos: x.Pos()}, x
+	}
+	p.errorExpected(x.Pos(), "identifier")
+	return &Ident{Name: "_", NamePos: x.Pos()}, nil
+}
+
+// isTargetPattern reports whether the expression is a destructuring pattern.
+func isTargetPattern(x Expr) bool {
+	switch x.(type) {
+	case *ArrayPattern, *MapPattern:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) parseExprList() (list []Expr) {
 	if p.trace {
 		defer untracep(tracep(p, "ExpressionList"))
@@ -1175,6 +1637,10 @@ func (p *Parser) parseMapElementLit() *MapElementLit {
 	}
 
 	pos := p.pos
+	if p.token == token.Ellipsis {
+		return &MapElementLit{KeyPos: pos, Value: p.parseSpreadExpr()}
+	}
+
 	name := "_"
 	if p.token == token.Ident {
 		name = p.tokenLit
@@ -1335,6 +1801,15 @@ func (p *Parser) next() {
 		}
 	}
 	p.token, p.tokenLit, p.pos = p.scanner.Scan()
+
+	// comments are collected, not passed to the parsing functions.
+	for p.token == token.Comment {
+		p.comments = append(p.comments, &Comment{
+			Slash: p.pos,
+			Text:  p.tokenLit,
+		})
+		p.token, p.tokenLit, p.pos = p.scanner.Scan()
+	}
 }
 
 func (p *Parser) printTrace(a ...interface{}) {
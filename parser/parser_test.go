@@ -1395,6 +1395,187 @@ key2: 2,
 }`)
 }
 
+func TestParseOptional(t *testing.T) {
+	expectParse(t, "a?.b", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				&SelectorExpr{
+					Expr:     ident("a", p(1, 1)),
+					Sel:      stringLit("b", p(1, 4)),
+					Optional: true,
+				}))
+	})
+	expectParse(t, "a?.[1]", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				&IndexExpr{
+					Expr:     ident("a", p(1, 1)),
+					Index:    intLit(1, p(1, 5)),
+					LBrack:   p(1, 4),
+					RBrack:   p(1, 6),
+					Optional: true,
+				}))
+	})
+	expectParse(t, "a?.()", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				&CallExpr{
+					Func:     ident("a", p(1, 1)),
+					LParen:   p(1, 4),
+					RParen:   p(1, 5),
+					Optional: true,
+				}))
+	})
+	expectParse(t, "a ?? b", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				binaryExpr(
+					ident("a", p(1, 1)),
+					ident("b", p(1, 6)),
+					token.Coalesce,
+					p(1, 3))))
+	})
+
+	expectParseString(t, "a?.b?.c", "a?.b?.c")
+	expectParseString(t, "a?.b.c", "a?.b.c")
+	expectParseString(t, `a?.["b"][c]`, `a?.["b"][c]`)
+	expectParseString(t, "a?.[1:2]", "a?.[1:2]")
+	expectParseString(t, "a.f?.(1, 2)?.b", "a.f?.(1, 2)?.b")
+	expectParseString(t, "a ?? b ?? c", "((a ?? b) ?? c)")
+	expectParseString(t, "a || b ?? c && d", "((a || b) ?? (c && d))")
+	expectParseString(t, "a ?? b ? c : d", "((a ?? b) ? c : d)")
+	expectParseString(t, "a ?.5 : b", "(a ? .5 : b)")
+
+	expectParseError(t, "a?.")
+	expectParseError(t, "a?.1")
+	expectParseError(t, "a ?? ")
+}
+
+func TestParseDestructuring(t *testing.T) {
+	expectParse(t, "a, b, ...r := x", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(&ArrayPattern{
+					Elements: []Expr{
+						ident("a", p(1, 1)),
+						ident("b", p(1, 4)),
+					},
+					Ellipsis: p(1, 7),
+					Rest:     ident("r", p(1, 10)),
+				}),
+				exprs(ident("x", p(1, 15))),
+				token.Define,
+				p(1, 12)))
+	})
+	expectParse(t, "[a, b = 1] = x", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(&ArrayPattern{
+					Elements: []Expr{
+						ident("a", p(1, 2)),
+						&DefaultPattern{
+							Pattern:   ident("b", p(1, 5)),
+							AssignPos: p(1, 7),
+							Default:   intLit(1, p(1, 9)),
+						},
+					},
+					LBrack: p(1, 1),
+					RBrack: p(1, 10),
+				}),
+				exprs(ident("x", p(1, 14))),
+				token.Assign,
+				p(1, 12)))
+	})
+	expectParse(t, "{a, b: c, ...r} := x", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(&MapPattern{
+					LBrace: p(1, 1),
+					Elements: []*MapElementLit{
+						mapElementLit("a", p(1, 2), NoPos,
+							ident("a", p(1, 2))),
+						mapElementLit("b", p(1, 5), p(1, 6),
+							ident("c", p(1, 8))),
+					},
+					Ellipsis: p(1, 11),
+					Rest:     ident("r", p(1, 14)),
+					RBrace:   p(1, 15),
+				}),
+				exprs(ident("x", p(1, 20))),
+				token.Define,
+				p(1, 17)))
+	})
+	expectParse(t, "for k, [a] in x {}", func(p pfn) []Stmt {
+		return stmts(
+			&ForInStmt{
+				Key:   ident("k", p(1, 5)),
+				Value: ident("_", p(1, 8)),
+				Pattern: &ArrayPattern{
+					Elements: []Expr{ident("a", p(1, 9))},
+					LBrack:   p(1, 8),
+					RBrack:   p(1, 10),
+				},
+				Iterable: ident("x", p(1, 15)),
+				Body:     blockStmt(p(1, 17), p(1, 18)),
+				ForPos:   p(1, 1),
+			})
+	})
+
+	expectParseString(t, "a, [b, c] := x", "a, [b, c] := x")
+	expectParseString(t, "{a = 1, b: [c] = d} := x",
+		"{a: a = 1, b: [c] = d} := x")
+	expectParseString(t, "for {a} in x {}", "for _, {a: a} in x {}")
+	expectParseString(t, "for { a() }", "for {a()}")
+	expectParseString(t, "[a, b]", "[a, b]")
+	expectParseString(t, "f := func(a, [b, c], ...{d}) {}",
+		"f := func(a, [b, c], ...{d: d}) {}")
+
+	expectParseError(t, "a, ...r")
+	expectParseError(t, "a, ...r, b := x")
+	expectParseError(t, "for a, ...r in x {}")
+	expectParseError(t, "{a, ...r, b} := x")
+}
+
+func TestParseSpread(t *testing.T) {
+	expectParse(t, "[1, ...a, ...b]", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				arrayLit(p(1, 1), p(1, 15),
+					intLit(1, p(1, 2)),
+					&SpreadExpr{
+						Ellipsis: p(1, 5),
+						Expr:     ident("a", p(1, 8)),
+					},
+					&SpreadExpr{
+						Ellipsis: p(1, 11),
+						Expr:     ident("b", p(1, 14)),
+					})))
+	})
+	expectParse(t, "x := {...a, k: 1}", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("x", p(1, 1))),
+				exprs(mapLit(p(1, 6), p(1, 17),
+					&MapElementLit{
+						KeyPos: p(1, 7),
+						Value: &SpreadExpr{
+							Ellipsis: p(1, 7),
+							Expr:     ident("a", p(1, 10)),
+						},
+					},
+					mapElementLit("k", p(1, 13), p(1, 14),
+						intLit(1, p(1, 16))))),
+				token.Define,
+				p(1, 3)))
+	})
+
+	expectParseString(t, "[...a.b, ...f(x)]", "[...a.b, ...f(x)]")
+	expectParseString(t, "x := {...a, ...b, c: 1}", "x := {...a, ...b, c: 1}")
+
+	expectParseError(t, "[...]")
+	expectParseError(t, "x := {...}")
+}
+
 func TestParsePrecedence(t *testing.T) {
 	expectParseString(t, `a + b + c`, `((a + b) + c)`)
 	expectParseString(t, `a + b * c`, `(a + (b * c))`)
@@ -1734,6 +1915,217 @@ func stmts(s ...Stmt) []Stmt {
 	return s
 }
 
+func TestParseTry(t *testing.T) {
+	expectParse(t, "try {} catch {}", func(p pfn) []Stmt {
+		return stmts(
+			tryStmt(
+				blockStmt(p(1, 5), p(1, 6)),
+				nil,
+				blockStmt(p(1, 14), p(1, 15)),
+				nil,
+				p(1, 1), p(1, 8), NoPos))
+	})
+
+	expectParse(t, "try { a } catch err { b } finally { c }", func(p pfn) []Stmt {
+		return stmts(
+			tryStmt(
+				blockStmt(p(1, 5), p(1, 9),
+					exprStmt(ident("a", p(1, 7)))),
+				ident("err", p(1, 17)),
+				blockStmt(p(1, 21), p(1, 25),
+					exprStmt(ident("b", p(1, 23)))),
+				blockStmt(p(1, 35), p(1, 39),
+					exprStmt(ident("c", p(1, 37)))),
+				p(1, 1), p(1, 11), p(1, 27)))
+	})
+
+	expectParse(t, "try {} finally {}", func(p pfn) []Stmt {
+		return stmts(
+			tryStmt(
+				blockStmt(p(1, 5), p(1, 6)),
+				nil,
+				nil,
+				blockStmt(p(1, 16), p(1, 17)),
+				p(1, 1), NoPos, p(1, 8)))
+	})
+
+	expectParseString(t, "try { a } catch err { b } finally { c }",
+		"try {a} catch err {b} finally {c}")
+
+	expectParseError(t, "try {}")
+	expectParseError(t, "try {} catch 1 {}")
+	expectParseError(t, "try {}\ncatch {}")
+	expectParseError(t, "try {} finally {} catch {}")
+}
+
+func TestParseYield(t *testing.T) {
+	expectParse(t, "yield", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(yieldExpr(p(1, 1), nil)))
+	})
+
+	expectParse(t, "yield a", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(yieldExpr(p(1, 1), ident("a", p(1, 7)))))
+	})
+
+	expectParse(t, "a := yield 1", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(yieldExpr(p(1, 6), intLit(1, p(1, 12)))),
+				token.Define, p(1, 3)))
+	})
+
+	expectParse(t, "f(yield)", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				callExpr(ident("f", p(1, 1)), p(1, 2), p(1, 8),
+					args(NoPos, yieldExpr(p(1, 3), nil)))))
+	})
+
+	expectParseString(t, "yield", "yield")
+	expectParseString(t, "yield a + 1", "yield (a + 1)")
+	expectParseString(t, "a := yield", "a := yield")
+
+	expectParseError(t, "yield :=")
+}
+
+func TestParseSwitch(t *testing.T) {
+	expectParse(t, "switch x { case 1, 2: a; default: b }", func(p pfn) []Stmt {
+		return stmts(
+			&SwitchStmt{
+				SwitchPos: p(1, 1),
+				Tag:       ident("x", p(1, 8)),
+				LBrace:    p(1, 10),
+				RBrace:    p(1, 37),
+				Cases: []*CaseClause{
+					{
+						CasePos: p(1, 12),
+						Patterns: []Expr{
+							intLit(1, p(1, 17)),
+							intLit(2, p(1, 20)),
+						},
+						Colon: p(1, 21),
+						Body:  []Stmt{exprStmt(ident("a", p(1, 23)))},
+					},
+					{
+						CasePos: p(1, 26),
+						Colon:   p(1, 33),
+						Body:    []Stmt{exprStmt(ident("b", p(1, 35)))},
+					},
+				},
+			})
+	})
+
+	expectParse(t, "switch { case [a, ...r] if a: }", func(p pfn) []Stmt {
+		return stmts(
+			&SwitchStmt{
+				SwitchPos: p(1, 1),
+				LBrace:    p(1, 8),
+				RBrace:    p(1, 31),
+				Cases: []*CaseClause{
+					{
+						CasePos: p(1, 10),
+						Patterns: []Expr{
+							&ArrayPattern{
+								Elements: []Expr{ident("a", p(1, 16))},
+								LBrack:   p(1, 15),
+								Ellipsis: p(1, 19),
+								Rest:     ident("r", p(1, 22)),
+								RBrack:   p(1, 23),
+							},
+						},
+						Guard: ident("a", p(1, 28)),
+						Colon: p(1, 29),
+					},
+				},
+			})
+	})
+
+	expectParse(t, `switch x { case {a, "b": 1}, is T: }`, func(p pfn) []Stmt {
+		return stmts(
+			&SwitchStmt{
+				SwitchPos: p(1, 1),
+				Tag:       ident("x", p(1, 8)),
+				LBrace:    p(1, 10),
+				RBrace:    p(1, 36),
+				Cases: []*CaseClause{
+					{
+						CasePos: p(1, 12),
+						Patterns: []Expr{
+							&MapPattern{
+								LBrace: p(1, 17),
+								Elements: []*MapElementLit{
+									mapElementLit("a", p(1, 18), NoPos,
+										ident("a", p(1, 18))),
+									mapElementLit("b", p(1, 21), p(1, 24),
+										intLit(1, p(1, 26))),
+								},
+								RBrace: p(1, 27),
+							},
+							&TypePattern{
+								IsPos: p(1, 30),
+								Type:  ident("T", p(1, 33)),
+							},
+						},
+						Colon: p(1, 34),
+					},
+				},
+			})
+	})
+
+	expectParseString(t, `
+switch s := f(); s {
+case "a", "b":
+	x = 1
+case [a, [b, _], ...] if a > b:
+case {k: [v], n}, is "int":
+	y = 2
+	z = 3
+default:
+}`, `switch s := f(); s {case "a", "b": x = 1; `+
+		`case [a, [b, _], ...] if (a > b): ; `+
+		`case {k: [v], n: n}, is "int": y = 2; z = 3; default: }`)
+
+	expectParseError(t, "switch x { a }")
+	expectParseError(t, "switch x { case: }")
+	expectParseError(t, "switch x { case [...r, a]: }")
+	expectParseError(t, "switch x { case {1: a}: }")
+	expectParseError(t, "switch x { case 1 }")
+	expectParseError(t, "switch a := 1 {}")
+}
+
+func TestParseComments(t *testing.T) {
+	src := []byte("// a\na := 1 /* b */\n\n// c\n")
+	fileSet := NewFileSet()
+	file := fileSet.AddFile("test", -1, len(src))
+
+	f, err := NewParser(file, src, nil).ParseFile()
+	require.NoError(t, err)
+	require.Equal(t, 0, len(f.Comments))
+	require.Equal(t, 1, len(f.Stmts))
+
+	file = fileSet.AddFile("test", -1, len(src))
+	f, err = NewParserWithMode(file, src, nil, ParseComments).ParseFile()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(f.Stmts))
+	require.Equal(t, 3, len(f.Comments))
+	for i, expected := range []struct {
+		text string
+		pos  string
+	}{
+		{"// a", "test:1:1"},
+		{"/* b */", "test:2:8"},
+		{"// c", "test:4:1"},
+	} {
+		c := f.Comments[i]
+		require.Equal(t, expected.text, c.Text)
+		require.Equal(t, expected.pos, file.Position(c.Pos()).String())
+		require.Equal(t, c.Pos()+Pos(len(c.Text)), c.End())
+	}
+}
+
 func exprStmt(x Expr) *ExprStmt {
 	return &ExprStmt{Expr: x}
 }
@@ -1789,6 +2181,19 @@ func ifStmt(
 	}
 }
 
+func tryStmt(
+	body *BlockStmt,
+	ident *Ident,
+	catch *BlockStmt,
+	finally *BlockStmt,
+	pos, catchPos, finallyPos Pos,
+) *TryStmt {
+	return &TryStmt{
+		Body: body, Ident: ident, Catch: catch, Finally: finally,
+		TryPos: pos, CatchPos: catchPos, FinallyPos: finallyPos,
+	}
+}
+
 func incDecStmt(
 	expr Expr,
 	tok token.Token,
@@ -2011,6 +2416,10 @@ func errorExpr(
 	}
 }
 
+func yieldExpr(pos Pos, result Expr) *YieldExpr {
+	return &YieldExpr{Result: result, YieldPos: pos}
+}
+
 func selectorExpr(x, sel Expr) *SelectorExpr {
 	return &SelectorExpr{Expr: x, Sel: sel}
 }
@@ -2071,6 +2480,8 @@ func equalStmt(t *testing.T, expected, actual Stmt) {
 			actual.(*ForInStmt).Key)
 		equalExpr(t, expected.Value,
 			actual.(*ForInStmt).Value)
+		equalExpr(t, expected.Pattern,
+			actual.(*ForInStmt).Pattern)
 		equalExpr(t, expected.Iterable,
 			actual.(*ForInStmt).Iterable)
 		equalStmt(t, expected.Body,
@@ -2082,6 +2493,32 @@ func equalStmt(t *testing.T, expected, actual Stmt) {
 			actual.(*ReturnStmt).Result)
 		require.Equal(t, expected.ReturnPos,
 			actual.(*ReturnStmt).ReturnPos)
+	case *TryStmt:
+		equalStmt(t, expected.Body, actual.(*TryStmt).Body)
+		equalExpr(t, expected.Ident, actual.(*TryStmt).Ident)
+		equalStmt(t, expected.Catch, actual.(*TryStmt).Catch)
+		equalStmt(t, expected.Finally, actual.(*TryStmt).Finally)
+		require.Equal(t, expected.TryPos, actual.(*TryStmt).TryPos)
+		require.Equal(t, expected.CatchPos, actual.(*TryStmt).CatchPos)
+		require.Equal(t, expected.FinallyPos,
+			actual.(*TryStmt).FinallyPos)
+	case *SwitchStmt:
+		equalStmt(t, expected.Init, actual.(*SwitchStmt).Init)
+		equalExpr(t, expected.Tag, actual.(*SwitchStmt).Tag)
+		require.Equal(t, len(expected.Cases), len(actual.(*SwitchStmt).Cases))
+		for i, c := range expected.Cases {
+			equalStmt(t, c, actual.(*SwitchStmt).Cases[i])
+		}
+		require.Equal(t, expected.SwitchPos,
+			actual.(*SwitchStmt).SwitchPos)
+		require.Equal(t, expected.LBrace, actual.(*SwitchStmt).LBrace)
+		require.Equal(t, expected.RBrace, actual.(*SwitchStmt).RBrace)
+	case *CaseClause:
+		equalExprs(t, expected.Patterns, actual.(*CaseClause).Patterns)
+		equalExpr(t, expected.Guard, actual.(*CaseClause).Guard)
+		equalStmts(t, expected.Body, actual.(*CaseClause).Body)
+		require.Equal(t, expected.CasePos, actual.(*CaseClause).CasePos)
+		require.Equal(t, expected.Colon, actual.(*CaseClause).Colon)
 	case *BranchStmt:
 		equalExpr(t, expected.Label,
 			actual.(*BranchStmt).Label)
@@ -2156,6 +2593,39 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*MapLit).RBrace)
 		equalMapElements(t, expected.Elements,
 			actual.(*MapLit).Elements)
+	case *ArrayPattern:
+		require.Equal(t, expected.LBrack,
+			actual.(*ArrayPattern).LBrack)
+		require.Equal(t, expected.RBrack,
+			actual.(*ArrayPattern).RBrack)
+		require.Equal(t, expected.Ellipsis,
+			actual.(*ArrayPattern).Ellipsis)
+		equalExprs(t, expected.Elements,
+			actual.(*ArrayPattern).Elements)
+		equalExpr(t, expected.Rest, actual.(*ArrayPattern).Rest)
+	case *MapPattern:
+		require.Equal(t, expected.LBrace,
+			actual.(*MapPattern).LBrace)
+		require.Equal(t, expected.RBrace,
+			actual.(*MapPattern).RBrace)
+		equalMapElements(t, expected.Elements,
+			actual.(*MapPattern).Elements)
+		require.Equal(t, expected.Ellipsis,
+			actual.(*MapPattern).Ellipsis)
+		equalExpr(t, expected.Rest, actual.(*MapPattern).Rest)
+	case *SpreadExpr:
+		require.Equal(t, expected.Ellipsis,
+			actual.(*SpreadExpr).Ellipsis)
+		equalExpr(t, expected.Expr, actual.(*SpreadExpr).Expr)
+	case *DefaultPattern:
+		equalExpr(t, expected.Pattern, actual.(*DefaultPattern).Pattern)
+		require.Equal(t, expected.AssignPos,
+			actual.(*DefaultPattern).AssignPos)
+		equalExpr(t, expected.Default, actual.(*DefaultPattern).Default)
+	case *TypePattern:
+		require.Equal(t, expected.IsPos,
+			actual.(*TypePattern).IsPos)
+		equalExpr(t, expected.Type, actual.(*TypePattern).Type)
 	case *BinaryExpr:
 		equalExpr(t, expected.LHS,
 			actual.(*BinaryExpr).LHS)
@@ -2190,6 +2660,8 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*CallExpr).Kwargs.Values)
 		equalIdents(t, expected.Kwargs.Names,
 			actual.(*CallExpr).Kwargs.Names)
+		require.Equal(t, expected.Optional,
+			actual.(*CallExpr).Optional)
 	case *ParenExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*ParenExpr).Expr)
@@ -2206,6 +2678,8 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*IndexExpr).LBrack)
 		require.Equal(t, expected.RBrack,
 			actual.(*IndexExpr).RBrack)
+		require.Equal(t, expected.Optional,
+			actual.(*IndexExpr).Optional)
 	case *SliceExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*SliceExpr).Expr)
@@ -2217,11 +2691,15 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*SliceExpr).LBrack)
 		require.Equal(t, expected.RBrack,
 			actual.(*SliceExpr).RBrack)
+		require.Equal(t, expected.Optional,
+			actual.(*SliceExpr).Optional)
 	case *SelectorExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*SelectorExpr).Expr)
 		equalExpr(t, expected.Sel,
 			actual.(*SelectorExpr).Sel)
+		require.Equal(t, expected.Optional,
+			actual.(*SelectorExpr).Optional)
 	case *ImportExpr:
 		require.Equal(t, expected.ModuleName,
 			actual.(*ImportExpr).ModuleName)
@@ -2238,6 +2716,11 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			int(actual.(*ErrorExpr).LParen))
 		require.Equal(t, int(expected.RParen),
 			int(actual.(*ErrorExpr).RParen))
+	case *YieldExpr:
+		equalExpr(t, expected.Result,
+			actual.(*YieldExpr).Result)
+		require.Equal(t, int(expected.YieldPos),
+			int(actual.(*YieldExpr).YieldPos))
 	case *CondExpr:
 		equalExpr(t, expected.Cond,
 			actual.(*CondExpr).Cond)
@@ -2258,6 +2741,8 @@ func equalFuncType(t *testing.T, expected, actual *FuncType) {
 	require.Equal(t, expected.Params.LParen, actual.Params.LParen)
 	require.Equal(t, expected.Params.RParen, actual.Params.RParen)
 	equalIdents(t, expected.Params.Args.List, actual.Params.Args.List)
+	equalExprs(t, expected.Params.Args.Patterns,
+		actual.Params.Args.Patterns)
 	equalIdents(t, expected.Params.Kwargs.Names, actual.Params.Kwargs.Names)
 	equalExprs(t, expected.Params.Kwargs.Values, actual.Params.Kwargs.Values)
 }
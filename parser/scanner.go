@@ -91,7 +91,7 @@ func (s *Scanner) Scan() (
 		switch tok {
 		case token.Ident, token.Break, token.Continue, token.Return,
 			token.Export, token.True, token.False, token.Undefined,
-			token.Default:
+			token.Default, token.Yield:
 			insertSemi = true
 		case token.CalledArgs, token.CalledKwargs, token.Callee:
 			insertSemi = true
@@ -142,7 +142,17 @@ func (s *Scanner) Scan() (
 		case ',':
 			tok = token.Comma
 		case '?':
-			tok = token.Question
+			switch {
+			case s.ch == '?':
+				s.next()
+				tok = token.Coalesce
+			case s.ch == '.' && !('0' <= s.peek() && s.peek() <= '9'):
+				// "a ?.5 : b" is a conditional expression
+				s.next()
+				tok = token.QuestionDot
+			default:
+				tok = token.Question
+			}
 		case ';':
 			tok = token.Semicolon
 			literal = ";"
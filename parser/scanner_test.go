@@ -108,6 +108,8 @@ func TestScanner_Scan(t *testing.T) {
 		{token.RBrace, "}"},
 		{token.Semicolon, ";"},
 		{token.Colon, ":"},
+		{token.QuestionDot, "?."},
+		{token.Coalesce, "??"},
 		{token.Break, "break"},
 		{token.Continue, "continue"},
 		{token.Else, "else"},
@@ -197,6 +197,7 @@ type ForInStmt struct {
 	ForPos   Pos
 	Key      *Ident
 	Value    *Ident
+	Pattern  Expr // destructuring pattern of the value; or nil
 	Iterable Expr
 	Body     *BlockStmt
 }
@@ -214,6 +215,10 @@ func (s *ForInStmt) End() Pos {
 }
 
 func (s *ForInStmt) String() string {
+	if s.Pattern != nil {
+		return "for " + s.Key.String() + ", " + s.Pattern.String() +
+			" in " + s.Iterable.String() + " " + s.Body.String()
+	}
 	if s.Value != nil {
 		return "for " + s.Key.String() + ", " + s.Value.String() +
 			" in " + s.Iterable.String() + " " + s.Body.String()
@@ -347,3 +352,127 @@ func (s *ReturnStmt) String() string {
 	}
 	return "return"
 }
+
+// TryStmt represents a try statement.
+type TryStmt struct {
+	TryPos     Pos
+	Body       *BlockStmt
+	CatchPos   Pos
+	Ident      *Ident     // error variable of catch block; or nil
+	Catch      *BlockStmt // catch block; or nil
+	FinallyPos Pos
+	Finally    *BlockStmt // finally block; or nil
+}
+
+func (s *TryStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *TryStmt) Pos() Pos {
+	return s.TryPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *TryStmt) End() Pos {
+	if s.Finally != nil {
+		return s.Finally.End()
+	}
+	if s.Catch != nil {
+		return s.Catch.End()
+	}
+	return s.Body.End()
+}
+
+func (s *TryStmt) String() string {
+	str := "try " + s.Body.String()
+	if s.Catch != nil {
+		str += " catch "
+		if s.Ident != nil {
+			str += s.Ident.String() + " "
+		}
+		str += s.Catch.String()
+	}
+	if s.Finally != nil {
+		str += " finally " + s.Finally.String()
+	}
+	return str
+}
+
+// SwitchStmt represents a switch statement.
+type SwitchStmt struct {
+	SwitchPos Pos
+	Init      Stmt
+	Tag       Expr // value to match; or nil
+	LBrace    Pos
+	Cases     []*CaseClause
+	RBrace    Pos
+}
+
+func (s *SwitchStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *SwitchStmt) Pos() Pos {
+	return s.SwitchPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *SwitchStmt) End() Pos {
+	return s.RBrace + 1
+}
+
+func (s *SwitchStmt) String() string {
+	str := "switch "
+	if s.Init != nil {
+		str += s.Init.String() + "; "
+	}
+	if s.Tag != nil {
+		str += s.Tag.String() + " "
+	}
+	var cases []string
+	for _, c := range s.Cases {
+		cases = append(cases, c.String())
+	}
+	return str + "{" + strings.Join(cases, "; ") + "}"
+}
+
+// CaseClause represents a case of a switch statement.
+type CaseClause struct {
+	CasePos  Pos
+	Patterns []Expr // patterns of the case; or nil for the default case
+	Guard    Expr   // condition after "if"; or nil
+	Colon    Pos
+	Body     []Stmt
+}
+
+func (s *CaseClause) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *CaseClause) Pos() Pos {
+	return s.CasePos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *CaseClause) End() Pos {
+	if n := len(s.Body); n > 0 {
+		return s.Body[n-1].End()
+	}
+	return s.Colon + 1
+}
+
+func (s *CaseClause) String() string {
+	str := "default"
+	if s.Patterns != nil {
+		var patterns []string
+		for _, p := range s.Patterns {
+			patterns = append(patterns, p.String())
+		}
+		str = "case " + strings.Join(patterns, ", ")
+		if s.Guard != nil {
+			str += " if " + s.Guard.String()
+		}
+	}
+	var body []string
+	for _, e := range s.Body {
+		body = append(body, e.String())
+	}
+	return str + ": " + strings.Join(body, "; ")
+}
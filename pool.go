@@ -0,0 +1,127 @@
+package tengo
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Pool runs a compiled script concurrently by multiple goroutines. Each run
+// has its own global variables: the values set to the compiled script before
+// the pool was created are copied for every run and the inputs of the run
+// are set over them. The VMs and the slices of the global variables are
+// reused by the following runs.
+type Pool struct {
+	c   *Compiled
+	vms chan *VM
+}
+
+// NewPool creates a pool running the compiled script. The pool keeps up to
+// size idle VMs for reuse. Changes to the compiled script after the pool was
+// created do not affect the runs of the pool.
+func NewPool(c *Compiled, size int) *Pool {
+	if size < 0 {
+		size = 0
+	}
+	return &Pool{
+		c:   c.Clone(),
+		vms: make(chan *VM, size),
+	}
+}
+
+// Run runs the compiled script with the inputs set to the global variables.
+// It returns the global variables after the run. An error is returned if
+// one of the inputs is not a global variable of the script. If the run is
+// suspended, the returned *Suspension can be resumed, but, the global
+// variables are not returned.
+func (p *Pool) Run(
+	ctx context.Context,
+	inputs map[string]interface{},
+) (map[string]*Variable, error) {
+	v := p.get()
+	for name, value := range inputs {
+		idx, ok := p.c.globalIndexes[name]
+		if !ok {
+			p.put(v)
+			return nil, fmt.Errorf("'%s' is not defined", name)
+		}
+		obj, err := FromInterface(value)
+		if err != nil {
+			p.put(v)
+			return nil, err
+		}
+		if _, ok := value.(Object); ok {
+			obj = copyObject(obj)
+		}
+		v.globals[idx] = obj
+	}
+
+	err := p.c.runContext(ctx, v, func() error {
+		return v.RunContext(ctx)
+	})
+	if err != nil {
+		if _, ok := err.(*Suspension); !ok {
+			p.put(v)
+		}
+		return nil, err
+	}
+
+	outputs := make(map[string]*Variable, len(p.c.globalIndexes))
+	for name, idx := range p.c.globalIndexes {
+		value := v.globals[idx]
+		if value == nil {
+			value = UndefinedValue
+		}
+		outputs[name] = &Variable{
+			name:  name,
+			value: value,
+		}
+	}
+	p.put(v)
+	return outputs, nil
+}
+
+// get returns an idle VM or a new VM if there is none, with the global
+// variables and the limits reset for a run.
+func (p *Pool) get() *VM {
+	var v *VM
+	select {
+	case v = <-p.vms:
+	default:
+		v = p.c.newVM(make([]Object, len(p.c.inputs)))
+	}
+	for idx, value := range p.c.inputs {
+		if value != nil {
+			value = copyObject(value)
+		}
+		v.globals[idx] = value
+	}
+	// the VM may have been aborted after the last run returned
+	atomic.StoreInt64(&v.aborting, 0)
+	p.c.resetLimits(v)
+	return v
+}
+
+// put releases the objects of the last run and keeps the VM for reuse if
+// the pool is not full.
+func (p *Pool) put(v *VM) {
+	for idx := range v.globals {
+		v.globals[idx] = nil
+	}
+	for idx := range v.stack {
+		v.stack[idx] = nil
+	}
+	select {
+	case p.vms <- v:
+	default:
+	}
+}
+
+// copyObject returns a copy of the object or the object itself if it
+// cannot be copied.
+func copyObject(o Object) Object {
+	if c := o.Copy(); c != nil {
+		return c
+	}
+	return o
+}
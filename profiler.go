@@ -0,0 +1,570 @@
+package tengo
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultProfileInterval is the default sampling interval of Profiler.
+const DefaultProfileInterval = time.Millisecond
+
+// profileCheckInstructions is the number of instructions between the checks
+// of the sampling interval. It must be a power of 2.
+const profileCheckInstructions = 256
+
+// ProfileEntry is the statistics of a function or a source line.
+type ProfileEntry struct {
+	Function     string // name of the function
+	File         string
+	Line         int           // source line or start line of the function
+	Instructions int64         // number of instructions executed
+	Samples      int64         // number of samples
+	Flat         time.Duration // time spent in the function or the line
+	Cum          time.Duration // time spent including the callees
+}
+
+// Profiler records the execution of the VMs. It counts the instructions
+// executed for each function and source line and it samples the call
+// stacks of the running VMs periodically to measure the wall time. A
+// Profiler can be shared by multiple VMs running concurrently and the
+// results of all runs are aggregated.
+type Profiler struct {
+	interval time.Duration
+	lock     sync.Mutex
+	start    time.Time
+	duration time.Duration
+	funcs    map[*byte]*profileFunc
+	lines    map[profileLine]*profileStat
+	samples  map[string]*profileSample
+}
+
+// NewProfiler creates a Profiler that samples the call stacks at the given
+// interval. If interval is not positive, DefaultProfileInterval is used.
+func NewProfiler(interval time.Duration) *Profiler {
+	if interval <= 0 {
+		interval = DefaultProfileInterval
+	}
+	return &Profiler{
+		interval: interval,
+		funcs:    make(map[*byte]*profileFunc),
+		lines:    make(map[profileLine]*profileStat),
+		samples:  make(map[string]*profileSample),
+	}
+}
+
+type profileFunc struct {
+	id   uint64
+	name string
+	file string
+	line int
+	profileStat
+}
+
+type profileLine struct {
+	fn   *profileFunc
+	line int
+}
+
+type profileStat struct {
+	instructions int64
+	samples      int64
+	flat         time.Duration
+	cum          time.Duration
+}
+
+type profileSample struct {
+	stack        []profileLine // innermost first
+	samples      int64
+	instructions int64
+	wall         time.Duration
+}
+
+// profileFrame is a call frame captured by sampling.
+type profileFrame struct {
+	fn *CompiledFunction
+	ip int
+}
+
+type rawSample struct {
+	frames       []profileFrame
+	instructions int64
+	wall         time.Duration
+}
+
+// vmProfile records a single run of a VM. It's merged into the Profiler when
+// the run ends.
+type vmProfile struct {
+	p            *Profiler
+	vm           *VM
+	fn           *CompiledFunction
+	counts       []int64
+	funcs        map[*byte]*vmProfileFunc
+	start        time.Time
+	last         time.Time
+	executed     int64
+	lastExecuted int64
+	samples      []rawSample
+}
+
+type vmProfileFunc struct {
+	fn     *CompiledFunction
+	counts []int64 // number of instructions executed for each ip
+}
+
+// begin starts recording a run of the VM.
+func (p *Profiler) begin(v *VM) *vmProfile {
+	now := time.Now()
+	return &vmProfile{
+		p:     p,
+		vm:    v,
+		funcs: make(map[*byte]*vmProfileFunc),
+		start: now,
+		last:  now,
+	}
+}
+
+// end merges the recorded run into the profiler.
+func (p *Profiler) end(r *vmProfile) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.start.IsZero() {
+		p.start = r.start
+	}
+	p.duration += time.Since(r.start)
+
+	for _, f := range r.funcs {
+		pf := p.function(r.vm, f.fn)
+		for ip, n := range f.counts {
+			if n == 0 {
+				continue
+			}
+			pf.instructions += n
+			p.lineStat(profileLine{fn: pf, line: r.line(f.fn, ip)}).
+				instructions += n
+		}
+	}
+
+	var key strings.Builder
+	for _, s := range r.samples {
+		stack := make([]profileLine, len(s.frames))
+		key.Reset()
+		for i, f := range s.frames {
+			stack[i] = profileLine{
+				fn:   p.function(r.vm, f.fn),
+				line: r.line(f.fn, f.ip),
+			}
+			key.WriteString(strconv.FormatUint(stack[i].fn.id, 16))
+			key.WriteByte(':')
+			key.WriteString(strconv.Itoa(stack[i].line))
+			key.WriteByte(';')
+		}
+		ps, ok := p.samples[key.String()]
+		if !ok {
+			ps = &profileSample{stack: stack}
+			p.samples[key.String()] = ps
+		}
+		ps.samples++
+		ps.instructions += s.instructions
+		ps.wall += s.wall
+
+		// recursive calls are counted once for the cumulative time.
+		seenFuncs := make(map[*profileFunc]bool)
+		seenLines := make(map[profileLine]bool)
+		for i, l := range stack {
+			ls := p.lineStat(l)
+			if i == 0 {
+				l.fn.samples++
+				l.fn.flat += s.wall
+				ls.samples++
+				ls.flat += s.wall
+			}
+			if !seenFuncs[l.fn] {
+				seenFuncs[l.fn] = true
+				l.fn.cum += s.wall
+			}
+			if !seenLines[l] {
+				seenLines[l] = true
+				ls.cum += s.wall
+			}
+		}
+	}
+}
+
+func (p *Profiler) function(v *VM, fn *CompiledFunction) *profileFunc {
+	key := functionKey(fn)
+	if f, ok := p.funcs[key]; ok {
+		return f
+	}
+
+	f := &profileFunc{id: uint64(len(p.funcs) + 1)}
+	// the start of the function is the first source position.
+	for _, pos := range fn.SourceMap {
+		filePos := v.fileSet.Position(pos)
+		if !filePos.IsValid() {
+			continue
+		}
+		if f.line == 0 || filePos.Line < f.line {
+			f.file = filePos.Filename
+			f.line = filePos.Line
+		}
+	}
+	switch {
+	case fn == v.bc.MainFunction:
+		f.name = "main"
+	case f.line > 0:
+		f.name = fmt.Sprintf("func@%s:%d", filepath.Base(f.file), f.line)
+	default:
+		f.name = fmt.Sprintf("func#%d", f.id)
+	}
+	p.funcs[key] = f
+	return f
+}
+
+func (p *Profiler) lineStat(l profileLine) *profileStat {
+	s, ok := p.lines[l]
+	if !ok {
+		s = &profileStat{}
+		p.lines[l] = s
+	}
+	return s
+}
+
+// Functions returns the statistics of the functions sorted by the flat time
+// and the number of instructions.
+func (p *Profiler) Functions() []ProfileEntry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var entries []ProfileEntry
+	for _, f := range p.funcs {
+		entries = append(entries, f.entry(f.line, &f.profileStat))
+	}
+	sortProfileEntries(entries)
+	return entries
+}
+
+// Lines returns the statistics of the source lines sorted by the flat time
+// and the number of instructions.
+func (p *Profiler) Lines() []ProfileEntry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var entries []ProfileEntry
+	for l, s := range p.lines {
+		entries = append(entries, l.fn.entry(l.line, s))
+	}
+	sortProfileEntries(entries)
+	return entries
+}
+
+// Duration returns the total wall time of the recorded runs.
+func (p *Profiler) Duration() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.duration
+}
+
+// Reset discards the recorded statistics.
+func (p *Profiler) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.start = time.Time{}
+	p.duration = 0
+	p.funcs = make(map[*byte]*profileFunc)
+	p.lines = make(map[profileLine]*profileStat)
+	p.samples = make(map[string]*profileSample)
+}
+
+func (f *profileFunc) entry(line int, s *profileStat) ProfileEntry {
+	return ProfileEntry{
+		Function:     f.name,
+		File:         f.file,
+		Line:         line,
+		Instructions: s.instructions,
+		Samples:      s.samples,
+		Flat:         s.flat,
+		Cum:          s.cum,
+	}
+}
+
+func sortProfileEntries(entries []ProfileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case a.Flat != b.Flat:
+			return a.Flat > b.Flat
+		case a.Instructions != b.Instructions:
+			return a.Instructions > b.Instructions
+		case a.File != b.File:
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+}
+
+// WriteReport writes the statistics of the functions and the source lines
+// in a human-readable text.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	funcs, lines := p.Functions(), p.Lines()
+	var total time.Duration
+	var instructions, samples int64
+	for _, f := range funcs {
+		total += f.Flat
+		instructions += f.Instructions
+		samples += f.Samples
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	percent := func(d time.Duration) string {
+		if total == 0 {
+			return "0.00%"
+		}
+		return fmt.Sprintf("%.2f%%", float64(d)*100/float64(total))
+	}
+	_, _ = fmt.Fprintf(tw, "Duration: %s, Samples: %d (%s), "+
+		"Instructions: %d\n\n", p.Duration(), samples, total, instructions)
+	_, _ = fmt.Fprintln(tw, "flat\tflat%\tcum\tcum%\tinstructions\t \t")
+	for _, f := range funcs {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t \t%s\n",
+			f.Flat, percent(f.Flat), f.Cum, percent(f.Cum), f.Instructions,
+			f.Function)
+	}
+	_, _ = fmt.Fprintln(tw)
+	_, _ = fmt.Fprintln(tw, "flat\tflat%\tcum\tcum%\tinstructions\t \t")
+	for _, l := range lines {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t \t%s:%d (%s)\n",
+			l.Flat, percent(l.Flat), l.Cum, percent(l.Cum), l.Instructions,
+			l.File, l.Line, l.Function)
+	}
+	return tw.Flush()
+}
+
+// WritePprof writes the profile in the gzip-compressed protocol buffer
+// format of pprof. The samples have the sample count, the estimated number
+// of instructions executed and the wall time as values.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	p.lock.Lock()
+	data := p.encodePprof()
+	p.lock.Unlock()
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// encodePprof encodes the profile as a message of profile.proto.
+func (p *Profiler) encodePprof() []byte {
+	strs := map[string]int64{"": 0}
+	strTable := []string{""}
+	str := func(s string) int64 {
+		if i, ok := strs[s]; ok {
+			return i
+		}
+		strs[s] = int64(len(strTable))
+		strTable = append(strTable, s)
+		return strs[s]
+	}
+	valueType := func(typ, unit string) []byte {
+		var b protoBuffer
+		b.int64(1, str(typ))
+		b.int64(2, str(unit))
+		return b
+	}
+
+	var b protoBuffer
+	b.bytes(1, valueType("samples", "count"))
+	b.bytes(1, valueType("instructions", "count"))
+	b.bytes(1, valueType("wall", "nanoseconds"))
+
+	// samples in a stable order
+	var keys []string
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	locations := make(map[profileLine]uint64)
+	var locationList []profileLine
+	for _, k := range keys {
+		s := p.samples[k]
+		var ids []uint64
+		for _, l := range s.stack {
+			id, ok := locations[l]
+			if !ok {
+				id = uint64(len(locationList) + 1)
+				locations[l] = id
+				locationList = append(locationList, l)
+			}
+			ids = append(ids, id)
+		}
+		var sb protoBuffer
+		sb.packedUint64(1, ids)
+		sb.packedInt64(2, []int64{s.samples, s.instructions, int64(s.wall)})
+		b.bytes(2, sb)
+	}
+
+	for i, l := range locationList {
+		var line protoBuffer
+		line.uint64(1, l.fn.id)
+		line.int64(2, int64(l.line))
+		var lb protoBuffer
+		lb.uint64(1, uint64(i+1))
+		lb.bytes(4, line)
+		b.bytes(4, lb)
+	}
+
+	funcs := make([]*profileFunc, 0, len(p.funcs))
+	for _, f := range p.funcs {
+		funcs = append(funcs, f)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].id < funcs[j].id })
+	for _, f := range funcs {
+		var fb protoBuffer
+		fb.uint64(1, f.id)
+		fb.int64(2, str(f.name))
+		fb.int64(3, str(f.name))
+		fb.int64(4, str(f.file))
+		fb.int64(5, int64(f.line))
+		b.bytes(5, fb)
+	}
+
+	// string table must be encoded after all strings are added.
+	periodType := valueType("wall", "nanoseconds")
+	for _, s := range strTable {
+		b.string(6, s)
+	}
+	if !p.start.IsZero() {
+		b.int64(9, p.start.UnixNano())
+	}
+	b.int64(10, int64(p.duration))
+	b.bytes(11, periodType)
+	b.int64(12, int64(p.interval))
+	return b
+}
+
+// trace is called by the VM before executing each instruction.
+func (r *vmProfile) trace() {
+	v := r.vm
+	if fn := v.curFrame.fn; fn != r.fn {
+		r.fn = fn
+		key := functionKey(fn)
+		f, ok := r.funcs[key]
+		if !ok {
+			f = &vmProfileFunc{fn: fn, counts: make([]int64, len(fn.Instructions))}
+			r.funcs[key] = f
+		}
+		r.counts = f.counts
+	}
+	if v.ip < len(r.counts) {
+		r.counts[v.ip]++
+	}
+	r.executed++
+
+	// reading the clock for every instruction is too expensive.
+	if r.executed&(profileCheckInstructions-1) == 0 {
+		if now := time.Now(); now.Sub(r.last) >= r.p.interval {
+			r.sample(now)
+		}
+	}
+}
+
+// sample captures the call stack of the VM.
+func (r *vmProfile) sample(now time.Time) {
+	v := r.vm
+	frames := make([]profileFrame, 0, v.framesIndex)
+	for i := v.framesIndex - 1; i >= 0; i-- {
+		f := &v.frames[i]
+		ip := f.ip
+		if i == v.framesIndex-1 {
+			ip = v.ip
+		}
+		frames = append(frames, profileFrame{fn: f.fn, ip: ip})
+	}
+	r.samples = append(r.samples, rawSample{
+		frames:       frames,
+		instructions: r.executed - r.lastExecuted,
+		wall:         now.Sub(r.last),
+	})
+	r.last = now
+	r.lastExecuted = r.executed
+}
+
+// line returns the source line of the instruction. The instructions without
+// source positions belong to the line of the previous instruction.
+func (r *vmProfile) line(fn *CompiledFunction, ip int) int {
+	for ; ip >= 0; ip-- {
+		if p, ok := fn.SourceMap[ip]; ok && p.IsValid() {
+			return r.vm.fileSet.Position(p).Line
+		}
+	}
+	return 0
+}
+
+// functionKey returns the key to identify the function. The closures of
+// the same function share the instructions.
+func functionKey(fn *CompiledFunction) *byte {
+	if len(fn.Instructions) == 0 {
+		return nil
+	}
+	return &fn.Instructions[0]
+}
+
+// protoBuffer encodes the protocol buffer messages.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) packedUint64(field int, xs []uint64) {
+	var data protoBuffer
+	for _, x := range xs {
+		data.varint(x)
+	}
+	b.bytes(field, data)
+}
+
+func (b *protoBuffer) packedInt64(field int, xs []int64) {
+	var data protoBuffer
+	for _, x := range xs {
+		data.varint(uint64(x))
+	}
+	b.bytes(field, data)
+}
@@ -0,0 +1,198 @@
+package tengo_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/require"
+)
+
+func TestProfiler(t *testing.T) {
+	src := `
+f := func(x) {
+	return x + 1
+}
+s := 0
+for i := 0; i < 100; i++ {
+	s = f(s)
+}
+`
+	p := tengo.NewProfiler(0)
+	v, _ := compileDebug(t, src)
+	v.SetProfiler(p)
+	require.NoError(t, v.Run())
+	require.NoError(t, v.Run())
+
+	funcs := p.Functions()
+	require.Equal(t, 2, len(funcs))
+	f := findProfileEntry(funcs, "func@test:3", 3)
+	require.NotNil(t, f)
+	require.Equal(t, "test", f.File)
+	// GETL, CONST, BINARYOP, RETURN for each call
+	require.Equal(t, int64(2*100*4), f.Instructions)
+	main := findProfileEntry(funcs, "main", 2)
+	require.NotNil(t, main)
+	require.True(t, main.Instructions > f.Instructions)
+
+	lines := p.Lines()
+	l := findProfileEntry(lines, "func@test:3", 3)
+	require.NotNil(t, l)
+	require.Equal(t, f.Instructions, l.Instructions)
+	var total int64
+	for _, l := range lines {
+		require.True(t, l.Line > 0)
+		total += l.Instructions
+	}
+	require.Equal(t, main.Instructions+f.Instructions, total)
+
+	p.Reset()
+	require.Equal(t, 0, len(p.Functions()))
+	require.Equal(t, int64(0), int64(p.Duration()))
+}
+
+func TestProfiler_Sampling(t *testing.T) {
+	src := `
+busy := func(n) {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+for i := 0; i < 5; i++ {
+	busy(20000)
+}
+`
+	p := tengo.NewProfiler(100 * time.Microsecond)
+	s := tengo.NewScript([]byte(src))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	c.SetProfiler(p)
+	require.NoError(t, c.Run())
+	require.NoError(t, c.Clone().Run())
+
+	busy := findProfileEntry(p.Functions(), "func@(main):3", 3)
+	require.NotNil(t, busy)
+	require.True(t, busy.Samples > 0)
+	require.True(t, busy.Flat > 0)
+	require.True(t, busy.Cum >= busy.Flat)
+	main := findProfileEntry(p.Functions(), "main", 2)
+	require.NotNil(t, main)
+	require.True(t, main.Cum >= busy.Cum)
+	require.True(t, p.Duration() >= main.Cum)
+
+	var report bytes.Buffer
+	require.NoError(t, p.WriteReport(&report))
+	require.True(t, strings.Contains(report.String(), "func@(main):3"))
+	require.True(t, strings.Contains(report.String(), "(main):5 (func@(main):3)"))
+
+	// pprof profile
+	var buf bytes.Buffer
+	require.NoError(t, p.WritePprof(&buf))
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+
+	fields := decodeProto(t, data)
+	var strs []string
+	for _, s := range fields[6] {
+		strs = append(strs, string(s.([]byte)))
+	}
+	require.Equal(t, "", strs[0])
+	for _, s := range []string{"samples", "instructions", "wall",
+		"nanoseconds", "main", "func@(main):3", "(main)"} {
+		require.True(t, containsString(strs, s), s)
+	}
+	require.Equal(t, 3, len(fields[1])) // sample types
+	require.True(t, len(fields[2]) > 0) // samples
+	require.True(t, len(fields[4]) > 0) // locations
+	require.Equal(t, 2, len(fields[5])) // functions
+	require.Equal(t, int64(100000), fields[12][0].(int64))
+
+	var samples, wall int64
+	for _, s := range fields[2] {
+		sample := decodeProto(t, s.([]byte))
+		values := decodePacked(t, sample[2][0].([]byte))
+		require.Equal(t, 3, len(values))
+		samples += values[0]
+		wall += values[2]
+	}
+	require.Equal(t, busy.Samples+findProfileEntry(p.Functions(),
+		"main", 2).Samples, samples)
+	require.Equal(t, int64(main.Cum), wall)
+}
+
+func findProfileEntry(
+	entries []tengo.ProfileEntry,
+	fn string,
+	line int,
+) *tengo.ProfileEntry {
+	for _, e := range entries {
+		if e.Function == fn && e.Line == line {
+			e := e
+			return &e
+		}
+	}
+	return nil
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeProto decodes the fields of a protocol buffer message. Varint
+// fields are int64 and length-delimited fields are []byte.
+func decodeProto(t *testing.T, data []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(data) > 0 {
+		tag, n := decodeVarint(t, data)
+		data = data[n:]
+		switch tag & 7 {
+		case 0:
+			v, n := decodeVarint(t, data)
+			data = data[n:]
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], int64(v))
+		case 2:
+			l, n := decodeVarint(t, data)
+			data = data[n:]
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], data[:l])
+			data = data[l:]
+		default:
+			t.Fatalf("unexpected wire type: %d", tag&7)
+		}
+	}
+	return fields
+}
+
+func decodePacked(t *testing.T, data []byte) []int64 {
+	var values []int64
+	for len(data) > 0 {
+		v, n := decodeVarint(t, data)
+		data = data[n:]
+		values = append(values, int64(v))
+	}
+	return values
+}
+
+func decodeVarint(t *testing.T, data []byte) (uint64, int) {
+	var x uint64
+	for i, b := range data {
+		x |= uint64(b&0x7f) << (7 * uint(i))
+		if b < 0x80 {
+			return x, i + 1
+		}
+	}
+	t.Fatal("invalid varint")
+	return 0, 0
+}
@@ -0,0 +1,794 @@
+package tengo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// types of the Go structs by the struct or the pointer type
+	reflectTypes sync.Map
+)
+
+// reflectField is an exported field of a Go struct.
+type reflectField struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+// structFields returns the exported fields of the struct type. The name of
+// the field is the first part of the "tengo" tag or the name of the field
+// if it has no tag. The fields tagged with "-" are skipped.
+func structFields(t reflect.Type) []reflectField {
+	var fields []reflectField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("tengo"); ok {
+			tag = strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, reflectField{
+			name:  name,
+			index: f.Index,
+			typ:   f.Type,
+		})
+	}
+	return fields
+}
+
+// structTags returns the tags of the struct field as a map of strings.
+func structTags(tag reflect.StructTag) map[string]Object {
+	tags := make(map[string]Object)
+	s := string(tag)
+	for {
+		s = strings.TrimLeft(s, " ")
+		i := strings.Index(s, ":\"")
+		if i <= 0 {
+			break
+		}
+		key := s[:i]
+		s = s[i+1:]
+		j := 1
+		for j < len(s) && s[j] != '"' {
+			if s[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(s) {
+			break
+		}
+		value, err := strconv.Unquote(s[:j+1])
+		if err != nil {
+			break
+		}
+		tags[key] = &String{Value: value}
+		s = s[j+1:]
+	}
+	return tags
+}
+
+// reflectType returns the script type of the Go struct type or the pointer
+// to a struct type. The fields of the type are the exported fields of the
+// struct and the methods are the exported methods of the pointer to the
+// struct. The instances of the type are converted back to the Go type by
+// ToInterface.
+func reflectType(t reflect.Type) *Type {
+	if typ, ok := reflectTypes.Load(t); ok {
+		return typ.(*Type)
+	}
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	typ := &Type{
+		Name:       st.Name(),
+		Fields:     &TypeFields{Value: map[string]*TypeField{}},
+		Methods:    &TypeMethods{Value: map[string]*TypeMethod{}},
+		Properties: &TypeProperties{Value: map[string]*TypeProperty{}},
+		goType:     t,
+	}
+	if typ.Name == "" {
+		typ.Name = st.String()
+	}
+	for _, f := range structFields(st) {
+		value, err := fromReflect(reflect.Zero(f.typ), nil)
+		if err != nil {
+			value = UndefinedValue
+		}
+		typ.Fields.Value[f.name] = &TypeField{
+			Value: value,
+			Tags:  structTags(st.FieldByIndex(f.index).Tag),
+		}
+	}
+	pt := reflect.PtrTo(st)
+	for i := 0; i < pt.NumMethod(); i++ {
+		m := pt.Method(i)
+		typ.Methods.Value[m.Name] = &TypeMethod{
+			Value: &UserFunctionCtx{
+				Name:  m.Name,
+				Value: reflectMethod(st, m.Index),
+			},
+			Tags: map[string]Object{},
+		}
+	}
+	typ2, _ := reflectTypes.LoadOrStore(t, typ)
+	return typ2.(*Type)
+}
+
+// reflectMethod returns the function calling the method of the Go struct.
+// The method is called on the struct of the instance, updated from the
+// values of the instance, and the values are updated from the struct after
+// the call.
+func reflectMethod(st reflect.Type, index int) CallableFuncCtx {
+	return func(ctx *CallContext) (Object, error) {
+		inst, ok := ctx.This.(*Instance)
+		if !ok {
+			return nil, fmt.Errorf("method is called without instance")
+		}
+		ptr, err := instanceValue(inst, st)
+		if err != nil {
+			return nil, err
+		}
+		ret, err := callReflect(ptr.Method(index), ctx.Args, ctx.Kwargs)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range structFields(st) {
+			value, err := fromReflect(ptr.Elem().FieldByIndex(f.index), nil)
+			if err != nil {
+				return nil, err
+			}
+			inst.Values[f.name] = value
+		}
+		return ret, nil
+	}
+}
+
+// instanceValue returns the pointer to the Go struct of the instance with the
+// exported fields set from the values of the instance. The struct is created
+// for the instances not converted from a Go struct, e.g. created by the
+// script, and is kept for the following calls.
+func instanceValue(inst *Instance, st reflect.Type) (reflect.Value, error) {
+	if !inst.goValue.IsValid() {
+		inst.goValue = reflect.New(st)
+	}
+	d := &decoder{}
+	if err := d.decode(inst, inst.goValue.Elem(), ""); err != nil {
+		return reflect.Value{}, err
+	}
+	return inst.goValue, nil
+}
+
+// copyGoValue returns a pointer to a copy of the Go struct pointed to by v.
+// It returns the invalid value if v is invalid.
+func copyGoValue(v reflect.Value) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c
+}
+
+// callReflect calls the Go function with the arguments converted to the
+// types of the parameters. The results are converted to an object or an
+// array of the objects if there are multiple results. A non-nil error
+// returned as the last result is converted to an error object. A panic of
+// the function is returned as an error.
+func callReflect(
+	fn reflect.Value,
+	args []Object,
+	kwargs map[string]Object,
+) (ret Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret, err = nil, fmt.Errorf("panic in Go function: %v", r)
+		}
+	}()
+	if len(kwargs) > 0 {
+		return nil, ErrUnexpectedKwargs
+	}
+	t := fn.Type()
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, ErrWrongNumArguments
+		}
+	} else if len(args) != numIn {
+		return nil, ErrWrongNumArguments
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			pt = t.In(numIn - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+		v, err := toReflect(arg, pt, nil)
+		if err != nil {
+			return nil, ErrInvalidArgumentType{
+				Name:     strconv.Itoa(i + 1),
+				Expected: pt.String(),
+				Found:    arg.TypeName(),
+			}
+		}
+		in[i] = v
+	}
+
+	out := fn.Call(in)
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return &Error{Value: &String{Value: err.Error()}}, nil
+		}
+		out = out[:n-1]
+	}
+	switch len(out) {
+	case 0:
+		return UndefinedValue, nil
+	case 1:
+		return fromReflect(out[0], nil)
+	}
+	arr := make([]Object, len(out))
+	for i, v := range out {
+		o, err := fromReflect(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = o
+	}
+	return &Array{Value: arr}, nil
+}
+
+// fromReflect converts a Go value to an object: the structs and the pointers
+// to the structs to the instances of their types, the slices and the arrays
+// to arrays, the maps with string, bool or number keys to maps and the
+// functions to user functions. The pointers to the same struct are converted
+// to the same instance, which keeps the pointer to call the methods on.
+func fromReflect(
+	v reflect.Value,
+	seen map[uintptr]Object,
+) (Object, error) {
+	if !v.IsValid() {
+		return UndefinedValue, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return UndefinedValue, nil
+		}
+	}
+	if v.CanInterface() {
+		x := v.Interface()
+		switch x.(type) {
+		case string, int64, int, bool, rune, byte, float64, []byte, error,
+			map[string]Object, map[string]interface{}, []Object,
+			[]interface{}, time.Time, Object, CallableFunc,
+			CallableFuncCtx:
+			return FromInterface(x)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		return fromReflect(v.Elem(), seen)
+	case reflect.Ptr:
+		if v.Elem().Kind() != reflect.Struct {
+			return fromReflect(v.Elem(), seen)
+		}
+		if o, ok := seen[v.Pointer()]; ok {
+			return o, nil
+		}
+		if seen == nil {
+			seen = make(map[uintptr]Object)
+		}
+		inst := &Instance{
+			Type:    reflectType(v.Type()),
+			Values:  make(map[string]Object),
+			goValue: v,
+		}
+		seen[v.Pointer()] = inst
+		if err := fromStruct(v.Elem(), inst, seen); err != nil {
+			return nil, err
+		}
+		return inst, nil
+	case reflect.Struct:
+		inst := &Instance{
+			Type:    reflectType(v.Type()),
+			Values:  make(map[string]Object),
+			goValue: reflect.New(v.Type()),
+		}
+		inst.goValue.Elem().Set(v)
+		if err := fromStruct(v, inst, seen); err != nil {
+			return nil, err
+		}
+		return inst, nil
+	case reflect.Bool:
+		if v.Bool() {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return &Int{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return &Int{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		if v.Len() > MaxStringLen {
+			return nil, ErrStringLimit
+		}
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() > MaxBytesLen {
+				return nil, ErrBytesLimit
+			}
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return &Bytes{Value: b}, nil
+		}
+		arr := make([]Object, v.Len())
+		for i := range arr {
+			o, err := fromReflect(v.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = o
+		}
+		return &Array{Value: arr}, nil
+	case reflect.Map:
+		if !mapKeyKind(v.Type().Key().Kind()) {
+			break
+		}
+		m := make(map[string]Object, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			o, err := fromReflect(iter.Value(), seen)
+			if err != nil {
+				return nil, err
+			}
+			m[formatMapKey(iter.Key())] = o
+		}
+		return &Map{Value: m}, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return UndefinedValue, nil
+		}
+		return &UserFunctionCtx{
+			Value: func(ctx *CallContext) (Object, error) {
+				return callReflect(v, ctx.Args, ctx.Kwargs)
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("cannot convert to object: %s", v.Type())
+}
+
+func fromStruct(
+	v reflect.Value,
+	inst *Instance,
+	seen map[uintptr]Object,
+) error {
+	for _, f := range structFields(v.Type()) {
+		o, err := fromReflect(v.FieldByIndex(f.index), seen)
+		if err != nil {
+			return err
+		}
+		inst.Values[f.name] = o
+	}
+	return nil
+}
+
+// toReflect converts an object to a Go value of the type.
+func toReflect(
+	o Object,
+	t reflect.Type,
+	seen map[Object]reflect.Value,
+) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	d := &decoder{seen: seen}
+	if err := d.decode(o, v, ""); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// decoder sets the Go values from the objects. The instances decoded to the
+// pointers are decoded to the same pointer.
+type decoder struct {
+	seen map[Object]reflect.Value
+}
+
+// decode sets the value from the object. Undefined sets the zero value and
+// the instances and the maps set the fields of the structs by the names and
+// the entries of the maps by the keys. The path of the value is used in the
+// errors.
+func (d *decoder) decode(o Object, v reflect.Value, path string) error {
+	t := v.Type()
+	if o == nil || o == UndefinedValue {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if inst, ok := o.(*Instance); ok && inst.Type.goType != nil {
+			iv := reflect.New(inst.Type.goType).Elem()
+			if err := d.decode(o, iv, path); err != nil {
+				return err
+			}
+			v.Set(iv)
+			return nil
+		}
+		if x := ToInterface(o); x != nil {
+			v.Set(reflect.ValueOf(x))
+		} else {
+			v.Set(reflect.Zero(t))
+		}
+		return nil
+	}
+	if reflect.TypeOf(o).AssignableTo(t) {
+		v.Set(reflect.ValueOf(o))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if pv, ok := d.seen[o]; ok && pv.Type() == t {
+			v.Set(pv)
+			return nil
+		}
+		if inst, ok := o.(*Instance); ok && inst.goValue.IsValid() &&
+			inst.goValue.Type() == t {
+			// the pointer the instance was converted from
+			if d.seen == nil {
+				d.seen = make(map[Object]reflect.Value)
+			}
+			d.seen[o] = inst.goValue
+			if err := d.decode(o, inst.goValue.Elem(), path); err != nil {
+				return err
+			}
+			v.Set(inst.goValue)
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		if _, ok := o.(*Instance); ok {
+			if d.seen == nil {
+				d.seen = make(map[Object]reflect.Value)
+			}
+			d.seen[o] = v
+		}
+		return d.decode(o, v.Elem(), path)
+	case reflect.Interface:
+		if inst, ok := o.(*Instance); ok && inst.Type.goType != nil &&
+			inst.Type.goType.Implements(t) {
+			iv := reflect.New(inst.Type.goType).Elem()
+			if err := d.decode(o, iv, path); err != nil {
+				return err
+			}
+			v.Set(iv)
+			return nil
+		}
+	case reflect.Bool:
+		if o, ok := o.(*Bool); ok {
+			v.SetBool(o == TrueValue)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		if t == durationType {
+			if s, ok := o.(*String); ok {
+				dur, err := time.ParseDuration(s.Value)
+				if err != nil {
+					break
+				}
+				v.SetInt(int64(dur))
+				return nil
+			}
+		}
+		if n, ok := decodeInt(o); ok {
+			if v.OverflowInt(n) {
+				return decodeRangeError(path, t, n)
+			}
+			v.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if n, ok := decodeInt(o); ok {
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return decodeRangeError(path, t, n)
+			}
+			v.SetUint(uint64(n))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch o := o.(type) {
+		case *Int:
+			v.SetFloat(float64(o.Value))
+			return nil
+		case *Float:
+			v.SetFloat(o.Value)
+			return nil
+		}
+	case reflect.String:
+		if o, ok := o.(*String); ok {
+			v.SetString(o.Value)
+			return nil
+		}
+	case reflect.Slice, reflect.Array:
+		var elems []Object
+		switch o := o.(type) {
+		case *Array:
+			elems = o.Value
+		case *ImmutableArray:
+			elems = o.Value
+		case *Bytes, *String:
+			if t.Elem().Kind() != reflect.Uint8 {
+				break
+			}
+			b, _ := ToByteSlice(o)
+			if t.Kind() == reflect.Slice {
+				v.Set(reflect.MakeSlice(t, len(b), len(b)))
+			} else if len(b) != t.Len() {
+				break
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		if elems == nil {
+			break
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(elems), len(elems)))
+		} else if len(elems) != t.Len() {
+			return &DecodeError{
+				Path:     path,
+				Expected: fmt.Sprintf("array of %d", t.Len()),
+				Found:    fmt.Sprintf("array of %d", len(elems)),
+			}
+		}
+		for i, elem := range elems {
+			err := d.decode(elem, v.Index(i), path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		values, ok := objectValues(o)
+		if !ok || !mapKeyKind(t.Key().Kind()) {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, len(values)))
+		}
+		for key, value := range values {
+			kv := reflect.New(t.Key()).Elem()
+			if !parseMapKey(key, kv) {
+				return &DecodeError{
+					Path:     joinPath(path, key),
+					Expected: decodeTypeName(t.Key()) + " key",
+					Found:    strconv.Quote(key),
+				}
+			}
+			ev := reflect.New(t.Elem()).Elem()
+			if err := d.decode(value, ev, joinPath(path, key)); err != nil {
+				return err
+			}
+			v.SetMapIndex(kv, ev)
+		}
+		return nil
+	case reflect.Struct:
+		if t == timeType {
+			if tm, ok := ToTime(o); ok {
+				v.Set(reflect.ValueOf(tm))
+				return nil
+			}
+			break
+		}
+		values, ok := objectValues(o)
+		if !ok {
+			break
+		}
+		// the unexported fields of the struct the instance was converted from
+		if inst, ok := o.(*Instance); ok && inst.goValue.IsValid() &&
+			inst.goValue.Type().Elem() == t {
+			v.Set(inst.goValue.Elem())
+		}
+		for _, f := range structFields(t) {
+			value, ok := values[f.name]
+			if !ok {
+				continue
+			}
+			err := d.decode(value, v.FieldByIndex(f.index),
+				joinPath(path, f.name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return &DecodeError{
+		Path:     path,
+		Expected: decodeTypeName(t),
+		Found:    o.TypeName(),
+	}
+}
+
+// decodeInt returns the value of an int or a char.
+func decodeInt(o Object) (int64, bool) {
+	switch o := o.(type) {
+	case *Int:
+		return o.Value, true
+	case *Char:
+		return int64(o.Value), true
+	}
+	return 0, false
+}
+
+// decodeRangeError returns the error where the int is out of the range of the
+// Go type.
+func decodeRangeError(path string, t reflect.Type, n int64) error {
+	return &DecodeError{
+		Path:     path,
+		Expected: decodeTypeName(t),
+		Found:    fmt.Sprintf("%d (out of range)", n),
+	}
+}
+
+// decodeTypeName returns the name of the script type decoded to the Go type.
+func decodeTypeName(t reflect.Type) string {
+	switch t {
+	case timeType:
+		return "time"
+	case durationType:
+		return "duration"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return decodeTypeName(t.Elem())
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		if t.Bits() < 64 {
+			return t.Kind().String()
+		}
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return t.Kind().String()
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return "array"
+	case reflect.Map:
+		if !mapKeyKind(t.Key().Kind()) {
+			break
+		}
+		return "map"
+	case reflect.Struct:
+		return "map"
+	}
+	return t.String()
+}
+
+// mapKeyKind returns true if the maps with the keys of the kind can be
+// converted to and from the maps with string keys.
+func mapKeyKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// formatMapKey returns the string of the map key, formatted by strconv if
+// it is not a string.
+func formatMapKey(k reflect.Value) string {
+	switch k.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(k.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(k.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(k.Float(), 'g', -1, 64)
+	}
+	return k.String()
+}
+
+// parseMapKey sets the map key from the string, parsed by strconv if the key
+// is not a string. It returns false if the string cannot be parsed.
+func parseMapKey(s string, k reflect.Value) bool {
+	switch k.Kind() {
+	case reflect.String:
+		k.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return false
+		}
+		k.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, k.Type().Bits())
+		if err != nil {
+			return false
+		}
+		k.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, k.Type().Bits())
+		if err != nil {
+			return false
+		}
+		k.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, k.Type().Bits())
+		if err != nil {
+			return false
+		}
+		k.SetFloat(f)
+	default:
+		return false
+	}
+	return true
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// objectValues returns the values of a map or an instance by the keys.
+func objectValues(o Object) (map[string]Object, bool) {
+	switch o := o.(type) {
+	case *Map:
+		return o.Value, true
+	case *ImmutableMap:
+		return o.Value, true
+	case *Instance:
+		return o.Values, true
+	}
+	return nil, false
+}
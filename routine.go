@@ -0,0 +1,731 @@
+package tengo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosedChannel is an error where a value is sent to a closed channel or
+// a closed channel is closed again.
+var ErrClosedChannel = errors.New("channel is closed")
+
+// Routine represents a function started by the go builtin function. It runs
+// concurrently in a VM of its own on copies of the values it uses. It is
+// aborted when the context of the run is done or the run starting it finishes.
+type Routine struct {
+	ObjectImpl
+	cancel context.CancelFunc
+	done   chan struct{}
+	result Object
+	err    error
+}
+
+// TypeName returns the name of the type.
+func (o *Routine) TypeName() string {
+	return "routine"
+}
+
+func (o *Routine) String() string {
+	return "<routine>"
+}
+
+// Copy returns the routine itself.
+func (o *Routine) Copy() Object {
+	return o
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Routine) Equals(x Object) bool {
+	return o == x
+}
+
+// IndexGet returns the methods of the routine: wait() returns the result of
+// the function. abort() aborts the routine. "done" is true if the function
+// returned.
+func (o *Routine) IndexGet(_ *VM, index Object) (Object, error) {
+	key, ok := index.(*String)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	switch key.Value {
+	case "wait":
+		return &UserFunctionCtx{Name: "wait", Value: o.wait}, nil
+	case "abort":
+		return &UserFunctionCtx{Name: "abort", Value: o.abort}, nil
+	case "done":
+		select {
+		case <-o.done:
+			return TrueValue, nil
+		default:
+			return FalseValue, nil
+		}
+	}
+	return UndefinedValue, nil
+}
+
+// Wait waits for the function to return and returns its result or the
+// runtime error of the routine.
+func (o *Routine) Wait() (Object, error) {
+	<-o.done
+	return o.result, o.err
+}
+
+func (o *Routine) wait(ctx *CallContext) (Object, error) {
+	if len(ctx.Args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	expired, stop := ctx.VM.expired()
+	defer stop()
+	select {
+	case <-o.done:
+		return o.result, o.err
+	case <-ctx.VM.done():
+		return nil, ctx.VM.context.Err()
+	case <-expired:
+		return nil, ctx.VM.timeLimitError()
+	}
+}
+
+func (o *Routine) abort(ctx *CallContext) (Object, error) {
+	if len(ctx.Args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	o.cancel()
+	return UndefinedValue, nil
+}
+
+// Channel represents a channel of objects for the routines to communicate.
+type Channel struct {
+	ObjectImpl
+	ch chan Object
+}
+
+// NewChannel creates a channel with the buffer size.
+func NewChannel(size int) *Channel {
+	return &Channel{ch: make(chan Object, size)}
+}
+
+// TypeName returns the name of the type.
+func (o *Channel) TypeName() string {
+	return "channel"
+}
+
+func (o *Channel) String() string {
+	return "<channel>"
+}
+
+// Copy returns the channel itself.
+func (o *Channel) Copy() Object {
+	return o
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Channel) Equals(x Object) bool {
+	return o == x
+}
+
+// IndexGet returns the methods of the channel: send(value), recv() and
+// close(). send sends a deep copy of the value. recv returns undefined if the
+// channel is closed.
+func (o *Channel) IndexGet(_ *VM, index Object) (Object, error) {
+	key, ok := index.(*String)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	switch key.Value {
+	case "send":
+		return &UserFunctionCtx{Name: "send", Value: o.send}, nil
+	case "recv":
+		return &UserFunctionCtx{Name: "recv", Value: o.recv}, nil
+	case "close":
+		return &UserFunctionCtx{Name: "close", Value: o.close}, nil
+	}
+	return UndefinedValue, nil
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Channel) CanIterate() bool {
+	return true
+}
+
+// Iterate returns an iterator receiving the values until the channel is
+// closed.
+func (o *Channel) Iterate() Iterator {
+	return &ChannelIterator{c: o}
+}
+
+func (o *Channel) send(ctx *CallContext) (ret Object, err error) {
+	if len(ctx.Args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	c := newIsolator()
+	value := c.copy(ctx.Args[0])
+	if err := alloc(ctx, c.size); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ret, err = nil, ErrClosedChannel
+		}
+	}()
+	expired, stop := ctx.VM.expired()
+	defer stop()
+	select {
+	case o.ch <- value:
+		return UndefinedValue, nil
+	case <-ctx.VM.done():
+		return nil, ctx.VM.context.Err()
+	case <-expired:
+		return nil, ctx.VM.timeLimitError()
+	}
+}
+
+func (o *Channel) recv(ctx *CallContext) (Object, error) {
+	if len(ctx.Args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	expired, stop := ctx.VM.expired()
+	defer stop()
+	select {
+	case value, ok := <-o.ch:
+		if !ok {
+			return UndefinedValue, nil
+		}
+		return value, nil
+	case <-ctx.VM.done():
+		return nil, ctx.VM.context.Err()
+	case <-expired:
+		return nil, ctx.VM.timeLimitError()
+	}
+}
+
+func (o *Channel) close(ctx *CallContext) (ret Object, err error) {
+	if len(ctx.Args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ret, err = nil, ErrClosedChannel
+		}
+	}()
+	close(o.ch)
+	return UndefinedValue, nil
+}
+
+// ChannelIterator is an iterator receiving the values of a channel.
+type ChannelIterator struct {
+	ObjectImpl
+	c     *Channel
+	i     int
+	value Object
+	vm    *VM
+	err   error
+}
+
+// TypeName returns the name of the type.
+func (i *ChannelIterator) TypeName() string {
+	return "channel-iterator"
+}
+
+func (i *ChannelIterator) String() string {
+	return "<channel-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *ChannelIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *ChannelIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *ChannelIterator) Copy() Object {
+	return &ChannelIterator{c: i.c, i: i.i, value: i.value, vm: i.vm}
+}
+
+// Next receives the next value and returns true unless the channel is
+// closed.
+func (i *ChannelIterator) Next() bool {
+	done := i.vm.done()
+	expired, stop := i.vm.expired()
+	defer stop()
+	select {
+	case value, ok := <-i.c.ch:
+		if !ok {
+			return false
+		}
+		i.value = value
+		i.i++
+		return true
+	case <-done:
+		i.err = i.vm.context.Err()
+		return false
+	case <-expired:
+		i.err = i.vm.timeLimitError()
+		return false
+	}
+}
+
+// Key returns the index of the current value.
+func (i *ChannelIterator) Key() Object {
+	return &Int{Value: int64(i.i - 1)}
+}
+
+// Value returns the current value received from the channel.
+func (i *ChannelIterator) Value() Object {
+	return i.value
+}
+
+// Err returns the error if the run was cancelled while receiving.
+func (i *ChannelIterator) Err() error {
+	return i.err
+}
+
+// done returns the channel closed when the context of the run is done or
+// nil if the VM runs without a context.
+func (v *VM) done() <-chan struct{} {
+	if v == nil || v.context == nil || v.context.Context == nil {
+		return nil
+	}
+	return v.context.Done()
+}
+
+// expired returns the channel receiving when the deadline of the VM passes.
+// It returns nil if the VM has no deadline. The returned function stops the timer.
+func (v *VM) expired() (<-chan time.Time, func()) {
+	if v == nil || v.deadline.IsZero() {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(v.deadline))
+	return t.C, func() { t.Stop() }
+}
+
+// timeLimitError returns the error of a wait stopped by the deadline.
+func (v *VM) timeLimitError() error {
+	return &LimitError{Err: ErrTimeLimit, Pos: v.Pos()}
+}
+
+// budget is the limits of a run shared by the VM running the script and the
+// VMs of its routines once it starts a routine. The VMs take the units from
+// the budget in leases, so that they don't count every instruction and
+// allocation atomically. The unused leases are returned when the VMs finish.
+// The limits are negative if there is no limit.
+type budget struct {
+	allocs int64
+	insts  int64
+	mem    int64
+}
+
+// leaseSize is the number of the instructions or the allocations the VMs take
+// from the shared budget at once. memLeaseSize is the bytes of memory.
+const (
+	leaseSize    = 64
+	memLeaseSize = 4096
+)
+
+// take takes at least min and at most max units from the budget. It returns
+// false if the budget has less than min units left.
+func take(budget *int64, min, max int64) (int64, bool) {
+	for {
+		n := atomic.LoadInt64(budget)
+		if n < min {
+			return 0, false
+		}
+		k := max
+		if n < k {
+			k = n
+		}
+		if atomic.CompareAndSwapInt64(budget, n, n-k) {
+			return k, true
+		}
+	}
+}
+
+// share moves the remaining limits of the run to the budget shared with the
+// routines the VM starts.
+func (v *VM) share() *budget {
+	if v.budget != nil {
+		return v.budget
+	}
+	b := &budget{allocs: -1, insts: -1, mem: -1}
+	if v.maxAllocs >= 0 {
+		b.allocs, v.allocs = v.allocs-1, 1
+	}
+	if v.maxInsts >= 0 {
+		b.insts, v.insts = v.insts, 0
+	}
+	if v.maxMem >= 0 {
+		b.mem, v.mem = v.mem, 0
+	}
+	v.budget = b
+	return b
+}
+
+// release returns the unused leases of the VM to the shared budget.
+func (v *VM) release() {
+	if v.maxAllocs >= 0 && v.allocs > 1 {
+		atomic.AddInt64(&v.budget.allocs, v.allocs-1)
+		v.allocs = 1
+	}
+	if v.maxInsts >= 0 && v.insts > 0 {
+		atomic.AddInt64(&v.budget.insts, v.insts)
+		v.insts = 0
+	}
+	if v.maxMem >= 0 && v.mem > 0 {
+		atomic.AddInt64(&v.budget.mem, v.mem)
+		v.mem = 0
+	}
+}
+
+// refillAllocs takes a lease of allocations from the shared budget. It
+// returns false and sets the runtime error if the run exceeded the limit.
+func (v *VM) refillAllocs() bool {
+	if v.budget != nil {
+		if n, ok := take(&v.budget.allocs, 1, leaseSize); ok {
+			v.allocs = n
+			return true
+		}
+	}
+	v.err = ErrObjectAllocLimit
+	return false
+}
+
+// refillInsts takes a lease of instructions from the shared budget. It
+// returns false if the run exceeded the limit.
+func (v *VM) refillInsts() bool {
+	if v.budget == nil {
+		return false
+	}
+	n, ok := take(&v.budget.insts, 1, leaseSize)
+	v.insts = n
+	return ok
+}
+
+// refillMem takes the memory the VM is short of plus a lease from the shared
+// budget. It returns false if the run exceeded the limit.
+func (v *VM) refillMem() bool {
+	if v.budget == nil {
+		return false
+	}
+	n, ok := take(&v.budget.mem, -v.mem, -v.mem+memLeaseSize)
+	v.mem += n
+	return ok
+}
+
+// isolator makes the deep copies of the objects passed to another routine so
+// that the routines do not share the mutable values. The objects referred to
+// more than once are copied once, which keeps the cycles. The channels, the
+// routines, the generators and the Go functions are shared.
+type isolator struct {
+	seen map[interface{}]Object
+	ptrs map[*ObjectPtr]*ObjectPtr
+	size int64 // approximate bytes allocated by the copies
+}
+
+func newIsolator() *isolator {
+	return &isolator{
+		seen: make(map[interface{}]Object),
+		ptrs: make(map[*ObjectPtr]*ObjectPtr),
+	}
+}
+
+func (c *isolator) copy(o Object) Object {
+	switch o := o.(type) {
+	case nil, *Int, *Float, *Bool, *Char, *String, *Bytes, *Time,
+		*Undefined:
+		// immutable
+		return o
+	case *Array:
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := &Array{Value: make([]Object, len(o.Value))}
+		c.seen[o] = r
+		for i, elem := range o.Value {
+			r.Value[i] = c.copy(elem)
+		}
+		c.size += sizeOf(r)
+		return r
+	case *ImmutableArray:
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := &ImmutableArray{Value: make([]Object, len(o.Value))}
+		c.seen[o] = r
+		for i, elem := range o.Value {
+			r.Value[i] = c.copy(elem)
+		}
+		c.size += sizeOf(r)
+		return r
+	case *Map:
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := &Map{Value: make(map[string]Object, len(o.Value))}
+		c.seen[o] = r
+		for k, v := range o.Value {
+			r.Value[k] = c.copy(v)
+		}
+		c.size += sizeOf(r)
+		return r
+	case *ImmutableMap:
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := &ImmutableMap{Value: make(map[string]Object, len(o.Value))}
+		c.seen[o] = r
+		for k, v := range o.Value {
+			r.Value[k] = c.copy(v)
+		}
+		c.size += sizeOf(r)
+		return r
+	case *Instance:
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := &Instance{
+			Type:     o.Type,
+			Callable: o.Callable,
+			Values:   make(map[string]Object, len(o.Values)),
+			goValue:  copyGoValue(o.goValue),
+		}
+		c.seen[o] = r
+		for k, v := range o.Values {
+			r.Values[k] = c.copy(v)
+		}
+		c.size += sizeOf(r)
+		return r
+	case *Error:
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := &Error{err: o.err, pos: o.pos}
+		c.seen[o] = r
+		r.Value = c.copy(o.Value)
+		c.size += sizeOf(r)
+		return r
+	case *CompiledFunction:
+		if len(o.Free) == 0 && o.methodTarget == nil {
+			return o
+		}
+		if r, ok := c.seen[o]; ok {
+			return r
+		}
+		r := new(CompiledFunction)
+		*r = *o
+		c.seen[o] = r
+		r.Free = make([]*ObjectPtr, len(o.Free))
+		for i, p := range o.Free {
+			r.Free[i] = c.ptr(p)
+		}
+		r.methodTarget = c.copy(o.methodTarget)
+		c.size += sizeOf(r)
+		return r
+	}
+	if r := o.Copy(); r != nil {
+		return r
+	}
+	return o
+}
+
+// ptr returns a copy of the free variable.
+func (c *isolator) ptr(p *ObjectPtr) *ObjectPtr {
+	if p == nil || p.Value == nil {
+		return p
+	}
+	if r, ok := c.ptrs[p]; ok {
+		return r
+	}
+	value := new(Object)
+	r := &ObjectPtr{Value: value}
+	c.ptrs[p] = r
+	*value = c.copy(*p.Value)
+	c.size += objectSize
+	return r
+}
+
+// spawn starts a routine calling the function in a VM of its own. The
+// global variables, the function and the arguments are copied for the
+// routine.
+func (v *VM) spawn(
+	fn Object,
+	args []Object,
+	kwargs map[string]Object,
+) (*Routine, error) {
+	c := newIsolator()
+	globals := make([]Object, len(v.globals))
+	for i, g := range v.globals {
+		globals[i] = c.copy(g)
+	}
+	fn = c.copy(fn)
+	for i, arg := range args {
+		args[i] = c.copy(arg)
+	}
+	if kwargs != nil {
+		copied := make(map[string]Object, len(kwargs))
+		for k, arg := range kwargs {
+			copied[k] = c.copy(arg)
+		}
+		kwargs = copied
+	}
+	if err := v.Alloc(c.size); err != nil {
+		return nil, err
+	}
+
+	// the VMs of the routines start with empty leases of the shared budget
+	b := v.share()
+	child := NewVM(v.bc, globals, limitOf(v.maxAllocs))
+	child.fs = v.fs
+	child.SetMaxInstructions(limitOf(v.maxInsts))
+	child.SetMaxMemory(limitOf(v.maxMem))
+	child.SetDeadline(v.deadline)
+	child.budget = b
+
+	parent := context.Background()
+	if v.context != nil && v.context.Context != nil {
+		parent = v.context.Context
+	}
+	ctx, cancel := context.WithCancel(parent)
+	r := &Routine{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		defer cancel()
+
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				child.Abort()
+			case <-stop:
+			}
+		}()
+		r.result, r.err = child.callContext(ctx, fn, args, kwargs)
+		close(stop)
+		if r.err == nil && ctx.Err() != nil {
+			r.result, r.err = nil, ctx.Err()
+		}
+	}()
+	v.routines = append(v.routines, r)
+	return r, nil
+}
+
+// limitOf returns the limit of a routine for the limit of the VM starting
+// it. It is 0 to take the leases from the shared budget. It is -1 if there is
+// no limit.
+func limitOf(n int64) int64 {
+	if n < 0 {
+		return -1
+	}
+	return 0
+}
+
+// stopRoutines aborts the routines started by the run and waits for them to
+// exit.
+func (v *VM) stopRoutines() {
+	for _, r := range v.routines {
+		r.cancel()
+	}
+	for _, r := range v.routines {
+		<-r.done
+	}
+	v.routines = nil
+}
+
+// selectChannels waits until one of the cases can proceed. A case is a
+// channel to receive from, or an array of a channel and a value to send. It
+// returns the index of the case and the received value. The index is -1 if
+// none of the cases can proceed and block is false.
+func selectChannels(
+	vm *VM,
+	cases []Object,
+	block bool,
+) (idx int, value Object, err error) {
+	selectCases := make([]reflect.SelectCase, 0, len(cases)+2)
+	copier := newIsolator()
+	for i, c := range cases {
+		var elems []Object
+		switch c := c.(type) {
+		case *Channel:
+			selectCases = append(selectCases, reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(c.ch),
+			})
+			continue
+		case *Array:
+			elems = c.Value
+		case *ImmutableArray:
+			elems = c.Value
+		}
+		var ch *Channel
+		if len(elems) == 2 {
+			ch, _ = elems[0].(*Channel)
+		}
+		if ch == nil {
+			return 0, nil, ErrInvalidArgumentType{
+				Name:     "case " + strconv.Itoa(i+1),
+				Expected: "channel or [channel, value]",
+				Found:    c.TypeName(),
+			}
+		}
+		send := copier.copy(elems[1])
+		selectCases = append(selectCases, reflect.SelectCase{
+			Dir:  reflect.SelectSend,
+			Chan: reflect.ValueOf(ch.ch),
+			Send: reflect.ValueOf(&send).Elem(),
+		})
+	}
+	if vm != nil {
+		if err := vm.Alloc(copier.size); err != nil {
+			return 0, nil, err
+		}
+	}
+	if done := vm.done(); done != nil {
+		selectCases = append(selectCases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(done),
+		})
+	}
+	expired, stop := vm.expired()
+	defer stop()
+	expiredCase := -1
+	if expired != nil {
+		expiredCase = len(selectCases)
+		selectCases = append(selectCases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(expired),
+		})
+	}
+	if !block {
+		selectCases = append(selectCases, reflect.SelectCase{
+			Dir: reflect.SelectDefault,
+		})
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			idx, value, err = 0, nil, ErrClosedChannel
+		}
+	}()
+	chosen, recv, ok := reflect.Select(selectCases)
+	switch {
+	case chosen >= len(cases) && selectCases[chosen].Dir == reflect.SelectDefault:
+		return -1, UndefinedValue, nil
+	case chosen == expiredCase:
+		return 0, nil, vm.timeLimitError()
+	case chosen >= len(cases):
+		return 0, nil, vm.context.Err()
+	case !ok || selectCases[chosen].Dir == reflect.SelectSend:
+		return chosen, UndefinedValue, nil
+	}
+	return chosen, recv.Interface().(Object), nil
+}
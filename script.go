@@ -3,10 +3,13 @@ package tengo
 import (
 	"context"
 	"fmt"
+	"io"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/d5/tengo/v2/parser"
+	"github.com/d5/tengo/v2/vfs"
 )
 
 // Script can simplify compilation and execution of embedded scripts.
@@ -15,9 +18,13 @@ type Script struct {
 	modules          ModuleGetter
 	input            []byte
 	maxAllocs        int64
+	maxInsts         int64
+	maxMem           int64
+	timeLimit        time.Duration
 	maxConstObjects  int
 	enableFileImport bool
 	importDir        string
+	fs               vfs.FS
 }
 
 // NewScript creates a Script instance with an input script.
@@ -26,6 +33,8 @@ func NewScript(input []byte) *Script {
 		variables:       make(map[string]*Variable),
 		input:           input,
 		maxAllocs:       -1,
+		maxInsts:        -1,
+		maxMem:          -1,
 		maxConstObjects: -1,
 	}
 }
@@ -58,16 +67,23 @@ func (s *Script) SetImports(modules ModuleGetter) {
 	s.modules = modules
 }
 
-// SetImportDir sets the initial import directory for script files.
+// SetImportDir sets the initial import directory for script files. If a file
+// system is set using SetFS, the directory is a path of that file system.
 func (s *Script) SetImportDir(dir string) error {
-	dir, err := filepath.Abs(dir)
-	if err != nil {
+	if _, err := filepath.Abs(dir); err != nil {
 		return err
 	}
 	s.importDir = dir
 	return nil
 }
 
+// SetFS sets the file system used to import the script files and by the
+// os module of the standard library. The host file system is used if fsys is
+// nil.
+func (s *Script) SetFS(fsys vfs.FS) {
+	s.fs = fsys
+}
+
 // SetMaxAllocs sets the maximum number of objects allocations during the run
 // time. Compiled script will return ErrObjectAllocLimit error if it
 // exceeds this limit.
@@ -75,6 +91,29 @@ func (s *Script) SetMaxAllocs(n int64) {
 	s.maxAllocs = n
 }
 
+// SetMaxMemory sets the approximate maximum number of bytes allocated for the
+// strings, bytes, arrays, maps and instances during the run time. Compiled
+// script will return ErrMemoryLimit error if it exceeds this limit. There is
+// no limit if n is negative, which is the default.
+func (s *Script) SetMaxMemory(n int64) {
+	s.maxMem = n
+}
+
+// SetMaxInstructions sets the maximum number of instructions executed during
+// the run time. Compiled script will return ErrInstructionLimit error if it
+// exceeds this limit. There is no limit if n is negative, which is the
+// default.
+func (s *Script) SetMaxInstructions(n int64) {
+	s.maxInsts = n
+}
+
+// SetTimeLimit sets the maximum duration of each run of the compiled script.
+// Compiled script will return ErrTimeLimit error if it exceeds this limit.
+// There is no limit if d is zero, which is the default.
+func (s *Script) SetTimeLimit(d time.Duration) {
+	s.timeLimit = d
+}
+
 // SetMaxConstObjects sets the maximum number of objects in the compiled
 // constants.
 func (s *Script) SetMaxConstObjects(n int) {
@@ -103,9 +142,17 @@ func (s *Script) Compile() (*Compiled, error) {
 		return nil, err
 	}
 
+	importDir := s.importDir
+	if s.fs == nil && importDir != "" {
+		if importDir, err = filepath.Abs(importDir); err != nil {
+			return nil, err
+		}
+	}
+
 	c := NewCompiler(srcFile, symbolTable, nil, s.modules, nil)
 	c.EnableFileImport(s.enableFileImport)
-	c.SetImportDir(s.importDir)
+	c.SetImportDir(importDir)
+	c.SetFS(s.fs)
 	if err := c.Compile(file); err != nil {
 		return nil, err
 	}
@@ -137,7 +184,12 @@ func (s *Script) Compile() (*Compiled, error) {
 		globalIndexes: globalIndexes,
 		bytecode:      bytecode,
 		globals:       globals,
+		inputs:        append([]Object{}, globals...),
 		maxAllocs:     s.maxAllocs,
+		maxInsts:      s.maxInsts,
+		maxMem:        s.maxMem,
+		timeLimit:     s.timeLimit,
+		fs:            s.fs,
 	}, nil
 }
 
@@ -198,7 +250,15 @@ type Compiled struct {
 	globalIndexes map[string]int // global symbol name to index
 	bytecode      *Bytecode
 	globals       []Object
+	inputs        []Object // values set by the host
+	sharedInputs  bool     // inputs are held by a clone or a suspension
 	maxAllocs     int64
+	maxInsts      int64
+	maxMem        int64
+	timeLimit     time.Duration
+	profiler      *Profiler
+	coverage      *Coverage
+	fs            vfs.FS
 	lock          sync.RWMutex
 }
 
@@ -207,8 +267,8 @@ func (c *Compiled) Run() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
-	return v.Run()
+	v := c.newVM(c.globals)
+	return c.suspended(v.Run())
 }
 
 // RunContext is like Run but includes a context.
@@ -216,7 +276,107 @@ func (c *Compiled) RunContext(ctx context.Context) (err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
+	v := c.newVM(c.globals)
+	return c.runContext(ctx, v, func() error {
+		return v.RunContext(ctx)
+	})
+}
+
+// Restore restores the state of a suspended run encoded by
+// Suspension.Encode. The state must be encoded from a run of the same script
+// with the same values set to the global variables, otherwise it returns
+// ErrStateMismatch. The global variables are replaced by the values in the
+// state and the run continues when the returned Suspension is resumed.
+func (c *Compiled) Restore(r io.Reader) (*Suspension, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v := c.newVM(c.globals)
+	if err := v.restore(r, c.inputs); err != nil {
+		return nil, err
+	}
+	v.suspension.c = c
+	v.suspension.inputs = c.inputs
+	c.sharedInputs = true
+	return v.suspension, nil
+}
+
+// Call calls the function of the global variable with the arguments
+// converted by FromInterface and returns the result. The function runs with
+// the global variables of the compiled script, usually after the script was
+// run to define the function and with the limits of a run. The function
+// cannot suspend the run.
+func (c *Compiled) Call(
+	ctx context.Context,
+	name string,
+	args []interface{},
+	kwargs map[string]interface{},
+) (*Variable, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	idx, ok := c.globalIndexes[name]
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not defined", name)
+	}
+	return c.call(ctx, name, c.globals[idx], args, kwargs)
+}
+
+func (c *Compiled) call(
+	ctx context.Context,
+	name string,
+	fn Object,
+	args []interface{},
+	kwargs map[string]interface{},
+) (*Variable, error) {
+	if fn == nil || !fn.CanCall() {
+		return nil, fmt.Errorf("'%s' is not callable", name)
+	}
+	argObjs := make([]Object, len(args))
+	for i, arg := range args {
+		obj, err := FromInterface(arg)
+		if err != nil {
+			return nil, err
+		}
+		argObjs[i] = obj
+	}
+	var kwargObjs map[string]Object
+	if len(kwargs) > 0 {
+		kwargObjs = make(map[string]Object, len(kwargs))
+		for k, kwarg := range kwargs {
+			obj, err := FromInterface(kwarg)
+			if err != nil {
+				return nil, err
+			}
+			kwargObjs[k] = obj
+		}
+	}
+
+	var ret Object
+	v := c.newVM(c.globals)
+	err := c.runContext(ctx, v, func() (err error) {
+		ret, err = v.callContext(ctx, fn, argObjs, kwargObjs)
+		if err != nil {
+			err = fmt.Errorf("Runtime Error: %w", err)
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Variable{
+		name:  name,
+		value: ret,
+	}, nil
+}
+
+// runContext runs the VM in a goroutine and aborts the VM when the context
+// is done.
+func (c *Compiled) runContext(
+	ctx context.Context,
+	v *VM,
+	run func() error,
+) (err error) {
 	ch := make(chan error, 1)
 	go func() {
 		defer func() {
@@ -231,7 +391,7 @@ func (c *Compiled) RunContext(ctx context.Context) (err error) {
 				}
 			}
 		}()
-		ch <- v.RunContext(ctx)
+		ch <- run()
 	}()
 
 	select {
@@ -240,10 +400,42 @@ func (c *Compiled) RunContext(ctx context.Context) (err error) {
 		<-ch
 		err = ctx.Err()
 	case err = <-ch:
+		err = c.suspended(err)
 	}
 	return
 }
 
+// suspended attaches the suspension returned from the run to the compiled
+// script.
+func (c *Compiled) suspended(err error) error {
+	if s, ok := err.(*Suspension); ok {
+		s.c = c
+		s.inputs = c.inputs
+		c.sharedInputs = true
+	}
+	return err
+}
+
+// resetLimits sets the time limit of the VM for a resumed run.
+func (c *Compiled) resetLimits(v *VM) {
+	if c.timeLimit > 0 {
+		v.SetDeadline(time.Now().Add(c.timeLimit))
+	}
+}
+
+func (c *Compiled) newVM(globals []Object) *VM {
+	v := NewVM(c.bytecode, globals, c.maxAllocs)
+	v.SetMaxInstructions(c.maxInsts)
+	v.SetMaxMemory(c.maxMem)
+	if c.timeLimit > 0 {
+		v.SetDeadline(time.Now().Add(c.timeLimit))
+	}
+	v.SetProfiler(c.profiler)
+	v.SetCoverage(c.coverage)
+	v.SetFS(c.fs)
+	return v
+}
+
 // Clone creates a new copy of Compiled. Cloned copies are safe for concurrent
 // use by multiple goroutines.
 func (c *Compiled) Clone() *Compiled {
@@ -254,8 +446,17 @@ func (c *Compiled) Clone() *Compiled {
 		globalIndexes: c.globalIndexes,
 		bytecode:      c.bytecode,
 		globals:       make([]Object, len(c.globals)),
+		inputs:        c.inputs,
+		sharedInputs:  true,
 		maxAllocs:     c.maxAllocs,
+		maxInsts:      c.maxInsts,
+		maxMem:        c.maxMem,
+		timeLimit:     c.timeLimit,
+		profiler:      c.profiler,
+		coverage:      c.coverage,
+		fs:            c.fs,
 	}
+	c.sharedInputs = true
 	// copy global objects
 	for idx, g := range c.globals {
 		if g != nil {
@@ -265,6 +466,22 @@ func (c *Compiled) Clone() *Compiled {
 	return clone
 }
 
+// SetProfiler sets the profiler to record the executions of the compiled
+// script. The profiler is disabled if p is nil.
+func (c *Compiled) SetProfiler(p *Profiler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.profiler = p
+}
+
+// SetCoverage sets the coverage to record the source lines and the branches
+// executed by the compiled script. The coverage is disabled if cov is nil.
+func (c *Compiled) SetCoverage(cov *Coverage) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.coverage = cov
+}
+
 // IsDefined returns true if the variable name is defined (has value) before or
 // after the execution.
 func (c *Compiled) IsDefined(name string) bool {
@@ -297,6 +514,7 @@ func (c *Compiled) Get(name string) *Variable {
 	return &Variable{
 		name:  name,
 		value: value,
+		c:     c,
 	}
 }
 
@@ -314,6 +532,7 @@ func (c *Compiled) GetAll() []*Variable {
 		vars = append(vars, &Variable{
 			name:  name,
 			value: value,
+			c:     c,
 		})
 	}
 	return vars
@@ -334,5 +553,30 @@ func (c *Compiled) Set(name string, value interface{}) error {
 		return fmt.Errorf("'%s' is not defined", name)
 	}
 	c.globals[idx] = obj
+	if c.sharedInputs {
+		c.inputs = append([]Object{}, c.inputs...)
+		c.sharedInputs = false
+	}
+	c.inputs[idx] = obj
 	return nil
 }
+
+// Callable is a function of a compiled script that can be called from Go.
+type Callable struct {
+	c    *Compiled
+	name string
+	fn   Object
+}
+
+// Call calls the function with the arguments converted by FromInterface and
+// returns the result. See Compiled.Call.
+func (f *Callable) Call(
+	ctx context.Context,
+	args []interface{},
+	kwargs map[string]interface{},
+) (*Variable, error) {
+	f.c.lock.Lock()
+	defer f.c.lock.Unlock()
+
+	return f.c.call(ctx, f.name, f.fn, args, kwargs)
+}
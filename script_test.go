@@ -1,19 +1,24 @@
 package tengo_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"math/rand"
+	"os"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
 	"github.com/d5/tengo/v2/require"
 	"github.com/d5/tengo/v2/stdlib"
 	"github.com/d5/tengo/v2/token"
+	"github.com/d5/tengo/v2/vfs"
 )
 
 func TestScript_Add(t *testing.T) {
@@ -99,6 +104,47 @@ a := enum.all([1,2,3], func(_, v) {
 	require.Error(t, err)
 }
 
+func TestScript_SetFS(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	require.NoError(t, vfs.MkdirAll(fsys, "lib/util", 0755))
+	require.NoError(t, vfs.WriteFile(fsys, "lib/mod.tengo",
+		[]byte(`export import("./util/double")(10)`), 0644))
+	require.NoError(t, vfs.WriteFile(fsys, "lib/util/double.tengo",
+		[]byte(`export func(x) { return x * 2 }`), 0644))
+
+	s := tengo.NewScript([]byte(`
+os := import("os")
+a := import("mod")
+f := os.create("/out.txt")
+f.write_string("hello")
+f.close()
+b := string(os.read_file("/lib/../out.txt"))
+c := os.stat("/lib").directory
+d := is_error(os.readlink("/out.txt"))
+`))
+	s.SetImports(stdlib.GetModuleMap("os"))
+	s.EnableFileImport(true)
+	require.NoError(t, s.SetImportDir("lib"))
+	s.SetFS(fsys)
+	c, err := s.Run()
+	require.NoError(t, err)
+	compiledGet(t, c, "a", int64(20))
+	compiledGet(t, c, "b", "hello")
+	compiledGet(t, c, "c", true)
+	compiledGet(t, c, "d", true)
+
+	data, err := vfs.ReadFile(fsys, "out.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	_, err = os.Stat("out.txt")
+	require.True(t, os.IsNotExist(err))
+
+	// the module files are not found in the host file system
+	s.SetFS(vfs.NewMemFS())
+	_, err = s.Compile()
+	require.Error(t, err)
+}
+
 func TestScript_SetMaxConstObjects(t *testing.T) {
 	// one constant '5'
 	s := tengo.NewScript([]byte(`a := 5`))
@@ -136,6 +182,202 @@ func TestScript_SetMaxConstObjects(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestScript_SetMaxMemory(t *testing.T) {
+	run := func(src string, limit int64) (*tengo.Compiled, error) {
+		s := tengo.NewScript([]byte(src))
+		err := s.Add("out", nil)
+		require.NoError(t, err)
+		s.SetMaxMemory(limit)
+		return s.Run()
+	}
+
+	c, err := run(`out = len(bytes(1000))`, 2000)
+	require.NoError(t, err)
+	compiledGet(t, c, "out", int64(1000))
+	_, err = run(`out = bytes(1000000000)`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`out = range(0, 1000000000)`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`out = len(range(0, 1 << 60))`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`out = range(0, 9223372036854775807, 3)`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`out = range(9223372036854775807, -9223372036854775807)`,
+		1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+
+	// string concatenation
+	_, err = run(`out = "x"; for { out += out }`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	c, err = run(`out = "x"; for i := 0; i < 10; i++ { out += out }`, 1<<20)
+	require.NoError(t, err)
+	compiledGet(t, c, "out", strings.Repeat("x", 1024))
+
+	// array and map growth
+	c, err = run(`out = []; for i := 0; i < 1000; i++ { out = append(out, i) }`,
+		1<<20)
+	require.NoError(t, err)
+	_, err = run(`out = []; for { out = append(out, 1, 2, 3) }`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`out = {}; for i := 0; true; i++ { out[string(i)] = i }`,
+		1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`out = [1]; for { splice(out, 0, 0, 1, 2, 3) }`, 10000)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`
+T := type("T")
+out = T()
+for i := 0; true; i++ { out[string(i)] = i }`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+
+	// spread elements
+	_, err = run(`a := range(0, 10000); for { out = [...a] }`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	_, err = run(`
+a := {}
+for i := 0; i < 1000; i++ { a[string(i)] = i }
+for { out = {...a} }`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+
+	// rest of destructuring
+	_, err = run(`a := range(0, 10000); for { _, ...out := a }`, 1<<20)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+
+	// the error cannot be caught
+	_, err = run(`try { out = bytes(1000000) } catch e { out = e }`, 1000)
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+
+	// no limit set
+	c, err = run(`out = len(bytes(1000000))`, -1)
+	require.NoError(t, err)
+	compiledGet(t, c, "out", int64(1000000))
+}
+
+func TestScript_SetMaxInstructions(t *testing.T) {
+	s := tengo.NewScript([]byte(`a := 0; for i := 0; i < 10; i++ { a += i }`))
+	s.SetMaxInstructions(1000)
+	c, err := s.Run()
+	require.NoError(t, err)
+	compiledGet(t, c, "a", int64(45))
+
+	s = tengo.NewScript([]byte(`
+a := 0
+for {
+	a++
+}`))
+	s.SetMaxInstructions(1000)
+	c, err = s.Run()
+	require.True(t, errors.Is(err, tengo.ErrInstructionLimit))
+	require.False(t, errors.Is(err, tengo.ErrTimeLimit))
+	var limitErr *tengo.LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, 4, limitErr.Pos.Line)
+	require.True(t, c.Get("a").Int() > 0)
+
+	// the limit applies to each run
+	a := c.Get("a").Int()
+	require.True(t, errors.Is(c.Run(), tengo.ErrInstructionLimit))
+	require.Equal(t, a, c.Get("a").Int())
+
+	// the limit includes the instructions of the called functions and it
+	// cannot be caught
+	s = tengo.NewScript([]byte(`
+f := func() { for {} }
+try {
+	call(f)
+} catch e {
+	out = e
+}`))
+	err = s.Add("call", &tengo.UserFunctionCtx{
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			return ctx.Args[0].Call(&tengo.CallContext{VM: ctx.VM})
+		},
+	})
+	require.NoError(t, err)
+	err = s.Add("out", nil)
+	require.NoError(t, err)
+	s.SetMaxInstructions(1000)
+	c, err = s.Run()
+	require.True(t, errors.Is(err, tengo.ErrInstructionLimit))
+	require.True(t, c.Get("out").IsUndefined())
+}
+
+func TestScript_RoutineLimits(t *testing.T) {
+	// the routines share the limits of the run
+	run := func(src string, configure func(s *tengo.Script)) error {
+		s := tengo.NewScript([]byte(src + `
+rs := []
+for i := 0; i < 20; i++ { rs = append(rs, go(f)) }
+for r in rs { r.wait() }`))
+		configure(s)
+		_, err := s.Run()
+		return err
+	}
+
+	f := `f := func() { return len(bytes(1000)) }`
+	err := run(f, func(s *tengo.Script) { s.SetMaxMemory(10000) })
+	require.True(t, errors.Is(err, tengo.ErrMemoryLimit))
+	err = run(f, func(s *tengo.Script) { s.SetMaxMemory(1 << 20) })
+	require.NoError(t, err)
+
+	f = `f := func() { for i := 0; i < 100; i++ {} }`
+	err = run(f, func(s *tengo.Script) { s.SetMaxInstructions(10000) })
+	require.True(t, errors.Is(err, tengo.ErrInstructionLimit))
+	err = run(f, func(s *tengo.Script) { s.SetMaxInstructions(100000) })
+	require.NoError(t, err)
+
+	f = `f := func() { a := []; for i := 0; i < 10; i++ { a = append(a, i) } }`
+	err = run(f, func(s *tengo.Script) { s.SetMaxAllocs(100) })
+	require.True(t, errors.Is(err, tengo.ErrObjectAllocLimit))
+	err = run(f, func(s *tengo.Script) { s.SetMaxAllocs(1000) })
+	require.NoError(t, err)
+
+	// the routines running forever exhaust the shared limit
+	err = run(`f := func() { for {} }`, func(s *tengo.Script) {
+		s.SetMaxInstructions(100000)
+	})
+	require.True(t, errors.Is(err, tengo.ErrInstructionLimit))
+}
+
+func TestScript_SetTimeLimit(t *testing.T) {
+	s := tengo.NewScript([]byte(`a := 5`))
+	s.SetTimeLimit(time.Second)
+	c, err := s.Run()
+	require.NoError(t, err)
+	compiledGet(t, c, "a", int64(5))
+
+	s = tengo.NewScript([]byte(`
+f := func() {
+	for {}
+}
+f()`))
+	s.SetTimeLimit(10 * time.Millisecond)
+	_, err = s.Run()
+	require.True(t, errors.Is(err, tengo.ErrTimeLimit))
+	var limitErr *tengo.LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, 3, limitErr.Pos.Line)
+	require.True(t, strings.HasPrefix(err.Error(),
+		"Runtime Error: time limit exceeded\n\tat (main):3:"))
+
+	// the waits of the routines and the channels are stopped
+	for _, src := range []string{
+		`ch := chan(); ch.recv()`,
+		`ch := chan(); ch.send(1)`,
+		`ch := chan(); for x in ch {}`,
+		`ch := chan(); r := go(func() { ch.recv() }); r.wait()`,
+		`ch := chan(); select(ch)`,
+		`ch := chan(); try { ch.recv() } catch e {}; for {}`,
+	} {
+		s = tengo.NewScript([]byte(src))
+		s.SetTimeLimit(50 * time.Millisecond)
+		start := time.Now()
+		_, err = s.Run()
+		require.True(t, errors.Is(err, tengo.ErrTimeLimit), "%s: %v", src, err)
+		require.True(t, time.Since(start) < time.Second, src)
+	}
+}
+
 func TestScriptConcurrency(t *testing.T) {
 	solve := func(a, b, c int) (d, e int) {
 		a += 2
@@ -477,6 +719,322 @@ func TestCompiled_RunContext(t *testing.T) {
 	defer cancel()
 	err = c.RunContext(ctx)
 	require.Equal(t, context.DeadlineExceeded, err)
+
+	// blocked routines and channels
+	for _, src := range []string{
+		`chan().recv()`,
+		`for x in chan() {}`,
+		`go(func() { for {} }).wait()`,
+		`go(func() { chan().recv() }).wait()`,
+	} {
+		c = compile(t, src, nil)
+		ctx, cancel := context.WithTimeout(context.Background(),
+			10*time.Millisecond)
+		err = c.RunContext(ctx)
+		cancel()
+		require.Equal(t, context.DeadlineExceeded, err, src)
+	}
+}
+
+func TestCompiled_Call(t *testing.T) {
+	c := compile(t, `
+total := 0
+on_order := func(order; strict=false) {
+	if strict && order.qty <= 0 { return error("invalid quantity") }
+	total += order.qty * order.price
+	return total
+}
+fail := func() { return 1 - "a" }
+loop := func() { for {} }
+not_func := 1
+`, nil)
+	ctx := context.Background()
+	_, err := c.Call(ctx, "on_order", []interface{}{M{"qty": 1, "price": 2}}, nil)
+	require.Error(t, err) // not defined before the run
+	compiledRun(t, c)
+
+	res, err := c.Call(ctx, "on_order",
+		[]interface{}{M{"qty": 2, "price": 5}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 10, res.Int())
+	res, err = c.Call(ctx, "on_order",
+		[]interface{}{M{"qty": 0, "price": 5}}, M{"strict": true})
+	require.NoError(t, err)
+	require.Equal(t, `error: "invalid quantity"`, res.Error().Error())
+	compiledGet(t, c, "total", int64(10))
+
+	// callable handles
+	fn := c.Get("on_order").Callable()
+	require.NotNil(t, fn)
+	for i := 1; i <= 3; i++ {
+		res, err = fn.Call(ctx, []interface{}{M{"qty": 1, "price": 1}}, nil)
+		require.NoError(t, err)
+		require.Equal(t, 10+i, res.Int())
+	}
+	require.Nil(t, c.Get("not_func").Callable())
+
+	// errors
+	_, err = c.Call(ctx, "fail", nil, nil)
+	require.Equal(t,
+		"Runtime Error: invalid operation: int - string\n\tat (main):8:25",
+		err.Error())
+	_, err = c.Call(ctx, "on_order", []interface{}{M{}}, M{"bogus": true})
+	require.Equal(t, `Runtime Error: unexpected kwarg "bogus"`, err.Error())
+	_, err = c.Call(ctx, "not_func", nil, nil)
+	require.Error(t, err)
+	_, err = c.Call(ctx, "undefined_name", nil, nil)
+	require.Error(t, err)
+	_, err = c.Call(ctx, "on_order", nil, nil)
+	require.Error(t, err)
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err = c.Call(tctx, "loop", nil, nil)
+	cancel()
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestCompiled_Suspend(t *testing.T) {
+	src := `
+text := import("text")
+upper := text.to_upper
+counter := func() {
+	n := 0
+	return func() { n++; return n }
+}()
+out := []
+step := func(x) {
+	try {
+		for i, v in ["a", "b"] {
+			out = append(out, upper(v) + wait(i + x))
+		}
+		wait("fail") - 1
+	} catch e {
+		out = append(out, e.value)
+	}
+	return counter()
+}
+total := step(1) + step(10)`
+	wait := &tengo.UserFunctionCtx{
+		Name: "wait",
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			return ctx.Suspend(ctx.Args[0])
+		},
+	}
+	compileSrc := func() *tengo.Compiled {
+		s := tengo.NewScript([]byte(src))
+		s.SetImports(stdlib.GetModuleMap("text"))
+		require.NoError(t, s.Add("wait", wait))
+		c, err := s.Compile()
+		require.NoError(t, err)
+		return c
+	}
+	run := func(restore bool) *tengo.Compiled {
+		c := compileSrc()
+		err := c.Run()
+		for n := 0; err != nil; n++ {
+			s, ok := err.(*tengo.Suspension)
+			require.True(t, ok, err)
+			if restore {
+				var buf bytes.Buffer
+				require.NoError(t, s.Encode(&buf))
+				c = compileSrc()
+				s, err = c.Restore(&buf)
+				require.NoError(t, err)
+			}
+			value := fmt.Sprint(tengo.ToInterface(s.Value))
+			err = s.Resume(context.Background(), value)
+			require.True(t, errors.Is(
+				s.Resume(context.Background(), nil), tengo.ErrNotSuspended))
+			require.True(t, n < 6)
+		}
+		return c
+	}
+
+	for _, restore := range []bool{false, true} {
+		c := run(restore)
+		require.Equal(t,
+			"[A1 B2 invalid operation: string - int "+
+				"A10 B11 invalid operation: string - int]",
+			fmt.Sprint(c.Get("out").Value()))
+		compiledGet(t, c, "total", int64(3))
+	}
+
+	// state of another script
+	c := compileSrc()
+	err := c.Run()
+	s, ok := err.(*tengo.Suspension)
+	require.True(t, ok, err)
+	var buf bytes.Buffer
+	require.NoError(t, s.Encode(&buf))
+	encoded := append([]byte{}, buf.Bytes()...)
+	c = compile(t, `wait(1)`, M{"wait": wait})
+	_, err = c.Restore(&buf)
+	require.True(t, errors.Is(err, tengo.ErrStateMismatch))
+
+	// corrupted states
+	var state suspendedState
+	require.NoError(t, gob.NewDecoder(bytes.NewReader(encoded)).
+		Decode(&state))
+	for _, corrupt := range []func(s *suspendedState){
+		func(s *suspendedState) { s.IP = -2 },
+		func(s *suspendedState) { s.IP = 1 << 20 },
+		func(s *suspendedState) { s.Frames[0].IP = -5 },
+		func(s *suspendedState) { s.Frames[0].BasePointer = -1 },
+		func(s *suspendedState) { s.Stack = s.Stack[:0] },
+		func(s *suspendedState) { s.Handlers[0].FramesIndex = 0 },
+		func(s *suspendedState) { s.Handlers[0].FramesIndex = 100 },
+		func(s *suspendedState) { s.Handlers[0].SP = -1 },
+		func(s *suspendedState) { s.Handlers[0].SP = tengo.StackSize },
+		func(s *suspendedState) { s.Handlers[0].CatchPos = -1 },
+		func(s *suspendedState) { s.Handlers[0].CatchPos = 1 << 20 },
+	} {
+		corrupted := state
+		corrupted.Frames = append([]suspendedFrame{}, state.Frames...)
+		corrupted.Handlers = append([]suspendedHandler{}, state.Handlers...)
+		corrupt(&corrupted)
+		buf.Reset()
+		require.NoError(t, gob.NewEncoder(&buf).Encode(corrupted))
+		_, err = compileSrc().Restore(&buf)
+		require.True(t, errors.Is(err, tengo.ErrStateMismatch), "%v", err)
+	}
+
+	// values set after the suspension do not change the encoded state
+	vars := M{"fns": M{
+		"wait": wait,
+		"f": func(args ...tengo.Object) (tengo.Object, error) {
+			return &tengo.String{Value: "ok"}, nil
+		},
+	}}
+	fnsSrc := `g := fns.f; fns.wait(1); out := g()`
+	c = compile(t, fnsSrc, vars)
+	err = c.Run()
+	s, ok = err.(*tengo.Suspension)
+	require.True(t, ok, err)
+	require.NoError(t, c.Set("fns", 1))
+	buf.Reset()
+	require.NoError(t, s.Encode(&buf))
+	c = compile(t, fnsSrc, vars)
+	s, err = c.Restore(&buf)
+	require.NoError(t, err)
+	require.NoError(t, s.Resume(context.Background(), nil))
+	compiledGet(t, c, "out", "ok")
+
+	// objects of other types cannot be encoded
+	c = compile(t, `t := type("T")(); wait(t)`, M{"wait": wait})
+	err = c.Run()
+	s, ok = err.(*tengo.Suspension)
+	require.True(t, ok, err)
+	require.Error(t, s.Encode(&buf))
+
+	// nested runs cannot be suspended
+	c = compile(t, `
+g := func() { yield wait(1) }()
+try { for x in g {} } catch e { out = e.value }`, M{"wait": wait, "out": nil})
+	compiledRun(t, c)
+	compiledGet(t, c, "out", tengo.ErrNotSuspendable.Error())
+}
+
+// suspendedState has the fields of the state encoded by Suspension.Encode.
+type suspendedState struct {
+	Checksum uint64
+	Known    int
+	Objects  []struct {
+		Kind  uint8
+		Int   int64
+		Float float64
+		Str   string
+		Bytes []byte
+		Refs  []int
+		Keys  []string
+		Pos   parser.SourceFilePos
+	}
+	Globals  []int
+	Stack    []int
+	Frames   []suspendedFrame
+	Handlers []suspendedHandler
+	IP       int
+	Value    int
+	Method   bool
+}
+
+type suspendedFrame struct {
+	Fn          int
+	Args        int
+	Kwargs      int
+	FreeVars    []int
+	IP          int
+	BasePointer int
+}
+
+type suspendedHandler struct {
+	FramesIndex int
+	SP          int
+	CatchPos    int
+}
+
+func TestPool(t *testing.T) {
+	c := compile(t, `
+m.count += n
+m.runs = append(m.runs, n)
+out := m.count * 10
+if n < 0 { out = 1 - "a" }
+`, M{"m": map[string]interface{}{"count": 1, "runs": []interface{}{}}, "n": 0})
+	p := tengo.NewPool(c, 4)
+
+	// every run starts with a copy of the values set before the pool
+	concurrency := 100
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func(n int) {
+			defer wg.Done()
+			out, err := p.Run(context.Background(), M{"n": n})
+			require.NoError(t, err)
+			require.Equal(t, (n+1)*10, out["out"].Int())
+			require.Equal(t, 1, len(out["m"].Map()["runs"].([]interface{})))
+		}(i)
+	}
+	wg.Wait()
+	compiledGet(t, c, "out", nil)
+
+	// inputs of the objects are copied
+	m := &tengo.Map{Value: map[string]tengo.Object{
+		"count": &tengo.Int{Value: 2},
+		"runs":  &tengo.Array{},
+	}}
+	out, err := p.Run(context.Background(), M{"m": m, "n": 3})
+	require.NoError(t, err)
+	require.Equal(t, 50, out["out"].Int())
+	require.Equal(t, int64(2), m.Value["count"].(*tengo.Int).Value)
+
+	// errors
+	_, err = p.Run(context.Background(), M{"x": 1})
+	require.Error(t, err)
+	_, err = p.Run(context.Background(), M{"n": -1})
+	require.Error(t, err)
+	out, err = p.Run(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 10, out["out"].Int())
+
+	// values set after the pool was created are not used by the pool
+	require.NoError(t, c.Set("n", 2))
+	out, err = p.Run(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 10, out["out"].Int())
+
+	// the VMs are reused after the runs are cancelled
+	p = tengo.NewPool(compile(t, `
+for i := 0; i < n; i++ {}
+out := n`, M{"n": 0}), 1)
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Millisecond)
+		_, err = p.Run(ctx, M{"n": 1 << 40})
+		cancel()
+		require.Equal(t, context.DeadlineExceeded, err)
+		out, err = p.Run(context.Background(), M{"n": 5})
+		require.NoError(t, err)
+		require.Equal(t, 5, out["out"].Int())
+	}
 }
 
 func compile(t *testing.T, input string, vars M) *tengo.Compiled {
@@ -6,13 +6,15 @@ import (
 
 // BuiltinModules are builtin type standard library modules.
 var BuiltinModules = map[string]map[string]tengo.Object{
-	"math":   mathModule,
-	"os":     osModule,
-	"text":   textModule,
-	"times":  timesModule,
-	"rand":   randModule,
-	"fmt":    fmtModule,
-	"json":   jsonModule,
-	"base64": base64Module,
-	"hex":    hexModule,
+	"math":    mathModule,
+	"os":      osModule,
+	"text":    textModule,
+	"times":   timesModule,
+	"rand":    randModule,
+	"fmt":     fmtModule,
+	"json":    jsonModule,
+	"base64":  base64Module,
+	"hex":     hexModule,
+	"testing": testingModule,
+	"sync":    syncModule,
 }
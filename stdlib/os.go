@@ -3,11 +3,11 @@ package stdlib
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 
 	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/vfs"
 )
 
 var osModule = map[string]tengo.Object{
@@ -43,15 +43,9 @@ var osModule = map[string]tengo.Object{
 		Name:  "args",
 		Value: osArgs,
 	}, // args() => array(string)
-	"chdir": &tengo.UserFunction{
-		Name:  "chdir",
-		Value: FuncASRE(os.Chdir),
-	}, // chdir(dir string) => error
-	"chmod": osFuncASFmRE("chmod", os.Chmod), // chmod(name string, mode int) => error
-	"chown": &tengo.UserFunction{
-		Name:  "chown",
-		Value: FuncASIIRE(os.Chown),
-	}, // chown(name string, uid int, gid int) => error
+	"chdir": osHostFunc("chdir", FuncASRE(os.Chdir)),   // chdir(dir string) => error
+	"chmod": osFuncASFmRE("chmod", vfs.Chmod),          // chmod(name string, mode int) => error
+	"chown": osHostFunc("chown", FuncASIIRE(os.Chown)), // chown(name string, uid int, gid int) => error
 	"clearenv": &tengo.UserFunction{
 		Name:  "clearenv",
 		Value: FuncAR(os.Clearenv),
@@ -112,68 +106,59 @@ var osModule = map[string]tengo.Object{
 		Name:  "hostname",
 		Value: FuncARSE(os.Hostname),
 	}, // hostname() => string/error
-	"lchown": &tengo.UserFunction{
-		Name:  "lchown",
-		Value: FuncASIIRE(os.Lchown),
-	}, // lchown(name string, uid int, gid int) => error
-	"link": &tengo.UserFunction{
-		Name:  "link",
-		Value: FuncASSRE(os.Link),
-	}, // link(oldname string, newname string) => error
+	"lchown": osHostFunc("lchown", FuncASIIRE(os.Lchown)), // lchown(name string, uid int, gid int) => error
+	"link":   osHostFunc("link", FuncASSRE(os.Link)),      // link(oldname string, newname string) => error
 	"lookup_env": &tengo.UserFunction{
 		Name:  "lookup_env",
 		Value: osLookupEnv,
 	}, // lookup_env(key string) => string/false
-	"mkdir":     osFuncASFmRE("mkdir", os.Mkdir),        // mkdir(name string, perm int) => error
-	"mkdir_all": osFuncASFmRE("mkdir_all", os.MkdirAll), // mkdir_all(name string, perm int) => error
-	"readlink": &tengo.UserFunction{
-		Name:  "readlink",
-		Value: FuncASRSE(os.Readlink),
-	}, // readlink(name string) => string/error
-	"remove": &tengo.UserFunction{
-		Name:  "remove",
-		Value: FuncASRE(os.Remove),
-	}, // remove(name string) => error
-	"remove_all": &tengo.UserFunction{
-		Name:  "remove_all",
-		Value: FuncASRE(os.RemoveAll),
-	}, // remove_all(name string) => error
-	"rename": &tengo.UserFunction{
-		Name:  "rename",
-		Value: FuncASSRE(os.Rename),
-	}, // rename(oldpath string, newpath string) => error
+	"mkdir":     osFuncASFmRE("mkdir", vfs.FS.Mkdir),            // mkdir(name string, perm int) => error
+	"mkdir_all": osFuncASFmRE("mkdir_all", vfs.MkdirAll),        // mkdir_all(name string, perm int) => error
+	"readlink":  osHostFunc("readlink", FuncASRSE(os.Readlink)), // readlink(name string) => string/error
+	"remove": osFSFunc("remove", func(
+		fsys vfs.FS,
+		args ...tengo.Object,
+	) (tengo.Object, error) {
+		return FuncASRE(fsys.Remove)(args...)
+	}), // remove(name string) => error
+	"remove_all": osFSFunc("remove_all", func(
+		fsys vfs.FS,
+		args ...tengo.Object,
+	) (tengo.Object, error) {
+		return FuncASRE(func(name string) error {
+			return vfs.RemoveAll(fsys, name)
+		})(args...)
+	}), // remove_all(name string) => error
+	"rename": osFSFunc("rename", func(
+		fsys vfs.FS,
+		args ...tengo.Object,
+	) (tengo.Object, error) {
+		return FuncASSRE(fsys.Rename)(args...)
+	}), // rename(oldpath string, newpath string) => error
 	"setenv": &tengo.UserFunction{
 		Name:  "setenv",
 		Value: FuncASSRE(os.Setenv),
 	}, // setenv(key string, value string) => error
-	"symlink": &tengo.UserFunction{
-		Name:  "symlink",
-		Value: FuncASSRE(os.Symlink),
-	}, // symlink(oldname string newname string) => error
+	"symlink": osHostFunc("symlink", FuncASSRE(os.Symlink)), // symlink(oldname string newname string) => error
 	"temp_dir": &tengo.UserFunction{
 		Name:  "temp_dir",
 		Value: FuncARS(os.TempDir),
 	}, // temp_dir() => string
-	"truncate": &tengo.UserFunction{
-		Name:  "truncate",
-		Value: FuncASI64RE(os.Truncate),
-	}, // truncate(name string, size int) => error
+	"truncate": osFSFunc("truncate", func(
+		fsys vfs.FS,
+		args ...tengo.Object,
+	) (tengo.Object, error) {
+		return FuncASI64RE(func(name string, size int64) error {
+			return vfs.Truncate(fsys, name, size)
+		})(args...)
+	}), // truncate(name string, size int) => error
 	"unsetenv": &tengo.UserFunction{
 		Name:  "unsetenv",
 		Value: FuncASRE(os.Unsetenv),
 	}, // unsetenv(key string) => error
-	"create": &tengo.UserFunction{
-		Name:  "create",
-		Value: osCreate,
-	}, // create(name string) => imap(file)/error
-	"open": &tengo.UserFunction{
-		Name:  "open",
-		Value: osOpen,
-	}, // open(name string) => imap(file)/error
-	"open_file": &tengo.UserFunction{
-		Name:  "open_file",
-		Value: osOpenFile,
-	}, // open_file(name string, flag int, perm int) => imap(file)/error
+	"create":    osFSFunc("create", osCreate),      // create(name string) => imap(file)/error
+	"open":      osFSFunc("open", osOpen),          // open(name string) => imap(file)/error
+	"open_file": osFSFunc("open_file", osOpenFile), // open_file(name string, flag int, perm int) => imap(file)/error
 	"find_process": &tengo.UserFunction{
 		Name:  "find_process",
 		Value: osFindProcess,
@@ -190,17 +175,14 @@ var osModule = map[string]tengo.Object{
 		Name:  "exec",
 		Value: osExec,
 	}, // exec(name, args...) => command
-	"stat": &tengo.UserFunction{
-		Name:  "stat",
-		Value: osStat,
-	}, // stat(name) => imap(fileinfo)/error
-	"read_file": &tengo.UserFunction{
-		Name:  "read_file",
-		Value: osReadFile,
-	}, // readfile(name) => array(byte)/error
+	"stat":      osFSFunc("stat", osStat),          // stat(name) => imap(fileinfo)/error
+	"read_file": osFSFunc("read_file", osReadFile), // readfile(name) => array(byte)/error
 }
 
-func osReadFile(args ...tengo.Object) (ret tengo.Object, err error) {
+func osReadFile(
+	fsys vfs.FS,
+	args ...tengo.Object,
+) (ret tengo.Object, err error) {
 	if len(args) != 1 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -212,7 +194,7 @@ func osReadFile(args ...tengo.Object) (ret tengo.Object, err error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	bytes, err := ioutil.ReadFile(fname)
+	bytes, err := vfs.ReadFile(fsys, fname)
 	if err != nil {
 		return wrapError(err), nil
 	}
@@ -222,7 +204,7 @@ func osReadFile(args ...tengo.Object) (ret tengo.Object, err error) {
 	return &tengo.Bytes{Value: bytes}, nil
 }
 
-func osStat(args ...tengo.Object) (ret tengo.Object, err error) {
+func osStat(fsys vfs.FS, args ...tengo.Object) (ret tengo.Object, err error) {
 	if len(args) != 1 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -234,10 +216,14 @@ func osStat(args ...tengo.Object) (ret tengo.Object, err error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	stat, err := os.Stat(fname)
+	stat, err := fsys.Stat(fname)
 	if err != nil {
 		return wrapError(err), nil
 	}
+	return makeOSFileInfo(stat), nil
+}
+
+func makeOSFileInfo(stat os.FileInfo) *tengo.ImmutableMap {
 	fstat := &tengo.ImmutableMap{
 		Value: map[string]tengo.Object{
 			"name":  &tengo.String{Value: stat.Name()},
@@ -251,10 +237,10 @@ func osStat(args ...tengo.Object) (ret tengo.Object, err error) {
 	} else {
 		fstat.Value["directory"] = tengo.FalseValue
 	}
-	return fstat, nil
+	return fstat
 }
 
-func osCreate(args ...tengo.Object) (tengo.Object, error) {
+func osCreate(fsys vfs.FS, args ...tengo.Object) (tengo.Object, error) {
 	if len(args) != 1 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -266,14 +252,14 @@ func osCreate(args ...tengo.Object) (tengo.Object, error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	res, err := os.Create(s1)
+	res, err := vfs.Create(fsys, s1)
 	if err != nil {
 		return wrapError(err), nil
 	}
 	return makeOSFile(res), nil
 }
 
-func osOpen(args ...tengo.Object) (tengo.Object, error) {
+func osOpen(fsys vfs.FS, args ...tengo.Object) (tengo.Object, error) {
 	if len(args) != 1 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -285,14 +271,14 @@ func osOpen(args ...tengo.Object) (tengo.Object, error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	res, err := os.Open(s1)
+	res, err := vfs.Open(fsys, s1)
 	if err != nil {
 		return wrapError(err), nil
 	}
 	return makeOSFile(res), nil
 }
 
-func osOpenFile(args ...tengo.Object) (tengo.Object, error) {
+func osOpenFile(fsys vfs.FS, args ...tengo.Object) (tengo.Object, error) {
 	if len(args) != 3 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -320,7 +306,7 @@ func osOpenFile(args ...tengo.Object) (tengo.Object, error) {
 			Found:    args[2].TypeName(),
 		}
 	}
-	res, err := os.OpenFile(s1, i2, os.FileMode(i3))
+	res, err := fsys.OpenFile(s1, i2, os.FileMode(i3))
 	if err != nil {
 		return wrapError(err), nil
 	}
@@ -343,35 +329,81 @@ func osArgs(args ...tengo.Object) (tengo.Object, error) {
 
 func osFuncASFmRE(
 	name string,
-	fn func(string, os.FileMode) error,
-) *tengo.UserFunction {
-	return &tengo.UserFunction{
-		Name: name,
-		Value: func(args ...tengo.Object) (tengo.Object, error) {
-			if len(args) != 2 {
-				return nil, tengo.ErrWrongNumArguments
+	fn func(vfs.FS, string, os.FileMode) error,
+) *tengo.UserFunctionCtx {
+	return osFSFunc(name, func(
+		fsys vfs.FS,
+		args ...tengo.Object,
+	) (tengo.Object, error) {
+		if len(args) != 2 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+		s1, ok := tengo.ToString(args[0])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "string(compatible)",
+				Found:    args[0].TypeName(),
 			}
-			s1, ok := tengo.ToString(args[0])
-			if !ok {
-				return nil, tengo.ErrInvalidArgumentType{
-					Name:     "first",
-					Expected: "string(compatible)",
-					Found:    args[0].TypeName(),
-				}
+		}
+		i2, ok := tengo.ToInt64(args[1])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "second",
+				Expected: "int(compatible)",
+				Found:    args[1].TypeName(),
 			}
-			i2, ok := tengo.ToInt64(args[1])
-			if !ok {
-				return nil, tengo.ErrInvalidArgumentType{
-					Name:     "second",
-					Expected: "int(compatible)",
-					Found:    args[1].TypeName(),
-				}
+		}
+		return wrapError(fn(fsys, s1, os.FileMode(i2))), nil
+	})
+}
+
+// osFSFunc returns the function that uses the file system of the VM calling
+// it.
+func osFSFunc(
+	name string,
+	fn func(fsys vfs.FS, args ...tengo.Object) (tengo.Object, error),
+) *tengo.UserFunctionCtx {
+	return &tengo.UserFunctionCtx{
+		Name: name,
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			if len(ctx.Kwargs) > 0 {
+				return nil, tengo.ErrUnexpectedKwargs
 			}
-			return wrapError(fn(s1, os.FileMode(i2))), nil
+			return fn(osFS(ctx), ctx.Args...)
 		},
 	}
 }
 
+// osHostFunc returns the function that only works with the host file system.
+// It returns an error if the VM calling it uses another file system.
+func osHostFunc(name string, fn tengo.CallableFunc) *tengo.UserFunctionCtx {
+	return osFSFunc(name, func(
+		fsys vfs.FS,
+		args ...tengo.Object,
+	) (tengo.Object, error) {
+		if fsys != vfs.OS() {
+			var path string
+			if len(args) > 0 {
+				path, _ = tengo.ToString(args[0])
+			}
+			return wrapError(&os.PathError{
+				Op:   name,
+				Path: path,
+				Err:  vfs.ErrNotSupported,
+			}), nil
+		}
+		return fn(args...)
+	})
+}
+
+func osFS(ctx *tengo.CallContext) vfs.FS {
+	if ctx.VM == nil {
+		return vfs.OS()
+	}
+	return ctx.VM.FS()
+}
+
 func osLookupEnv(args ...tengo.Object) (tengo.Object, error) {
 	if len(args) != 1 {
 		return nil, tengo.ErrWrongNumArguments
@@ -430,6 +462,15 @@ func osExpandEnv(args ...tengo.Object) (tengo.Object, error) {
 }
 
 func osExec(args ...tengo.Object) (tengo.Object, error) {
+	cmd, err := osCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	return makeOSExecCommand(cmd), nil
+}
+
+// osCommand returns the command of exec.
+func osCommand(args ...tengo.Object) (*exec.Cmd, error) {
 	if len(args) == 0 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -453,7 +494,7 @@ func osExec(args ...tengo.Object) (tengo.Object, error) {
 		}
 		execArgs = append(execArgs, execArg)
 	}
-	return makeOSExecCommand(exec.Command(name, execArgs...)), nil
+	return exec.Command(name, execArgs...), nil
 }
 
 func osFindProcess(args ...tengo.Object) (tengo.Object, error) {
@@ -476,6 +517,16 @@ func osFindProcess(args ...tengo.Object) (tengo.Object, error) {
 }
 
 func osStartProcess(args ...tengo.Object) (tengo.Object, error) {
+	return startProcess(nil, args...)
+}
+
+// startProcess starts the process of start_process. If the env argument is
+// empty, the environment of the process is defaultEnv or the environment of
+// the host if defaultEnv is nil.
+func startProcess(
+	defaultEnv []string,
+	args ...tengo.Object,
+) (tengo.Object, error) {
 	if len(args) != 4 {
 		return nil, tengo.ErrWrongNumArguments
 	}
@@ -537,6 +588,9 @@ func osStartProcess(args ...tengo.Object) (tengo.Object, error) {
 		}
 	}
 
+	if len(env) == 0 {
+		env = defaultEnv
+	}
 	proc, err := os.StartProcess(name, argv, &os.ProcAttr{
 		Dir: dir,
 		Env: env,
@@ -1,23 +1,36 @@
 package stdlib
 
 import (
+	"io"
 	"os"
 
 	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/vfs"
 )
 
-func makeOSFile(file *os.File) *tengo.ImmutableMap {
+func makeOSFile(file vfs.File) *tengo.ImmutableMap {
 	return &tengo.ImmutableMap{
 		Value: map[string]tengo.Object{
 			// chdir() => true/error
 			"chdir": &tengo.UserFunction{
-				Name:  "chdir",
-				Value: FuncARE(file.Chdir),
+				Name: "chdir",
+				Value: FuncARE(func() error {
+					if f, ok := file.(interface{ Chdir() error }); ok {
+						return f.Chdir()
+					}
+					return osFileNotSupported("chdir", file)
+				}),
 			}, //
 			// chown(uid int, gid int) => true/error
 			"chown": &tengo.UserFunction{
-				Name:  "chown",
-				Value: FuncAIIRE(file.Chown),
+				Name: "chown",
+				Value: FuncAIIRE(func(uid, gid int) error {
+					f, ok := file.(interface{ Chown(int, int) error })
+					if ok {
+						return f.Chown(uid, gid)
+					}
+					return osFileNotSupported("chown", file)
+				}),
 			}, //
 			// close() => error
 			"close": &tengo.UserFunction{
@@ -46,8 +59,10 @@ func makeOSFile(file *os.File) *tengo.ImmutableMap {
 			}, //
 			// write(string) => int/error
 			"write_string": &tengo.UserFunction{
-				Name:  "write_string",
-				Value: FuncASRIE(file.WriteString),
+				Name: "write_string",
+				Value: FuncASRIE(func(s string) (int, error) {
+					return io.WriteString(file, s)
+				}),
 			}, //
 			// read(bytes) => int/error
 			"read": &tengo.UserFunction{
@@ -69,7 +84,11 @@ func makeOSFile(file *os.File) *tengo.ImmutableMap {
 							Found:    args[0].TypeName(),
 						}
 					}
-					return wrapError(file.Chmod(os.FileMode(i1))), nil
+					f, ok := file.(interface{ Chmod(os.FileMode) error })
+					if !ok {
+						return wrapError(osFileNotSupported("chmod", file)), nil
+					}
+					return wrapError(f.Chmod(os.FileMode(i1))), nil
 				},
 			},
 			// seek(offset int, whence int) => int/error
@@ -109,9 +128,17 @@ func makeOSFile(file *os.File) *tengo.ImmutableMap {
 					if len(args) != 0 {
 						return nil, tengo.ErrWrongNumArguments
 					}
-					return osStat(&tengo.String{Value: file.Name()})
+					stat, err := file.Stat()
+					if err != nil {
+						return wrapError(err), nil
+					}
+					return makeOSFileInfo(stat), nil
 				},
 			},
 		},
 	}
 }
+
+func osFileNotSupported(op string, file vfs.File) error {
+	return &os.PathError{Op: op, Path: file.Name(), Err: vfs.ErrNotSupported}
+}
@@ -0,0 +1,407 @@
+package stdlib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/vfs"
+)
+
+// Policy grants the capabilities of the os module to the scripts. Everything
+// that is not granted is denied: the denied functions return an error value
+// without touching the host.
+type Policy struct {
+	// ReadDirs is the list of directories the scripts can read files from.
+	// The files are read-only: the functions that create, modify or remove
+	// files are always denied.
+	ReadDirs []string
+
+	// Executables is the list of programs the scripts can run using exec
+	// and start_process.
+	Executables []string
+
+	// EnvVars is the list of environment variables the scripts can read,
+	// and pass to the programs they run. The functions that modify the
+	// environment of the host process are always denied.
+	EnvVars []string
+
+	// SystemInfo allows the functions that return information about the
+	// process and the host such as args, getpid, getwd and hostname.
+	SystemInfo bool
+}
+
+// GetModuleMapWithPolicy returns the module map that includes all modules
+// for the given module names. Unlike GetModuleMap, the os module only has
+// the capabilities granted by the policy. A nil policy denies everything.
+func GetModuleMapWithPolicy(
+	policy *Policy,
+	names ...string,
+) *tengo.ModuleMap {
+	if policy == nil {
+		policy = &Policy{}
+	}
+	modules := GetModuleMap(names...)
+	for _, name := range names {
+		if name == "os" {
+			modules.AddBuiltinModule(name, policy.osModule())
+		}
+	}
+	return modules
+}
+
+var osSystemInfoFuncs = []string{
+	"args", "getegid", "geteuid", "getgid", "getgroups", "getpagesize",
+	"getpid", "getppid", "getuid", "getwd", "hostname", "temp_dir",
+}
+
+// osModule returns a copy of the os module where the functions are
+// restricted by the policy.
+func (p *Policy) osModule() map[string]tengo.Object {
+	mod := make(map[string]tengo.Object, len(osModule))
+	for name, v := range osModule {
+		if v.CanCall() {
+			mod[name] = policyDeny(name)
+		} else {
+			mod[name] = v
+		}
+	}
+	if p.SystemInfo {
+		for _, name := range osSystemInfoFuncs {
+			mod[name] = osModule[name]
+		}
+	}
+
+	mod["open"] = p.readFunc("open", policyOpen(osModule["open"]))
+	mod["open_file"] = p.readFunc("open_file", policyOpen(policyOpenFile))
+	mod["read_file"] = p.readFunc("read_file", osModule["read_file"])
+	mod["readlink"] = p.readFunc("readlink", osModule["readlink"])
+	mod["stat"] = p.readFunc("stat", osModule["stat"])
+
+	mod["exec"] = p.execFunc("exec", &tengo.UserFunction{
+		Name:  "exec",
+		Value: p.osExec,
+	})
+	mod["exec_look_path"] = p.execFunc("exec_look_path",
+		osModule["exec_look_path"])
+	mod["start_process"] = p.execFunc("start_process", &tengo.UserFunction{
+		Name:  "start_process",
+		Value: p.osStartProcess,
+	})
+
+	mod["getenv"] = p.envFunc("getenv", osModule["getenv"])
+	mod["lookup_env"] = p.envFunc("lookup_env", osModule["lookup_env"])
+	mod["expand_env"] = &tengo.UserFunction{
+		Name:  "expand_env",
+		Value: p.osExpandEnv,
+	}
+	mod["environ"] = &tengo.UserFunction{
+		Name:  "environ",
+		Value: p.osEnviron,
+	}
+	return mod
+}
+
+// readFunc returns the function that fails unless its first argument is a
+// path inside one of the readable directories.
+func (p *Policy) readFunc(name string, fn tengo.Object) *tengo.UserFunctionCtx {
+	return &tengo.UserFunctionCtx{
+		Name: name,
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			if len(ctx.Args) > 0 {
+				path, ok := tengo.ToString(ctx.Args[0])
+				if ok && !p.readable(osFS(ctx), path) {
+					return policyDenyPath(name, path), nil
+				}
+			}
+			return fn.Call(ctx)
+		},
+	}
+}
+
+// readable returns true if the path is inside one of the readable
+// directories. The symbolic links of the host file system are resolved so
+// they cannot be used to escape from the directories.
+func (p *Policy) readable(fsys vfs.FS, path string) bool {
+	resolve := resolvePath
+	if fsys != vfs.OS() {
+		resolve = func(path string) string {
+			return filepath.Join(string(filepath.Separator), path)
+		}
+	}
+	path = resolve(path)
+	if path == "" {
+		return false
+	}
+	for _, dir := range p.ReadDirs {
+		root := resolve(dir)
+		if root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		if rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolvePath(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// policyOpenFile is like open_file of the os module, but the files can only
+// be opened for reading.
+var policyOpenFile = osFSFunc("open_file", func(
+	fsys vfs.FS,
+	args ...tengo.Object,
+) (tengo.Object, error) {
+	if len(args) == 3 {
+		flag, ok := tengo.ToInt(args[1])
+		writeFlags := os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE |
+			os.O_TRUNC
+		if ok && flag&writeFlags != 0 {
+			name, _ := tengo.ToString(args[0])
+			return policyDenyPath("open_file", name), nil
+		}
+	}
+	return osOpenFile(fsys, args...)
+})
+
+// policyOpen returns the function that removes the functions modifying the
+// file or the process from the files opened by fn.
+func policyOpen(fn tengo.Object) tengo.Object {
+	return &tengo.UserFunctionCtx{
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			res, err := fn.Call(ctx)
+			if file, ok := res.(*tengo.ImmutableMap); ok && err == nil {
+				for _, name := range []string{
+					"chdir", "chmod", "chown", "write", "write_string",
+				} {
+					delete(file.Value, name)
+				}
+			}
+			return res, err
+		},
+	}
+}
+
+// execFunc returns the function that fails unless its first argument is one
+// of the allowed executables.
+func (p *Policy) execFunc(name string, fn tengo.Object) *tengo.UserFunctionCtx {
+	return &tengo.UserFunctionCtx{
+		Name: name,
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			if len(ctx.Args) > 0 {
+				file, ok := tengo.ToString(ctx.Args[0])
+				if ok && !p.executable(file) {
+					return policyDenyPath(name, file), nil
+				}
+			}
+			return fn.Call(ctx)
+		},
+	}
+}
+
+// executable returns true if the file is one of the allowed executables or
+// resolves to the same program as one of them.
+func (p *Policy) executable(file string) bool {
+	path, err := exec.LookPath(file)
+	for _, allowed := range p.Executables {
+		if allowed == file {
+			return true
+		}
+		if err != nil {
+			continue
+		}
+		if allowedPath, err := exec.LookPath(allowed); err == nil &&
+			allowedPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+// osExec is like exec of the os module, but the command cannot be changed
+// to run a different program, and its environment only has the allowed
+// environment variables.
+func (p *Policy) osExec(args ...tengo.Object) (tengo.Object, error) {
+	cmd, err := osCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = p.environ()
+	res := makeOSExecCommand(cmd)
+	delete(res.Value, "set_path")
+	res.Value["set_env"] = &tengo.UserFunction{
+		Name: "set_env",
+		Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			env, denied, err := p.parseEnviron("set_env", "first", args[0])
+			if denied != nil || err != nil {
+				return denied, err
+			}
+			if len(env) == 0 {
+				env = p.environ()
+			}
+			cmd.Env = env
+			return tengo.UndefinedValue, nil
+		},
+	}
+	return res, nil
+}
+
+// osStartProcess is like start_process of the os module, but the
+// environment of the process only has the allowed environment variables.
+func (p *Policy) osStartProcess(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) == 4 {
+		_, denied, err := p.parseEnviron("start_process", "fourth", args[3])
+		if denied != nil || err != nil {
+			return denied, err
+		}
+	}
+	return startProcess(p.environ(), args...)
+}
+
+// parseEnviron returns the environment given as the argument of the
+// function. It returns the error value if the environment has a variable
+// that is not allowed.
+func (p *Policy) parseEnviron(
+	name, argName string,
+	arg tengo.Object,
+) ([]string, tengo.Object, error) {
+	var elems []tengo.Object
+	switch arg := arg.(type) {
+	case *tengo.Array:
+		elems = arg.Value
+	case *tengo.ImmutableArray:
+		elems = arg.Value
+	default:
+		return nil, nil, tengo.ErrInvalidArgumentType{
+			Name:     argName,
+			Expected: "array",
+			Found:    arg.TypeName(),
+		}
+	}
+	env, err := stringArray(elems, argName)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, kv := range env {
+		if key := envKey(kv); !p.envVar(key) {
+			return nil, policyDenyPath(name, key), nil
+		}
+	}
+	return env, nil, nil
+}
+
+// environ returns the allowed environment variables of the host process.
+// The result is never nil so the programs do not inherit the environment of
+// the host process.
+func (p *Policy) environ() []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if p.envVar(envKey(kv)) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// envKey returns the name of the variable of the "key=value" string.
+func envKey(kv string) string {
+	if idx := strings.IndexByte(kv, '='); idx >= 0 {
+		return kv[:idx]
+	}
+	return kv
+}
+
+// envFunc returns the function that fails unless its first argument is one
+// of the allowed environment variables.
+func (p *Policy) envFunc(name string, fn tengo.Object) *tengo.UserFunctionCtx {
+	return &tengo.UserFunctionCtx{
+		Name: name,
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			if len(ctx.Args) == 1 {
+				key, ok := tengo.ToString(ctx.Args[0])
+				if ok && !p.envVar(key) {
+					return policyDenyPath(name, key), nil
+				}
+			}
+			return fn.Call(ctx)
+		},
+	}
+}
+
+func (p *Policy) envVar(key string) bool {
+	for _, allowed := range p.EnvVars {
+		if allowed == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) osExpandEnv(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) == 1 {
+		if s1, ok := tengo.ToString(args[0]); ok {
+			var denied string
+			os.Expand(s1, func(k string) string {
+				if denied == "" && !p.envVar(k) {
+					denied = k
+				}
+				return ""
+			})
+			if denied != "" {
+				return policyDenyPath("expand_env", denied), nil
+			}
+		}
+	}
+	return osExpandEnv(args...)
+}
+
+func (p *Policy) osEnviron(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	arr := &tengo.Array{}
+	for _, env := range os.Environ() {
+		if !p.envVar(envKey(env)) {
+			continue
+		}
+		if len(env) > tengo.MaxStringLen {
+			return nil, tengo.ErrStringLimit
+		}
+		arr.Value = append(arr.Value, &tengo.String{Value: env})
+	}
+	return arr, nil
+}
+
+func policyDeny(name string) *tengo.UserFunction {
+	return &tengo.UserFunction{
+		Name: name,
+		Value: func(args ...tengo.Object) (tengo.Object, error) {
+			return wrapError(fmt.Errorf("%s: %w", name, os.ErrPermission)), nil
+		},
+	}
+}
+
+func policyDenyPath(op, path string) tengo.Object {
+	return wrapError(&os.PathError{Op: op, Path: path, Err: os.ErrPermission})
+}
@@ -0,0 +1,208 @@
+package stdlib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/require"
+	"github.com/d5/tengo/v2/stdlib"
+	"github.com/d5/tengo/v2/vfs"
+)
+
+func TestPolicyFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "tengo-policy")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	allowed := filepath.Join(root, "allowed")
+	require.NoError(t, os.Mkdir(allowed, 0755))
+	inside := filepath.Join(allowed, "inside.txt")
+	require.NoError(t, ioutil.WriteFile(inside, []byte("inside"), 0644))
+	outside := filepath.Join(root, "outside.txt")
+	require.NoError(t, ioutil.WriteFile(outside, []byte("outside"), 0644))
+	link := filepath.Join(allowed, "link.txt")
+	require.NoError(t, os.Symlink(outside, link))
+
+	mod := policyModule(t, &stdlib.Policy{ReadDirs: []string{allowed}})
+	mod.call("read_file", inside).expect([]byte("inside"))
+	mod.call("read_file", outside).expectDenied()
+	mod.call("read_file", filepath.Join(allowed, "..", "outside.txt")).
+		expectDenied()
+	mod.call("read_file", link).expectDenied()
+	mod.call("stat", allowed).expectNoError()
+	mod.call("stat", root).expectDenied()
+	mod.call("read_file").expectError()
+
+	file := mod.call("open", inside)
+	file.expectNoError()
+	file.call("name").expect(inside)
+	file.call("write", []byte("x")).expectError()
+	file.call("chmod", 0777).expectError()
+	file.call("close").expectNoError()
+	mod.call("open", outside).expectDenied()
+
+	rdonly := int64(0)
+	mod.call("open_file", inside, rdonly, 0).expectNoError()
+	mod.call("open_file", inside, 1, 0).expectDenied()
+	mod.call("open_file", outside, rdonly, 0).expectDenied()
+
+	mod.call("create", filepath.Join(allowed, "new.txt")).expectDenied()
+	mod.call("remove", inside).expectDenied()
+	mod.call("remove_all", allowed).expectDenied()
+	mod.call("mkdir", filepath.Join(allowed, "dir"), 0755).expectDenied()
+	mod.call("chdir", allowed).expectDenied()
+	_, err = ioutil.ReadFile(inside)
+	require.NoError(t, err)
+}
+
+func TestPolicyExec(t *testing.T) {
+	mod := policyModule(t, &stdlib.Policy{Executables: []string{"echo"}})
+	cmd := mod.call("exec", "echo", "foo")
+	cmd.expectNoError()
+	cmd.call("set_path", "/bin/sh").expectError()
+	cmd.call("output").expect([]byte("foo\n"))
+	mod.call("exec", "sh", "-c", "echo foo").expectDenied()
+	mod.call("exec_look_path", "sh").expectDenied()
+	mod.call("start_process", "/bin/sh", ARR{}, "", ARR{}).expectDenied()
+	mod.call("find_process", 1).expectDenied()
+	mod.call("exit", 1).expectDenied()
+}
+
+func TestPolicyExecEnv(t *testing.T) {
+	_ = os.Setenv("TENGO_ALLOWED", "foo")
+	_ = os.Setenv("TENGO_SECRET", "hunter2")
+	defer func() {
+		_ = os.Unsetenv("TENGO_ALLOWED")
+		_ = os.Unsetenv("TENGO_SECRET")
+	}()
+
+	mod := policyModule(t, &stdlib.Policy{
+		Executables: []string{"sh", "/bin/sh"},
+		EnvVars:     []string{"TENGO_ALLOWED"},
+	})
+	echo := "echo $TENGO_ALLOWED$TENGO_SECRET$LD_PRELOAD"
+
+	// the commands only inherit the allowed variables
+	mod.call("exec", "sh", "-c", echo).call("output").
+		expect([]byte("foo\n"))
+
+	cmd := mod.call("exec", "sh", "-c", echo)
+	cmd.expectNoError()
+	cmd.call("set_env", ARR{"LD_PRELOAD=/tmp/x.so"}).expectDenied()
+	cmd.call("set_env", IARR{"TENGO_ALLOWED=bar", "PATH=/tmp"}).
+		expectDenied()
+	cmd.call("set_env", "TENGO_SECRET=x").expectError()
+	cmd.call("set_env", ARR{1}).expectError()
+	cmd.call("set_env", ARR{"TENGO_ALLOWED=bar"}).expectNoError()
+	cmd.call("output").expect([]byte("bar\n"))
+
+	cmd = mod.call("exec", "sh", "-c", echo)
+	cmd.call("set_env", ARR{}).expectNoError()
+	cmd.call("output").expect([]byte("foo\n"))
+
+	mod.call("start_process", "/bin/sh", ARR{"sh"}, "",
+		ARR{"LD_PRELOAD=/tmp/x.so"}).expectDenied()
+	mod.call("start_process", "/bin/sh", ARR{"sh"}, "", "x").expectError()
+	for _, env := range []ARR{{}, {"TENGO_ALLOWED=foo"}} {
+		proc := mod.call("start_process", "/bin/sh", ARR{"sh", "-c",
+			`test "$TENGO_ALLOWED$TENGO_SECRET" = foo`}, "", env)
+		proc.expectNoError()
+		proc.call("wait").call("success").expect(true)
+	}
+}
+
+func TestPolicyEnv(t *testing.T) {
+	_ = os.Setenv("TENGO_ALLOWED", "foo")
+	_ = os.Setenv("TENGO_DENIED", "bar")
+	defer func() {
+		_ = os.Unsetenv("TENGO_ALLOWED")
+		_ = os.Unsetenv("TENGO_DENIED")
+	}()
+
+	mod := policyModule(t, &stdlib.Policy{
+		EnvVars: []string{"TENGO_ALLOWED"},
+	})
+	mod.call("getenv", "TENGO_ALLOWED").expect("foo")
+	mod.call("getenv", "TENGO_DENIED").expectDenied()
+	mod.call("lookup_env", "TENGO_ALLOWED").expect("foo")
+	mod.call("lookup_env", "TENGO_DENIED").expectDenied()
+	mod.call("expand_env", "${TENGO_ALLOWED}!").expect("foo!")
+	mod.call("expand_env", "$TENGO_ALLOWED $TENGO_DENIED").expectDenied()
+	mod.call("environ").expect(ARR{"TENGO_ALLOWED=foo"})
+	mod.call("setenv", "TENGO_ALLOWED", "baz").expectDenied()
+	mod.call("unsetenv", "TENGO_ALLOWED").expectDenied()
+	mod.call("clearenv").expectDenied()
+	require.Equal(t, "foo", os.Getenv("TENGO_ALLOWED"))
+}
+
+func TestPolicySystemInfo(t *testing.T) {
+	policyModule(t, &stdlib.Policy{}).call("getpid").expectDenied()
+	policyModule(t, &stdlib.Policy{}).call("o_rdonly").expectError()
+	policyModule(t, &stdlib.Policy{SystemInfo: true}).call("getpid").
+		expect(os.Getpid())
+}
+
+func TestGetModuleMapWithPolicy(t *testing.T) {
+	mods := stdlib.GetModuleMapWithPolicy(nil, "os", "text")
+	require.Equal(t, 2, mods.Len())
+	require.NotNil(t, mods.Get("text"))
+
+	s := tengo.NewScript([]byte(`
+os := import("os")
+out := [is_error(os.remove_all("/")), string(os.getenv("HOME")), os.o_rdonly]
+`))
+	s.SetImports(mods)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{
+		true, "error: \"getenv HOME: permission denied\"", 0,
+	}), c.Get("out").Object())
+}
+
+func TestPolicyFS(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	require.NoError(t, vfs.MkdirAll(fsys, "data", 0755))
+	require.NoError(t, vfs.WriteFile(fsys, "data/a.txt", []byte("a"), 0644))
+	require.NoError(t, vfs.WriteFile(fsys, "b.txt", []byte("b"), 0644))
+
+	s := tengo.NewScript([]byte(`
+os := import("os")
+out := [
+	string(os.read_file("/data/a.txt")),
+	is_error(os.read_file("/data/../b.txt")),
+	is_error(os.create("/data/c.txt"))
+]
+`))
+	s.SetImports(stdlib.GetModuleMapWithPolicy(&stdlib.Policy{
+		ReadDirs: []string{"/data"},
+	}, "os"))
+	s.SetFS(fsys)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{"a", true, true}), c.Get("out").Object())
+}
+
+func policyModule(t *testing.T, policy *stdlib.Policy) callres {
+	mod := stdlib.GetModuleMapWithPolicy(policy, "os").
+		GetBuiltinModule("os")
+	require.NotNil(t, mod)
+	return callres{t: t, o: mod}
+}
+
+func (c callres) expectNoError() {
+	require.NoError(c.t, c.e)
+	_, isErr := c.o.(*tengo.Error)
+	require.False(c.t, isErr, "unexpected error: %v", c.o)
+}
+
+func (c callres) expectDenied() {
+	require.NoError(c.t, c.e)
+	err, ok := c.o.(*tengo.Error)
+	require.True(c.t, ok, "expected error: %v", c.o)
+	require.True(c.t, strings.Contains(err.String(), "permission denied"),
+		err.String())
+}
@@ -118,13 +118,12 @@ func (c callres) call(funcName string, args ...interface{}) callres {
 				"function not found: %s", funcName)}
 		}
 
-		f, ok := m.(*tengo.UserFunction)
-		if !ok {
+		if !m.CanCall() {
 			return callres{t: c.t, e: fmt.Errorf(
 				"non-callable: %s", funcName)}
 		}
 
-		res, err := f.Value(oargs...)
+		res, err := m.Call(&tengo.CallContext{Args: oargs})
 		return callres{t: c.t, o: res, e: err}
 	case *tengo.UserFunction:
 		res, err := o.Value(oargs...)
@@ -135,12 +134,11 @@ func (c callres) call(funcName string, args ...interface{}) callres {
 			return callres{t: c.t, e: fmt.Errorf("function not found: %s", funcName)}
 		}
 
-		f, ok := m.(*tengo.UserFunction)
-		if !ok {
+		if !m.CanCall() {
 			return callres{t: c.t, e: fmt.Errorf("non-callable: %s", funcName)}
 		}
 
-		res, err := f.Value(oargs...)
+		res, err := m.Call(&tengo.CallContext{Args: oargs})
 		return callres{t: c.t, o: res, e: err}
 	default:
 		panic(fmt.Errorf("unexpected object: %v (%T)", o, o))
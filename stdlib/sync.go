@@ -0,0 +1,160 @@
+package stdlib
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/d5/tengo/v2"
+)
+
+var syncModule = map[string]tengo.Object{
+	"wait_group": &tengo.UserFunction{
+		Name:  "wait_group",
+		Value: syncWaitGroup,
+	}, // wait_group() => wait group
+	"mutex": &tengo.UserFunction{
+		Name:  "mutex",
+		Value: syncMutex,
+	}, // mutex() => mutex
+}
+
+// vmDone returns the channel closed when the context of the run is done or
+// nil if the VM runs without a context.
+func vmDone(vm *tengo.VM) <-chan struct{} {
+	if vm == nil || vm.Context() == nil || vm.Context().Context == nil {
+		return nil
+	}
+	return vm.Context().Done()
+}
+
+func syncWaitGroup(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	var (
+		lock  sync.Mutex
+		count int64
+		zero  = make(chan struct{})
+	)
+	close(zero)
+
+	add := func(delta int64) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if count+delta < 0 {
+			return errors.New("negative wait group counter")
+		}
+		if count == 0 && delta > 0 {
+			zero = make(chan struct{})
+		}
+		count += delta
+		if count == 0 && delta < 0 {
+			close(zero)
+		}
+		return nil
+	}
+
+	return &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			// add([delta]) => undefined
+			"add": &tengo.UserFunction{
+				Name: "add",
+				Value: func(args ...tengo.Object) (tengo.Object, error) {
+					delta := int64(1)
+					switch len(args) {
+					case 0:
+					case 1:
+						n, ok := tengo.ToInt64(args[0])
+						if !ok {
+							return nil, tengo.ErrInvalidArgumentType{
+								Name:     "first",
+								Expected: "int(compatible)",
+								Found:    args[0].TypeName(),
+							}
+						}
+						delta = n
+					default:
+						return nil, tengo.ErrWrongNumArguments
+					}
+					if err := add(delta); err != nil {
+						return nil, err
+					}
+					return tengo.UndefinedValue, nil
+				},
+			},
+			// done() => undefined
+			"done": &tengo.UserFunction{
+				Name: "done",
+				Value: func(args ...tengo.Object) (tengo.Object, error) {
+					if len(args) != 0 {
+						return nil, tengo.ErrWrongNumArguments
+					}
+					if err := add(-1); err != nil {
+						return nil, err
+					}
+					return tengo.UndefinedValue, nil
+				},
+			},
+			// wait() => undefined
+			"wait": &tengo.UserFunctionCtx{
+				Name: "wait",
+				Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+					if len(ctx.Args) != 0 {
+						return nil, tengo.ErrWrongNumArguments
+					}
+					lock.Lock()
+					ch := zero
+					lock.Unlock()
+					select {
+					case <-ch:
+						return tengo.UndefinedValue, nil
+					case <-vmDone(ctx.VM):
+						return nil, ctx.VM.Context().Err()
+					}
+				},
+			},
+		},
+	}, nil
+}
+
+func syncMutex(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	sem := make(chan struct{}, 1)
+
+	return &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			// lock() => undefined
+			"lock": &tengo.UserFunctionCtx{
+				Name: "lock",
+				Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+					if len(ctx.Args) != 0 {
+						return nil, tengo.ErrWrongNumArguments
+					}
+					select {
+					case sem <- struct{}{}:
+						return tengo.UndefinedValue, nil
+					case <-vmDone(ctx.VM):
+						return nil, ctx.VM.Context().Err()
+					}
+				},
+			},
+			// unlock() => undefined
+			"unlock": &tengo.UserFunction{
+				Name: "unlock",
+				Value: func(args ...tengo.Object) (tengo.Object, error) {
+					if len(args) != 0 {
+						return nil, tengo.ErrWrongNumArguments
+					}
+					select {
+					case <-sem:
+						return tengo.UndefinedValue, nil
+					default:
+						return nil, errors.New("unlock of unlocked mutex")
+					}
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,66 @@
+package stdlib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/require"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+func TestSync(t *testing.T) {
+	expect(t, `
+sync := import("sync")
+wg := sync.wait_group()
+mu := sync.mutex()
+counter := chan(1)
+counter.send(0)
+for i := 0; i < 10; i++ {
+	wg.add()
+	go(func() {
+		for j := 0; j < 100; j++ {
+			mu.lock()
+			counter.send(counter.recv() + 1)
+			mu.unlock()
+		}
+		wg.done()
+	})
+}
+wg.wait()
+out := counter.recv()`, int64(1000))
+
+	expect(t, `
+sync := import("sync")
+wg := sync.wait_group()
+wg.wait()
+wg.add(2)
+wg.done()
+wg.done()
+wg.wait()
+out := undefined
+try { wg.done() } catch e { out = e.value }`, "negative wait group counter")
+
+	s := tengo.NewScript([]byte(`
+sync := import("sync")
+wg := sync.wait_group()
+wg.add(1)
+wg.wait()`))
+	s.SetImports(stdlib.GetModuleMap("sync"))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, c.RunContext(ctx))
+
+	s = tengo.NewScript([]byte(`
+sync := import("sync")
+out := undefined
+try { sync.mutex().unlock() } catch e { out = e.value }`))
+	s.SetImports(stdlib.GetModuleMap("sync"))
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, "unlock of unlocked mutex", c.Get("out").Value())
+}
@@ -0,0 +1,243 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/d5/tengo/v2"
+)
+
+var testingModule = map[string]tengo.Object{
+	"equal": &tengo.UserFunction{
+		Name:  "equal",
+		Value: testingEqual,
+	}, // equal(actual, expected, msg) => undefined/error
+	"not_equal": &tengo.UserFunction{
+		Name:  "not_equal",
+		Value: testingNotEqual,
+	}, // not_equal(actual, unexpected, msg) => undefined/error
+	"is_true": &tengo.UserFunction{
+		Name:  "is_true",
+		Value: testingIsTrue,
+	}, // is_true(value, msg) => undefined/error
+	"is_false": &tengo.UserFunction{
+		Name:  "is_false",
+		Value: testingIsFalse,
+	}, // is_false(value, msg) => undefined/error
+	"is_error": &tengo.UserFunction{
+		Name:  "is_error",
+		Value: testingIsError,
+	}, // is_error(value, msg) => undefined/error
+	"no_error": &tengo.UserFunction{
+		Name:  "no_error",
+		Value: testingNoError,
+	}, // no_error(value, msg) => undefined/error
+	"is_undefined": &tengo.UserFunction{
+		Name:  "is_undefined",
+		Value: testingIsUndefined,
+	}, // is_undefined(value, msg) => undefined/error
+	"contains": &tengo.UserFunction{
+		Name:  "contains",
+		Value: testingContains,
+	}, // contains(container, element, msg) => undefined/error
+	"raises": &tengo.UserFunctionCtx{
+		Name:  "raises",
+		Value: testingRaises,
+	}, // raises(fn, msg) => string/error
+	"fail": &tengo.UserFunction{
+		Name:  "fail",
+		Value: testingFail,
+	}, // fail(msg) => error
+}
+
+// testingArgs checks the number of the arguments of an assertion. The
+// assertions take n arguments and an optional message.
+func testingArgs(args []tengo.Object, n int) error {
+	if len(args) < n || len(args) > n+1 {
+		return tengo.ErrWrongNumArguments
+	}
+	return nil
+}
+
+// testingFailure returns the error of a failed assertion. The optional
+// message of the assertion is the n-th argument.
+func testingFailure(
+	args []tengo.Object,
+	n int,
+	format string,
+	a ...interface{},
+) error {
+	msg := fmt.Sprintf(format, a...)
+	if len(args) > n {
+		if s, ok := tengo.ToString(args[n]); ok {
+			msg = s + ": " + msg
+		}
+	}
+	return errors.New(msg)
+}
+
+func testingEqual(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 2); err != nil {
+		return nil, err
+	}
+	if !args[0].Equals(args[1]) {
+		return nil, testingFailure(args, 2, "expected %s, got %s",
+			args[1].String(), args[0].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingNotEqual(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 2); err != nil {
+		return nil, err
+	}
+	if args[0].Equals(args[1]) {
+		return nil, testingFailure(args, 2, "expected not %s",
+			args[1].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingIsTrue(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 1); err != nil {
+		return nil, err
+	}
+	if args[0].IsFalsy() {
+		return nil, testingFailure(args, 1, "expected truthy value, got %s",
+			args[0].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingIsFalse(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 1); err != nil {
+		return nil, err
+	}
+	if !args[0].IsFalsy() {
+		return nil, testingFailure(args, 1, "expected falsy value, got %s",
+			args[0].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingIsError(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 1); err != nil {
+		return nil, err
+	}
+	if _, ok := args[0].(*tengo.Error); !ok {
+		return nil, testingFailure(args, 1, "expected error, got %s",
+			args[0].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingNoError(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 1); err != nil {
+		return nil, err
+	}
+	if _, ok := args[0].(*tengo.Error); ok {
+		return nil, testingFailure(args, 1, "unexpected %s",
+			args[0].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingIsUndefined(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 1); err != nil {
+		return nil, err
+	}
+	if args[0] != tengo.UndefinedValue {
+		return nil, testingFailure(args, 1, "expected undefined, got %s",
+			args[0].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingContains(args ...tengo.Object) (ret tengo.Object, err error) {
+	if err := testingArgs(args, 2); err != nil {
+		return nil, err
+	}
+
+	var found bool
+	switch container := args[0].(type) {
+	case *tengo.String:
+		s, ok := tengo.ToString(args[1])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "element",
+				Expected: "string(compatible)",
+				Found:    args[1].TypeName(),
+			}
+		}
+		found = strings.Contains(container.Value, s)
+	case *tengo.Array:
+		found = testingArrayContains(container.Value, args[1])
+	case *tengo.ImmutableArray:
+		found = testingArrayContains(container.Value, args[1])
+	case *tengo.Map:
+		_, found = container.Value[testingKey(args[1])]
+	case *tengo.ImmutableMap:
+		_, found = container.Value[testingKey(args[1])]
+	default:
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "container",
+			Expected: "string/array/map",
+			Found:    args[0].TypeName(),
+		}
+	}
+	if !found {
+		return nil, testingFailure(args, 2, "%s does not contain %s",
+			args[0].String(), args[1].String())
+	}
+	return tengo.UndefinedValue, nil
+}
+
+func testingArrayContains(arr []tengo.Object, element tengo.Object) bool {
+	for _, e := range arr {
+		if e.Equals(element) {
+			return true
+		}
+	}
+	return false
+}
+
+func testingKey(key tengo.Object) string {
+	s, _ := tengo.ToString(key)
+	return s
+}
+
+func testingRaises(ctx *tengo.CallContext) (ret tengo.Object, err error) {
+	args := ctx.Args
+	if err := testingArgs(args, 1); err != nil {
+		return nil, err
+	}
+	if !args[0].CanCall() {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "fn",
+			Expected: "callable",
+			Found:    args[0].TypeName(),
+		}
+	}
+	_, err = args[0].Call(&tengo.CallContext{VM: ctx.VM})
+	if err == nil {
+		return nil, testingFailure(args, 1, "expected runtime error")
+	}
+	// the message of the error without the stack trace
+	for e := errors.Unwrap(err); e != nil; e = errors.Unwrap(e) {
+		err = e
+	}
+	return &tengo.String{Value: err.Error()}, nil
+}
+
+func testingFail(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) > 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	if len(args) == 1 {
+		if s, ok := tengo.ToString(args[0]); ok {
+			return nil, errors.New(s)
+		}
+	}
+	return nil, errors.New("failed")
+}
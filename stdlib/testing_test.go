@@ -0,0 +1,61 @@
+package stdlib_test
+
+import (
+	"testing"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/require"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+func TestTesting(t *testing.T) {
+	u := tengo.UndefinedValue
+	module(t, "testing").call("equal", 1, 1).expect(u)
+	module(t, "testing").call("equal", ARR{1, "a"}, ARR{1, "a"}).expect(u)
+	module(t, "testing").call("equal", 1, 2).expectError()
+	module(t, "testing").call("equal", 1).expectError()
+	module(t, "testing").call("not_equal", 1, 2).expect(u)
+	module(t, "testing").call("not_equal", 1, 1, "msg").expectError()
+	module(t, "testing").call("is_true", 1).expect(u)
+	module(t, "testing").call("is_true", "").expectError()
+	module(t, "testing").call("is_false", "").expect(u)
+	module(t, "testing").call("is_false", 1).expectError()
+	module(t, "testing").call("is_error", &tengo.Error{}).expect(u)
+	module(t, "testing").call("is_error", 1).expectError()
+	module(t, "testing").call("no_error", 1).expect(u)
+	module(t, "testing").call("no_error", &tengo.Error{}).expectError()
+	module(t, "testing").call("is_undefined", u).expect(u)
+	module(t, "testing").call("is_undefined", 0).expectError()
+	module(t, "testing").call("contains", "foobar", "oba").expect(u)
+	module(t, "testing").call("contains", "foobar", "x").expectError()
+	module(t, "testing").call("contains", ARR{1, 2}, 2).expect(u)
+	module(t, "testing").call("contains", ARR{1, 2}, 3).expectError()
+	module(t, "testing").call("contains", MAP{"a": 1}, "a").expect(u)
+	module(t, "testing").call("contains", MAP{"a": 1}, "b").expectError()
+	module(t, "testing").call("contains", 1, 1).expectError()
+	module(t, "testing").call("fail").expectError()
+
+	expectRuntimeError(t, `import("testing").equal(1 + 1, 3)`,
+		"expected 3, got 2")
+	expectRuntimeError(t, `import("testing").equal("a", "b", "strings")`,
+		`strings: expected "b", got "a"`)
+	expectRuntimeError(t, `import("testing").contains([1], 2, "x")`,
+		"x: [1] does not contain 2")
+	expectRuntimeError(t, `import("testing").fail("oops")`, "oops")
+	expectRuntimeError(t, `import("testing").raises(func() {})`,
+		"expected runtime error")
+
+	expect(t, `
+assert := import("testing")
+out := assert.raises(func() { return 1 + "a" })
+`, "invalid operation: int + string")
+}
+
+func expectRuntimeError(t *testing.T, input, expected string) {
+	s := tengo.NewScript([]byte(input))
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	_, err := s.Run()
+	require.Error(t, err)
+	require.Equal(t, "Runtime Error: "+expected+"\n\tat (main):1:1",
+		err.Error())
+}
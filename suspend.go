@@ -0,0 +1,762 @@
+package tengo
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"sort"
+	"sync/atomic"
+
+	"github.com/d5/tengo/v2/parser"
+)
+
+// ErrNotSuspendable is an error where a Go function requests the suspension
+// of a VM running a generator or a compiled function called from Go.
+var ErrNotSuspendable = errors.New("vm cannot be suspended in a nested run")
+
+// ErrNotSuspended is an error where a suspension is resumed or encoded after
+// the VM was resumed.
+var ErrNotSuspended = errors.New("vm is not suspended")
+
+// ErrStateMismatch is an error where the state of a suspended VM is restored
+// for a different script.
+var ErrStateMismatch = errors.New("state does not match the script")
+
+// Suspension is returned as the error of a run when a Go function suspended
+// the VM using CallContext.Suspend. The VM keeps its state until it is
+// resumed and the state can be encoded to resume the run later in another
+// process.
+type Suspension struct {
+	// Value is the value the Go function suspended the VM with.
+	Value Object
+
+	vm     *VM
+	c      *Compiled
+	inputs []Object // values set by the host before the run
+	method bool     // the result of the call replaces the receiver
+}
+
+func (s *Suspension) Error() string {
+	return "vm suspended"
+}
+
+// Resume continues the suspended run. The value is the result of the call
+// of the Go function that suspended the VM. Like the runs, Resume returns a
+// runtime error or another Suspension if the VM is suspended again. The
+// limits of the VM apply to each resumed run separately.
+func (s *Suspension) Resume(ctx context.Context, value interface{}) error {
+	obj, err := FromInterface(value)
+	if err != nil {
+		return err
+	}
+	if s.c != nil {
+		s.c.lock.Lock()
+		defer s.c.lock.Unlock()
+
+		if s.vm.suspension != s {
+			return ErrNotSuspended
+		}
+		s.c.resetLimits(s.vm)
+		err := s.c.runContext(ctx, s.vm, func() error {
+			return s.vm.resumeContext(ctx, obj)
+		})
+		if next, ok := err.(*Suspension); ok {
+			next.inputs = s.inputs
+		}
+		return err
+	}
+	if s.vm.suspension != s {
+		return ErrNotSuspended
+	}
+	return s.vm.resumeContext(ctx, obj)
+}
+
+// Encode writes the state of the suspended VM to the writer: the globals,
+// the stack, the call frames and the free variables. The Go functions and
+// the compiled functions are encoded as references to the builtin functions,
+// the constants and the values set to the global variables before the run.
+// So the state can only be restored for the same script with the same
+// values. The objects of other types cannot be encoded. The iterators of the
+// for-in loops are restored with the elements they had when the VM was
+// suspended.
+func (s *Suspension) Encode(w io.Writer) error {
+	if s.c != nil {
+		s.c.lock.RLock()
+		defer s.c.lock.RUnlock()
+	}
+	if s.vm.suspension != s {
+		return ErrNotSuspended
+	}
+	state, err := s.vm.encodeState(s.inputs)
+	if err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// suspend suspends the VM after the Go function being called returns.
+func (v *VM) suspend(value Object) error {
+	if v.nested || v.gen != nil || atomic.LoadInt64(&v.calls) > 0 {
+		return ErrNotSuspendable
+	}
+	if value == nil {
+		value = UndefinedValue
+	}
+	v.suspension = &Suspension{Value: value, vm: v}
+	return nil
+}
+
+// resumeContext continues the suspended run with the value as the result of
+// the suspended call.
+func (v *VM) resumeContext(ctx context.Context, value Object) error {
+	old := v.context
+	defer func() {
+		v.context = old
+	}()
+	v.context = &VmContext{Context: ctx, VM: v}
+
+	if v.suspension.method {
+		v.stack[v.sp-1] = value
+	} else {
+		v.stack[v.sp] = value
+		v.sp++
+	}
+	v.suspension = nil
+	return v.execute()
+}
+
+// vmState is the encoded state of a suspended VM. The objects are indexes of
+// Objects or -1 for nil.
+type vmState struct {
+	Checksum uint64
+	Known    int
+	Objects  []stateObject
+	Globals  []int
+	Stack    []int
+	Frames   []stateFrame
+	Handlers []stateHandler
+	IP       int
+	Value    int
+	Method   bool
+}
+
+type stateFrame struct {
+	Fn          int
+	Args        int
+	Kwargs      int
+	FreeVars    []int
+	IP          int
+	BasePointer int
+}
+
+type stateHandler struct {
+	FramesIndex int
+	SP          int
+	CatchPos    int
+}
+
+type stateKind uint8
+
+const (
+	stateKnown stateKind = iota
+	stateUndefined
+	stateDefault
+	stateBool
+	stateInt
+	stateFloat
+	stateString
+	stateChar
+	stateBytes
+	stateTime
+	stateError
+	stateArray
+	stateImmutableArray
+	stateMap
+	stateImmutableMap
+	stateFreeVar
+	stateClosure
+	stateArrayIterator
+	stateBytesIterator
+	stateMapIterator
+	stateStringIterator
+)
+
+type stateObject struct {
+	Kind  stateKind
+	Int   int64
+	Float float64
+	Str   string
+	Bytes []byte
+	Refs  []int
+	Keys  []string
+	Pos   parser.SourceFilePos
+}
+
+// knownObjects returns the objects encoded as references in the order of
+// the builtin functions, the main function, the constants and the inputs.
+// Only the Go functions, the compiled functions and the objects of other
+// types that cannot be encoded are included.
+func (v *VM) knownObjects(inputs []Object) []Object {
+	var known []Object
+	seen := make(map[Object]bool)
+	var walk func(o Object)
+	walk = func(o Object) {
+		if o == nil || !reflect.TypeOf(o).Comparable() || seen[o] {
+			return
+		}
+		seen[o] = true
+		switch o := o.(type) {
+		case *Array:
+			for _, e := range o.Value {
+				walk(e)
+			}
+		case *ImmutableArray:
+			for _, e := range o.Value {
+				walk(e)
+			}
+		case *Map:
+			walkMap(o.Value, walk)
+		case *ImmutableMap:
+			walkMap(o.Value, walk)
+		case *Undefined, *Default, *Bool, *Int, *Float, *String, *Char,
+			*Bytes, *Time, *Error:
+		default:
+			known = append(known, o)
+		}
+	}
+	for _, fn := range builtinFuncs {
+		walk(fn)
+	}
+	walk(v.bc.MainFunction)
+	for _, c := range v.bc.Constants {
+		walk(c)
+	}
+	for _, o := range inputs {
+		walk(o)
+	}
+	return known
+}
+
+func walkMap(m map[string]Object, walk func(o Object)) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		walk(m[k])
+	}
+}
+
+// checksum returns the hash of the instructions of the compiled functions
+// and the types of the constants.
+func (v *VM) checksum() uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(v.bc.MainFunction.Instructions)
+	for _, c := range v.bc.Constants {
+		_, _ = io.WriteString(h, c.TypeName())
+		if fn, ok := c.(*CompiledFunction); ok {
+			_, _ = h.Write(fn.Instructions)
+		}
+	}
+	return h.Sum64()
+}
+
+type stateEncoder struct {
+	state *vmState
+	known map[Object]int
+	fns   map[*byte]int // first instruction to the known compiled function
+	seen  map[Object]int
+}
+
+func (v *VM) encodeState(inputs []Object) (*vmState, error) {
+	known := v.knownObjects(inputs)
+	e := &stateEncoder{
+		state: &vmState{
+			Checksum: v.checksum(),
+			Known:    len(known),
+			IP:       v.ip,
+			Method:   v.suspension.method,
+		},
+		known: make(map[Object]int, len(known)),
+		fns:   make(map[*byte]int),
+		seen:  make(map[Object]int),
+	}
+	for i, o := range known {
+		e.known[o] = i
+		if fn, ok := o.(*CompiledFunction); ok && len(fn.Instructions) > 0 {
+			if _, ok := e.fns[&fn.Instructions[0]]; !ok {
+				e.fns[&fn.Instructions[0]] = i
+			}
+		}
+	}
+
+	var err error
+	if e.state.Value, err = e.encode(v.suspension.Value); err != nil {
+		return nil, err
+	}
+	if e.state.Globals, err = e.encodeAll(v.globals); err != nil {
+		return nil, err
+	}
+	if e.state.Stack, err = e.encodeAll(v.stack[:v.sp]); err != nil {
+		return nil, err
+	}
+	for i := 0; i < v.framesIndex; i++ {
+		f := &v.frames[i]
+		sf := stateFrame{IP: f.ip, BasePointer: f.basePointer}
+		if sf.Fn, err = e.encode(f.fn); err != nil {
+			return nil, err
+		}
+		if sf.Args, err = e.encode(&Array{Value: f.args.Value}); err != nil {
+			return nil, err
+		}
+		if sf.Kwargs, err = e.encode(&Map{Value: f.kwargs.Value}); err != nil {
+			return nil, err
+		}
+		for _, fv := range f.freeVars {
+			ref, err := e.encode(fv)
+			if err != nil {
+				return nil, err
+			}
+			sf.FreeVars = append(sf.FreeVars, ref)
+		}
+		e.state.Frames = append(e.state.Frames, sf)
+	}
+	for _, h := range v.handlers {
+		e.state.Handlers = append(e.state.Handlers, stateHandler{
+			FramesIndex: h.framesIndex,
+			SP:          h.sp,
+			CatchPos:    h.catchPos,
+		})
+	}
+	return e.state, nil
+}
+
+func (e *stateEncoder) encodeAll(objs []Object) ([]int, error) {
+	refs := make([]int, len(objs))
+	for i, o := range objs {
+		ref, err := e.encode(o)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+// encode adds the object to the state and returns its index. The index is
+// assigned before the elements are encoded so the cycles are preserved.
+func (e *stateEncoder) encode(o Object) (int, error) {
+	if o == nil {
+		return -1, nil
+	}
+	if !reflect.TypeOf(o).Comparable() {
+		return 0, fmt.Errorf("cannot encode %s", o.TypeName())
+	}
+	if ref, ok := e.seen[o]; ok {
+		return ref, nil
+	}
+	ref := len(e.state.Objects)
+	e.seen[o] = ref
+	e.state.Objects = append(e.state.Objects, stateObject{})
+
+	var (
+		so  stateObject
+		err error
+	)
+	if idx, ok := e.known[o]; ok {
+		so = stateObject{Kind: stateKnown, Int: int64(idx)}
+	} else {
+		so, err = e.encodeValue(o)
+		if err != nil {
+			return 0, err
+		}
+	}
+	e.state.Objects[ref] = so
+	return ref, nil
+}
+
+func (e *stateEncoder) encodeValue(o Object) (so stateObject, err error) {
+	switch o := o.(type) {
+	case *Undefined:
+		so.Kind = stateUndefined
+	case *Default:
+		so.Kind = stateDefault
+	case *Bool:
+		so.Kind = stateBool
+		if o.value {
+			so.Int = 1
+		}
+	case *Int:
+		so.Kind, so.Int = stateInt, o.Value
+	case *Float:
+		so.Kind, so.Float = stateFloat, o.Value
+	case *String:
+		so.Kind, so.Str = stateString, o.Value
+	case *Char:
+		so.Kind, so.Int = stateChar, int64(o.Value)
+	case *Bytes:
+		so.Kind, so.Bytes = stateBytes, o.Value
+	case *Time:
+		so.Kind = stateTime
+		so.Bytes, err = o.Value.MarshalBinary()
+	case *Error:
+		so.Kind, so.Pos = stateError, o.pos
+		if o.err != nil {
+			so.Int, so.Str = 1, o.err.Error()
+		}
+		so.Refs, err = e.encodeAll([]Object{o.Value})
+	case *Array:
+		so.Kind = stateArray
+		so.Refs, err = e.encodeAll(o.Value)
+	case *ImmutableArray:
+		so.Kind = stateImmutableArray
+		so.Refs, err = e.encodeAll(o.Value)
+	case *Map:
+		so.Kind = stateMap
+		so.Keys, so.Refs, err = e.encodeMap(o.Value)
+	case *ImmutableMap:
+		so.Kind = stateImmutableMap
+		so.Keys, so.Refs, err = e.encodeMap(o.Value)
+	case *ObjectPtr:
+		so.Kind = stateFreeVar
+		so.Refs, err = e.encodeAll([]Object{*o.Value})
+	case *CompiledFunction:
+		// closures share the instructions of the compiled function
+		idx, ok := -1, false
+		if len(o.Instructions) > 0 {
+			idx, ok = e.fns[&o.Instructions[0]]
+		}
+		if !ok || o.methodTarget != nil {
+			return so, fmt.Errorf("cannot encode %s", o.TypeName())
+		}
+		so.Kind, so.Int = stateClosure, int64(idx)
+		for _, fv := range o.Free {
+			ref, err := e.encode(fv)
+			if err != nil {
+				return so, err
+			}
+			so.Refs = append(so.Refs, ref)
+		}
+	case *ArrayIterator:
+		so.Kind, so.Int = stateArrayIterator, int64(o.i)
+		so.Refs, err = e.encodeAll(o.v)
+	case *BytesIterator:
+		so.Kind, so.Int, so.Bytes = stateBytesIterator, int64(o.i), o.v
+	case *MapIterator:
+		so.Kind, so.Int = stateMapIterator, int64(o.i)
+		so.Refs, err = e.encodeAll([]Object{&Map{Value: o.v}})
+		so.Keys = o.k
+	case *StringIterator:
+		so.Kind, so.Int, so.Str = stateStringIterator, int64(o.i), string(o.v)
+	default:
+		return so, fmt.Errorf("cannot encode %s", o.TypeName())
+	}
+	return so, err
+}
+
+func (e *stateEncoder) encodeMap(
+	m map[string]Object,
+) (keys []string, refs []int, err error) {
+	for k, v := range m {
+		ref, err := e.encode(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, k)
+		refs = append(refs, ref)
+	}
+	return
+}
+
+// restore decodes the state of a suspended VM read from the reader. The VM
+// must be created for the same bytecode and globals the state was encoded
+// with.
+func (v *VM) restore(r io.Reader, inputs []Object) error {
+	var state vmState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	known := v.knownObjects(inputs)
+	if state.Checksum != v.checksum() || state.Known != len(known) ||
+		len(state.Globals) > len(v.globals) ||
+		len(state.Stack) > StackSize ||
+		len(state.Frames) == 0 || len(state.Frames) > MaxFrames {
+		return ErrStateMismatch
+	}
+	// the result of the suspended call replaces the receiver on the stack
+	// or is pushed onto it
+	if state.Method && len(state.Stack) == 0 ||
+		!state.Method && len(state.Stack) == StackSize {
+		return ErrStateMismatch
+	}
+
+	d := &stateDecoder{
+		state:   &state,
+		known:   known,
+		objects: make([]Object, len(state.Objects)),
+	}
+	if err := d.decodeAll(); err != nil {
+		return err
+	}
+
+	value, err := d.get(state.Value)
+	if err != nil {
+		return err
+	}
+	for i, ref := range state.Globals {
+		if v.globals[i], err = d.get(ref); err != nil {
+			return err
+		}
+	}
+	for i, ref := range state.Stack {
+		if v.stack[i], err = d.get(ref); err != nil {
+			return err
+		}
+	}
+	for i, sf := range state.Frames {
+		f := &v.frames[i]
+		o, err := d.get(sf.Fn)
+		if err != nil {
+			return err
+		}
+		fn, ok := o.(*CompiledFunction)
+		if !ok || sf.IP < -1 || sf.IP >= len(fn.Instructions) ||
+			sf.BasePointer < 0 || sf.BasePointer > len(state.Stack) {
+			return ErrStateMismatch
+		}
+		o, err = d.get(sf.Args)
+		if err != nil {
+			return err
+		}
+		args, ok := o.(*Array)
+		if !ok {
+			return ErrStateMismatch
+		}
+		o, err = d.get(sf.Kwargs)
+		if err != nil {
+			return err
+		}
+		kwargs, ok := o.(*Map)
+		if !ok {
+			return ErrStateMismatch
+		}
+		freeVars, err := d.freeVars(sf.FreeVars)
+		if err != nil {
+			return err
+		}
+		*f = frame{
+			fn:          fn,
+			freeVars:    freeVars,
+			ip:          sf.IP,
+			basePointer: sf.BasePointer,
+		}
+		f.args.Value = args.Value
+		f.kwargs.Value = kwargs.Value
+	}
+	insts := v.frames[len(state.Frames)-1].fn.Instructions
+	if state.IP < -1 || state.IP >= len(insts) {
+		return ErrStateMismatch
+	}
+	v.handlers = v.handlers[:0]
+	for _, h := range state.Handlers {
+		if h.FramesIndex < 1 || h.FramesIndex > len(state.Frames) ||
+			h.SP < 0 || h.SP >= StackSize || h.CatchPos < 0 ||
+			h.CatchPos >= len(v.frames[h.FramesIndex-1].fn.Instructions) {
+			return ErrStateMismatch
+		}
+		v.handlers = append(v.handlers, tryHandler{
+			framesIndex: h.FramesIndex,
+			sp:          h.SP,
+			catchPos:    h.CatchPos,
+		})
+	}
+
+	v.sp = len(state.Stack)
+	v.framesIndex = len(state.Frames)
+	v.curFrame = &v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = state.IP
+	v.suspension = &Suspension{Value: value, vm: v, method: state.Method}
+	return nil
+}
+
+type stateDecoder struct {
+	state   *vmState
+	known   []Object
+	objects []Object
+}
+
+func (d *stateDecoder) get(ref int) (Object, error) {
+	if ref == -1 {
+		return nil, nil
+	}
+	if ref < 0 || ref >= len(d.objects) {
+		return nil, ErrStateMismatch
+	}
+	return d.objects[ref], nil
+}
+
+// decodeAll creates the objects first and sets their elements next so the
+// cycles are restored.
+func (d *stateDecoder) decodeAll() error {
+	for i, so := range d.state.Objects {
+		var o Object
+		switch so.Kind {
+		case stateKnown:
+			if so.Int < 0 || so.Int >= int64(len(d.known)) {
+				return ErrStateMismatch
+			}
+			o = d.known[so.Int]
+		case stateUndefined:
+			o = UndefinedValue
+		case stateDefault:
+			o = DefaultValue
+		case stateBool:
+			o = FalseValue
+			if so.Int != 0 {
+				o = TrueValue
+			}
+		case stateInt:
+			o = &Int{Value: so.Int}
+		case stateFloat:
+			o = &Float{Value: so.Float}
+		case stateString:
+			o = &String{Value: so.Str}
+		case stateChar:
+			o = &Char{Value: rune(so.Int)}
+		case stateBytes:
+			o = &Bytes{Value: so.Bytes}
+		case stateTime:
+			t := &Time{}
+			if err := t.Value.UnmarshalBinary(so.Bytes); err != nil {
+				return err
+			}
+			o = t
+		case stateError:
+			e := &Error{pos: so.Pos}
+			if so.Int != 0 {
+				e.err = errors.New(so.Str)
+			}
+			o = e
+		case stateArray:
+			o = &Array{Value: make([]Object, len(so.Refs))}
+		case stateImmutableArray:
+			o = &ImmutableArray{Value: make([]Object, len(so.Refs))}
+		case stateMap:
+			o = &Map{Value: make(map[string]Object, len(so.Refs))}
+		case stateImmutableMap:
+			o = &ImmutableMap{Value: make(map[string]Object, len(so.Refs))}
+		case stateFreeVar:
+			var value Object
+			o = &ObjectPtr{Value: &value}
+		case stateClosure:
+			if so.Int < 0 || so.Int >= int64(len(d.known)) {
+				return ErrStateMismatch
+			}
+			fn, ok := d.known[so.Int].(*CompiledFunction)
+			if !ok {
+				return ErrStateMismatch
+			}
+			o = fn.closure(nil)
+		case stateArrayIterator:
+			o = &ArrayIterator{
+				v: make([]Object, len(so.Refs)),
+				i: int(so.Int),
+				l: len(so.Refs),
+			}
+		case stateBytesIterator:
+			o = &BytesIterator{v: so.Bytes, i: int(so.Int), l: len(so.Bytes)}
+		case stateMapIterator:
+			o = &MapIterator{k: so.Keys, i: int(so.Int), l: len(so.Keys)}
+		case stateStringIterator:
+			runes := []rune(so.Str)
+			o = &StringIterator{v: runes, i: int(so.Int), l: len(runes)}
+		default:
+			return ErrStateMismatch
+		}
+		d.objects[i] = o
+	}
+
+	for i, so := range d.state.Objects {
+		elems := make([]Object, len(so.Refs))
+		for j, ref := range so.Refs {
+			o, err := d.get(ref)
+			if err != nil {
+				return err
+			}
+			elems[j] = o
+		}
+		switch o := d.objects[i].(type) {
+		case *Error:
+			if len(elems) != 1 {
+				return ErrStateMismatch
+			}
+			o.Value = elems[0]
+		case *Array:
+			copy(o.Value, elems)
+		case *ImmutableArray:
+			copy(o.Value, elems)
+		case *Map:
+			if len(so.Keys) != len(elems) {
+				return ErrStateMismatch
+			}
+			for j, k := range so.Keys {
+				o.Value[k] = elems[j]
+			}
+		case *ImmutableMap:
+			if len(so.Keys) != len(elems) {
+				return ErrStateMismatch
+			}
+			for j, k := range so.Keys {
+				o.Value[k] = elems[j]
+			}
+		case *ObjectPtr:
+			if len(elems) != 1 {
+				return ErrStateMismatch
+			}
+			*o.Value = elems[0]
+		case *CompiledFunction:
+			if so.Kind != stateClosure {
+				break
+			}
+			free, err := d.freeVars(so.Refs)
+			if err != nil {
+				return err
+			}
+			o.Free = free
+		case *ArrayIterator:
+			copy(o.v, elems)
+		case *MapIterator:
+			if len(elems) != 1 {
+				return ErrStateMismatch
+			}
+			m, ok := elems[0].(*Map)
+			if !ok {
+				return ErrStateMismatch
+			}
+			o.v = m.Value
+		}
+	}
+	return nil
+}
+
+func (d *stateDecoder) freeVars(refs []int) ([]*ObjectPtr, error) {
+	var free []*ObjectPtr
+	for _, ref := range refs {
+		o, err := d.get(ref)
+		if err != nil {
+			return nil, err
+		}
+		fv, ok := o.(*ObjectPtr)
+		if !ok {
+			return nil, ErrStateMismatch
+		}
+		free = append(free, fv)
+	}
+	return free, nil
+}
@@ -3,6 +3,7 @@ package tengo
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
 	"time"
 )
@@ -239,12 +240,37 @@ func ToInterface(o Object) (res interface{}) {
 		res = errors.New(o.String())
 	case *Undefined:
 		res = nil
+	case *Instance:
+		if o.Type != nil && o.Type.goType != nil {
+			if v, err := toReflect(o, o.Type.goType, nil); err == nil {
+				return v.Interface()
+			}
+		}
+		return o
 	case Object:
 		return o
 	}
 	return
 }
 
+// Decode decodes the object to the Go value pointed to by dst. The maps and
+// the instances are decoded to the structs by the names of the fields, see
+// FromInterface for the names. They are also decoded to the maps with string
+// keys, or with bool and number keys parsed by strconv. The arrays are decoded
+// to the slices and the arrays. The ints and the duration strings are decoded
+// to time.Duration, the times to time.Time. The fields of the structs and the
+// entries of the maps not in the object are left unchanged. Undefined sets the
+// zero value. It returns a *DecodeError if a value in the object cannot be decoded
+// to the type.
+func Decode(o Object, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("decode to non-pointer or nil: %T", dst)
+	}
+	d := &decoder{}
+	return d.decode(o, v.Elem(), "")
+}
+
 // FromInterface will attempt to convert an interface{} v to a Tengo Object
 func FromInterface(v interface{}) (Object, error) {
 	switch v := v.(type) {
@@ -310,5 +336,5 @@ func FromInterface(v interface{}) (Object, error) {
 	case CallableFuncCtx:
 		return &UserFunctionCtx{Value: v}, nil
 	}
-	return nil, fmt.Errorf("cannot convert to object: %T", v)
+	return fromReflect(reflect.ValueOf(v), nil)
 }
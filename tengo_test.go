@@ -1,6 +1,8 @@
 package tengo_test
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -135,3 +137,202 @@ func makeInstruction(
 	inst := tengo.MakeInstruction(opcode, operands...)
 	require.Equal(t, expected, inst)
 }
+
+type reflectAddress struct {
+	City string `tengo:"city" json:"city,omitempty"`
+	Zip  uint16 `tengo:"zip"`
+}
+
+type reflectUser struct {
+	Name     string            `tengo:"name"`
+	Age      int8              `tengo:"age"`
+	Scores   []float32         `tengo:"scores"`
+	Labels   map[string]string `tengo:"labels"`
+	Address  *reflectAddress   `tengo:"address"`
+	Friend   *reflectUser      `tengo:"friend"`
+	Password string            `tengo:"-"`
+	internal int
+}
+
+func (u *reflectUser) Greet(greeting string) string {
+	return greeting + ", " + u.Name
+}
+
+func (u *reflectUser) Birthday() (int8, error) {
+	if u.Age == 127 {
+		return 0, errors.New("too old")
+	}
+	u.Age++
+	return u.Age, nil
+}
+
+type reflectClient struct {
+	data map[string]string
+}
+
+type reflectService struct {
+	Name   string `tengo:"name"`
+	client *reflectClient
+	hits   int
+}
+
+func (s *reflectService) Fetch(key string) string {
+	s.hits++
+	return s.client.data[key]
+}
+
+func (s *reflectService) Hits() int {
+	return s.hits
+}
+
+func (s *reflectService) Rename(name string) {
+	s.Name = name
+}
+
+func (s *reflectService) Fail() {
+	panic("failed")
+}
+
+func TestReflect(t *testing.T) {
+	u := &reflectUser{
+		Name:     "foo",
+		Age:      20,
+		Scores:   []float32{1.5, 2},
+		Labels:   map[string]string{"a": "b"},
+		Address:  &reflectAddress{City: "bar", Zip: 12345},
+		Password: "secret",
+		internal: 1,
+	}
+	u.Friend = u
+
+	o, err := tengo.FromInterface(u)
+	require.NoError(t, err)
+	inst, ok := o.(*tengo.Instance)
+	require.True(t, ok, o)
+	require.Equal(t, "reflectUser", inst.Type.Name)
+	require.Equal(t, "foo", inst.Values["name"].(*tengo.String).Value)
+	require.Equal(t, int64(20), inst.Values["age"].(*tengo.Int).Value)
+	require.Equal(t, 2, len(inst.Values["scores"].(*tengo.Array).Value))
+	require.Equal(t, "b",
+		inst.Values["labels"].(*tengo.Map).Value["a"].(*tengo.String).Value)
+	require.True(t, inst.Values["friend"] == inst)
+	_, ok = inst.Values["Password"]
+	require.False(t, ok)
+	_, ok = inst.Values["internal"]
+	require.False(t, ok)
+	addr := inst.Values["address"].(*tengo.Instance)
+	require.Equal(t, "city,omitempty",
+		addr.Type.Fields.Value["city"].Tags["json"].(*tengo.String).Value)
+
+	// back to the pointer the instance was converted from
+	inst.Values["age"] = &tengo.Int{Value: 21}
+	u2, ok := tengo.ToInterface(o).(*reflectUser)
+	require.True(t, ok)
+	require.True(t, u2 == u)
+	require.True(t, u.Age == 21 && u.Password == "secret" && u.internal == 1)
+	a, ok := tengo.ToInterface(&tengo.Instance{
+		Type:   addr.Type,
+		Values: map[string]tengo.Object{"zip": &tengo.Int{Value: 1}},
+	}).(*reflectAddress)
+	require.True(t, ok)
+	require.True(t, a.Zip == 1)
+	_, ok = tengo.ToInterface(&tengo.Instance{
+		Type:   addr.Type,
+		Values: map[string]tengo.Object{"zip": &tengo.Int{Value: -1}},
+	}).(*tengo.Instance)
+	require.True(t, ok)
+
+	// typed values and functions in scripts
+	s := tengo.NewScript([]byte(`
+greeting := user.Greet("hello")
+age := user.Birthday()
+city := user.address.city
+user.scores = append(user.scores, 3)
+sum := add(1, 2, 3)
+err := is_error(div(1, 0))
+addr := Address(city="baz", zip=1)
+`))
+	require.NoError(t, s.Add("user", &reflectUser{Name: "foo", Age: 20,
+		Address: &reflectAddress{City: "bar"}}))
+	require.NoError(t, s.Add("add", func(a int, b ...int64) int64 {
+		for _, n := range b {
+			a += int(n)
+		}
+		return int64(a)
+	}))
+	require.NoError(t, s.Add("div", func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	}))
+	o, err = tengo.FromInterface(reflectAddress{})
+	require.NoError(t, err)
+	require.NoError(t, s.Add("Address", o.(*tengo.Instance).Type))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, "hello, foo", c.Get("greeting").String())
+	require.Equal(t, int64(21), c.Get("age").Value())
+	require.Equal(t, "bar", c.Get("city").String())
+	require.Equal(t, int64(6), c.Get("sum").Value())
+	require.True(t, c.Get("err").Bool())
+	user := c.Get("user").Value().(*reflectUser)
+	require.True(t, user.Age == 21)
+	require.True(t, reflect.DeepEqual([]float32{3}, user.Scores), user.Scores)
+	require.True(t, reflect.DeepEqual(reflectAddress{City: "baz", Zip: 1},
+		c.Get("addr").Value()), c.Get("addr").Value())
+
+	// the keys of the maps are formatted by strconv
+	o, err = tengo.FromInterface(map[int]string{1: "a", -2: "b"})
+	require.NoError(t, err)
+	require.Equal(t, &tengo.Map{Value: map[string]tengo.Object{
+		"1":  &tengo.String{Value: "a"},
+		"-2": &tengo.String{Value: "b"},
+	}}, o)
+	o, err = tengo.FromInterface(map[bool]uint{true: 1})
+	require.NoError(t, err)
+	require.Equal(t, &tengo.Map{Value: map[string]tengo.Object{
+		"true": &tengo.Int{Value: 1},
+	}}, o)
+	o, err = tengo.FromInterface(map[float32]int{1.5: 1})
+	require.NoError(t, err)
+	require.Equal(t, &tengo.Map{Value: map[string]tengo.Object{
+		"1.5": &tengo.Int{Value: 1},
+	}}, o)
+	_, err = tengo.FromInterface(map[[2]int]string{})
+	require.Error(t, err)
+}
+
+func TestReflect_Methods(t *testing.T) {
+	// the methods are called on the pointer added to the script
+	svc := &reflectService{
+		Name:   "a",
+		client: &reflectClient{data: map[string]string{"k": "v"}},
+	}
+	s := tengo.NewScript([]byte(`
+value := svc.Fetch("k")
+svc.Fetch("x")
+hits := svc.Hits()
+svc.name = "b"
+svc.Rename(svc.name + "!")
+name := svc.name
+copied := copy(svc)
+copied.Fetch("k")
+`))
+	require.NoError(t, s.Add("svc", svc))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, "v", c.Get("value").String())
+	require.Equal(t, int64(2), c.Get("hits").Value())
+	require.Equal(t, "b!", c.Get("name").String())
+	require.True(t, svc.hits == 2 && svc.Name == "b!", svc)
+	require.True(t, c.Get("svc").Value() == svc)
+
+	// panics of the Go functions are runtime errors
+	s = tengo.NewScript([]byte(`svc.Fail()`))
+	require.NoError(t, s.Add("svc", svc))
+	_, err = s.Run()
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(),
+		"panic in Go function: failed"), err.Error())
+}
@@ -0,0 +1,125 @@
+package tester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteReport writes the result in the format of "go test". The passed and
+// the skipped tests are written only if verbose is true.
+func (r *FileResult) WriteReport(w io.Writer, verbose bool) error {
+	var b strings.Builder
+	if r.Err != nil {
+		b.WriteString(r.Err.Error())
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "FAIL\t%s\t[setup failed]\n", r.File)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	for _, t := range r.Tests {
+		if verbose {
+			writeRun(&b, t)
+		}
+		writeResult(&b, t, 0, verbose)
+	}
+	status := "ok  "
+	if r.Failed() {
+		status = "FAIL"
+	}
+	fmt.Fprintf(&b, "%s\t%s\t%.3fs\n", status, r.File, r.Elapsed.Seconds())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeRun(b *strings.Builder, t *Result) {
+	fmt.Fprintf(b, "=== RUN   %s\n", t.Name)
+	for _, sub := range t.Subtests {
+		writeRun(b, sub)
+	}
+}
+
+func writeResult(b *strings.Builder, t *Result, level int, verbose bool) {
+	if t.Status != StatusFail && !verbose {
+		return
+	}
+	indent := strings.Repeat("    ", level)
+	fmt.Fprintf(b, "%s--- %s: %s (%.2fs)\n", indent,
+		strings.ToUpper(t.Status), t.Name, t.Elapsed.Seconds())
+	for _, out := range t.Output {
+		fmt.Fprintf(b, "%s    %s\n", indent, out)
+	}
+	for _, sub := range t.Subtests {
+		writeResult(b, sub, level+1, verbose)
+	}
+}
+
+// Event is a test event in the JSON output. It has the same fields as the
+// events of "go test -json" with the file name as the package.
+type Event struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string   `json:",omitempty"`
+	Elapsed *float64 `json:",omitempty"`
+	Output  string   `json:",omitempty"`
+}
+
+// WriteJSON writes the result as a stream of JSON events.
+func (r *FileResult) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	now := time.Now()
+	emit := func(action, test, output string, elapsed *time.Duration) error {
+		e := Event{
+			Time:    now,
+			Action:  action,
+			Package: r.File,
+			Test:    test,
+			Output:  output,
+		}
+		if elapsed != nil {
+			sec := elapsed.Seconds()
+			e.Elapsed = &sec
+		}
+		return enc.Encode(e)
+	}
+
+	var writeTest func(t *Result, level int) error
+	writeTest = func(t *Result, level int) error {
+		if err := emit("run", t.Name, "", nil); err != nil {
+			return err
+		}
+		indent := strings.Repeat("    ", level+1)
+		for _, out := range t.Output {
+			if err := emit("output", t.Name, indent+out+"\n",
+				nil); err != nil {
+				return err
+			}
+		}
+		for _, sub := range t.Subtests {
+			if err := writeTest(sub, level+1); err != nil {
+				return err
+			}
+		}
+		return emit(t.Status, t.Name, "", &t.Elapsed)
+	}
+
+	if r.Err != nil {
+		if err := emit("output", "", r.Err.Error()+"\n", nil); err != nil {
+			return err
+		}
+	}
+	for _, t := range r.Tests {
+		if err := writeTest(t, 0); err != nil {
+			return err
+		}
+	}
+	status := StatusPass
+	if r.Failed() {
+		status = StatusFail
+	}
+	return emit(status, "", "", &r.Elapsed)
+}
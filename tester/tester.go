@@ -0,0 +1,380 @@
+// Package tester runs the test functions defined in Tengo test files.
+//
+// The test files are the source files with "_test.tengo" suffix. A test
+// function is a global variable whose name starts with "test_" and whose
+// value is a function. The test functions are called in the order of their
+// definitions after the file is executed, with a test object "t" as the
+// argument:
+//
+//	assert := import("testing")
+//
+//	test_add := func(t) {
+//		assert.equal(1 + 2, 3)
+//		t.run("negative", func(t) {
+//			assert.equal(-1 + -2, -3)
+//		})
+//	}
+//
+// A test fails if it raises a runtime error, e.g. by a failed assertion of
+// the "testing" module. It also fails if it returns an error value, or if
+// t.fail or t.fatal is called.
+package tester
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+)
+
+const (
+	// TestFileSuffix is the suffix of the test file names.
+	TestFileSuffix = "_test.tengo"
+
+	// TestFuncPrefix is the prefix of the test function names.
+	TestFuncPrefix = "test_"
+)
+
+// Status of a test.
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// errStop is raised by t.skip and t.fatal to stop the test.
+var errStop = errors.New("test stopped")
+
+// Result is the result of a test function or a subtest.
+type Result struct {
+	Name     string // e.g. "test_add/negative"
+	Status   string
+	Elapsed  time.Duration
+	Output   []string // logs and failures of the test
+	Subtests []*Result
+}
+
+// FileResult is the result of the tests in a file.
+type FileResult struct {
+	File    string
+	Tests   []*Result
+	Elapsed time.Duration
+	Err     error // error compiling or executing the file
+}
+
+// Failed returns true if the file cannot be executed or any test failed.
+func (r *FileResult) Failed() bool {
+	if r.Err != nil {
+		return true
+	}
+	for _, t := range r.Tests {
+		if t.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Runner runs the test functions of the test files.
+type Runner struct {
+	modules         tengo.ModuleGetter
+	filter          []*regexp.Regexp
+	allowFileImport bool
+	importDir       string
+}
+
+// NewRunner creates a Runner. The modules are used to resolve the imports
+// of the test files.
+func NewRunner(modules tengo.ModuleGetter) *Runner {
+	return &Runner{modules: modules}
+}
+
+// EnableFileImport enables or disables module loading from local files.
+func (r *Runner) EnableFileImport(enable bool) {
+	r.allowFileImport = enable
+}
+
+// SetImportDir sets the directory to resolve the file imports.
+func (r *Runner) SetImportDir(dir string) {
+	r.importDir = dir
+}
+
+// SetFilter sets the regular expression to select the tests to run. Like
+// "go test -run", the pattern is split by slashes and each element
+// matches the name of the test at the same level of the subtests.
+func (r *Runner) SetFilter(pattern string) error {
+	r.filter = nil
+	if pattern == "" {
+		return nil
+	}
+	for _, p := range strings.Split(pattern, "/") {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		r.filter = append(r.filter, re)
+	}
+	return nil
+}
+
+func (r *Runner) matches(level int, name string) bool {
+	return level >= len(r.filter) || r.filter[level].MatchString(name)
+}
+
+// Run executes the test file and runs its test functions.
+func (r *Runner) Run(filename string, src []byte) *FileResult {
+	start := time.Now()
+	res := &FileResult{File: filename}
+	res.Tests, res.Err = r.run(filename, src)
+	res.Elapsed = time.Since(start)
+	return res
+}
+
+func (r *Runner) run(filename string, src []byte) ([]*Result, error) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(filename, -1, len(src))
+	p := parser.NewParser(srcFile, src, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := tengo.NewSymbolTable()
+	c := tengo.NewCompiler(srcFile, symbols, nil, r.modules, nil)
+	c.EnableFileImport(r.allowFileImport)
+	if r.importDir != "" {
+		c.SetImportDir(r.importDir)
+	}
+	if err := c.Compile(file); err != nil {
+		return nil, err
+	}
+
+	globals := make([]tengo.Object, tengo.GlobalsSize)
+	v := tengo.NewVM(c.Bytecode(), globals, -1)
+	if err := v.Run(); err != nil {
+		return nil, err
+	}
+
+	// the test functions in the order of the definitions
+	var tests []*tengo.Symbol
+	for _, name := range symbols.Names() {
+		if !strings.HasPrefix(name, TestFuncPrefix) ||
+			!r.matches(0, name) {
+			continue
+		}
+		s, _, _ := symbols.Resolve(name, false)
+		if s.Scope != tengo.ScopeGlobal {
+			continue
+		}
+		if _, ok := globals[s.Index].(*tengo.CompiledFunction); ok {
+			tests = append(tests, s)
+		}
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		return tests[i].Index < tests[j].Index
+	})
+
+	var results []*Result
+	for _, s := range tests {
+		res := &Result{Name: s.Name}
+		r.runTest(v, globals[s.Index], res, 0)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// runTest calls the test function in the VM.
+func (r *Runner) runTest(v *tengo.VM, fn tengo.Object, res *Result, level int) {
+	t := &test{runner: r, result: res, level: level}
+	var args []tengo.Object
+	if cfn, ok := fn.(*tengo.CompiledFunction); !ok || cfn.NumArgs > 0 {
+		args = []tengo.Object{t.object()}
+	}
+
+	start := time.Now()
+	ret, err := call(fn, &tengo.CallContext{VM: v, Args: args})
+	res.Elapsed = time.Since(start)
+
+	switch {
+	case errors.Is(err, errStop):
+	case err != nil:
+		t.failed = true
+		res.Output = append(res.Output, runtimeError(err))
+	default:
+		if e, ok := ret.(*tengo.Error); ok {
+			t.failed = true
+			res.Output = append(res.Output, "returned "+e.String())
+		}
+	}
+
+	res.Status = StatusPass
+	for _, sub := range res.Subtests {
+		if sub.Status == StatusFail {
+			t.failed = true
+		}
+	}
+	if t.failed {
+		res.Status = StatusFail
+	} else if t.skipped {
+		res.Status = StatusSkip
+	}
+}
+
+// call calls the function. The panic of the call is returned as an error.
+func call(fn tengo.Object, ctx *tengo.CallContext) (ret tengo.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn.Call(ctx)
+}
+
+// runtimeError returns the message of the runtime error with the innermost
+// source position, e.g. "foo_test.tengo:3: expected 1, got 2".
+func runtimeError(err error) string {
+	msg := err
+	for e := errors.Unwrap(msg); e != nil; e = errors.Unwrap(e) {
+		msg = e
+	}
+
+	// the error has the positions of the call frames, starting from the
+	// innermost one: "Runtime Error: msg\n\tat file:line:column\n\tat ..."
+	s := err.Error()
+	i := strings.Index(s, "\n\tat ")
+	if i < 0 {
+		return msg.Error()
+	}
+	pos := s[i+len("\n\tat "):]
+	if i := strings.IndexByte(pos, '\n'); i >= 0 {
+		pos = pos[:i]
+	}
+	if pos == "-" {
+		return msg.Error()
+	}
+	// drop the column
+	if i := strings.LastIndexByte(pos, ':'); i >= 0 {
+		pos = pos[:i]
+	}
+	return fmt.Sprintf("%s: %s", pos, msg.Error())
+}
+
+// test is the state of a running test. Its object is passed to the test
+// function as the argument.
+type test struct {
+	runner  *Runner
+	result  *Result
+	level   int
+	failed  bool
+	skipped bool
+}
+
+func (t *test) object() tengo.Object {
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"name": &tengo.String{Value: t.result.Name},
+		"run": &tengo.UserFunctionCtx{
+			Name:  "run",
+			Value: t.run,
+		}, // run(name, fn) => bool
+		"log": &tengo.UserFunctionCtx{
+			Name:  "log",
+			Value: t.log,
+		}, // log(args...)
+		"fail": &tengo.UserFunctionCtx{
+			Name:  "fail",
+			Value: t.fail,
+		}, // fail(args...)
+		"fatal": &tengo.UserFunctionCtx{
+			Name:  "fatal",
+			Value: t.fatal,
+		}, // fatal(args...)
+		"skip": &tengo.UserFunctionCtx{
+			Name:  "skip",
+			Value: t.skip,
+		}, // skip(args...)
+	}}
+}
+
+func (t *test) run(ctx *tengo.CallContext) (tengo.Object, error) {
+	if len(ctx.Args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(ctx.Args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "name",
+			Expected: "string(compatible)",
+			Found:    ctx.Args[0].TypeName(),
+		}
+	}
+	if !ctx.Args[1].CanCall() {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "fn",
+			Expected: "callable",
+			Found:    ctx.Args[1].TypeName(),
+		}
+	}
+
+	name = strings.ReplaceAll(name, " ", "_")
+	if !t.runner.matches(t.level+1, name) {
+		return tengo.TrueValue, nil
+	}
+	res := &Result{Name: t.result.Name + "/" + name}
+	t.result.Subtests = append(t.result.Subtests, res)
+	t.runner.runTest(ctx.VM, ctx.Args[1], res, t.level+1)
+	if res.Status == StatusFail {
+		return tengo.FalseValue, nil
+	}
+	return tengo.TrueValue, nil
+}
+
+// output adds the message with the position of the call to the output.
+func (t *test) output(ctx *tengo.CallContext, defaultMsg string) {
+	var msg []string
+	for _, arg := range ctx.Args {
+		s, _ := tengo.ToString(arg)
+		msg = append(msg, s)
+	}
+	if len(msg) == 0 {
+		msg = []string{defaultMsg}
+	}
+	pos := ctx.VM.Pos()
+	t.result.Output = append(t.result.Output, fmt.Sprintf("%s:%d: %s",
+		pos.Filename, pos.Line, strings.Join(msg, " ")))
+}
+
+func (t *test) log(ctx *tengo.CallContext) (tengo.Object, error) {
+	t.output(ctx, "")
+	return tengo.UndefinedValue, nil
+}
+
+func (t *test) fail(ctx *tengo.CallContext) (tengo.Object, error) {
+	t.output(ctx, "failed")
+	t.failed = true
+	return tengo.UndefinedValue, nil
+}
+
+func (t *test) fatal(ctx *tengo.CallContext) (tengo.Object, error) {
+	t.output(ctx, "failed")
+	t.failed = true
+	return nil, errStop
+}
+
+func (t *test) skip(ctx *tengo.CallContext) (tengo.Object, error) {
+	if len(ctx.Args) > 0 {
+		t.output(ctx, "")
+	}
+	t.skipped = true
+	return nil, errStop
+}
+
+// IsTestFile returns true if the file name has the test file suffix.
+func IsTestFile(name string) bool {
+	return strings.HasSuffix(filepath.Base(name), TestFileSuffix)
+}
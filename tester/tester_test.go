@@ -0,0 +1,241 @@
+package tester_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d5/tengo/v2/require"
+	"github.com/d5/tengo/v2/stdlib"
+	"github.com/d5/tengo/v2/tester"
+)
+
+const testSrc = `
+assert := import("testing")
+
+add := func(a, b) { return a + b }
+
+test_add := func(t) {
+	assert.equal(add(1, 2), 3)
+	t.run("negative numbers", func(t) {
+		assert.equal(add(-1, -2), -3)
+	})
+	t.run("strings", func(t) {
+		t.log("concat")
+		assert.equal(add("a", "b"), "abc")
+	})
+}
+
+test_skip := func(t) {
+	t.skip("later")
+	t.fail("unreachable")
+}
+
+test_fail := func(t) {
+	t.fail("first", 1)
+	ok := t.run("sub", func(t) { t.fatal("second") })
+	t.log(ok, t.name)
+}
+
+test_error := func() {
+	return error("bad")
+}
+
+test_runtime := func(t) {
+	helper := func() { return 1 + "a" }
+	helper()
+}
+
+not_test := func(t) { t.fail() }
+test_value := 1
+`
+
+func TestRunner(t *testing.T) {
+	r := newRunner()
+	res := r.Run("foo_test.tengo", []byte(testSrc))
+	require.NoError(t, res.Err)
+	require.True(t, res.Failed())
+	require.Equal(t, []string{
+		"test_add fail",
+		"test_add/negative_numbers pass",
+		"test_add/strings fail",
+		"  foo_test.tengo:12: concat",
+		`  foo_test.tengo:13: expected "abc", got "ab"`,
+		"test_skip skip",
+		"  foo_test.tengo:18: later",
+		"test_fail fail",
+		"  foo_test.tengo:23: first 1",
+		"  foo_test.tengo:25: false test_fail",
+		"test_fail/sub fail",
+		"  foo_test.tengo:24: second",
+		"test_error fail",
+		`  returned error: "bad"`,
+		"test_runtime fail",
+		"  foo_test.tengo:33: invalid operation: int + string",
+	}, resultLines(res.Tests))
+
+	// filter
+	require.NoError(t, r.SetFilter("add/neg|str"))
+	res = r.Run("foo_test.tengo", []byte(testSrc))
+	require.Equal(t, []string{
+		"test_add fail",
+		"test_add/negative_numbers pass",
+		"test_add/strings fail",
+		"  foo_test.tengo:12: concat",
+		`  foo_test.tengo:13: expected "abc", got "ab"`,
+	}, resultLines(res.Tests))
+
+	require.NoError(t, r.SetFilter("_add/neg"))
+	res = r.Run("foo_test.tengo", []byte(testSrc))
+	require.False(t, res.Failed())
+	require.Equal(t, []string{
+		"test_add pass",
+		"test_add/negative_numbers pass",
+	}, resultLines(res.Tests))
+
+	require.Error(t, r.SetFilter("("))
+
+	// file errors
+	require.NoError(t, r.SetFilter(""))
+	res = r.Run("foo_test.tengo", []byte(`a := `))
+	require.Error(t, res.Err)
+	require.True(t, res.Failed())
+	res = r.Run("foo_test.tengo", []byte(`a := 1 + "a"`))
+	require.Error(t, res.Err)
+	res = r.Run("foo_test.tengo", []byte(`a := import("foo")`))
+	require.Error(t, res.Err)
+}
+
+func TestRunner_FileImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tester")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	err = ioutil.WriteFile(filepath.Join(dir, "mod.tengo"),
+		[]byte(`export func() { return 5 }`), 0644)
+	require.NoError(t, err)
+
+	r := newRunner()
+	r.EnableFileImport(true)
+	r.SetImportDir(dir)
+	res := r.Run("foo_test.tengo", []byte(`
+assert := import("testing")
+mod := import("./mod")
+test_mod := func(t) { assert.equal(mod(), 5) }`))
+	require.NoError(t, res.Err)
+	require.False(t, res.Failed())
+	require.Equal(t, []string{"test_mod pass"}, resultLines(res.Tests))
+}
+
+func TestFileResult_WriteReport(t *testing.T) {
+	res := newRunner().Run("foo_test.tengo", []byte(testSrc))
+	clearElapsed(res)
+	var buf bytes.Buffer
+	require.NoError(t, res.WriteReport(&buf, false))
+	require.Equal(t, `--- FAIL: test_add (0.00s)
+    --- FAIL: test_add/strings (0.00s)
+        foo_test.tengo:12: concat
+        foo_test.tengo:13: expected "abc", got "ab"
+--- FAIL: test_fail (0.00s)
+    foo_test.tengo:23: first 1
+    foo_test.tengo:25: false test_fail
+    --- FAIL: test_fail/sub (0.00s)
+        foo_test.tengo:24: second
+--- FAIL: test_error (0.00s)
+    returned error: "bad"
+--- FAIL: test_runtime (0.00s)
+    foo_test.tengo:33: invalid operation: int + string
+FAIL	foo_test.tengo	0.000s
+`, buf.String())
+
+	res = newRunner().Run("foo_test.tengo", []byte(`
+test_a := func(t) { t.run("b", func(t) {}) }
+test_c := func(t) { t.skip() }`))
+	clearElapsed(res)
+	buf.Reset()
+	require.NoError(t, res.WriteReport(&buf, true))
+	require.Equal(t, `=== RUN   test_a
+=== RUN   test_a/b
+--- PASS: test_a (0.00s)
+    --- PASS: test_a/b (0.00s)
+=== RUN   test_c
+--- SKIP: test_c (0.00s)
+ok  	foo_test.tengo	0.000s
+`, buf.String())
+
+	res = newRunner().Run("foo_test.tengo", []byte(`a := `))
+	buf.Reset()
+	require.NoError(t, res.WriteReport(&buf, true))
+	require.True(t, strings.HasSuffix(buf.String(),
+		"\nFAIL\tfoo_test.tengo\t[setup failed]\n"))
+}
+
+func TestFileResult_WriteJSON(t *testing.T) {
+	res := newRunner().Run("foo_test.tengo", []byte(`
+assert := import("testing")
+test_a := func(t) {
+	t.run("b", func(t) { assert.is_true(false) })
+}`))
+	var buf bytes.Buffer
+	require.NoError(t, res.WriteJSON(&buf))
+
+	var events []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e tester.Event
+		require.NoError(t, dec.Decode(&e))
+		require.Equal(t, "foo_test.tengo", e.Package)
+		require.False(t, e.Time.IsZero())
+		s := e.Action + " " + e.Test + " " + e.Output
+		if e.Elapsed != nil {
+			s += "elapsed"
+		}
+		events = append(events, strings.TrimSpace(s))
+	}
+	require.Equal(t, []string{
+		"run test_a",
+		"run test_a/b",
+		"output test_a/b         foo_test.tengo:4: expected truthy value, got false",
+		"fail test_a/b elapsed",
+		"fail test_a elapsed",
+		"fail  elapsed",
+	}, events)
+}
+
+func TestIsTestFile(t *testing.T) {
+	require.True(t, tester.IsTestFile("foo_test.tengo"))
+	require.True(t, tester.IsTestFile("dir/foo_test.tengo"))
+	require.False(t, tester.IsTestFile("foo.tengo"))
+	require.False(t, tester.IsTestFile("foo_test.go"))
+}
+
+func newRunner() *tester.Runner {
+	return tester.NewRunner(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+}
+
+func clearElapsed(res *tester.FileResult) {
+	res.Elapsed = 0
+	var clear func(results []*tester.Result)
+	clear = func(results []*tester.Result) {
+		for _, r := range results {
+			r.Elapsed = 0
+			clear(r.Subtests)
+		}
+	}
+	clear(res.Tests)
+}
+
+func resultLines(results []*tester.Result) []string {
+	var lines []string
+	for _, r := range results {
+		lines = append(lines, r.Name+" "+r.Status)
+		for _, out := range r.Output {
+			lines = append(lines, "  "+out)
+		}
+		lines = append(lines, resultLines(r.Subtests)...)
+	}
+	return lines
+}
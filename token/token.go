@@ -67,6 +67,8 @@ const (
 	Semicolon    // ;
 	Colon        // :
 	Question     // ?
+	QuestionDot  // ?.
+	Coalesce     // ??
 	_operatorEnd
 	_keywordBeg
 	Break
@@ -88,6 +90,13 @@ const (
 	Callee
 	CalledArgs
 	CalledKwargs
+	Try
+	Catch
+	Finally
+	Switch
+	Case
+	Is
+	Yield
 	_keywordEnd
 )
 
@@ -147,6 +156,8 @@ var tokens = [...]string{
 	Semicolon:    ";",
 	Colon:        ":",
 	Question:     "?",
+	QuestionDot:  "?.",
+	Coalesce:     "??",
 	Break:        "break",
 	Continue:     "continue",
 	Else:         "else",
@@ -166,6 +177,13 @@ var tokens = [...]string{
 	Callee:       "callee",
 	CalledArgs:   "argv",
 	CalledKwargs: "kwargv",
+	Try:          "try",
+	Catch:        "catch",
+	Finally:      "finally",
+	Switch:       "switch",
+	Case:         "case",
+	Is:           "is",
+	Yield:        "yield",
 }
 
 func (tok Token) String() string {
@@ -188,16 +206,18 @@ const LowestPrec = 0
 // Precedence returns the precedence for the operator token.
 func (tok Token) Precedence() int {
 	switch tok {
-	case LOr:
+	case Coalesce:
 		return 1
-	case LAnd:
+	case LOr:
 		return 2
-	case Equal, NotEqual, Less, LessEq, Greater, GreaterEq:
+	case LAnd:
 		return 3
-	case Add, Sub, Or, Xor:
+	case Equal, NotEqual, Less, LessEq, Greater, GreaterEq:
 		return 4
-	case Mul, Quo, Rem, Shl, Shr, And, AndNot:
+	case Add, Sub, Or, Xor:
 		return 5
+	case Mul, Quo, Rem, Shl, Shr, And, AndNot:
+		return 6
 	}
 	return LowestPrec
 }
@@ -8,6 +8,7 @@ import (
 type Variable struct {
 	name  string
 	value Object
+	c     *Compiled // compiled script of the variable, if any
 }
 
 // NewVariable creates a Variable.
@@ -124,6 +125,21 @@ func (v *Variable) Error() error {
 	return nil
 }
 
+// Decode decodes the variable value to the Go value pointed to by dst. See
+// Decode for the conversions.
+func (v *Variable) Decode(dst interface{}) error {
+	return Decode(v.value, dst)
+}
+
+// Callable returns the function of the variable to call from Go or nil if
+// the value is not callable or the variable is not of a compiled script.
+func (v *Variable) Callable() *Callable {
+	if v.c == nil || !v.value.CanCall() {
+		return nil
+	}
+	return &Callable{c: v.c, name: v.name, fn: v.value}
+}
+
 // Object returns an underlying Object of the variable value. Note that
 // returned Object is a copy of an actual Object used in the script.
 func (v *Variable) Object() Object {
@@ -1,7 +1,11 @@
 package tengo_test
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/d5/tengo/v2"
 	"github.com/d5/tengo/v2/require"
@@ -79,3 +83,85 @@ func TestVariable(t *testing.T) {
 		require.Equal(t, tc.IsUndefined, v.IsUndefined(), "Name: %s", tc.Name)
 	}
 }
+
+func TestVariable_Decode(t *testing.T) {
+	type rule struct {
+		Name      string        `tengo:"name"`
+		Threshold float64       `tengo:"threshold"`
+		Interval  time.Duration `tengo:"interval"`
+		Tags      []string      `tengo:"tags"`
+	}
+	type config struct {
+		Rules   []rule             `tengo:"rules"`
+		Limits  map[string]uint8   `tengo:"limits"`
+		Ports   map[uint16]string  `tengo:"ports"`
+		Weights map[float64]int8   `tengo:"weights"`
+		Index   map[[2]int]string  `tengo:"index"`
+		Start   time.Time          `tengo:"start"`
+		Owner   *string            `tengo:"owner"`
+		Extra   interface{}        `tengo:"extra"`
+		Default string             `tengo:"default"`
+		Ignored map[string]float64 `tengo:"-"`
+	}
+
+	c := compile(t, `
+out := {
+	rules: [
+		{name: "a", threshold: 0.5, interval: "1m30s", tags: ["x", "y"]},
+		{name: "b", threshold: 2, interval: 1000}
+	],
+	limits: {cpu: 'c', mem: 64},
+	ports: {"80": "http", "443": "https"},
+	weights: {"0.5": -1},
+	start: 0,
+	owner: "foo",
+	extra: [1, "two"]
+}`, nil)
+	compiledRun(t, c)
+	cfg := config{Default: "keep"}
+	require.NoError(t, c.Get("out").Decode(&cfg))
+	require.Equal(t, 2, len(cfg.Rules))
+	require.Equal(t, "a", cfg.Rules[0].Name)
+	require.Equal(t, 0.5, cfg.Rules[0].Threshold)
+	require.True(t, cfg.Rules[0].Interval == 90*time.Second)
+	require.Equal(t, "x,y", strings.Join(cfg.Rules[0].Tags, ","))
+	require.Equal(t, 2.0, cfg.Rules[1].Threshold)
+	require.True(t, cfg.Rules[1].Interval == 1000)
+	require.True(t, cfg.Limits["cpu"] == 'c' && cfg.Limits["mem"] == 64)
+	require.True(t, len(cfg.Ports) == 2 && cfg.Ports[443] == "https")
+	require.True(t, len(cfg.Weights) == 1 && cfg.Weights[0.5] == -1)
+	require.Equal(t, int64(0), cfg.Start.Unix())
+	require.Equal(t, "foo", *cfg.Owner)
+	require.Equal(t, "[1 two]", fmt.Sprint(cfg.Extra))
+	require.Equal(t, "keep", cfg.Default)
+
+	var n int
+	require.NoError(t, tengo.Decode(&tengo.Int{Value: 5}, &n))
+	require.Equal(t, 5, n)
+	require.NoError(t, tengo.Decode(tengo.UndefinedValue, &n))
+	require.Equal(t, 0, n)
+	require.Error(t, tengo.Decode(&tengo.Int{Value: 5}, n))
+
+	// errors with the paths of the values
+	for src, expected := range map[string]string{
+		`out := 1`: "expected map, got int",
+		`out := {rules: [{}, {}, {}, {threshold: "high"}]}`: "rules[3].threshold: expected float, got string",
+		`out := {rules: [{interval: "soon"}]}`:              "rules[0].interval: expected duration, got string",
+		`out := {rules: [{tags: ["a", 1]}]}`:                "rules[0].tags[1]: expected string, got int",
+		`out := {limits: {cpu: 256}}`:                       "limits.cpu: expected uint8, got 256 (out of range)",
+		`out := {limits: {cpu: -1}}`:                        "limits.cpu: expected uint8, got -1 (out of range)",
+		`out := {weights: {a: 1 << 40}}`:                    "weights.a: expected float key, got \"a\"",
+		`out := {weights: {"1": 1 << 40}}`:                  "weights.1: expected int8, got 1099511627776 (out of range)",
+		`out := {ports: {"65536": "x"}}`:                    "ports.65536: expected uint16 key, got \"65536\"",
+		`out := {index: {}}`:                                "index: expected map[[2]int]string, got map",
+		`out := {rules: {}}`:                                "rules: expected array, got map",
+	} {
+		c := compile(t, src, nil)
+		compiledRun(t, c)
+		var cfg config
+		err := c.Get("out").Decode(&cfg)
+		var decodeErr *tengo.DecodeError
+		require.True(t, errors.As(err, &decodeErr), src)
+		require.Equal(t, expected, err.Error(), src)
+	}
+}
@@ -0,0 +1,400 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory file system. The zero value is not usable, use
+// NewMemFS to create one.
+type MemFS struct {
+	lock  sync.RWMutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+// NewMemFS creates an empty in-memory file system.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		nodes: map[string]*memNode{
+			"/": {mode: os.ModeDir | 0755, modTime: time.Now()},
+		},
+	}
+}
+
+// OpenFile opens the named file.
+func (m *MemFS) OpenFile(
+	name string,
+	flag int,
+	perm os.FileMode,
+) (File, error) {
+	name = clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[name]
+	switch {
+	case node != nil && flag&(os.O_CREATE|os.O_EXCL) ==
+		os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case node != nil && node.mode.IsDir() && isWrite(flag):
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	case node == nil && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case node == nil:
+		if err := m.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		node = &memNode{mode: perm & os.ModePerm, modTime: time.Now()}
+		m.nodes[name] = node
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if writable && flag&os.O_TRUNC != 0 {
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	return &memFile{
+		fs:       m,
+		node:     node,
+		name:     name,
+		readable: flag&os.O_WRONLY == 0,
+		writable: writable,
+		append:   flag&os.O_APPEND != 0,
+	}, nil
+}
+
+// Stat returns the file info of the named file.
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = clean(name)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return node.info(name), nil
+}
+
+// Mkdir creates the named directory.
+func (m *MemFS) Mkdir(name string, perm os.FileMode) error {
+	name = clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.nodes[name] != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{
+		mode:    os.ModeDir | perm&os.ModePerm,
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Remove removes the named file or empty directory.
+func (m *MemFS) Remove(name string) error {
+	name = clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[name]
+	switch {
+	case name == "/":
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	case node == nil:
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	case node.mode.IsDir() && len(m.children(name)) > 0:
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+// Rename renames the file or directory, replacing the existing file.
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = clean(oldpath), clean(newpath)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	linkError := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	node := m.nodes[oldpath]
+	if node == nil {
+		return linkError(os.ErrNotExist)
+	}
+	if oldpath == newpath {
+		return nil
+	}
+	if oldpath == "/" || within(newpath, oldpath) {
+		return linkError(os.ErrPermission)
+	}
+	if dst := m.nodes[newpath]; dst != nil {
+		if dst.mode.IsDir() != node.mode.IsDir() {
+			if dst.mode.IsDir() {
+				return linkError(errIsDir)
+			}
+			return linkError(errNotDir)
+		}
+		if dst.mode.IsDir() && len(m.children(newpath)) > 0 {
+			return linkError(errNotEmpty)
+		}
+	}
+	if err := m.checkParent("rename", newpath); err != nil {
+		return linkError(err.(*os.PathError).Err)
+	}
+	moved := make(map[string]*memNode)
+	for name, n := range m.nodes {
+		if within(name, oldpath) {
+			moved[newpath+name[len(oldpath):]] = n
+			delete(m.nodes, name)
+		}
+	}
+	for name, n := range moved {
+		m.nodes[name] = n
+	}
+	return nil
+}
+
+// Chmod changes the mode of the named file.
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	name = clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[name]
+	if node == nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrNotExist}
+	}
+	node.mode = node.mode&os.ModeType | mode&os.ModePerm
+	return nil
+}
+
+// Truncate changes the size of the named file.
+func (m *MemFS) Truncate(name string, size int64) error {
+	name = clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node := m.nodes[name]
+	switch {
+	case node == nil:
+		return &os.PathError{Op: "truncate", Path: name, Err: os.ErrNotExist}
+	case node.mode.IsDir():
+		return &os.PathError{Op: "truncate", Path: name, Err: errIsDir}
+	}
+	node.truncate(size)
+	return nil
+}
+
+func (m *MemFS) checkParent(op, name string) error {
+	parent := m.nodes[path.Dir(name)]
+	if parent == nil {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+// children returns the sorted names of the entries in the directory.
+func (m *MemFS) children(dir string) []string {
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = dir
+	}
+	var names []string
+	for name := range m.nodes {
+		if name != "/" && strings.HasPrefix(name, prefix) &&
+			!strings.Contains(name[len(prefix):], "/") {
+			names = append(names, name[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return &memFileInfo{
+		name:    path.Base(name),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+func (n *memNode) truncate(size int64) {
+	if size < int64(len(n.data)) {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, size-int64(len(n.data)))...)
+	}
+	n.modTime = time.Now()
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+type memFile struct {
+	fs       *MemFS
+	node     *memNode
+	name     string
+	offset   int64
+	dirNames []string
+	readable bool
+	writable bool
+	append   bool
+	closed   bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+
+	if err := f.check("read", f.readable); err != nil {
+		return 0, err
+	}
+	if f.node.mode.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if err := f.check("write", f.writable); err != nil {
+		return 0, err
+	}
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		f.node.truncate(end)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+
+	if err := f.check("seek", true); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if err := f.check("close", true); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+
+	if err := f.check("stat", true); err != nil {
+		return nil, err
+	}
+	return f.node.info(f.name), nil
+}
+
+// Readdirnames returns the next n names of the directory or all the
+// remaining names if n <= 0.
+func (f *memFile) Readdirnames(n int) ([]string, error) {
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+
+	if err := f.check("readdirent", true); err != nil {
+		return nil, err
+	}
+	if !f.node.mode.IsDir() {
+		return nil, &os.PathError{
+			Op:   "readdirent",
+			Path: f.name,
+			Err:  errNotDir,
+		}
+	}
+	if f.dirNames == nil {
+		f.dirNames = f.fs.children(f.name)
+	}
+	names := f.dirNames
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(names) {
+			names = names[:n]
+		}
+	}
+	f.dirNames = f.dirNames[len(names):]
+	return names, nil
+}
+
+func (f *memFile) Sync() error {
+	return f.check("sync", true)
+}
+
+func (f *memFile) check(op string, allowed bool) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	if !allowed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrPermission}
+	}
+	return nil
+}
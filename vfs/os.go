@@ -0,0 +1,201 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type osFS struct{}
+
+// OS returns the host file system. The names are host paths as in the os
+// package.
+func OS() FS {
+	return osFS{}
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFS) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+type dirFS struct {
+	root string
+}
+
+// Dir returns the file system rooted at the host directory. The names
+// cannot refer to the files outside of the directory, either using ".." or
+// symbolic links.
+func Dir(root string) FS {
+	return &dirFS{root: root}
+}
+
+// path returns the host path of the name. It fails if the name, or its
+// nearest existing parent, resolves outside of the root directory.
+func (d *dirFS) path(op, name string) (string, error) {
+	name = clean(name)
+	hostPath := filepath.Join(d.root, filepath.FromSlash(name))
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: name, Err: err}
+	}
+	for dir := hostPath; ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if os.IsNotExist(err) {
+			if _, err := os.Lstat(dir); err == nil {
+				// a dangling symbolic link could point anywhere
+				return "", &os.PathError{
+					Op:   op,
+					Path: name,
+					Err:  os.ErrPermission,
+				}
+			}
+			if dir != filepath.Dir(dir) {
+				continue
+			}
+		}
+		if err != nil {
+			return "", &os.PathError{Op: op, Path: name, Err: err}
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", &os.PathError{
+				Op:   op,
+				Path: name,
+				Err:  os.ErrPermission,
+			}
+		}
+		return hostPath, nil
+	}
+}
+
+func (d *dirFS) OpenFile(
+	name string,
+	flag int,
+	perm os.FileMode,
+) (File, error) {
+	hostPath, err := d.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(hostPath, flag, perm)
+	if err != nil {
+		return nil, d.pathError(err, name)
+	}
+	return &dirFile{File: f, name: clean(name)}, nil
+}
+
+func (d *dirFS) Stat(name string) (os.FileInfo, error) {
+	hostPath, err := d.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(hostPath)
+	return fi, d.pathError(err, name)
+}
+
+func (d *dirFS) Mkdir(name string, perm os.FileMode) error {
+	hostPath, err := d.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return d.pathError(os.Mkdir(hostPath, perm), name)
+}
+
+func (d *dirFS) Remove(name string) error {
+	if clean(name) == "/" {
+		return &os.PathError{Op: "remove", Path: "/", Err: os.ErrPermission}
+	}
+	hostPath, err := d.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return d.pathError(os.Remove(hostPath), name)
+}
+
+func (d *dirFS) Rename(oldpath, newpath string) error {
+	oldHostPath, err := d.path("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newHostPath, err := d.path("rename", newpath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(oldHostPath, newHostPath)
+	if err, ok := err.(*os.LinkError); ok {
+		err.Old, err.New = clean(oldpath), clean(newpath)
+	}
+	return err
+}
+
+func (d *dirFS) Chmod(name string, mode os.FileMode) error {
+	hostPath, err := d.path("chmod", name)
+	if err != nil {
+		return err
+	}
+	return d.pathError(os.Chmod(hostPath, mode), name)
+}
+
+func (d *dirFS) Truncate(name string, size int64) error {
+	hostPath, err := d.path("truncate", name)
+	if err != nil {
+		return err
+	}
+	return d.pathError(os.Truncate(hostPath, size), name)
+}
+
+// pathError replaces the host path in the error with the name so the errors
+// do not reveal the root directory.
+func (d *dirFS) pathError(err error, name string) error {
+	if err, ok := err.(*os.PathError); ok {
+		err.Path = clean(name)
+	}
+	return err
+}
+
+// dirFile is a host file whose name is relative to the root directory.
+type dirFile struct {
+	*os.File
+	name string
+}
+
+func (f *dirFile) Name() string {
+	return f.name
+}
@@ -0,0 +1,368 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// OverlayFS is a file system that reads the files from the upper file system
+// first and the lower file system next and writes only to the upper file
+// system. The files of the lower file system are copied to the upper file
+// system before they are modified and the removed files are only hidden.
+type OverlayFS struct {
+	upper   FS
+	lower   FS
+	lock    sync.RWMutex
+	removed map[string]bool // names hiding the lower files and children
+}
+
+// NewOverlayFS creates an overlay of the upper file system on top of the
+// lower file system. The lower file system is never modified.
+func NewOverlayFS(upper, lower FS) *OverlayFS {
+	return &OverlayFS{
+		upper:   upper,
+		lower:   lower,
+		removed: make(map[string]bool),
+	}
+}
+
+// OpenFile opens the named file.
+func (o *OverlayFS) OpenFile(
+	name string,
+	flag int,
+	perm os.FileMode,
+) (File, error) {
+	name = clean(name)
+	if isWrite(flag) {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+
+		if flag&os.O_EXCL != 0 {
+			if _, err := o.stat(name); err == nil {
+				return nil, &os.PathError{
+					Op:   "open",
+					Path: name,
+					Err:  os.ErrExist,
+				}
+			}
+		}
+		if flag&os.O_TRUNC != 0 {
+			if err := o.copyUpDir("open", path.Dir(name)); err != nil {
+				return nil, err
+			}
+		} else if err := o.copyUp("open", name); err != nil {
+			return nil, err
+		}
+		return o.upper.OpenFile(name, flag, perm)
+	}
+
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	fi, err := o.stat(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if fi.IsDir() {
+		return &overlayDir{fs: o, name: name, info: fi}, nil
+	}
+	if _, err := o.upper.Stat(name); err == nil {
+		return o.upper.OpenFile(name, flag, perm)
+	}
+	return o.lower.OpenFile(name, flag, perm)
+}
+
+// Stat returns the file info of the named file.
+func (o *OverlayFS) Stat(name string) (os.FileInfo, error) {
+	name = clean(name)
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.stat(name)
+}
+
+// Mkdir creates the named directory.
+func (o *OverlayFS) Mkdir(name string, perm os.FileMode) error {
+	name = clean(name)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, err := o.stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := o.copyUpDir("mkdir", path.Dir(name)); err != nil {
+		return err
+	}
+	return o.upper.Mkdir(name, perm)
+}
+
+// Remove removes the named file or empty directory.
+func (o *OverlayFS) Remove(name string) error {
+	name = clean(name)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	fi, err := o.stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		names, err := o.readDirNames(name)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+		}
+	}
+	if _, err := o.upper.Stat(name); err == nil {
+		if err := RemoveAll(o.upper, name); err != nil {
+			return err
+		}
+	}
+	if _, err := o.lowerStat(name); err == nil {
+		o.removed[name] = true
+	}
+	return nil
+}
+
+// Rename renames the file or directory, replacing the existing file.
+func (o *OverlayFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = clean(oldpath), clean(newpath)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, err := o.stat(oldpath); err != nil {
+		return &os.LinkError{
+			Op:  "rename",
+			Old: oldpath,
+			New: newpath,
+			Err: os.ErrNotExist,
+		}
+	}
+	if err := o.copyUpAll(oldpath); err != nil {
+		return err
+	}
+	if err := o.copyUpDir("rename", path.Dir(newpath)); err != nil {
+		return err
+	}
+	if err := o.upper.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	if _, err := o.lowerStat(oldpath); err == nil {
+		o.removed[oldpath] = true
+	}
+	if _, err := o.lowerStat(newpath); err == nil {
+		o.removed[newpath] = true
+	}
+	return nil
+}
+
+// Chmod changes the mode of the named file.
+func (o *OverlayFS) Chmod(name string, mode os.FileMode) error {
+	name = clean(name)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err := o.copyUp("chmod", name); err != nil {
+		return err
+	}
+	return Chmod(o.upper, name, mode)
+}
+
+// Truncate changes the size of the named file.
+func (o *OverlayFS) Truncate(name string, size int64) error {
+	name = clean(name)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err := o.copyUp("truncate", name); err != nil {
+		return err
+	}
+	return Truncate(o.upper, name, size)
+}
+
+func (o *OverlayFS) stat(name string) (os.FileInfo, error) {
+	if fi, err := o.upper.Stat(name); err == nil {
+		return fi, nil
+	}
+	return o.lowerStat(name)
+}
+
+// lowerStat returns the file info of the lower file unless it was removed.
+func (o *OverlayFS) lowerStat(name string) (os.FileInfo, error) {
+	for dir := name; ; dir = path.Dir(dir) {
+		if o.removed[dir] {
+			return nil, &os.PathError{
+				Op:   "stat",
+				Path: name,
+				Err:  os.ErrNotExist,
+			}
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	return o.lower.Stat(name)
+}
+
+// copyUp copies the lower file to the upper file system unless it is
+// already there. It does nothing if the file does not exist.
+func (o *OverlayFS) copyUp(op, name string) error {
+	if _, err := o.upper.Stat(name); err == nil {
+		return nil
+	}
+	fi, err := o.lowerStat(name)
+	if err != nil {
+		return o.copyUpDir(op, path.Dir(name))
+	}
+	if fi.IsDir() {
+		return o.copyUpDir(op, name)
+	}
+	if err := o.copyUpDir(op, path.Dir(name)); err != nil {
+		return err
+	}
+	data, err := ReadFile(o.lower, name)
+	if err != nil {
+		return err
+	}
+	return WriteFile(o.upper, name, data, fi.Mode().Perm())
+}
+
+// copyUpDir creates the lower directory and its parents in the upper file
+// system.
+func (o *OverlayFS) copyUpDir(op, name string) error {
+	if fi, err := o.upper.Stat(name); err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: op, Path: name, Err: errNotDir}
+		}
+		return nil
+	}
+	fi, err := o.lowerStat(name)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	if err := o.copyUpDir(op, path.Dir(name)); err != nil {
+		return err
+	}
+	return o.upper.Mkdir(name, fi.Mode().Perm())
+}
+
+// copyUpAll copies the lower file or directory and all its children to the
+// upper file system.
+func (o *OverlayFS) copyUpAll(name string) error {
+	if err := o.copyUp("rename", name); err != nil {
+		return err
+	}
+	fi, err := o.stat(name)
+	if err != nil || !fi.IsDir() {
+		return err
+	}
+	names, err := o.readDirNames(name)
+	if err != nil {
+		return err
+	}
+	for _, child := range names {
+		if err := o.copyUpAll(path.Join(name, child)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDirNames returns the sorted names of the entries in the directory of
+// both file systems.
+func (o *OverlayFS) readDirNames(name string) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	upper, upperErr := readDirNames(o.upper, name)
+	for _, child := range upper {
+		seen[child] = true
+		names = append(names, child)
+	}
+	var lowerErr error
+	if _, err := o.lowerStat(name); err == nil {
+		var lower []string
+		lower, lowerErr = readDirNames(o.lower, name)
+		for _, child := range lower {
+			if !seen[child] && !o.removed[path.Join(name, child)] {
+				names = append(names, child)
+			}
+		}
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// overlayDir is an open directory of the overlay file system.
+type overlayDir struct {
+	fs    *OverlayFS
+	name  string
+	info  os.FileInfo
+	names []string
+	read  bool
+}
+
+func (d *overlayDir) Name() string {
+	return d.name
+}
+
+func (d *overlayDir) Read([]byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *overlayDir) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: errIsDir}
+}
+
+func (d *overlayDir) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+func (d *overlayDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+// Readdirnames returns the next n names of the directory or all the
+// remaining names if n <= 0.
+func (d *overlayDir) Readdirnames(n int) ([]string, error) {
+	if !d.read {
+		d.fs.lock.RLock()
+		names, err := d.fs.readDirNames(d.name)
+		d.fs.lock.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		d.names, d.read = names, true
+	}
+	names := d.names
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(names) {
+			names = names[:n]
+		}
+	}
+	d.names = d.names[len(names):]
+	return names, nil
+}
+
+func (d *overlayDir) Sync() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package vfs
+
+import (
+	"os"
+)
+
+type readOnlyFS struct {
+	fs FS
+}
+
+// ReadOnly returns the view of the file system that fails to open the files
+// for writing and to create, modify or remove the files.
+func ReadOnly(fsys FS) FS {
+	return &readOnlyFS{fs: fsys}
+}
+
+// ReadOnlyDir returns the read-only file system rooted at the host
+// directory.
+func ReadOnlyDir(root string) FS {
+	return ReadOnly(Dir(root))
+}
+
+func (r *readOnlyFS) OpenFile(
+	name string,
+	flag int,
+	perm os.FileMode,
+) (File, error) {
+	if isWrite(flag) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return r.fs.OpenFile(name, flag, perm)
+}
+
+func (r *readOnlyFS) Stat(name string) (os.FileInfo, error) {
+	return r.fs.Stat(name)
+}
+
+func (r *readOnlyFS) Mkdir(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+}
+
+func (r *readOnlyFS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+}
+
+func (r *readOnlyFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{
+		Op:  "rename",
+		Old: oldpath,
+		New: newpath,
+		Err: os.ErrPermission,
+	}
+}
@@ -0,0 +1,203 @@
+// Package vfs defines the file system interface used by the compiler to load
+// the module files and by the os module of the standard library and its
+// implementations: the host file system, a host directory, an in-memory file
+// system, an overlay of two file systems and a read-only view.
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotSupported is returned by the operations the file system does not
+// implement.
+var ErrNotSupported = errors.New("operation not supported")
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// File is an open file of a file system. *os.File implements File.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Readdirnames(n int) ([]string, error)
+	Sync() error
+}
+
+// FS is a file system with write support. The flags and the permissions
+// have the same meaning as in the os package and the errors should be
+// *os.PathError wrapping os.ErrNotExist, os.ErrExist or os.ErrPermission
+// where they apply.
+//
+// Except for the host file system returned by OS, the names are slash
+// separated paths relative to the root of the file system: "a/b", "/a/b"
+// and "../a/b" all name the same file.
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Mkdir(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+}
+
+// MkdirAllFS is a file system with an optimized MkdirAll.
+type MkdirAllFS interface {
+	FS
+	MkdirAll(name string, perm os.FileMode) error
+}
+
+// RemoveAllFS is a file system with an optimized RemoveAll.
+type RemoveAllFS interface {
+	FS
+	RemoveAll(name string) error
+}
+
+// ChmodFS is a file system that can change the mode of the files.
+type ChmodFS interface {
+	FS
+	Chmod(name string, mode os.FileMode) error
+}
+
+// TruncateFS is a file system that can change the size of the files.
+type TruncateFS interface {
+	FS
+	Truncate(name string, size int64) error
+}
+
+// Open opens the named file for reading.
+func Open(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates the named file.
+func Create(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// ReadFile reads the whole named file.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	f, err := Open(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ioutil.ReadAll(f)
+}
+
+// WriteFile writes data to the named file, creating it if necessary.
+func WriteFile(fsys FS, name string, data []byte, perm os.FileMode) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// MkdirAll creates the named directory along with any necessary parents.
+func MkdirAll(fsys FS, name string, perm os.FileMode) error {
+	if fsys, ok := fsys.(MkdirAllFS); ok {
+		return fsys.MkdirAll(name, perm)
+	}
+	name = clean(name)
+	if name == "/" {
+		return nil
+	}
+	if fi, err := fsys.Stat(name); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+	}
+	if err := MkdirAll(fsys, path.Dir(name), perm); err != nil {
+		return err
+	}
+	err := fsys.Mkdir(name, perm)
+	if err != nil && os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RemoveAll removes the named file or directory and any children it
+// contains. It returns nil if the name does not exist.
+func RemoveAll(fsys FS, name string) error {
+	if fsys, ok := fsys.(RemoveAllFS); ok {
+		return fsys.RemoveAll(name)
+	}
+	fi, err := fsys.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() {
+		names, err := readDirNames(fsys, name)
+		if err != nil {
+			return err
+		}
+		for _, child := range names {
+			if err := RemoveAll(fsys, path.Join(name, child)); err != nil {
+				return err
+			}
+		}
+	}
+	return fsys.Remove(name)
+}
+
+// Chmod changes the mode of the named file.
+func Chmod(fsys FS, name string, mode os.FileMode) error {
+	if fsys, ok := fsys.(ChmodFS); ok {
+		return fsys.Chmod(name, mode)
+	}
+	return &os.PathError{Op: "chmod", Path: name, Err: ErrNotSupported}
+}
+
+// Truncate changes the size of the named file.
+func Truncate(fsys FS, name string, size int64) error {
+	if fsys, ok := fsys.(TruncateFS); ok {
+		return fsys.Truncate(name, size)
+	}
+	return &os.PathError{Op: "truncate", Path: name, Err: ErrNotSupported}
+}
+
+func readDirNames(fsys FS, name string) ([]string, error) {
+	f, err := Open(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return f.Readdirnames(-1)
+}
+
+// clean returns the rooted slash separated form of the name.
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// within returns true if the cleaned name is the directory dir or is inside
+// it.
+func within(name, dir string) bool {
+	return name == dir || dir == "/" || strings.HasPrefix(name, dir+"/")
+}
+
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|
+		os.O_TRUNC) != 0
+}
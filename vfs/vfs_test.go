@@ -0,0 +1,233 @@
+package vfs_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/d5/tengo/v2/require"
+	"github.com/d5/tengo/v2/vfs"
+)
+
+func TestMemFS(t *testing.T) {
+	testFS(t, vfs.NewMemFS())
+}
+
+func TestDir(t *testing.T) {
+	root := tempDir(t)
+	defer func() { _ = os.RemoveAll(root) }()
+	testFS(t, vfs.Dir(root))
+}
+
+func TestOverlayFS(t *testing.T) {
+	testFS(t, vfs.NewOverlayFS(vfs.NewMemFS(), vfs.NewMemFS()))
+
+	lower := vfs.NewMemFS()
+	require.NoError(t, vfs.MkdirAll(lower, "a/b", 0755))
+	require.NoError(t, vfs.WriteFile(lower, "a/b/c.txt", []byte("lower"), 0644))
+	require.NoError(t, vfs.WriteFile(lower, "a/d.txt", []byte("d"), 0644))
+	upper := vfs.NewMemFS()
+	fsys := vfs.NewOverlayFS(upper, lower)
+
+	expectFile(t, fsys, "a/b/c.txt", "lower")
+	require.NoError(t, vfs.WriteFile(fsys, "a/b/c.txt", []byte("upper"), 0644))
+	expectFile(t, fsys, "a/b/c.txt", "upper")
+	expectFile(t, lower, "a/b/c.txt", "lower")
+	expectFile(t, upper, "a/b/c.txt", "upper")
+
+	// appending copies the lower file first
+	f, err := fsys.OpenFile("a/d.txt", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("!"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	expectFile(t, fsys, "a/d.txt", "d!")
+	expectFile(t, lower, "a/d.txt", "d")
+
+	// removed lower files are hidden
+	require.NoError(t, fsys.Remove("a/d.txt"))
+	expectNotExist(t, fsys, "a/d.txt")
+	expectFile(t, lower, "a/d.txt", "d")
+	expectNames(t, fsys, "a", "b")
+	require.NoError(t, vfs.RemoveAll(fsys, "a"))
+	expectNotExist(t, fsys, "a/b/c.txt")
+	expectNames(t, fsys, "/")
+	require.NoError(t, fsys.Mkdir("a", 0755))
+	expectNames(t, fsys, "a")
+	expectFile(t, lower, "a/b/c.txt", "lower")
+
+	// renaming a lower directory moves its children
+	fsys = vfs.NewOverlayFS(vfs.NewMemFS(), lower)
+	require.NoError(t, fsys.Rename("a", "x"))
+	expectFile(t, fsys, "x/b/c.txt", "lower")
+	expectNotExist(t, fsys, "a")
+	expectFile(t, lower, "a/b/c.txt", "lower")
+}
+
+func TestReadOnly(t *testing.T) {
+	mem := vfs.NewMemFS()
+	require.NoError(t, vfs.WriteFile(mem, "a.txt", []byte("a"), 0644))
+	fsys := vfs.ReadOnly(mem)
+
+	expectFile(t, fsys, "a.txt", "a")
+	_, err := fsys.OpenFile("a.txt", os.O_RDWR, 0)
+	require.True(t, os.IsPermission(err))
+	_, err = vfs.Create(fsys, "b.txt")
+	require.True(t, os.IsPermission(err))
+	require.True(t, os.IsPermission(fsys.Mkdir("dir", 0755)))
+	require.True(t, os.IsPermission(fsys.Remove("a.txt")))
+	require.True(t, os.IsPermission(fsys.Rename("a.txt", "b.txt")))
+	require.True(t, os.IsPermission(vfs.RemoveAll(fsys, "a.txt")))
+	expectFile(t, mem, "a.txt", "a")
+}
+
+func TestDirEscape(t *testing.T) {
+	tmp := tempDir(t)
+	defer func() { _ = os.RemoveAll(tmp) }()
+	root := filepath.Join(tmp, "root")
+	require.NoError(t, os.Mkdir(root, 0755))
+	outside := filepath.Join(tmp, "outside.txt")
+	require.NoError(t, ioutil.WriteFile(outside, []byte("outside"), 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "link")))
+	require.NoError(t, os.Symlink(tmp, filepath.Join(root, "dir")))
+	require.NoError(t, os.Symlink(filepath.Join(tmp, "new.txt"),
+		filepath.Join(root, "dangling")))
+
+	fsys := vfs.ReadOnlyDir(root)
+	expectNotExist(t, fsys, "../outside.txt")
+	_, err := vfs.ReadFile(fsys, "link")
+	require.True(t, os.IsPermission(err))
+	_, err = vfs.ReadFile(fsys, "dir/outside.txt")
+	require.True(t, os.IsPermission(err))
+
+	fsys = vfs.Dir(root)
+	err = vfs.WriteFile(fsys, "dangling", []byte("x"), 0644)
+	require.True(t, os.IsPermission(err))
+	_, err = os.Stat(filepath.Join(tmp, "new.txt"))
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, vfs.WriteFile(fsys, "/../a.txt", []byte("a"), 0644))
+	data, err := ioutil.ReadFile(filepath.Join(root, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(data))
+	f, err := vfs.Open(fsys, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "/a.txt", f.Name())
+	require.NoError(t, f.Close())
+}
+
+// testFS tests the common behaviors of the writable file systems.
+func testFS(t *testing.T, fsys vfs.FS) {
+	require.NoError(t, vfs.WriteFile(fsys, "a.txt", []byte("hello"), 0644))
+	expectFile(t, fsys, "a.txt", "hello")
+	expectFile(t, fsys, "/a.txt", "hello")
+	fi, err := fsys.Stat("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", fi.Name())
+	require.Equal(t, int64(5), fi.Size())
+	require.False(t, fi.IsDir())
+
+	_, err = fsys.OpenFile("a.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	require.True(t, os.IsExist(err))
+	expectNotExist(t, fsys, "b.txt")
+	_, err = vfs.Create(fsys, "x/b.txt")
+	require.True(t, os.IsNotExist(err))
+
+	// read, write and seek
+	f, err := fsys.OpenFile("a.txt", os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.Seek(1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("EL"))
+	require.NoError(t, err)
+	pos, err := f.Seek(-1, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), pos)
+	buf := make([]byte, 3)
+	n, err := f.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "o", string(buf[:n]))
+	_, err = f.Read(buf)
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, f.Close())
+	expectFile(t, fsys, "a.txt", "hELlo")
+
+	f, err = vfs.Open(fsys, "a.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	require.Error(t, err)
+	require.NoError(t, f.Close())
+
+	// directories
+	require.NoError(t, vfs.MkdirAll(fsys, "d/e/f", 0755))
+	require.NoError(t, vfs.MkdirAll(fsys, "d/e", 0755))
+	require.True(t, os.IsExist(fsys.Mkdir("d", 0755)))
+	require.NoError(t, vfs.WriteFile(fsys, "d/e/g.txt", nil, 0644))
+	fi, err = fsys.Stat("d/e")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	expectNames(t, fsys, "d/e", "f", "g.txt")
+	expectNames(t, fsys, "/", "a.txt", "d")
+	require.Error(t, fsys.Remove("d"))
+
+	// rename
+	require.NoError(t, fsys.Rename("d/e", "h"))
+	expectNames(t, fsys, "h", "f", "g.txt")
+	expectNotExist(t, fsys, "d/e")
+	require.NoError(t, fsys.Rename("a.txt", "h/a.txt"))
+	expectFile(t, fsys, "h/a.txt", "hELlo")
+
+	// chmod and truncate
+	require.NoError(t, vfs.Chmod(fsys, "h/a.txt", 0600))
+	fi, err = fsys.Stat("h/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(0600), int64(fi.Mode().Perm()))
+	require.NoError(t, vfs.Truncate(fsys, "h/a.txt", 2))
+	expectFile(t, fsys, "h/a.txt", "hE")
+
+	// remove
+	require.NoError(t, fsys.Remove("h/a.txt"))
+	expectNotExist(t, fsys, "h/a.txt")
+	require.True(t, os.IsNotExist(fsys.Remove("h/a.txt")))
+	require.NoError(t, vfs.RemoveAll(fsys, "h"))
+	require.NoError(t, vfs.RemoveAll(fsys, "h"))
+	expectNames(t, fsys, "/", "d")
+}
+
+func expectFile(t *testing.T, fsys vfs.FS, name, expected string) {
+	data, err := vfs.ReadFile(fsys, name)
+	require.NoError(t, err, name)
+	require.Equal(t, expected, string(data), name)
+}
+
+func expectNotExist(t *testing.T, fsys vfs.FS, name string) {
+	_, err := fsys.Stat(name)
+	require.True(t, os.IsNotExist(err), name)
+	_, err = vfs.Open(fsys, name)
+	require.True(t, os.IsNotExist(err), name)
+}
+
+func expectNames(t *testing.T, fsys vfs.FS, dir string, expected ...string) {
+	f, err := vfs.Open(fsys, dir)
+	require.NoError(t, err, dir)
+	defer func() { _ = f.Close() }()
+	names, err := f.Readdirnames(-1)
+	require.NoError(t, err, dir)
+	if len(expected) == 0 {
+		require.Equal(t, 0, len(names), dir)
+		return
+	}
+	sort.Strings(names)
+	require.Equal(t, expected, names, dir)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tengo-vfs")
+	require.NoError(t, err)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	return dir
+}
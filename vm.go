@@ -2,11 +2,14 @@ package tengo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
+	"time"
 
 	"github.com/d5/tengo/v2/parser"
 	"github.com/d5/tengo/v2/token"
+	"github.com/d5/tengo/v2/vfs"
 )
 
 // frame represents a function call frame.
@@ -19,6 +22,13 @@ type frame struct {
 	basePointer int
 }
 
+// tryHandler represents an active try block.
+type tryHandler struct {
+	framesIndex int
+	sp          int
+	catchPos    int
+}
+
 // VM is a virtual machine that executes the bytecode compiled by Compiler.
 type VM struct {
 	bc          *Bytecode
@@ -32,9 +42,31 @@ type VM struct {
 	curFrame    *frame
 	curInsts    []byte
 	ip          int
+	handlers    []tryHandler
+	debugger    *Debugger
+	profiler    *Profiler
+	profile     *vmProfile
+	coverage    *Coverage
+	cover       *vmCoverage
+	fs          vfs.FS
 	aborting    int64
 	maxAllocs   int64
 	allocs      int64
+	maxInsts    int64
+	insts       int64
+	maxMem      int64
+	mem         int64
+	memReported bool
+	budget      *budget // limits shared with the routines
+	gen         *Generator
+	suspension  *Suspension
+	calls       int64 // calls of the compiled functions by the Go functions
+	goCalls     int64 // calls of the Go functions by the VM, 1 if not running
+	routines    []*Routine
+	nested      bool
+	deadline    time.Time
+	ticks       int64
+	limited     bool
 	err         error
 }
 
@@ -55,6 +87,9 @@ func NewVM(
 		framesIndex: 1,
 		ip:          -1,
 		maxAllocs:   maxAllocs,
+		maxInsts:    -1,
+		maxMem:      -1,
+		goCalls:     1,
 	}
 	v.frames[0].fn = bytecode.MainFunction
 	v.frames[0].ip = -1
@@ -68,6 +103,73 @@ func (v *VM) Abort() {
 	atomic.StoreInt64(&v.aborting, 1)
 }
 
+// SetProfiler sets the profiler to record the execution of the VM. The
+// profiler is disabled if p is nil.
+func (v *VM) SetProfiler(p *Profiler) {
+	v.profiler = p
+}
+
+// SetCoverage sets the coverage to record the source lines and the branches
+// executed by the VM. The coverage is disabled if c is nil.
+func (v *VM) SetCoverage(c *Coverage) {
+	v.coverage = c
+}
+
+// SetMaxInstructions sets the maximum number of instructions the VM can
+// execute in a run. The run fails with ErrInstructionLimit if it exceeds the
+// limit. There is no limit if n is negative, which is the default.
+func (v *VM) SetMaxInstructions(n int64) {
+	v.maxInsts = n
+}
+
+// SetMaxMemory sets the maximum number of bytes the VM can allocate in a run.
+// The run fails with ErrMemoryLimit if it exceeds the limit. The sizes of the
+// strings, bytes, arrays, maps and instances are approximated. The memory is
+// not released when the objects become unreachable. There is no
+// limit if n is negative, which is the default.
+func (v *VM) SetMaxMemory(n int64) {
+	v.maxMem = n
+}
+
+// Alloc reports size bytes of memory allocated by a Go function called from
+// the VM. It returns ErrMemoryLimit if the run exceeded the memory limit. The
+// function should then return the error without allocating the memory. The
+// functions that do not report their allocations are charged the size of the
+// objects they return.
+func (v *VM) Alloc(size int64) error {
+	if v.maxMem < 0 {
+		return nil
+	}
+	v.memReported = true
+	v.mem -= size
+	if v.mem < 0 && !v.refillMem() {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// SetDeadline sets the time the runs of the VM must finish by. A run fails
+// with ErrTimeLimit if it is still executing the instructions after the
+// deadline. The calls to the Go functions are not interrupted. There is no
+// deadline if t is zero, which is the default.
+func (v *VM) SetDeadline(t time.Time) {
+	v.deadline = t
+}
+
+// SetFS sets the file system the functions called by the VM should use. The
+// host file system is used if fsys is nil.
+func (v *VM) SetFS(fsys vfs.FS) {
+	v.fs = fsys
+}
+
+// FS returns the file system the functions called by the VM should use.
+func (v *VM) FS() vfs.FS {
+	if v.fs == nil {
+		return vfs.OS()
+	}
+	return v.fs
+}
+
 // Run starts the execution.
 func (v *VM) Run() (err error) {
 	// reset VM states
@@ -76,13 +178,59 @@ func (v *VM) Run() (err error) {
 	v.curInsts = v.curFrame.fn.Instructions
 	v.framesIndex = 1
 	v.ip = -1
+	v.handlers = v.handlers[:0]
+	v.suspension = nil
+	v.err = nil
+	return v.execute()
+}
+
+// execute runs the instructions from the current position until the VM
+// returns, fails or suspends.
+func (v *VM) execute() (err error) {
+	atomic.AddInt64(&v.goCalls, -1)
+	defer atomic.AddInt64(&v.goCalls, 1)
+
 	v.allocs = v.maxAllocs + 1
+	v.insts = v.maxInsts
+	v.mem = v.maxMem
+	v.ticks = 0
+	v.limited = v.maxInsts >= 0 || !v.deadline.IsZero()
+
+	if v.profiler != nil {
+		v.profile = v.profiler.begin(v)
+		defer func() {
+			v.profiler.end(v.profile)
+			v.profile = nil
+		}()
+	}
+	if v.coverage != nil {
+		v.cover = v.coverage.begin(v)
+		defer func() {
+			v.coverage.end(v.cover)
+			v.cover = nil
+		}()
+	}
 
 	v.run()
+	for v.err != nil && v.catch() {
+		v.run()
+	}
+	if len(v.routines) > 0 {
+		v.stopRoutines()
+	}
+	if v.budget != nil {
+		v.release()
+		v.budget = nil
+	}
 
 	atomic.StoreInt64(&v.aborting, 0)
 
-	if err = v.err; err != nil {
+	if err = v.err; err != nil && v.nested {
+		// the frame of callFunction has no source position
+		if v.framesIndex > 1 {
+			err = v.traceError(err, 1)
+		}
+	} else if err != nil {
 		filePos := v.fileSet.Position(
 			v.curFrame.fn.SourcePos(v.ip - 1))
 		err = fmt.Errorf("Runtime Error: %w\n\tat %s",
@@ -94,6 +242,8 @@ func (v *VM) Run() (err error) {
 				v.curFrame.fn.SourcePos(v.curFrame.ip - 1))
 			err = fmt.Errorf("%w\n\tat %s", err, filePos)
 		}
+	} else if v.suspension != nil {
+		err = v.suspension
 	}
 	return
 }
@@ -112,6 +262,25 @@ func (v *VM) run() {
 	for atomic.LoadInt64(&v.aborting) == 0 {
 		v.ip++
 
+		if v.limited && !v.checkLimits() {
+			// the error position is the instruction that was not executed
+			v.ip++
+			return
+		}
+
+		if v.debugger != nil {
+			v.debugger.trace()
+			if atomic.LoadInt64(&v.aborting) != 0 {
+				return
+			}
+		}
+		if v.profile != nil {
+			v.profile.trace()
+		}
+		if v.cover != nil {
+			v.cover.trace()
+		}
+
 		switch v.curInsts[v.ip] {
 		case parser.OpConstant:
 			v.ip += 2
@@ -150,8 +319,10 @@ func (v *VM) run() {
 			}
 
 			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.allocs == 0 && !v.refillAllocs() {
+				return
+			}
+			if v.maxMem >= 0 && !v.allocObject(res) {
 				return
 			}
 
@@ -201,8 +372,7 @@ func (v *VM) run() {
 			case *Int:
 				var res Object = &Int{Value: ^x.Value}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = res
@@ -219,8 +389,7 @@ func (v *VM) run() {
 			case *Int:
 				var res Object = &Int{Value: -x.Value}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = res
@@ -228,8 +397,7 @@ func (v *VM) run() {
 			case *Float:
 				var res Object = &Float{Value: -x.Value}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = res
@@ -264,6 +432,21 @@ func (v *VM) run() {
 			}
 		case parser.OpJump:
 			v.ip = int(v.curInsts[v.ip+2]) | int(v.curInsts[v.ip+1])<<8 - 1
+		case parser.OpChainJump:
+			v.ip += 2
+			if isNullish(v.stack[v.sp-1]) {
+				v.stack[v.sp-1] = UndefinedValue
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
+		case parser.OpCoalesceJump:
+			v.ip += 2
+			if isNullish(v.stack[v.sp-1]) {
+				v.sp--
+			} else {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
 		case parser.OpSetGlobal:
 			v.ip += 2
 			v.sp--
@@ -303,8 +486,10 @@ func (v *VM) run() {
 
 			var arr Object = &Array{Value: elements}
 			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.allocs == 0 && !v.refillAllocs() {
+				return
+			}
+			if v.maxMem >= 0 && !v.allocObject(arr) {
 				return
 			}
 
@@ -323,8 +508,10 @@ func (v *VM) run() {
 
 			var m Object = &Map{Value: kv}
 			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.allocs == 0 && !v.refillAllocs() {
+				return
+			}
+			if v.maxMem >= 0 && !v.allocObject(m) {
 				return
 			}
 			v.stack[v.sp] = m
@@ -335,8 +522,7 @@ func (v *VM) run() {
 				Value: value,
 			}
 			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.allocs == 0 && !v.refillAllocs() {
 				return
 			}
 			v.stack[v.sp-1] = e
@@ -348,8 +534,7 @@ func (v *VM) run() {
 					Value: value.Value,
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp-1] = immutableArray
@@ -358,8 +543,7 @@ func (v *VM) run() {
 					Value: value.Value,
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp-1] = immutableMap
@@ -433,8 +617,7 @@ func (v *VM) run() {
 					Value: left.Value[lowIdx:highIdx],
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = val
@@ -470,8 +653,7 @@ func (v *VM) run() {
 					Value: left.Value[lowIdx:highIdx],
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = val
@@ -507,8 +689,7 @@ func (v *VM) run() {
 					Value: left.Value[lowIdx:highIdx],
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = val
@@ -544,8 +725,7 @@ func (v *VM) run() {
 					Value: left.Value[lowIdx:highIdx],
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
 					return
 				}
 				v.stack[v.sp] = val
@@ -725,6 +905,25 @@ func (v *VM) run() {
 					}
 				}
 
+				if callee.IsGenerator {
+					// suspend the call until the generator is resumed
+					g := &Generator{
+						vm:    v,
+						frame: frame{fn: callee, freeVars: callee.Free, ip: -1},
+						stack: make([]Object, callee.NumLocals),
+					}
+					g.frame.args.Value = args
+					g.frame.kwargs.Value = kwargs
+					copy(g.stack, v.stack[start:v.sp])
+					v.allocs--
+					if v.allocs == 0 && !v.refillAllocs() {
+						return
+					}
+					v.stack[start-1] = g
+					v.sp = start
+					break
+				}
+
 				// test if it's tail-call
 				if callee == v.curFrame.fn { // recursion
 					nextOp := v.curInsts[v.ip+1]
@@ -756,6 +955,11 @@ func (v *VM) run() {
 				v.curInsts = callee.Instructions
 				v.ip = -1
 
+				// clear the locals left by the previous calls
+				for sp := v.sp; sp < start+callee.NumLocals; sp++ {
+					v.stack[sp] = nil
+				}
+
 				v.framesIndex++
 				v.sp = v.curFrame.basePointer + callee.NumLocals
 			} else {
@@ -774,10 +978,20 @@ func (v *VM) run() {
 					ctx.This = v.stack[start-2]
 				}
 
-				ret, e := value.Call(ctx)
+				v.memReported = false
+				ret, e := v.callGo(value, ctx)
 
 				v.sp = start - 1
 
+				if v.suspension != nil {
+					if e == nil {
+						// the result is pushed when the VM is resumed
+						v.suspension.method = method
+						return
+					}
+					v.suspension = nil
+				}
+
 				// runtime error
 				if e != nil {
 					if e == ErrWrongNumArguments {
@@ -808,8 +1022,10 @@ func (v *VM) run() {
 					ret = UndefinedValue
 				}
 				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if v.allocs == 0 && !v.refillAllocs() {
+					return
+				}
+				if v.maxMem >= 0 && !v.allocReturn(ret, ctx) {
 					return
 				}
 				if method {
@@ -827,6 +1043,13 @@ func (v *VM) run() {
 			} else {
 				retVal = UndefinedValue
 			}
+			if v.gen != nil && v.framesIndex-1 == v.gen.frameIndex {
+				// the generator returned
+				v.gen.value = retVal
+				v.gen.running = false
+				v.gen.done = true
+				return
+			}
 			// v.sp--
 			v.framesIndex--
 			v.curFrame = &v.frames[v.framesIndex-1]
@@ -917,20 +1140,9 @@ func (v *VM) run() {
 				}
 			}
 			v.sp -= numFree
-			cl := &CompiledFunction{
-				Instructions: fn.Instructions,
-				IsMethod:     fn.IsMethod,
-				NumLocals:    fn.NumLocals,
-				NumArgs:      fn.NumArgs,
-				VarArgs:      fn.VarArgs,
-				KwargsNames:  fn.KwargsNames,
-				Kwargs:       fn.Kwargs,
-				VarKwargs:    fn.VarKwargs,
-				Free:         free,
-			}
+			cl := fn.closure(free)
 			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.allocs == 0 && !v.refillAllocs() {
 				return
 			}
 			v.stack[v.sp] = cl
@@ -993,9 +1205,9 @@ func (v *VM) run() {
 				return
 			}
 			iterator = dst.Iterate()
+			v.bindIterator(iterator)
 			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if v.allocs == 0 && !v.refillAllocs() {
 				return
 			}
 			v.stack[v.sp] = iterator
@@ -1004,6 +1216,18 @@ func (v *VM) run() {
 			iterator := v.stack[v.sp-1]
 			v.sp--
 			hasMore := iterator.(Iterator).Next()
+			switch it := iterator.(type) {
+			case *GeneratorIterator:
+				if it.err != nil {
+					v.err = it.err
+					return
+				}
+			case *ChannelIterator:
+				if it.err != nil {
+					v.err = it.err
+					return
+				}
+			}
 			if hasMore {
 				v.stack[v.sp] = TrueValue
 			} else {
@@ -1022,6 +1246,218 @@ func (v *VM) run() {
 			val := iterator.(Iterator).Value()
 			v.stack[v.sp] = val
 			v.sp++
+		case parser.OpTry:
+			v.ip += 2
+			v.handlers = append(v.handlers, tryHandler{
+				framesIndex: v.framesIndex,
+				sp:          v.sp,
+				catchPos:    int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8,
+			})
+		case parser.OpTryEnd:
+			v.handlers = v.handlers[:len(v.handlers)-1]
+		case parser.OpThrow:
+			v.sp--
+			if e, ok := v.stack[v.sp].(*Error); ok && e.err != nil {
+				v.err = e.err
+			} else {
+				v.err = fmt.Errorf("%s", v.stack[v.sp].String())
+			}
+			return
+		case parser.OpSwitch:
+			v.ip += 4
+			tableIndex := int(v.curInsts[v.ip-2]) | int(v.curInsts[v.ip-3])<<8
+			numCases := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+			table := v.bc.Constants[tableIndex].(*ImmutableMap)
+			v.sp--
+
+			// the n-th jump instruction following this is of the n-th case
+			// and the last one is of the default case.
+			caseIndex := numCases
+			if key, ok := switchKey(v.stack[v.sp]); ok {
+				if i, ok := table.Value[key]; ok {
+					caseIndex = int(i.(*Int).Value)
+				}
+			}
+			v.ip += caseIndex * 3
+		case parser.OpMatchArray:
+			v.ip += 3
+			numElements := int(v.curInsts[v.ip-1]) | int(v.curInsts[v.ip-2])<<8
+			hasRest := v.curInsts[v.ip] == 1
+
+			length := -1
+			switch value := v.stack[v.sp-1].(type) {
+			case *Array:
+				length = len(value.Value)
+			case *ImmutableArray:
+				length = len(value.Value)
+			}
+			if length == numElements ||
+				(hasRest && length > numElements) {
+				v.stack[v.sp-1] = TrueValue
+			} else {
+				v.stack[v.sp-1] = FalseValue
+			}
+		case parser.OpMatchMap:
+			v.ip += 2
+			numKeys := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+
+			var m map[string]Object
+			matched := true
+			switch value := v.stack[v.sp-numKeys-1].(type) {
+			case *Map:
+				m = value.Value
+			case *ImmutableMap:
+				m = value.Value
+			default:
+				matched = false
+			}
+			for i := v.sp - numKeys; matched && i < v.sp; i++ {
+				_, matched = m[v.stack[i].(*String).Value]
+			}
+			v.sp -= numKeys
+			if matched {
+				v.stack[v.sp-1] = TrueValue
+			} else {
+				v.stack[v.sp-1] = FalseValue
+			}
+		case parser.OpArrayRest:
+			v.ip += 2
+			numElements := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+
+			var elements []Object
+			switch value := v.stack[v.sp-1].(type) {
+			case *Array:
+				elements = value.Value
+			case *ImmutableArray:
+				elements = value.Value
+			default:
+				v.err = fmt.Errorf("not an array: %s", value.TypeName())
+				return
+			}
+			rest := make([]Object, 0)
+			if numElements < len(elements) {
+				rest = append(rest, elements[numElements:]...)
+			}
+
+			var val Object = &Array{Value: rest}
+			v.allocs--
+			if v.allocs == 0 && !v.refillAllocs() {
+				return
+			}
+			if v.maxMem >= 0 && !v.allocObject(val) {
+				return
+			}
+			v.stack[v.sp-1] = val
+		case parser.OpMapRest:
+			v.ip += 2
+			numKeys := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+
+			value := v.stack[v.sp-numKeys-1]
+			m, ok := objectValues(value)
+			if conv, isConv := value.(ToMapConverter); !ok && isConv {
+				m, ok = conv.ToMap(false).Value, true
+			}
+			if !ok {
+				v.err = fmt.Errorf("not a map: %s", value.TypeName())
+				return
+			}
+			rest := make(map[string]Object, len(m))
+			for k, e := range m {
+				rest[k] = e
+			}
+			for i := v.sp - numKeys; i < v.sp; i++ {
+				delete(rest, v.stack[i].(*String).Value)
+			}
+			v.sp -= numKeys
+
+			var val Object = &Map{Value: rest}
+			v.allocs--
+			if v.allocs == 0 && !v.refillAllocs() {
+				return
+			}
+			if v.maxMem >= 0 && !v.allocObject(val) {
+				return
+			}
+			v.stack[v.sp-1] = val
+		case parser.OpSpreadArray:
+			arr := v.stack[v.sp-2].(*Array)
+			value := v.stack[v.sp-1]
+			v.sp--
+
+			numElements := len(arr.Value)
+			switch value := value.(type) {
+			case *Array:
+				arr.Value = append(arr.Value, value.Value...)
+			case *ImmutableArray:
+				arr.Value = append(arr.Value, value.Value...)
+			default:
+				if !value.CanIterate() {
+					v.err = fmt.Errorf("not iterable: %s", value.TypeName())
+					return
+				}
+				it := value.Iterate()
+				v.bindIterator(it)
+				for it.Next() {
+					arr.Value = append(arr.Value, it.Value())
+				}
+				if it, ok := it.(interface{ Err() error }); ok &&
+					it.Err() != nil {
+					v.err = it.Err()
+					return
+				}
+			}
+			size := int64(len(arr.Value)-numElements) * refSize
+			if err := v.Alloc(size); err != nil {
+				v.err = err
+				return
+			}
+		case parser.OpSpreadMap:
+			m := v.stack[v.sp-2].(*Map)
+			value := v.stack[v.sp-1]
+			v.sp--
+
+			// undefined is spread as an empty map like it's iterated as an
+			// empty array.
+			entries, ok := objectValues(value)
+			if conv, isConv := value.(ToMapConverter); !ok && isConv {
+				entries, ok = conv.ToMap(false).Value, true
+			}
+			if !ok && value != UndefinedValue {
+				v.err = fmt.Errorf("not a map: %s", value.TypeName())
+				return
+			}
+			for k, e := range entries {
+				if err := allocMapEntry(v, m.Value, k); err != nil {
+					v.err = err
+					return
+				}
+				m.Value[k] = e
+			}
+		case parser.OpMatchType:
+			typ := v.stack[v.sp-1]
+			value := v.stack[v.sp-2]
+			v.sp--
+
+			var matched bool
+			switch typ := typ.(type) {
+			case *String:
+				matched = value.TypeName() == typ.Value
+			case *Type:
+				inst, ok := value.(ObjectInstancer)
+				matched = ok && inst.InstanceType() == typ
+			default:
+				v.err = fmt.Errorf("invalid type pattern: %s", typ.TypeName())
+				return
+			}
+			if matched {
+				v.stack[v.sp-1] = TrueValue
+			} else {
+				v.stack[v.sp-1] = FalseValue
+			}
+		case parser.OpYield:
+			v.sp--
+			v.yield(v.stack[v.sp])
+			return
 		case parser.OpSuspend:
 			return
 		default:
@@ -1031,6 +1467,234 @@ func (v *VM) run() {
 	}
 }
 
+// timeCheckInterval is the number of instructions executed between the
+// checks of the deadline.
+const timeCheckInterval = 1024
+
+// checkLimits returns false and sets the runtime error if the execution
+// exceeded the instruction or the time budget.
+func (v *VM) checkLimits() bool {
+	var err error
+	if v.maxInsts >= 0 {
+		if v.insts == 0 && !v.refillInsts() {
+			err = ErrInstructionLimit
+		} else {
+			v.insts--
+		}
+	}
+	if err == nil && !v.deadline.IsZero() {
+		v.ticks++
+		if v.ticks%timeCheckInterval == 0 && time.Now().After(v.deadline) {
+			err = ErrTimeLimit
+		}
+	}
+	if err != nil {
+		v.err = &LimitError{Err: err, Pos: v.Pos()}
+		return false
+	}
+	return true
+}
+
+// allocObject returns false and sets the runtime error if the allocation of
+// the object exceeds the memory limit.
+func (v *VM) allocObject(o Object) bool {
+	v.mem -= sizeOf(o)
+	if v.mem < 0 && !v.refillMem() {
+		v.err = ErrMemoryLimit
+		return false
+	}
+	return true
+}
+
+// allocReturn charges the object returned by a Go function unless the
+// function reported its allocations using Alloc or returned an argument.
+func (v *VM) allocReturn(o Object, ctx *CallContext) bool {
+	if v.mem < 0 {
+		v.err = ErrMemoryLimit
+		return false
+	}
+	if v.memReported || o == UndefinedValue || o == ctx.This {
+		return true
+	}
+	for _, arg := range ctx.Args {
+		if o == arg {
+			return true
+		}
+	}
+	return v.allocObject(o)
+}
+
+// bindIterator sets the VM iterating the channels and the generators. The
+// receives are cancelled when the run is done. The generators are resumed on
+// the VM.
+func (v *VM) bindIterator(it Object) {
+	switch it := it.(type) {
+	case *ChannelIterator:
+		it.vm = v
+	case *GeneratorIterator:
+		it.vm = v
+	}
+}
+
+// callGo calls the callable object as a Go function of the VM. The object can
+// call the compiled functions with the VM until it returns.
+func (v *VM) callGo(fn Object, ctx *CallContext) (Object, error) {
+	if v == nil {
+		return fn.Call(ctx)
+	}
+	atomic.AddInt64(&v.goCalls, 1)
+	defer atomic.AddInt64(&v.goCalls, -1)
+	return fn.Call(ctx)
+}
+
+// callFunction is the function of the call frames calling the compiled
+// functions for the Go functions. It calls the function with the args and the
+// kwargs on the stack. It stops when the function returns.
+var callFunction = &CompiledFunction{
+	Instructions: append(MakeInstruction(parser.OpCall, 0, 1, 0, 1),
+		MakeInstruction(parser.OpSuspend)...),
+}
+
+// call calls the compiled function on top of the current call frame, then
+// restores the state of the VM. It is used by the Go functions to call the
+// compiled functions passed to them. The function shares the limits and the
+// context of the run. Its runtime errors are returned with the source
+// positions of its call frames.
+func (v *VM) call(
+	fn *CompiledFunction,
+	args []Object,
+	kwargs map[string]Object,
+) (Object, error) {
+	base := v.sp
+	if v.framesIndex >= MaxFrames || base+3 >= StackSize {
+		return nil, ErrStackOverflow
+	}
+
+	// save the state of the VM
+	var (
+		curFrame    = v.curFrame
+		curInsts    = v.curInsts
+		ip          = v.ip
+		sp          = v.sp
+		framesIndex = v.framesIndex
+		numHandlers = len(v.handlers)
+		gen         = v.gen
+	)
+	defer func() {
+		v.curFrame = curFrame
+		v.curInsts = curInsts
+		v.ip = ip
+		v.sp = sp
+		v.framesIndex = framesIndex
+		v.handlers = v.handlers[:numHandlers]
+		v.gen = gen
+		v.memReported = true
+	}()
+
+	v.curFrame.ip = v.ip + 1 // the positions of the errors are ip - 1
+	v.stack[base] = fn
+	v.stack[base+1] = &Array{Value: args}
+	v.stack[base+2] = &Map{Value: kwargs}
+	v.sp = base + 3
+	v.curFrame = &v.frames[v.framesIndex]
+	*v.curFrame = frame{fn: callFunction, basePointer: v.sp}
+	v.curInsts = callFunction.Instructions
+	v.ip = -1
+	v.framesIndex++
+	v.gen = nil
+
+	v.run()
+	for v.err != nil && len(v.handlers) > numHandlers && v.catch() {
+		v.run()
+	}
+	if err := v.err; err != nil {
+		v.err = nil
+		return nil, v.traceError(err, framesIndex+1)
+	}
+	if atomic.LoadInt64(&v.aborting) != 0 {
+		return nil, ErrAborted
+	}
+	return v.stack[base], nil
+}
+
+// callContext runs the VM calling the function instead of the main function.
+// It returns the result, or the runtime error with the source positions of
+// the call frames of the function. The run cannot be suspended.
+func (v *VM) callContext(
+	ctx context.Context,
+	fn Object,
+	args []Object,
+	kwargs map[string]Object,
+) (Object, error) {
+	old := v.context
+	defer func() {
+		v.context = old
+	}()
+	v.context = &VmContext{Context: ctx, VM: v}
+
+	v.stack[0] = fn
+	v.stack[1] = &Array{Value: args}
+	v.stack[2] = &Map{Value: kwargs}
+	v.sp = 3
+	v.frames[0] = frame{fn: callFunction}
+	v.curFrame = &v.frames[0]
+	v.curInsts = callFunction.Instructions
+	v.framesIndex = 1
+	v.ip = -1
+	v.handlers = v.handlers[:0]
+	v.suspension = nil
+	v.err = nil
+	v.nested = true
+	if err := v.execute(); err != nil {
+		return nil, err
+	}
+	return v.stack[0], nil
+}
+
+// catch transfers the control to the innermost try handler if the current
+// runtime error can be caught. The error is pushed onto the stack as an Error
+// object.
+func (v *VM) catch() bool {
+	if len(v.handlers) == 0 || errors.Is(v.err, ErrObjectAllocLimit) ||
+		errors.Is(v.err, ErrMemoryLimit) {
+		return false
+	}
+	var limitErr *LimitError
+	if errors.As(v.err, &limitErr) {
+		return false
+	}
+	h := v.handlers[len(v.handlers)-1]
+	v.handlers = v.handlers[:len(v.handlers)-1]
+
+	err, trace := errorTrace(v.err)
+	errObj := &Error{
+		Value: &String{Value: err.Error()},
+		err:   err,
+		pos: v.fileSet.Position(
+			v.curFrame.fn.SourcePos(v.ip - 1)),
+	}
+	if len(trace) > 0 {
+		errObj.pos = trace[0]
+	}
+	v.err = nil
+
+	// unwind call frames
+	v.framesIndex = h.framesIndex
+	v.curFrame = &v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = h.catchPos - 1
+	v.sp = h.sp
+	v.stack[v.sp] = errObj
+	v.sp++
+	return true
+}
+
+// Pos returns the source position of the instruction being executed. The Go
+// functions called by the VM can use it to find the position of the call.
+func (v *VM) Pos() parser.SourceFilePos {
+	return v.fileSet.Position(v.curFrame.fn.SourcePos(v.ip))
+}
+
 // IsStackEmpty tests if the stack is empty or not.
 func (v *VM) IsStackEmpty() bool {
 	return v.sp == 0
@@ -1069,3 +1733,13 @@ func indexAssign(vm *VM, dst, src Object, selectors []Object) error {
 	}
 	return nil
 }
+
+// isNullish returns true if the value short-circuits the optional chaining
+// and the nil-coalescing operators.
+func isNullish(o Object) bool {
+	switch o.(type) {
+	case *Undefined, *Error:
+		return true
+	}
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	_runtime "runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/d5/tengo/v2"
 	"github.com/d5/tengo/v2/parser"
@@ -970,6 +971,63 @@ out = [
 `, nil, ARR{"abc...", "abc", "ab", "ab..."})
 }
 
+func TestCallFromGo(t *testing.T) {
+	apply := &tengo.UserFunctionCtx{
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			return ctx.Args[0].Call(&tengo.CallContext{
+				VM:     ctx.VM,
+				Args:   ctx.Args[1:],
+				Kwargs: ctx.Kwargs,
+			})
+		},
+	}
+	opts := Opts().Symbol("apply", apply).Skip2ndPass()
+
+	expectRun(t, `out = apply(func(x) { return x * 2 }, 21)`, opts, 42)
+	expectRun(t, `out = apply(func(x; y=1) { return x + y }, 1; y=2)`,
+		opts, 3)
+	expectRun(t, `
+f := func(n) { return n < 2 ? n : apply(f, n-1) + apply(f, n-2) }
+out = apply(f, 15)`, opts, 610)
+	expectRun(t, `
+g := func() { for i := 0; i < 3; i++ { yield apply(func(x) { return x }, i) } }
+out = []
+for x in g() { out = append(out, x) }`, opts, ARR{0, 1, 2})
+
+	// runtime errors
+	expectError(t, `
+f := func() { return 1 - "a" }
+apply(f)`, opts, "Runtime Error: invalid operation: int - string\n\tat test:2:22\n\tat test:3:1")
+	expectRun(t, `
+try { apply(func() { 1 - "a" }) } catch e { out = "caught" }`, opts, "caught")
+	expectRun(t, `
+out = apply(func() { try { 1 - "a" } catch e { return "inner" } })`,
+		opts, "inner")
+	expectError(t, `apply(func() { for { a := [] } })`,
+		opts.copy().MaxAllocs(100), "allocation limit exceeded")
+
+	// calls from another goroutine while the VM is running
+	var callErr error
+	done := make(chan struct{})
+	start := &tengo.UserFunctionCtx{
+		Value: func(ctx *tengo.CallContext) (tengo.Object, error) {
+			fn, vm := ctx.Args[0], ctx.VM
+			go func() {
+				defer close(done)
+				time.Sleep(10 * time.Millisecond)
+				_, callErr = fn.Call(&tengo.CallContext{VM: vm})
+				vm.Abort()
+			}()
+			return tengo.UndefinedValue, nil
+		},
+	}
+	expectRun(t, `out = 1; start(func() { return 2 }); for {}`,
+		Opts().Symbol("start", start).Skip2ndPass(), 1)
+	<-done
+	require.Equal(t, "compiled function is called concurrently with VM",
+		callErr.Error())
+}
+
 func TestChar(t *testing.T) {
 	expectRun(t, `out = 'a'`, nil, 'a')
 	expectRun(t, `out = '九'`, nil, rune(20061))
@@ -2133,7 +2191,7 @@ func (o *StringDict) TypeName() string {
 	return "string-dict"
 }
 
-func (o *StringDict) IndexGet(index tengo.Object) (tengo.Object, error) {
+func (o *StringDict) IndexGet(_ *tengo.VM, index tengo.Object) (tengo.Object, error) {
 	strIdx, ok := index.(*tengo.String)
 	if !ok {
 		return nil, tengo.ErrInvalidIndexType
@@ -2148,7 +2206,7 @@ func (o *StringDict) IndexGet(index tengo.Object) (tengo.Object, error) {
 	return tengo.UndefinedValue, nil
 }
 
-func (o *StringDict) IndexSet(index, value tengo.Object) error {
+func (o *StringDict) IndexSet(_ *tengo.VM, index, value tengo.Object) error {
 	strIdx, ok := index.(*tengo.String)
 	if !ok {
 		return tengo.ErrInvalidIndexType
@@ -2177,7 +2235,7 @@ func (o *StringCircle) String() string {
 	return ""
 }
 
-func (o *StringCircle) IndexGet(index tengo.Object) (tengo.Object, error) {
+func (o *StringCircle) IndexGet(_ *tengo.VM, index tengo.Object) (tengo.Object, error) {
 	intIdx, ok := index.(*tengo.Int)
 	if !ok {
 		return nil, tengo.ErrInvalidIndexType
@@ -2191,7 +2249,7 @@ func (o *StringCircle) IndexGet(index tengo.Object) (tengo.Object, error) {
 	return &tengo.String{Value: o.Value[r]}, nil
 }
 
-func (o *StringCircle) IndexSet(index, value tengo.Object) error {
+func (o *StringCircle) IndexSet(_ *tengo.VM, index, value tengo.Object) error {
 	intIdx, ok := index.(*tengo.Int)
 	if !ok {
 		return tengo.ErrInvalidIndexType
@@ -2270,7 +2328,7 @@ func (o *StringArray) TypeName() string {
 	return "string-array"
 }
 
-func (o *StringArray) IndexGet(index tengo.Object) (tengo.Object, error) {
+func (o *StringArray) IndexGet(_ *tengo.VM, index tengo.Object) (tengo.Object, error) {
 	intIdx, ok := index.(*tengo.Int)
 	if ok {
 		if intIdx.Value >= 0 && intIdx.Value < int64(len(o.Value)) {
@@ -2294,7 +2352,7 @@ func (o *StringArray) IndexGet(index tengo.Object) (tengo.Object, error) {
 	return nil, tengo.ErrInvalidIndexType
 }
 
-func (o *StringArray) IndexSet(index, value tengo.Object) error {
+func (o *StringArray) IndexSet(_ *tengo.VM, index, value tengo.Object) error {
 	strVal, ok := tengo.ToString(value)
 	if !ok {
 		return tengo.ErrInvalidIndexValueType
@@ -2547,6 +2605,187 @@ func TestLogical(t *testing.T) {
 		nil, 7)
 }
 
+func TestOptional(t *testing.T) {
+	expectRun(t, `a := {b: {c: 5}}; out = a?.b?.c`, nil, 5)
+	expectRun(t, `a := undefined; out = a?.b.c`, nil, tengo.UndefinedValue)
+	expectRun(t, `a := error("x"); out = a?.value`, nil, tengo.UndefinedValue)
+	expectRun(t, `a := error("x"); out = a.value`, nil, "x")
+	expectRun(t, `a := [1, [2, 3]]; out = a?.[1]?.[0]`, nil, 2)
+	expectRun(t, `a := undefined; out = a?.[1][0]`, nil, tengo.UndefinedValue)
+	expectRun(t, `a := [1, 2, 3]; out = a?.[1:]`, nil, ARR{2, 3})
+	expectRun(t, `a := undefined; out = a?.[1:]`, nil, tengo.UndefinedValue)
+	expectRun(t, `f := func(x) { return x * 2 }; out = f?.(4)`, nil, 8)
+	expectRun(t, `f := undefined; out = f?.(4)`, nil, tengo.UndefinedValue)
+	expectRun(t, `a := {}; out = a.f?.(1).b()`, nil, tengo.UndefinedValue)
+	expectRun(t, `a := {f: func() { return {b: 3} }}; out = a.f?.()?.b`,
+		nil, 3)
+
+	// the rest of the chain is not evaluated
+	expectRun(t, `a := undefined; f := func() { out = 1 }; a?.[f()].b(f())`,
+		nil, tengo.UndefinedValue)
+	expectRun(t, `a := {b: func(x) {}}; f := func() { out = 1 }; a?.b(f())`,
+		nil, 1)
+
+	// parentheses end the chain
+	expectError(t, `a := undefined; (a?.b)()`, nil, "not callable")
+	expectRun(t, `
+f := func(a) {
+	return a?.b?.c
+}
+out = [f({b: {c: 1}}), f({}), f(undefined)]`,
+		nil, ARR{1, tengo.UndefinedValue, tengo.UndefinedValue})
+
+	expectRun(t, `out = undefined ?? 1`, nil, 1)
+	expectRun(t, `out = error("x") ?? 2`, nil, 2)
+	expectRun(t, `out = 0 ?? 3`, nil, 0)
+	expectRun(t, `out = false ?? 3`, nil, false)
+	expectRun(t, `out = "" ?? 3`, nil, "")
+	expectRun(t, `out = undefined ?? error("x") ?? 4`, nil, 4)
+	expectRun(t, `a := {}; out = a.b?.c ?? a.d ?? 5`, nil, 5)
+	expectRun(t, `out = undefined ?? 1 + 2`, nil, 3)
+	expectRun(t, `out = undefined ?? false || 6`, nil, 6)
+	expectRun(t, `out = 7 ?? undefined ? 8 : 9`, nil, 8)
+	expectRun(t, `f := func() { out = 1 }; out = 10 ?? f()`, nil, 10)
+	expectRun(t, `
+f := func(a, b) {
+	return a ?? b
+}
+out = [f(1, 2), f(undefined, 2)]`, nil, ARR{1, 2})
+
+	expectError(t, `a := {}; a?.b = 1`, nil,
+		"optional chaining not allowed in assignment")
+	expectError(t, `a := {}; a?.["b"].c += 1`, nil,
+		"optional chaining not allowed in assignment")
+}
+
+func TestDestructuring(t *testing.T) {
+	expectRun(t, `a, b := [1, 2]; out = a + b`, nil, 3)
+	expectRun(t, `a, b, ...r := [1, 2, 3, 4]; out = [a, b, r]`,
+		nil, ARR{1, 2, ARR{3, 4}})
+	expectRun(t, `a, b, ...r := [1]; out = [a, b, r]`,
+		nil, ARR{1, tengo.UndefinedValue, ARR{}})
+	expectRun(t, `[_, a, ...] := immutable([1, 2, 3]); out = a`, nil, 2)
+	expectRun(t, `a, [b, c] := [1, [2, 3]]; out = a + b + c`, nil, 6)
+	expectRun(t, `v, err := [1, error("e")]; out = [v, err.value]`,
+		nil, ARR{1, "e"})
+	expectRun(t, `a, b := "xy"; out = [a, b]`, nil, ARR{'x', 'y'})
+
+	// defaults are used for undefined elements
+	expectRun(t, `a, [b = 2, c = 3] := [1, [0]]; out = [a, b, c]`,
+		nil, ARR{1, 0, 3})
+	expectRun(t, `[a = 1] := [undefined]; out = a`, nil, 1)
+	expectRun(t, `[a = 1] := [false]; out = a`, nil, false)
+	expectRun(t, `c := 0; f := func() { c++ }; [a = f()] := [2]; out = [a, c]`,
+		nil, ARR{2, 0})
+	expectRun(t, `[a, b = a * 2] := [3]; out = b`, nil, 6)
+
+	// map patterns
+	expectRun(t, `
+{name, age: years, ...others} := {name: "n", age: 3, k: 1}
+out = [name, years, others]`, nil, ARR{"n", 3, MAP{"k": 1}})
+	expectRun(t, `{a = 1, "b c": b = 2} := {a: 5}; out = [a, b]`,
+		nil, ARR{5, 2})
+	expectRun(t, `{a: [b, c]} := immutable({a: [1, 2]}); out = b + c`, nil, 3)
+	expectRun(t, `
+P := type("P", fields(x=0, y=0, z=0))
+{x, ...r} := P(; x=1, y=2, z=3)
+out = [x, r]`, nil, ARR{1, MAP{"y": 2, "z": 3}})
+	expectRun(t, `m := {a: 1}; {...r} := m; r.a = 2; out = m.a`, nil, 1)
+
+	// ":=" defines new variables and assigns the others
+	expectRun(t, `a := 1; a, b := [2, 3]; out = [a, b]`, nil, ARR{2, 3})
+	expectRun(t, `a := 1; func() { a, b := [2, 3] }(); out = a`, nil, 1)
+	expectRun(t, `a := 1; if true { a, b := [2, 3] }; out = a`, nil, 1)
+
+	// "=" assigns variables, selectors and indexes
+	expectRun(t, `a := 0; b := 0; a, b = [1, 2]; out = a + b`, nil, 3)
+	expectRun(t, `a := 1; b := 2; a, b = [b, a]; out = [a, b]`,
+		nil, ARR{2, 1})
+	expectRun(t, `m := {}; x := [0]; [m.a, x[0]] = [1, 2]; out = [m, x]`,
+		nil, ARR{MAP{"a": 1}, ARR{2}})
+	expectRun(t, `
+a := 0
+f := func() { {b: a} = {b: 5} }
+f()
+out = a`, nil, 5)
+
+	// for-in statements
+	expectRun(t, `
+out = 0
+for i, [a, b] in [[1, 2], [3, 4]] { out += i * 10 + a * b }`, nil, 24)
+	expectRun(t, `
+out = []
+for {k, v = 0} in [{k: "a", v: 1}, {k: "b"}] { out = append(out, k, v) }`,
+		nil, ARR{"a", 1, "b", 0})
+	expectRun(t, `
+f := func(xs) {
+	s := 0
+	for [a, ...r] in xs { s += a + len(r) }
+	return s
+}
+out = f([[1, 2, 3], [4]])`, nil, 7)
+
+	// function parameters
+	expectRun(t, `f := func([a, b], {c = 3}) { return a + b + c }; out = f([1, 2], {})`,
+		nil, 6)
+	expectRun(t, `f := func(x, ...[a, b]) { return [x, a, b] }; out = f(1, 2, 3)`,
+		nil, ARR{1, 2, 3})
+	expectRun(t, `
+f := func([a, b]) {
+	return func() { return a + b }
+}
+out = f([1, 2])()`, nil, 3)
+
+	expectError(t, `a, b := 1, 2`, nil, "tuple assignment not allowed")
+	expectError(t, `a, b := [1]; a, b := [2]`, nil,
+		"no new variables on left side of :=")
+	expectError(t, `a, a := [1, 2]`, nil, "'a' redeclared in this block")
+	expectError(t, `m := {}; m.a, b := [1, 2]`, nil,
+		"operator ':=' not allowed with selector")
+	expectError(t, `a, b = [1, 2]`, nil, "unresolved reference 'a'")
+	expectError(t, `a, 1 = [1, 2]`, nil, "invalid destructuring target: 1")
+	expectError(t, `a, ...r := 1`, nil, "not indexable")
+	expectError(t, `[...r] := 1`, nil, "not an array: int")
+	expectError(t, `{...r} := 1`, nil, "not a map: int")
+}
+
+func TestSpreadLiteral(t *testing.T) {
+	expectRun(t, `xs := [2, 3]; out = [1, ...xs, 4]`, nil, ARR{1, 2, 3, 4})
+	expectRun(t, `xs := [1]; out = [...xs, ...xs, ...[], ...immutable([2])]`,
+		nil, ARR{1, 1, 2})
+	expectRun(t, `xs := [1]; ys := [...xs]; ys[0] = 2; out = [xs, ys]`,
+		nil, ARR{ARR{1}, ARR{2}})
+	expectRun(t, `out = [..."ab", ...bytes("c"), ...undefined]`,
+		nil, ARR{'a', 'b', 99})
+	expectRun(t, `out = [...{a: 1}]`, nil, ARR{1})
+	expectRun(t, `g := func() { yield 1; yield 2 }; out = [0, ...g()]`,
+		nil, ARR{0, 1, 2})
+	expectRun(t, `f := func(...a) { return [...a, len(a)] }; out = f(1, 2)`,
+		nil, ARR{1, 2, 2})
+
+	expectRun(t, `
+defaults := {a: 1, b: 2}
+overrides := {b: 3}
+out = {...defaults, ...overrides, c: 4}`, nil, MAP{"a": 1, "b": 3, "c": 4})
+	expectRun(t, `m := {a: 1}; out = {a: 0, ...m, ...immutable({b: 2})}`,
+		nil, MAP{"a": 1, "b": 2})
+	expectRun(t, `m := {a: 1}; out = {...m, a: 2}; out.b = 3; out = [m, out]`,
+		nil, ARR{MAP{"a": 1}, MAP{"a": 2, "b": 3}})
+	expectRun(t, `out = {...undefined}`, nil, MAP{})
+	expectRun(t, `
+P := type("P", fields(x=0, y=0))
+out = {...P(; x=1), z: 3}`, nil, MAP{"x": 1, "y": 0, "z": 3})
+
+	// the elements are evaluated in order
+	expectRun(t, `
+out = []
+f := func(x) { out = append(out, x); return [x] }
+a := [...f(1), f(2)[0], ...f(3)]`, nil, ARR{1, 2, 3})
+
+	expectError(t, `a := [...1]`, nil, "not iterable: int")
+	expectError(t, `a := {...[1]}`, nil, "not a map: array")
+}
+
 func TestMap(t *testing.T) {
 	expectRun(t, `
 out = {
@@ -3692,6 +3931,500 @@ func() {
 }()`, nil, 25)
 }
 
+func TestTry(t *testing.T) {
+	expectRun(t, `out = 0; try { out = 1 } catch { out = 2 }`, nil, 1)
+	expectRun(t, `try { 1 + "a" } catch err { out = err.value }`,
+		nil, "invalid operation: int + string")
+	expectRun(t, `try { 1 + "a" } catch err { out = is_error(err) }`,
+		nil, true)
+	expectRun(t, `out = import("mod1")`, Opts().Module("mod1",
+		`try { 1 + "a" } catch err { export err.pos }`), "mod1:1:7")
+	expectRun(t, `try { 1 + "a" } catch { out = 1 }`, nil, 1)
+	expectRun(t, `try { [1, 2] + 1 } catch _ { out = 1 }`, nil, 1)
+	expectRun(t, `
+f := func() { return 1 + "a" }
+try { f() } catch err { out = err.value }`,
+		nil, "invalid operation: int + string")
+	expectRun(t, `
+f := func(n) { if n == 0 { return {}() }; return f(n-1) }
+try { f(10) } catch err { out = err.value }`,
+		nil, "not callable: map")
+	expectRun(t, `out = func() { try { return 1 + "a" } catch { return 2 } }()`,
+		nil, 2)
+	expectRun(t, `
+a := 1
+out = func() {
+	b := 2
+	try { c := 3; b = c + "a" } catch err { return a + b }
+}()`, nil, 3)
+
+	// nested
+	expectRun(t, `
+try {
+	try { 1 + "a" } catch err { out = "inner"; 1 + "b" }
+} catch err { out += " outer" }`, nil, "inner outer")
+	expectRun(t, `
+try {
+	try { 1 + "a" } finally { out = "finally" }
+} catch err { out += " " + err.value }`,
+		nil, "finally invalid operation: int + string")
+
+	// finally
+	expectRun(t, `out = ""; try { out += "a" } finally { out += "b" }`,
+		nil, "ab")
+	expectRun(t, `
+out = ""
+try { out += "a"; 1 + "a" } catch { out += "b" } finally { out += "c" }`,
+		nil, "abc")
+	expectRun(t, `
+out = ""
+try {
+	try { 1 + "a" } catch { out += "a"; 1 + "b" } finally { out += "b" }
+} catch err { out += err.value }`,
+		nil, "abinvalid operation: int + string")
+	expectRun(t, `
+out = ""
+f := func() { try { return 1 } finally { out += "f" } }
+r := f()
+out += string(r)`, nil, "f1")
+	expectRun(t, `
+out = ""
+for i := 0; i < 3; i++ {
+	try {
+		if i == 1 { continue }
+		if i == 2 { break }
+		out += "t"
+	} finally { out += string(i) }
+}`, nil, "t012")
+	expectRun(t, `
+out = 0
+for x in [1, 2, 3] {
+	try { try { out += x; break } finally { out += 10 } } finally { out += 100 }
+}`, nil, 111)
+
+	// error in finally and errors that cannot be caught
+	expectError(t, `a := 0; try { 1 + "a" } finally { a = 1 }`,
+		nil, "invalid operation: int + string")
+	expectError(t, `try { 1 } finally { 1 + "b" }`,
+		nil, "invalid operation: int + string")
+	expectError(t, `try { 1 + "a" } catch { 1 + "b" }`,
+		nil, "invalid operation: int + string")
+	expectError(t, `try { a := [1, 2, 3]; for { a = a + [1] } } catch { }`,
+		Opts().MaxAllocs(20), "allocation limit exceeded")
+
+	expectError(t, `a := 0; try {} catch err {}; a = err`,
+		nil, "unresolved reference 'err'")
+}
+
+func TestRoutine(t *testing.T) {
+	expectRun(t, `
+ch := chan()
+r := go(func(a, b) { ch.send(a + b); return a * b }, 2, 3)
+out = [ch.recv(), r.wait()]`, nil, ARR{5, 6})
+
+	expectRun(t, `
+r := go(func(a; b=1) { return a + b }, 2, b=3)
+out = [r.wait(), r.done, type_name(r)]`, nil, ARR{5, true, "routine"})
+
+	// fan out
+	expectRun(t, `
+ch := chan(10)
+rs := []
+for i := 0; i < 5; i++ {
+	rs = append(rs, go(func(x) { ch.send(x * x) }, i))
+}
+for r in rs { r.wait() }
+ch.close()
+out = 0
+for v in ch { out += v }`, nil, 30)
+
+	expectRun(t, `
+ch := chan()
+go(func() {
+	for i := 0; i < 3; i++ { ch.send(i) }
+	ch.close()
+})
+out = []
+for i, v in ch { out = append(out, [i, v]) }
+out = append(out, ch.recv())`, nil, ARR{ARR{0, 0}, ARR{1, 1}, ARR{2, 2},
+		tengo.UndefinedValue})
+
+	// select
+	expectRun(t, `
+a := chan(1)
+b := chan(1)
+b.send(2)
+out = [select(a, b), select(a, block=false), select([a, 5]), a.recv()]`,
+		nil, ARR{ARR{1, 2}, tengo.UndefinedValue, ARR{0, tengo.UndefinedValue}, 5})
+
+	// routines are aborted when the run finishes
+	expectRun(t, `
+r := go(func() { for {} })
+out = r.done`, nil, false)
+	expectRun(t, `
+ch := chan()
+r := go(func() { ch.recv() })
+out = 1`, nil, 1)
+
+	// routines do not share the mutable values
+	expectRun(t, `
+P := type("P", fields(n=0), methods(inc=func(this) { this.n++; return this.n }))
+p := P()
+p.inc()
+r := go(func() { return p.inc() })
+out = [r.wait(), p.n]`, nil, ARR{2, 1})
+	expectRun(t, `
+m := {}
+rs := []
+for i := 0; i < 8; i++ {
+	rs = append(rs, go(func(i) {
+		for j := 0; j < 100; j++ { m[string(j)] = i }
+		return len(m)
+	}, i))
+}
+out = 0
+for r in rs { out += r.wait() }
+out = [out, len(m)]`, nil, ARR{800, 0})
+	expectRun(t, `
+n := 0
+a := [1]
+f := func() { n++; a[0]++; return [n, a[0]] }
+out = [go(f).wait(), go(func(b) { b[0] = 5 }, a).wait(), n, a]`,
+		nil, ARR{ARR{1, 2}, tengo.UndefinedValue, 0, ARR{1}})
+	expectRun(t, `
+f := func() {
+	m := {a: [1]}
+	m.b = m
+	return go(func() { m.a[0] = 2; return m.b.a[0] }).wait() + m.a[0]
+}
+out = f()`, nil, 3)
+	expectRun(t, `
+ch := chan(2)
+a := [1]
+ch.send(a)
+select([ch, a])
+b := ch.recv()
+b[0] = 2
+out = [a, ch.recv(), b]`, nil, ARR{ARR{1}, ARR{1}, ARR{2}})
+
+	// generators are resumed by the routines creating them
+	expectRun(t, `
+gen := func(n) { for i := 0; i < n; i++ { yield i } }
+out = go(func() { s := 0; for x in gen(4) { s += x }; return s }).wait()`,
+		nil, 6)
+	expectError(t, `
+gen := func(n) { for i := 0; i < n; i++ { yield i } }
+g := gen(200000)
+go(func() { for x in g {} }).wait()`, nil, "generator belongs to another routine")
+	expectError(t, `
+g := func() { yield 1 }()
+go(func() { resume(g) }).wait()`, nil, "generator belongs to another routine")
+	expectError(t, `
+g := func() { yield 1 }()
+go(func(g) { return [...g] }, g).wait()`, nil, "generator belongs to another routine")
+
+	expectError(t, `go(func() { return 1 - "a" }).wait()`, nil,
+		"Runtime Error: invalid operation: int - string\n\tat test:1:20\n\tat test:1:1")
+	expectRun(t, `
+r := go(func() { return 1 - "a" })
+try { r.wait() } catch e { out = e.value }`,
+		nil, "invalid operation: int - string")
+	expectError(t, `go(1)`, nil, "invalid type for argument 'first'")
+	expectError(t, `ch := chan(); ch.close(); ch.close()`, nil,
+		"channel is closed")
+	expectError(t, `ch := chan(); ch.close(); ch.send(1)`, nil,
+		"channel is closed")
+	expectError(t, `select(1)`, nil, "invalid type for argument 'case 1'")
+	expectError(t, `chan(-1)`, nil, "invalid index")
+}
+
+func TestGenerator(t *testing.T) {
+	expectRun(t, `
+gen := func(n) { for i := 0; i < n; i++ { yield i * 10 } }
+out = []
+for i, x in gen(3) { out = append(out, [i, x]) }`,
+		nil, ARR{ARR{0, 0}, ARR{1, 10}, ARR{2, 20}})
+	expectRun(t, `
+gen := func() { yield; yield 1 }
+out = []
+for x in gen() { out = append(out, x) }`,
+		nil, ARR{tengo.UndefinedValue, 1})
+	expectRun(t, `
+out = 0
+gen := func() { return 5; yield 1 }
+for x in gen() { out = x }`, nil, 0)
+
+	// lazy evaluation
+	expectRun(t, `
+out = ""
+gen := func() { out += "a"; yield 1; out += "b"; yield 2; out += "c" }
+g := gen()
+out += "<"
+for x in g { out += string(x); if x == 1 { break } }
+out += ">"
+for x in g { out += string(x) }`, nil, "<a1>b2c")
+	expectRun(t, `
+naturals := func() { n := 0; for { yield n; n++ } }
+take := func(g, n) {
+	for x in g {
+		if n == 0 { return }
+		yield x
+		n--
+	}
+}
+mapper := func(g, fn) { for x in g { yield fn(x) } }
+out = []
+for x in take(mapper(naturals(), func(x) { return x * x }), 4) {
+	out = append(out, x)
+}`, nil, ARR{0, 1, 4, 9})
+
+	// recursion and closures
+	expectRun(t, `
+walk := func(tree) {
+	if !is_array(tree) { yield tree; return }
+	for node in tree { for x in walk(node) { yield x } }
+}
+out = []
+for x in walk([1, [2, [3, 4]], 5]) { out = append(out, x) }`,
+		nil, ARR{1, 2, 3, 4, 5})
+	expectRun(t, `
+counter := func() {
+	n := 0
+	inc := func() { n++ }
+	for { inc(); yield n }
+}
+g := counter()
+out = [resume(g), resume(g), resume(g)]`, nil, ARR{1, 2, 3})
+	expectRun(t, `
+gen := func(a, ...rest; k=1) { yield a; yield rest; yield k }
+out = []
+for x in gen(1, 2, 3; k=4) { out = append(out, x) }`,
+		nil, ARR{1, ARR{2, 3}, 4})
+
+	// coroutines
+	expectRun(t, `
+acc := func() {
+	total := 0
+	for {
+		x := yield total
+		if x == undefined { return total * 10 }
+		total += x
+	}
+}
+co := acc()
+out = [resume(co), resume(co, 5), resume(co, 7), co.done, resume(co), co.done]`,
+		nil, ARR{0, 5, 12, false, 120, true})
+	expectRun(t, `
+gen := func() { x := 1 + (yield 1) * 2; yield x }
+g := gen()
+out = [resume(g, 100), resume(g, 10)]`, nil, ARR{1, 21})
+	expectRun(t, `
+gen := func() { yield 1 }
+g := gen()
+out = [type_name(g), string(g), g == g, g == gen()]`,
+		nil, ARR{"generator", "<generator>", true, false})
+
+	// try blocks
+	expectRun(t, `
+gen := func() {
+	try {
+		yield 1
+		1 + "a"
+	} catch err {
+		yield err.value
+	} finally {
+		yield "finally"
+	}
+}
+out = []
+for x in gen() { out = append(out, x) }`,
+		nil, ARR{1, "invalid operation: int + string", "finally"})
+	expectRun(t, `
+gen := func() { yield 1; 1 + "a" }
+out = []
+try {
+	for x in gen() { out = append(out, x) }
+} catch err {
+	out = append(out, err.value)
+}`, nil, ARR{1, "invalid operation: int + string"})
+	expectRun(t, `
+gen := func() { yield 1; 1 + "a" }
+g := gen()
+try { resume(g); resume(g) } catch err { out = [err.value, g.done] }`,
+		nil, ARR{"invalid operation: int + string", true})
+	expectRun(t, `
+inner := func() { yield 1; 1 + "a" }
+outer := func() {
+	try { for x in inner() { yield x } } catch err { yield "caught" }
+	yield "after"
+}
+out = []
+for x in outer() { out = append(out, x) }`, nil, ARR{1, "caught", "after"})
+
+	expectError(t, `
+gen := func() {
+	yield 1
+	1 + "a"
+}
+g := gen()
+resume(g)
+resume(g)`, nil, "invalid operation: int + string\n\tat test:4:2\n\tat test:8:1")
+	expectError(t, `g := func() { yield 1 }(); resume(g); resume(g); resume(g)`,
+		nil, "generator is finished")
+	expectError(t, `g := 0; g = func() { resume(g); yield }(); resume(g)`,
+		nil, "generator is already running")
+	expectError(t, `resume(1)`, nil,
+		"invalid type for argument 'first' in call to 'builtin-function:resume'")
+	expectError(t, `yield 1`, nil, "yield not allowed outside function")
+}
+
+func TestSwitch(t *testing.T) {
+	// constant cases
+	expectRun(t, `switch 2 { case 1: out = "a"; case 2, 3: out = "b" }`,
+		nil, "b")
+	expectRun(t, `switch 4 { case 1: out = "a"; default: out = "d" }`,
+		nil, "d")
+	expectRun(t, `out = 0; switch 4 { case 1: out = 1 }`, nil, 0)
+	expectRun(t, `switch "x" { case 'x': out = 1; case "x": out = 2 }`,
+		nil, 2)
+	expectRun(t, `switch 1 { case 1: out = 1; case 1: out = 2 }`, nil, 1)
+	expectRun(t, `switch 1.0 { case 1: out = 1; default: out = 2 }`, nil, 2)
+	expectRun(t, `
+f := func(s) {
+	switch s {
+	case "idle": return "run"
+	case "run": return "stop"
+	}
+	return "idle"
+}
+out = f(f(f(f("idle"))))`, nil, "run")
+	expectRun(t, `switch a := 3; a { case 3: out = a }`, nil, 3)
+
+	// value cases
+	expectRun(t, `a := 2; switch 2 { case a - 1: out = 1; case a: out = 2 }`,
+		nil, 2)
+	expectRun(t, `switch [1, 2] { case [1, 2]: out = 1 }`, nil, 1)
+	expectRun(t, `x := 5; switch 5 { case x: out = 1 }`, nil, 1)
+
+	// switch without value
+	expectRun(t, `a := 5; switch { case a < 3: out = 1; case a < 10: out = 2 }`,
+		nil, 2)
+	expectRun(t, `switch { case false, 0, "": out = 1; default: out = 2 }`,
+		nil, 2)
+
+	// type cases
+	expectRun(t, `switch 1.5 { case is "int": out = 1; case is "float": out = 2 }`,
+		nil, 2)
+	expectRun(t, `
+P := type("P", fields(x=0))
+Q := type("Q", fields(x=0))
+f := func(v) {
+	switch v {
+	case is P: return "P"
+	case is Q: return "Q"
+	case is "string", is "char": return "text"
+	}
+	return type_name(v)
+}
+out = [f(P(1)), f(Q(1)), f("a"), f('a'), f(1)]`,
+		nil, ARR{"P", "Q", "text", "text", "int"})
+
+	// array patterns
+	expectRun(t, `switch [1, 2] { case [a]: out = a; case [a, b]: out = a + b }`,
+		nil, 3)
+	expectRun(t, `switch [1, 2, 3] { case [a, b]: out = 0; default: out = 1 }`,
+		nil, 1)
+	expectRun(t, `switch [1, 2, 3] { case [1, _, c]: out = c }`, nil, 3)
+	expectRun(t, `switch [1, 2, 3] { case [2, _, c]: out = c; default: out = 0 }`,
+		nil, 0)
+	expectRun(t, `switch [1, 2, 3] { case [a, ...r]: out = r }`,
+		nil, ARR{2, 3})
+	expectRun(t, `switch [1] { case [a, ...r]: out = r }`, nil, ARR{})
+	expectRun(t, `switch [] { case [a, ...]: out = 1; default: out = 2 }`,
+		nil, 2)
+	expectRun(t, `switch immutable([1, [2, 3]]) { case [a, [b, c]]: out = a + b + c }`,
+		nil, 6)
+	expectRun(t, `switch "ab" { case [a, b]: out = 1; default: out = 2 }`,
+		nil, 2)
+
+	// map patterns
+	expectRun(t, `switch ({a: 1, b: 2}) { case {a, b: x}: out = a + x }`,
+		nil, 3)
+	expectRun(t, `m := {a: 1}; switch m { case {a, b}: out = 1; case {a}: out = 2 }`,
+		nil, 2)
+	expectRun(t, `
+switch ({k: "c", r: 2}) {
+case {k: "s", r}: out = 1
+case {k: "c", r}: out = r
+}`,
+		nil, 2)
+	expectRun(t, `switch ({a: [1, {b: 2}]}) { case {a: [_, {b}]}: out = b }`,
+		nil, 2)
+	expectRun(t, `switch ({a: 1, b: 2}) { case {a, ...r}: out = r }`,
+		nil, MAP{"b": 2})
+	expectError(t, `switch [1] { case [a = 2]: }`, nil,
+		"default value not allowed in case pattern")
+	expectRun(t, `switch [1, 2] { case {a}: out = 1; default: out = 2 }`,
+		nil, 2)
+
+	// guards
+	expectRun(t, `
+f := func(v) {
+	switch v {
+	case [a, b] if a > b: return "desc"
+	case [a, b] if a < b: return "asc"
+	case [_, _]: return "same"
+	}
+}
+out = [f([2, 1]), f([1, 2]), f([1, 1])]`, nil, ARR{"desc", "asc", "same"})
+	expectRun(t, `a := 1; switch 1 { case 1 if a > 1: out = 1; default: out = 2 }`,
+		nil, 2)
+
+	// multiple patterns with bindings
+	expectRun(t, `
+f := func(v) {
+	switch v {
+	case [x], {x}: return x
+	}
+}
+out = [f([1]), f({x: 2}), f(3)]`, nil, ARR{1, 2, tengo.UndefinedValue})
+
+	// scopes
+	expectRun(t, `a := 1; switch [2] { case [a]: out = a }; out += a`, nil, 3)
+	expectRun(t, `
+out = func() {
+	switch [1, [2]] {
+	case [a, [b]]:
+		f := func() { return a + b }
+		return f()
+	}
+}()`, nil, 3)
+	expectError(t, `switch [1] { case [a]: }; b := a`,
+		nil, "unresolved reference 'a'")
+
+	// break and continue are of the loop
+	expectRun(t, `
+out = 0
+for i := 0; i < 10; i++ {
+	switch i {
+	case 1: continue
+	case 3: break
+	}
+	out += i
+}`, nil, 2)
+
+	// errors
+	expectError(t, `switch 1 { case is 1: }`, nil, "invalid type pattern: int")
+	expectError(t, `switch { case [a]: }`, nil,
+		"pattern not allowed in switch without value")
+	expectError(t, `switch 1 { default: ; default: }`, nil,
+		"multiple defaults in switch")
+	expectError(t, `switch 1 { case 1: 1 + "a" }`, nil,
+		"invalid operation: int + string")
+	expectRun(t, `try { switch 1 { case is 1: } } catch err { out = err.value }`,
+		nil, "invalid type pattern: int")
+}
+
 func TestSpread(t *testing.T) {
 	expectRun(t, `
 	f := func(...a) {